@@ -0,0 +1,349 @@
+package gopack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AccessControlFile        = ".gpkacl"
+	AccessControlFileVersion = "1.0.0"
+)
+
+//Right is a level of access to packages. Each level includes the lower ones.
+type Right int
+
+const (
+	NoRight    Right = iota
+	ReadRight        // fetch, search, and get executables
+	PushRight        // push packages and executables
+	AdminRight       // administrative operations, like overwriting a release
+)
+
+var rightNames = []string{"none", "read", "push", "admin"}
+
+//String returns the right name
+func (r Right) String() string {
+	if r < NoRight || int(r) >= len(rightNames) {
+		return fmt.Sprintf("right(%d)", int(r))
+	}
+	return rightNames[r]
+}
+
+//ParseRight reads a right by its name: none, read, push or admin
+func ParseRight(name string) (r Right, err error) {
+	for i, n := range rightNames {
+		if strings.EqualFold(n, name) {
+			return Right(i), nil
+		}
+	}
+	return NoRight, errors.New(fmt.Sprintf("Invalid right \"%s\", expecting one of %s", name, strings.Join(rightNames, ", ")))
+}
+
+//Grant gives a right on the package named Prefix, and every package under it (Prefix/...)
+type Grant struct {
+	Prefix string
+	Right  Right
+}
+
+//AccessEntry is a token, and the rights it grants. Only the token hash is kept.
+type AccessEntry struct {
+	Name   string // the token owner, to help managing them
+	Hash   string // see TokenHash
+	Grants []Grant
+}
+
+//ID is a short identifier of the token
+func (e AccessEntry) ID() string {
+	if len(e.Hash) < len(tokenHashPrefix)+8 {
+		return e.Hash
+	}
+	return e.Hash[len(tokenHashPrefix) : len(tokenHashPrefix)+8]
+}
+
+//AccessControl maps tokens to rights on package names. It is stored in a json file, usually the .gpkacl of the served repository.
+// Requests without token, or with an unknown one, are granted the Anonymous rights.
+type AccessControl struct {
+	path      string
+	modTime   time.Time // of the file when it was read
+	anonymous []Grant
+	entries   []AccessEntry
+	delegates map[string]string          // by delegate token hash, the hash of the entry it stands for, see Delegate
+	delegated map[string]*protocol.Token // the delegate tokens, by entry hash
+	mutex     sync.Mutex
+}
+
+//NewAccessControl creates an empty access control that will be saved in path.
+// Anonymous users can read everything, and nothing else.
+func NewAccessControl(path string) *AccessControl {
+	return &AccessControl{
+		path:      path,
+		anonymous: []Grant{{"", ReadRight}},
+	}
+}
+
+//ReadAccessControl reads an access control file.
+func ReadAccessControl(path string) (a *AccessControl, err error) {
+	a = &AccessControl{path: path}
+	err = a.read()
+	return
+}
+
+func (a *AccessControl) read() (err error) {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return
+	}
+	if err = JsonReadFile(a.path, a); err != nil {
+		return
+	}
+	a.modTime = fi.ModTime()
+	return
+}
+
+//refresh reads the file again if it has been modified since, so that minted and revoked tokens are taken into account by a running server.
+func (a *AccessControl) refresh() {
+	fi, err := os.Stat(a.path)
+	if err != nil || fi.ModTime().Equal(a.modTime) {
+		return
+	}
+	log.Printf("Reloading access control %s", a.path)
+	fresh := &AccessControl{path: a.path}
+	if err := fresh.read(); err != nil {
+		log.Printf("Cannot reload %s, keeping the previous one: %v", a.path, err)
+		return
+	}
+	a.modTime, a.anonymous, a.entries = fresh.modTime, fresh.anonymous, fresh.entries
+}
+
+//Write down the access control file
+func (a *AccessControl) Write() (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err = JsonWriteFile(a.path, a); err != nil {
+		return
+	}
+	if fi, err := os.Stat(a.path); err == nil {
+		a.modTime = fi.ModTime()
+	}
+	return
+}
+
+//Path is the access control file path
+func (a *AccessControl) Path() string {
+	return a.path
+}
+
+//tokenHashPrefix tells the algorithm of the token hashes
+const tokenHashPrefix = "sha256:"
+
+//TokenHash computes the hash under which a token is stored
+func TokenHash(token protocol.Token) string {
+	h := sha256.Sum256(token)
+	return tokenHashPrefix + hex.EncodeToString(h[:])
+}
+
+//Mint creates a new random token for name, with the grants. The token itself is not stored, only its hash:
+// it is returned so that it can be given to its owner.
+func (a *AccessControl) Mint(name string, grants []Grant) (token *protocol.Token, err error) {
+	token = protocol.NewToken(32)
+	if token == nil {
+		return nil, errors.New("Cannot generate a random token")
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refresh() // a running server mints tokens too
+	a.entries = append(a.entries, AccessEntry{name, TokenHash(*token), grants})
+	return
+}
+
+//Lookup returns the entry of token, if it is known
+func (a *AccessControl) Lookup(token *protocol.Token) (e AccessEntry, ok bool) {
+	if token == nil || len(*token) == 0 {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refresh()
+	hash := a.entryHash(*token)
+	for _, e := range a.entries {
+		if e.Hash == hash {
+			return e, true
+		}
+	}
+	return
+}
+
+//entryHash is the hash of the entry token stands for: its own hash, unless it is a delegate. The mutex must be held.
+func (a *AccessControl) entryHash(token protocol.Token) string {
+	hash := TokenHash(token)
+	if entry, ok := a.delegates[hash]; ok {
+		return entry
+	}
+	return hash
+}
+
+//Delegate returns a token that stands for the entry whose hash is given: it has the rights of that entry, as long as it exists.
+// It is only known by this process, it is never written down, and is meant for the requests a server has authenticated
+// by other means, like the OAuth provider does. ok is false if there is no such entry, e.g. it has been revoked.
+func (a *AccessControl) Delegate(hash string) (token *protocol.Token, ok bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refresh()
+	for _, e := range a.entries {
+		ok = ok || e.Hash == hash
+	}
+	if !ok {
+		return nil, false
+	}
+	if token, ok = a.delegated[hash]; ok {
+		return
+	}
+	if token = protocol.NewToken(32); token == nil {
+		return nil, false
+	}
+	if a.delegates == nil {
+		a.delegates, a.delegated = make(map[string]string), make(map[string]*protocol.Token)
+	}
+	a.delegates[TokenHash(*token)], a.delegated[hash] = hash, token
+	return token, true
+}
+
+//Revoke removes the tokens whose name, or ID, is key. It returns the removed entries.
+func (a *AccessControl) Revoke(key string) (removed []AccessEntry) {
+	kept := make([]AccessEntry, 0, len(a.entries))
+	for _, e := range a.entries {
+		if e.Name == key || e.ID() == key {
+			removed = append(removed, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	a.entries = kept
+	return
+}
+
+//Entries lists every token entry
+func (a *AccessControl) Entries() []AccessEntry {
+	return a.entries[:]
+}
+
+//Anonymous lists the rights granted to everyone
+func (a *AccessControl) Anonymous() []Grant {
+	return a.anonymous[:]
+}
+
+//SetAnonymous replaces the rights granted to everyone
+func (a *AccessControl) SetAnonymous(grants []Grant) {
+	a.anonymous = grants
+}
+
+//RightOn computes the right granted by token on the package name. token can be nil.
+func (a *AccessControl) RightOn(token *protocol.Token, name string) (r Right) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refresh()
+	r = highest(a.anonymous, name, NoRight)
+	if token == nil || len(*token) == 0 {
+		return
+	}
+	hash := a.entryHash(*token)
+	for _, e := range a.entries {
+		if e.Hash == hash {
+			r = highest(e.Grants, name, r)
+		}
+	}
+	return
+}
+
+//Owner returns the name of the token owner, or "anonymous" if the token is unknown
+func (a *AccessControl) Owner(token *protocol.Token) string {
+	if token == nil || len(*token) == 0 {
+		return "anonymous"
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	hash := a.entryHash(*token)
+	for _, e := range a.entries {
+		if e.Hash == hash {
+			return e.Name
+		}
+	}
+	return "anonymous"
+}
+
+//Authorize returns protocol.StatusForbidden unless token is granted at least right on the package name
+func (a *AccessControl) Authorize(token *protocol.Token, name string, right Right) error {
+	if a.RightOn(token, name) < right {
+		return protocol.StatusForbidden
+	}
+	return nil
+}
+
+//highest returns the highest right among r and the grants applicable to name
+func highest(grants []Grant, name string, r Right) Right {
+	for _, g := range grants {
+		if hasNamePrefix(name, g.Prefix) && g.Right > r {
+			r = g.Right
+		}
+	}
+	return r
+}
+
+//UnmarshalJSON part of the json protocol
+func (r *Right) UnmarshalJSON(data []byte) (err error) {
+	var name string
+	if err = json.Unmarshal(data, &name); err != nil {
+		return
+	}
+	*r, err = ParseRight(name)
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (r Right) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+//UnmarshalJSON part of the json protocol
+func (a *AccessControl) UnmarshalJSON(data []byte) (err error) {
+	type AccessControlFile struct {
+		FormatVersion string
+		Anonymous     []Grant
+		Tokens        []AccessEntry
+	}
+	var af AccessControlFile
+	if err = json.Unmarshal(data, &af); err != nil {
+		return
+	}
+	if af.FormatVersion != AccessControlFileVersion {
+		log.Printf("Warning: Unknown format version \"%s\"", af.FormatVersion)
+	}
+	a.anonymous = af.Anonymous
+	a.entries = af.Tokens
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (a *AccessControl) MarshalJSON() ([]byte, error) {
+	type AccessControlFile struct {
+		FormatVersion string
+		Anonymous     []Grant
+		Tokens        []AccessEntry
+	}
+	af := AccessControlFile{
+		FormatVersion: AccessControlFileVersion,
+		Anonymous:     a.anonymous,
+		Tokens:        a.entries,
+	}
+	return json.Marshal(af)
+}
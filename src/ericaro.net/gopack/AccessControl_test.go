@@ -0,0 +1,132 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRight(t *testing.T) {
+	for _, r := range []Right{NoRight, ReadRight, PushRight, AdminRight} {
+		if parsed, err := ParseRight(r.String()); err != nil || parsed != r {
+			t.Errorf("%s parsed as %s: %v", r, parsed, err)
+		}
+	}
+	if _, err := ParseRight("write"); err == nil {
+		t.Errorf("write is not a right")
+	}
+}
+
+func TestMint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AccessControlFile)
+	a := NewAccessControl(path)
+	token, err := a.Mint("alice", []Grant{{"ex", PushRight}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := a.Mint("bob", []Grant{{"ex", ReadRight}})
+	if string(*token) == string(*other) {
+		t.Fatalf("Two minted tokens are the same")
+	}
+	if err = a.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadAccessControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := read.Lookup(token)
+	if !ok || e.Name != "alice" || read.Owner(token) != "alice" {
+		t.Fatalf("The minted token is unknown: %v", e)
+	}
+	if e.Hash == string(*token) || e.Hash != TokenHash(*token) {
+		t.Errorf("The token is stored as is, rather than its hash")
+	}
+	if _, ok := read.Lookup(protocol.NewToken(32)); ok || read.Owner(nil) != "anonymous" {
+		t.Errorf("An unknown token is known")
+	}
+	if removed := read.Revoke(e.ID()); len(removed) != 1 || removed[0].Name != "alice" {
+		t.Errorf("Revoked %v, expected alice", removed)
+	}
+	if _, ok := read.Lookup(token); ok {
+		t.Errorf("A revoked token is still known")
+	}
+}
+
+func TestRightOn(t *testing.T) {
+	a := NewAccessControl(filepath.Join(t.TempDir(), AccessControlFile))
+	a.SetAnonymous([]Grant{{"pub", ReadRight}})
+	token, _ := a.Mint("alice", []Grant{{"ex", PushRight}, {"ex/admin", AdminRight}, {"team/", ReadRight}})
+	cases := []struct {
+		token *protocol.Token
+		name  string
+		right Right
+	}{
+		{token, "ex", PushRight},
+		{token, "ex/a", PushRight},
+		{token, "ex/admin", AdminRight},
+		{token, "ex/admin/a", AdminRight},
+		{token, "ex/administration", PushRight},
+		{token, "example", NoRight},
+		{token, "exa/b", NoRight},
+		{token, "team/a", ReadRight},
+		{token, "teams/a", NoRight},
+		{token, "pub/a", ReadRight}, // the anonymous rights are granted to everyone
+		{nil, "pub", ReadRight},
+		{nil, "public", NoRight},
+		{nil, "ex/a", NoRight},
+		{protocol.NewToken(32), "ex/a", NoRight},
+	}
+	for _, c := range cases {
+		if r := a.RightOn(c.token, c.name); r != c.right {
+			t.Errorf("Right on %s is %s, expected %s", c.name, r, c.right)
+		}
+	}
+	if a.Authorize(token, "ex/a", AdminRight) != protocol.StatusForbidden || a.Authorize(token, "ex/a", PushRight) != nil {
+		t.Errorf("Authorize does not match the rights")
+	}
+}
+
+func TestNewAccessControlReadsEverything(t *testing.T) {
+	a := NewAccessControl(filepath.Join(t.TempDir(), AccessControlFile))
+	if a.RightOn(nil, "any/package") != ReadRight {
+		t.Errorf("By default, anonymous users can only read")
+	}
+}
+
+func TestDelegate(t *testing.T) {
+	a := NewAccessControl(filepath.Join(t.TempDir(), AccessControlFile))
+	token, _ := a.Mint("alice", []Grant{{"ex", PushRight}})
+	e, _ := a.Lookup(token)
+	delegate, ok := a.Delegate(e.Hash)
+	if !ok || string(*delegate) == string(*token) {
+		t.Fatalf("Cannot delegate alice's token")
+	}
+	if again, _ := a.Delegate(e.Hash); string(*again) != string(*delegate) {
+		t.Errorf("Every delegation is a new token")
+	}
+	if a.RightOn(delegate, "ex/a") != PushRight || a.Owner(delegate) != "alice" {
+		t.Errorf("The delegate does not have alice's rights")
+	}
+	if _, ok := a.Delegate(TokenHash(*protocol.NewToken(32))); ok {
+		t.Errorf("An unknown token has been delegated")
+	}
+
+	// the delegate is not written down
+	if err := a.Write(); err != nil {
+		t.Fatal(err)
+	}
+	read, _ := ReadAccessControl(a.Path())
+	if _, ok := read.Lookup(delegate); ok || len(read.Entries()) != 1 {
+		t.Errorf("The delegate has been written down")
+	}
+
+	a.Revoke(e.ID())
+	if _, ok := a.Lookup(delegate); ok || a.RightOn(delegate, "ex/a") != ReadRight {
+		t.Errorf("The delegate of a revoked token is still valid")
+	}
+	if _, ok := a.Delegate(e.Hash); ok {
+		t.Errorf("A revoked token has been delegated")
+	}
+}
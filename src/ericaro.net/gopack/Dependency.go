@@ -0,0 +1,118 @@
+package gopack
+
+import (
+	"encoding/json"
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+)
+
+//Scope tells what a dependency is required for.
+type Scope string
+
+const (
+	//CompileScope dependencies are required to compile the project, and by the projects depending on it
+	CompileScope Scope = "compile"
+	//TestScope dependencies are only required to compile and run the project tests, they are not transitive
+	TestScope Scope = "test"
+	//ToolScope dependencies provide executables, installed in the project ToolsDir. They are neither in the GOPATH, nor transitive
+	ToolScope Scope = "tool"
+)
+
+//ParseScope reads one of the scope names
+func ParseScope(s string) (Scope, error) {
+	switch sc := Scope(s); sc {
+	case CompileScope, TestScope, ToolScope:
+		return sc, nil
+	}
+	return CompileScope, errors.New(fmt.Sprintf("Unknown scope %q, expecting %s, %s or %s", s, CompileScope, TestScope, ToolScope))
+}
+
+//Dependency is a reference to a package name, and the constraint its version must satisfy.
+// Unlike ProjectID it does not identify a single Package, it is resolved into one by the LocalRepository.
+type Dependency struct {
+	name       string // any valid package name
+	constraint Constraint
+	scope      Scope // empty means CompileScope
+}
+
+//NewDependency creates a new Dependency, in the CompileScope
+func NewDependency(name string, constraint Constraint) *Dependency {
+	return &Dependency{name: name, constraint: constraint}
+}
+
+//NewScopedDependency creates a new Dependency in scope
+func NewScopedDependency(name string, constraint Constraint, scope Scope) *Dependency {
+	return &Dependency{name: name, constraint: constraint, scope: scope}
+}
+
+//Name the name of the package this Dependency references
+func (d *Dependency) Name() string {
+	return d.name
+}
+
+//Constraint the set of versions acceptable for this dependency
+func (d *Dependency) Constraint() Constraint {
+	return d.constraint
+}
+
+//Scope what this dependency is required for
+func (d *Dependency) Scope() Scope {
+	if d.scope == "" {
+		return CompileScope
+	}
+	return d.scope
+}
+
+//String returns a simple " " separated representation of the dependency, followed by its scope if it is not CompileScope
+func (d Dependency) String() string {
+	if d.Scope() != CompileScope {
+		return fmt.Sprintf("%s %s (%s)", d.name, d.constraint.String(), d.scope)
+	}
+	return fmt.Sprintf("%s %s", d.name, d.constraint.String())
+}
+
+//FilterScope returns the dependencies in one of the scopes
+func FilterScope(dependencies []Dependency, scopes ...Scope) (filtered []Dependency) {
+	for _, d := range dependencies {
+		for _, scope := range scopes {
+			if d.Scope() == scope {
+				filtered = append(filtered, d)
+				break
+			}
+		}
+	}
+	return
+}
+
+//UnmarshalJSON part of the json protocol
+func (d *Dependency) UnmarshalJSON(data []byte) (err error) {
+	type DependencyFile struct {
+		Name, Version string
+		Scope         string
+	}
+	var df DependencyFile
+	json.Unmarshal(data, &df)
+	d.name = df.Name
+	d.constraint, err = ParseConstraint(df.Version)
+	if err == nil && df.Scope != "" {
+		d.scope, err = ParseScope(df.Scope)
+	}
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (d *Dependency) MarshalJSON() ([]byte, error) {
+	type DependencyFile struct {
+		Name, Version string
+		Scope         string `json:",omitempty"` // CompileScope is omitted
+	}
+	df := DependencyFile{
+		Name:    d.name,
+		Version: d.constraint.String(),
+	}
+	if d.Scope() != CompileScope {
+		df.Scope = string(d.scope)
+	}
+	return json.Marshal(df)
+}
@@ -1,7 +1,6 @@
 package gopack
 
 import (
-	"bytes"
 	"ericaro.net/gopack/protocol"
 	"fmt"
 	"io"
@@ -34,13 +33,13 @@ func NewFileClient(name string, u url.URL, token *protocol.Token) (r protocol.Cl
 
 func (c *FileClient) Push(pid protocol.PID, r io.Reader) (err error) {
 	//dst := filepath.Join(c.repo.Root(), pid.Path())
-	_, err = c.repo.Install(r)
+	_, err = c.repo.Receive(pid, r, localUser())
 	return
 }
 
 func (c *FileClient) PushExecutables(pid protocol.PID, r io.Reader) (err error) {
 	//dst := filepath.Join(c.repo.Root(), pid.Path())
-	_, err = c.repo.InstallAppend(r)
+	_, err = c.repo.ReceiveExecutables(pid, r)
 	return
 }
 
@@ -56,17 +55,15 @@ func (c *FileClient) Fetch(pid protocol.PID) (r io.ReadCloser, err error) {
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	rp.Pack(buf)
-
-	// the package has been built into the buffer
-	return closeable{buf}, nil
-}
-
-
-// internal trick to fake a closeable, even if we use a buffer that is not closeable in fact
-type closeable struct {
-	*bytes.Buffer
+	sig, err := rp.Signature()
+	if err != nil {
+		return
+	}
+	// the package is packed while it is read
+	a := &protocol.Archive{ReadCloser: rp.PackReader(), Digest: rp.Digest()}
+	if sig != nil {
+		a.Signature = sig.Format()
+	}
+	return a, nil
 }
 
-func (c closeable) Close() error { return nil }
@@ -1,10 +1,10 @@
 package gopack
 
 import (
-	"bytes"
 	"encoding/json"
 	"ericaro.net/gopack/protocol"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -18,6 +18,7 @@ func init() { // register this as a handler for file:/// url scheme
 //Http implementation of a Remote 
 type HttpClient struct {
 	protocol.BaseClient // doesn't require anything else
+	Client              *http.Client // sends the requests, http.DefaultClient if nil
 }
 
 func NewHttpClient(name string, u url.URL, token *protocol.Token) (r protocol.Client, err error) {
@@ -28,6 +29,18 @@ func NewHttpClient(name string, u url.URL, token *protocol.Token) (r protocol.Cl
 }
 
 func (c *HttpClient) Fetch(pid protocol.PID) (r io.ReadCloser, err error) {
+	return c.FetchFrom(pid, 0, "")
+}
+
+func (c *HttpClient) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+//FetchFrom fetches the package archive, using an http Range request to resume from offset, if the archive tag (its ETag) has not changed.
+func (c *HttpClient) FetchFrom(pid protocol.PID, offset int64, tag string) (a *protocol.Archive, err error) {
 	v := &url.Values{}
 	pid.InParameter(v)
 	//query url
@@ -36,64 +49,72 @@ func (c *HttpClient) Fetch(pid protocol.PID) (r io.ReadCloser, err error) {
 		RawQuery: v.Encode(),
 	}
 	remote := c.Path()
-	resp, err := http.Get(remote.ResolveReference(u).String())
+	req, err := http.NewRequest("GET", remote.ResolveReference(u).String(), nil)
 	if err != nil {
 		return
 	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New(resp.Status)
+	if offset > 0 && tag != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", tag)
 	}
-	return resp.Body, nil
-}
-
-func (c *HttpClient) Push(pid protocol.PID, r io.Reader) (err error) {
-	v := &url.Values{}
-	pid.InParameter(v)
-	//query url
-	u := &url.URL{
-		Path:     protocol.PUSH,
-		RawQuery: v.Encode(),
-	}
-
-	buf := new(bytes.Buffer)
-	io.Copy(buf, r)
-
-	var client http.Client
-	remote := c.Path()
-	req, err := http.NewRequest("POST", remote.ResolveReference(u).String(), buf)
+	resp, err := c.client().Do(req)
 	if err != nil {
 		return
 	}
-	req.ContentLength = int64(buf.Len()) // fuck I can't do that, I need to compute the length first
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 { // the partial archive is not a part of this one
+		resp.Body.Close()
+		return c.FetchFrom(pid, 0, "")
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New(resp.Status)
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	a = &protocol.Archive{
+		ReadCloser: resp.Body,
+		Digest:     resp.Header.Get(protocol.DigestHeader),
+		Signature:  resp.Header.Get(protocol.SignatureHeader),
+		Tag:        resp.Header.Get("ETag"),
+		Size:       resp.ContentLength,
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		var last int64
+		if _, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &a.Offset, &last, &a.Size); err != nil {
+			resp.Body.Close()
+			return nil, errors.New(fmt.Sprintf("Invalid Content-Range \"%s\": %v", resp.Header.Get("Content-Range"), err))
+		}
 	}
 	return
 }
+
+func (c *HttpClient) Push(pid protocol.PID, r io.Reader) (err error) {
+	return c.post(protocol.PUSH, pid, r)
+}
+
 func (c *HttpClient) PushExecutables(pid protocol.PID, r io.Reader) (err error) {
+	return c.post(protocol.PUSH_EXEC, pid, r)
+}
+
+//post streams the content of r to the remote operation path.
+// The length is not known in advance, so it is sent using the chunked transfer encoding.
+func (c *HttpClient) post(path string, pid protocol.PID, r io.Reader) (err error) {
 	v := &url.Values{}
 	pid.InParameter(v)
 	//query url
 	u := &url.URL{
-		Path:     protocol.PUSH_EXEC,
+		Path:     path,
 		RawQuery: v.Encode(),
 	}
 
-	buf := new(bytes.Buffer)
-	io.Copy(buf, r)
-
-	var client http.Client
 	remote := c.Path()
-	req, err := http.NewRequest("POST", remote.ResolveReference(u).String(), buf)
+	req, err := http.NewRequest("POST", remote.ResolveReference(u).String(), r)
 	if err != nil {
 		return
 	}
-	req.ContentLength = int64(buf.Len())
-	resp, err := client.Do(req)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return errors.New(resp.Status)
 	}
@@ -104,6 +125,9 @@ func (c *HttpClient) Search(query string, start int) (result []protocol.PID) {
 	v := url.Values{}
 	v.Set("q", query)
 	v.Set("start", strconv.Itoa(start))
+	if c.Token() != nil {
+		v.Set("k", c.Token().FormatURL())
+	}
 
 	//query url
 	u := &url.URL{
@@ -112,7 +136,7 @@ func (c *HttpClient) Search(query string, start int) (result []protocol.PID) {
 		RawQuery: v.Encode(),
 	}
 	remote := c.Path()
-	resp, err := http.Get(remote.ResolveReference(u).String())
+	resp, err := c.client().Get(remote.ResolveReference(u).String())
 	if err != nil {
 		return result
 	}
@@ -8,21 +8,40 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 //HttpServer serve a local repository as a remote
 type HttpServer struct {
 	Local  LocalRepository // handles the real operations
+	ACL    *AccessControl  // if nil, everyone can do everything
+	Proxy  *Proxy          // if not nil, missing packages are downloaded from the local repository remotes
+	Auth   Authenticator   // if not nil, every request must be authenticated by it
 	server http.Server
 }
 
+//Authenticator authenticates the requests of an HttpServer, like the OAuth provider does.
+type Authenticator interface {
+	//HandleMux registers its own routes, under the path p
+	HandleMux(p string, mux *http.ServeMux)
+	//Protect only lets authenticated requests reach h, with the access control token ("k" parameter) they stand for.
+	Protect(h http.Handler) http.Handler
+}
+
 //Start starts an http server at the addr provided.
 func (s *HttpServer) Start(addr string) {
 	mux := http.NewServeMux()
 	protocol.HandleMux("/", s, mux)
+	var handler http.Handler = mux
+	if s.Auth != nil {
+		root := http.NewServeMux()
+		s.Auth.HandleMux("/", root)
+		root.Handle("/", s.Auth.Protect(mux))
+		handler = root
+	}
 	s.server = http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: handler,
 	}
 	s.server.ListenAndServe()
 }
@@ -31,9 +50,37 @@ func (s *HttpServer) Debugf(format string, args ...interface{}) {
 	log.Printf(format, args...)
 }
 
+//authorize checks that the pid token is granted right on the pid package, which name must be canonical: a grant
+// on a prefix must not cover "PREFIX/../other".
+func (s *HttpServer) authorize(pid protocol.PID, right Right) (err error) {
+	if err = protocol.CheckName(pid.Name); err != nil {
+		return
+	}
+	if s.ACL == nil {
+		return
+	}
+	err = s.ACL.Authorize(pid.Token, pid.Name, right)
+	if err != nil {
+		log.Printf("FORBIDDEN %s on %s %s", right, pid.Name, pid.Version.String())
+	}
+	return
+}
+
 //Contains return true if the server contains the ProjectID
+//Receive installs the pushed package. Replacing a release requires the AdminRight.
 func (s *HttpServer) Receive(pid protocol.PID, r io.ReadCloser) (err error) {
-	pak, err := s.Local.Install(r)
+	right := PushRight
+	if pid.Force {
+		right = AdminRight
+	}
+	if err = s.authorize(pid, right); err != nil {
+		return
+	}
+	by := "anonymous"
+	if s.ACL != nil {
+		by = s.ACL.Owner(pid.Token)
+	}
+	pak, err := s.Local.Receive(pid, r, by)
 	if err != nil {
 		return
 	}
@@ -43,37 +90,73 @@ func (s *HttpServer) Receive(pid protocol.PID, r io.ReadCloser) (err error) {
 
 //Contains return true if the server contains the ProjectID
 func (s *HttpServer) ReceiveExecutables(pid protocol.PID, r io.ReadCloser) (err error) {
-	pak, err := s.Local.InstallAppend(r)
+	if err = s.authorize(pid, PushRight); err != nil {
+		return
+	}
+	pak, err := s.Local.ReceiveExecutables(pid, r)
 	if err != nil {
 		return
 	}
 	log.Printf("RECEIVING EXECUTABLES %s %s %s INTO %s", pak.Name(), pak.Version().String(), pak.License(), pak.InstallDir())
 	return
 }
-func (s *HttpServer) Serve(pid protocol.PID, w io.Writer) (err error) {
+//Serve sends the package archive. Sources are served from the archive cache, so Range requests are supported.
+// If pid has a Timestamp, the package is only sent if it is newer.
+func (s *HttpServer) Serve(pid protocol.PID, w http.ResponseWriter, r *http.Request) (err error) {
 	//func (s *StandaloneBackendServer) Send(id gopack.ProjectID, w http.ResponseWriter, r *http.Request) {
 	log.Printf("SERVING %s %s", pid.Name, pid.Version.String())
+	if err = s.authorize(pid, ReadRight); err != nil {
+		return
+	}
 	id := *NewProjectID(pid.Name, pid.Version)
-	p, err := s.Local.FindPackage(id)
-
+	var p *Package
+	if s.Proxy != nil {
+		p, err = s.Proxy.find(&s.Local, id)
+	} else {
+		p, err = s.Local.FindPackage(id)
+	}
 	if err != nil {
 		return
 	}
-	if pid.Timestamp != nil && p.Timestamp().Before(*pid.Timestamp) {
+	if pid.Timestamp != nil && !p.Timestamp().Truncate(time.Second).After(*pid.Timestamp) { // the timestamp is sent to the second
 		return protocol.StatusNotNewPackage
 	}
 
 	if *pid.Executables {
-		p.PackExecutables(w)
-	} else {
-		p.Pack(w)
+		return p.PackExecutables(w)
+	}
+	archive, err := s.Local.Archive(p)
+	if err != nil {
+		return
+	}
+	defer archive.Close()
+	fi, err := archive.Stat()
+	if err != nil {
+		return
+	}
+	if p.Digest() != "" {
+		w.Header().Set(protocol.DigestHeader, p.Digest())
+	}
+	if sig, _ := p.Signature(); sig != nil {
+		w.Header().Set(protocol.SignatureHeader, sig.Format())
 	}
+	w.Header().Set("Content-Type", "application/x-gzip")
+	w.Header().Set("ETag", `"`+ArchiveTag(p)+`"`)
+	http.ServeContent(w, r, "", fi.ModTime(), archive)
 	return
 }
 
 func (s *HttpServer) Get(pid protocol.PID, goos, goarch, name string, w io.Writer) (err error) {
 	//func (s *StandaloneBackendServer) Send(id gopack.ProjectID, w http.ResponseWriter, r *http.Request) {
 	log.Printf("SERVING Exec %s %s %s %s %s", pid.Name, pid.Version.String(), goos, goarch, name)
+	for _, elem := range []string{goos, goarch, name} {
+		if err = protocol.CheckPathElement(elem); err != nil {
+			return
+		}
+	}
+	if err = s.authorize(pid, ReadRight); err != nil {
+		return
+	}
 	id := *NewProjectID(pid.Name, pid.Version)
 	p, err := s.Local.FindPackage(id)
 	if err != nil {
@@ -93,6 +176,14 @@ func (s *HttpServer) Get(pid protocol.PID, goos, goarch, name string, w io.Write
 func (s *HttpServer) List(pid protocol.PID, goos, goarch string, w io.Writer) (list []string, err error) {
 	//func (s *StandaloneBackendServer) Send(id gopack.ProjectID, w http.ResponseWriter, r *http.Request) {
 	log.Printf("LIST Exec %s %s %s %s", pid.Name, pid.Version.String(), goos, goarch)
+	for _, elem := range []string{goos, goarch} {
+		if err = protocol.CheckPathElement(elem); err != nil {
+			return
+		}
+	}
+	if err = s.authorize(pid, ReadRight); err != nil {
+		return
+	}
 	id := *NewProjectID(pid.Name, pid.Version)
 	p, err := s.Local.FindPackage(id)
 	if err != nil {
@@ -115,7 +206,16 @@ func (s *HttpServer) List(pid protocol.PID, goos, goarch string, w io.Writer) (l
 	return
 }
 
-func (s *HttpServer) Search(query string, start int) (pids []protocol.PID, err error) {
-	pids = s.Local.Search(query, start)
+//Search only returns the packages the token can read
+func (s *HttpServer) Search(query string, start int, token *protocol.Token) (pids []protocol.PID, err error) {
+	var readable func(name string) bool
+	if s.ACL != nil {
+		readable = func(name string) bool { return s.ACL.RightOn(token, name) >= ReadRight }
+	}
+	if s.Proxy != nil {
+		pids = s.Proxy.search(&s.Local, query, start, readable)
+	} else {
+		pids = s.Local.SearchFilter(query, start, readable)
+	}
 	return
 }
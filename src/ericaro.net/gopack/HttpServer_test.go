@@ -0,0 +1,130 @@
+package gopack
+
+import (
+	"bytes"
+	"ericaro.net/gopack/protocol"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//newTestServer serves r, with the access control acl, and returns its url
+func newTestServer(t *testing.T, r *LocalRepository, acl *AccessControl) url.URL {
+	mux := http.NewServeMux()
+	protocol.HandleMux("/", &HttpServer{Local: *r, ACL: acl}, mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return *u
+}
+
+//push pushes p to the server at u, with token
+func push(t *testing.T, u url.URL, token *protocol.Token, p *Package, force bool) error {
+	var archive bytes.Buffer
+	if err := p.Pack(&archive); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewHttpClient("srv", u, token)
+	return c.Push(protocol.PID{Name: p.Name(), Version: p.Version(), Digest: p.Digest(), Force: force, Token: token}, &archive)
+}
+
+func TestReleasesAreWriteOnce(t *testing.T) {
+	srv := newTestRepository(t)
+	acl := NewAccessControl(filepath.Join(t.TempDir(), AccessControlFile))
+	pusher, _ := acl.Mint("alice", []Grant{{"ex", PushRight}})
+	admin, _ := acl.Mint("bob", []Grant{{"ex", AdminRight}})
+	u := newTestServer(t, srv, acl)
+
+	first := installTestPackage(t, newTestRepository(t), "ex/a", "1.0.0")
+	if err := push(t, u, pusher, first, false); err != nil {
+		t.Fatalf("Cannot push %s: %v", first.ID(), err)
+	}
+	second := installTestPackage(t, newTestRepository(t), "ex/a", "1.0.0", "ex/b ^1.0")
+	if second.Digest() == first.Digest() {
+		t.Fatalf("The replacing package must be a different one")
+	}
+
+	cases := []struct {
+		token  *protocol.Token
+		force  bool
+		status int
+	}{
+		{pusher, false, http.StatusConflict},
+		{admin, false, http.StatusConflict},
+		{pusher, true, http.StatusForbidden},
+		{nil, true, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		err := push(t, u, c.token, second, c.force)
+		if err == nil || !strings.HasPrefix(err.Error(), strconv.Itoa(c.status)) {
+			t.Errorf("Pushing over a release by %s (force %v): %v, expected %d", acl.Owner(c.token), c.force, err, c.status)
+		}
+		if p, _ := srv.FindPackage(first.ID()); p == nil || p.Digest() != first.Digest() {
+			t.Fatalf("The release has been replaced by %s", acl.Owner(c.token))
+		}
+	}
+
+	if err := push(t, u, admin, second, true); err != nil {
+		t.Fatalf("An admin cannot replace the release: %v", err)
+	}
+	if p, _ := srv.FindPackage(first.ID()); p == nil || p.Digest() != second.Digest() {
+		t.Fatalf("The release has not been replaced")
+	}
+	log, _ := ioutil.ReadFile(filepath.Join(srv.root, ReleaseLogFile))
+	if !strings.Contains(string(log), "ex/a 1.0.0 by bob") || !strings.Contains(string(log), second.Digest()) {
+		t.Errorf("The replacement has not been logged:\n%s", log)
+	}
+
+	// snapshots are meant to be replaced
+	master := installTestPackage(t, newTestRepository(t), "ex/a", "master")
+	for i := 0; i < 2; i++ {
+		if err := push(t, u, pusher, master, false); err != nil {
+			t.Errorf("Cannot push the snapshot %s: %v", master.ID(), err)
+		}
+	}
+}
+
+func TestTraversingNamesAreRejected(t *testing.T) {
+	srv := newTestRepository(t)
+	acl := NewAccessControl(filepath.Join(t.TempDir(), AccessControlFile))
+	pusher, _ := acl.Mint("alice", []Grant{{"ex", PushRight}})
+	u := newTestServer(t, srv, acl)
+
+	// a package named ex/../other/a, that lies under other
+	p := installTestPackage(t, newTestRepository(t), "ex/a", "1.0.0")
+	p.self.name = "ex/../other/a"
+	if err := p.Write(); err != nil {
+		t.Fatal(err)
+	}
+	err := push(t, u, pusher, p, false)
+	if err == nil || !strings.HasPrefix(err.Error(), strconv.Itoa(http.StatusBadRequest)) {
+		t.Errorf("Pushing %s: %v, expected %d", p.ID(), err, http.StatusBadRequest)
+	}
+	if FileExists(filepath.Join(srv.root, "other")) {
+		t.Fatalf("%s has been installed", p.ID())
+	}
+
+	installTestPackage(t, srv, "other/a", "1.0.0")
+	for _, query := range []string{
+		"fetch?n=ex/../other/a&v=1.0.0",
+		"fetch?n=/other/a&v=1.0.0",
+		"fetch?n=ex/./a&v=1.0.0",
+		"get?n=ex/a&v=1.0.0&goos=../..&goarch=x&exe=gpk",
+		"get?n=ex/a&v=1.0.0&goos=linux&goarch=amd64&exe=../../../other/a/1.0.0/.gpk",
+		"list?n=ex/a&v=1.0.0&goos=linux/..&goarch=amd64",
+	} {
+		resp, err := http.Get(u.String() + "/" + query + "&k=" + pusher.FormatURL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: %d, expected %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package gopack
 
 import (
-	"bytes"
 	"encoding/json"
 	"ericaro.net/gopack/protocol"
 	. "ericaro.net/gopack/semver"
@@ -13,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,12 +23,39 @@ const (
 
 //LocalRepository centralize operations around a directory (root), and a slice of remotes
 type LocalRepository struct {
-	root    string // absolute path to the repo, this must be a filesystem writable path.
-	remotes []protocol.Client
+	root        string // absolute path to the repo, this must be a filesystem writable path.
+	remotes     []protocol.Client
+	policies    map[string]RemotePolicy // by lower case remote name
+	trust       *TrustStore
+	jobs        int                        // max number of packages downloaded at the same time
+	progress    Progress                   // notified of downloads
+	credentials CredentialStore            // of the remote tokens, nil if they are kept in the .gpkrepository file
+	prompt      func() (string, error)     // asks for the credentials file passphrase
+	conflicts   ConflictPolicy             // of the dependency resolution
+	problems    func(err error)            // reports the dependency problems that do not stop the resolution
+	transient   map[string]string          // origin of the remotes not declared in the .gpkrepository file, by lower case name
+	shadowed    map[string]protocol.Client // the remotes replaced by a transient one, by lower case name
+	retention   int                        // builds kept for each snapshot, 0 keeps them all
+	budget      int64                      // disk space the packages may use, see Collect, 0 if there is no limit
+	leases      *leases                    // of this process
+	collecting  bool                       // the repository is being scanned by the garbage collection
 }
 
-//Write persists the LocalRepository information into it (as a .gpkrepository file)
+//Write persists the LocalRepository information into it (as a .gpkrepository file).
+// If there is a credential store, the remote tokens are saved into it, and the .gpkrepository file only references them.
 func (p LocalRepository) Write() (err error) {
+	if p.credentials != nil {
+		for _, r := range p.persistent() {
+			if s, ok := r.(*storedClient); ok && !s.resolved() {
+				continue // unchanged
+			}
+			if t := r.Token(); t != nil && len(*t) > 0 {
+				if err = p.credentials.Store(p.credentialRef(r), *t); err != nil {
+					return
+				}
+			}
+		}
+	}
 	dst := filepath.Join(p.root, GpkrepositoryFile)
 	err = JsonWriteFile(dst, &p)
 	return err
@@ -49,13 +76,131 @@ func NewLocalRepository(root string) (r *LocalRepository, err error) {
 
 	dst := filepath.Join(root, GpkrepositoryFile)
 	r = &LocalRepository{
-		root:    root,
-		remotes: make([]protocol.Client, 0),
+		root:      root,
+		remotes:   make([]protocol.Client, 0),
+		jobs:      DefaultJobs,
+		progress:  noProgress{},
+		conflicts: FailOnConflict,
+		leases:    &leases{},
+	}
+	r.credentials, _ = r.ParseCredentials(DefaultStoreSpec)
+	if err = JsonReadFile(dst, r); err != nil && !os.IsNotExist(err) { // the remotes would be erased by the next Write
+		return r, errors.New(fmt.Sprintf("Invalid local repository %s: %v", dst, err))
+	}
+	r.trust, err = ReadTrustStore(root)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Invalid trust store %s: %v", filepath.Join(root, TrustStoreFile), err))
+	}
+	return
+}
+
+//SetPassphrasePrompt sets the function asking for the passphrase of the credentials file, when the GPK_PASSPHRASE
+// environment variable is not set.
+func (r *LocalRepository) SetPassphrasePrompt(prompt func() (string, error)) {
+	r.prompt = prompt
+}
+
+func (r *LocalRepository) passphrase() (string, error) {
+	return readPassphrase(r.prompt)
+}
+
+//Credentials returns the store of the remote tokens, nil if they are kept in the .gpkrepository file
+func (r *LocalRepository) Credentials() CredentialStore {
+	return r.credentials
+}
+
+//ParseCredentials parses a credential store specification (see NewCredentialStore) for this repository
+func (r *LocalRepository) ParseCredentials(spec string) (CredentialStore, error) {
+	return NewCredentialStore(r.root, spec, r.passphrase)
+}
+
+//SetCredentials moves every remote token into store (or back into the .gpkrepository file if store is nil),
+// writes the repository, and then erases them from the previous store.
+func (r *LocalRepository) SetCredentials(store CredentialStore) (err error) {
+	previous, refs := r.credentials, make([]string, 0, len(r.remotes))
+	for i, remote := range r.remotes {
+		if s, ok := remote.(*storedClient); ok {
+			if r.remotes[i], err = s.resolve(); err != nil {
+				return
+			}
+		}
+		refs = append(refs, r.credentialRef(remote))
+	}
+	r.credentials = store
+	if err = r.Write(); err != nil {
+		r.credentials = previous
+		return
+	}
+	if previous != nil && (store == nil || previous.String() != store.String()) {
+		for _, ref := range refs {
+			if err := previous.Erase(ref); err != nil {
+				log.Printf("Cannot erase %s from the previous credential store: %v", ref, err)
+			}
+		}
 	}
-	JsonReadFile(dst, r) // those errors are escaped
 	return
 }
 
+//credentialRef returns the reference of the remote token in the credential store
+func (r *LocalRepository) credentialRef(remote protocol.Client) string {
+	if s, ok := remote.(*storedClient); ok {
+		return s.ref
+	}
+	return credentialRef(remote.Name(), remote.Path())
+}
+
+//RedactedToken returns a printable form of the remote token, that does not disclose it. A token still in the
+// credential store is not read from it.
+func (r *LocalRepository) RedactedToken(remote protocol.Client) string {
+	if s, ok := remote.(*storedClient); ok && !s.resolved() {
+		return "<" + r.credentials.String() + ">"
+	}
+	return RedactToken(remote.Token())
+}
+
+//TrustStore returns the keys trusted to sign packages in this repository
+func (r *LocalRepository) TrustStore() *TrustStore {
+	return r.trust
+}
+
+//checkSignature verifies the signature of an installed package, against the trust store
+func (r *LocalRepository) checkSignature(p *Package) (err error) {
+	sig, err := p.Signature()
+	if err != nil {
+		return
+	}
+	digest, err := DigestDir(p.InstallDir())
+	if err != nil {
+		return
+	}
+	return r.trust.Check(p.ID(), digest, sig)
+}
+
+//InstallExecutables copies the executables of the package p for the platform (GOOS_GOARCH) into dst, and returns their path.
+// They are run on this machine: whatever the strict mode, the package content must match its digest, signed by a key
+// trusted for it.
+func (r *LocalRepository) InstallExecutables(p *Package, dst, platform string) (installed []string, err error) {
+	if p.Digest() == "" {
+		return nil, errors.New(fmt.Sprintf("Package %s has no digest of its executables", p.ID()))
+	}
+	digest, err := DigestDir(p.InstallDir())
+	if err != nil {
+		return
+	}
+	if digest != p.Digest() {
+		return nil, errors.New(fmt.Sprintf("Package %s content does not match its digest", p.ID()))
+	}
+	sig, err := p.Signature()
+	if err != nil {
+		return
+	}
+	strict := &TrustStore{keys: r.trust.keys, strict: true}
+	if err = strict.Check(p.ID(), digest, sig); err != nil {
+		return
+	}
+	return p.installExecutables(dst, platform)
+}
+
 //Remotes is to get the current slice of remotes
 func (r *LocalRepository) Remotes() []protocol.Client {
 	return r.remotes
@@ -81,15 +226,105 @@ func (p *LocalRepository) RemoteAdd(remote protocol.Client) (err error) {
 			//return errors.New(fmt.Sprintf("A Remote called %s already exists", remote.Name()))
 		}
 	}
+	if renewer, ok := remote.(protocol.Renewer); ok {
+		renewer.OnRenew(p.renewed)
+	}
 	p.remotes = append(p.remotes, remote)
 	return
 }
 
+//renewals serializes the writes of renewed tokens, they can be renewed by concurrent downloads
+var renewals sync.Mutex
+
+//renewed persists the remotes once the token of one of them has been renewed
+func (p *LocalRepository) renewed() {
+	renewals.Lock()
+	defer renewals.Unlock()
+	if err := p.Write(); err != nil {
+		log.Printf("Cannot save the renewed token: %v", err)
+	}
+}
+
+//AddTransientRemote adds a remote declared outside of the .gpkrepository file, by the project or the environment:
+// origin tells where. It is never written in the .gpkrepository file.
+// A remote with the same name and URL is kept as it is, with its token. A remote with the same name and another URL
+// is kept if override is false, otherwise it is replaced for this execution only.
+func (p *LocalRepository) AddTransientRemote(name string, u url.URL, origin string, override bool) (err error) {
+	if p.transient == nil {
+		p.transient = make(map[string]string)
+		p.shadowed = make(map[string]protocol.Client)
+	}
+	lname := strings.ToLower(name)
+	for i, r := range p.remotes {
+		if !strings.EqualFold(name, r.Name()) {
+			continue
+		}
+		ru := r.Path()
+		if ru.String() == u.String() {
+			return
+		}
+		if !override {
+			log.Printf("Remote %s %s declared by %s is ignored, it is already %s", name, u.String(), origin, ru.String())
+			return
+		}
+		client, err := protocol.NewClient(name, u, nil)
+		if err != nil {
+			return err
+		}
+		if _, ok := p.transient[lname]; !ok {
+			p.shadowed[lname] = r
+		} else {
+			delete(p.policies, lname) // the policy of the transient remote it replaces
+		}
+		p.transient[lname] = origin
+		p.remotes[i] = client
+		return nil
+	}
+	client, err := protocol.NewClient(name, u, nil)
+	if err != nil {
+		return
+	}
+	p.remotes = append(p.remotes, client)
+	p.transient[lname] = origin
+	return
+}
+
+//RemoteOrigin returns where the remote has been declared, if it is not in the .gpkrepository file (see AddTransientRemote)
+func (p *LocalRepository) RemoteOrigin(name string) string {
+	return p.transient[strings.ToLower(name)]
+}
+
+//persistent returns the remotes written in the .gpkrepository file: the transient ones are skipped, or replaced by the remote they shadow.
+func (p *LocalRepository) persistent() (remotes []protocol.Client) {
+	for _, r := range p.remotes {
+		lname := strings.ToLower(r.Name())
+		if _, ok := p.transient[lname]; !ok {
+			remotes = append(remotes, r)
+		} else if shadowed, ok := p.shadowed[lname]; ok {
+			remotes = append(remotes, shadowed)
+		}
+	}
+	return
+}
+
 //RemoteRemove remove a remote from the list. Cannot fail. If there is no such remote it exit silently.
 func (p *LocalRepository) RemoteRemove(name string) (ref protocol.Client, err error) {
 	for i, r := range p.remotes {
 		if strings.EqualFold(name, r.Name()) {
 			ref = r
+			lname := strings.ToLower(r.Name())
+			delete(p.policies, lname)
+			stored := r // the remote whose token might be stored
+			if _, ok := p.transient[lname]; ok {
+				stored = p.shadowed[lname]
+				delete(p.transient, lname)
+				delete(p.shadowed, lname)
+			}
+			if p.credentials != nil && stored != nil {
+				if err := p.credentials.Erase(p.credentialRef(stored)); err != nil {
+					log.Printf("Cannot erase the token of %s: %v", r.Name(), err)
+				}
+			}
 			tmp := make([]protocol.Client, 0, len(p.remotes))
 			if i > 0 {
 				tmp = append(tmp, p.remotes[0:i]...)
@@ -105,36 +340,55 @@ func (p *LocalRepository) RemoteRemove(name string) (ref protocol.Client, err er
 }
 
 //FindPackage Look for the package identified by its PID within this local repository
+// A plain snapshot name stands for its newest build.
 func (r *LocalRepository) FindPackage(p ProjectID) (prj *Package, err error) {
+	if v := p.Version(); v.IsSnapshot() && !IsBuild(v) {
+		if builds := r.Builds(p.Name(), v); len(builds) > 0 {
+			p = *NewProjectID(p.Name(), builds[len(builds)-1])
+		}
+	}
 	relative := p.Path()
 	abs := filepath.Join(r.root, relative, GpkFile)
 	//log.Printf("Looking for %v into %v", p, abs)
 	_, err = os.Stat(abs)
 	if os.IsNotExist(err) {
 		err = errors.New(fmt.Sprintf("Package %s %s is missing.", p.Name(), p.Version().String()))
-	} else {
-		prj, err = ReadPackageFile(abs)
+	} else if prj, err = ReadPackageFile(abs); err == nil {
+		r.touch(prj)
 	}
 	return // nil possible
 }
 
 //InstallProject Creates a Package for this project, and the provided version. Copy the project content into this local repository
-func (r *LocalRepository) InstallProject(prj *Project, v Version) (p *Package) {
-
+// Releases cannot be installed twice, unless force is set: the replacement is then logged in the ReleaseLogFile.
+// A snapshot is installed as a new build (see SnapshotBuild), the oldest builds are removed according to the retention.
+func (r *LocalRepository) InstallProject(prj *Project, v Version, force bool) (p *Package, err error) {
+	if err = protocol.CheckName(prj.name); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid project name %q, it must be a clean relative path", prj.name))
+	}
 	p = &Package{
 		self:      *prj,
 		version:   v,
 		timestamp: time.Now(),
 	}
-	// computes the project relative path 
-	// computes the absolute path
-	dst := filepath.Join(r.root, p.Path())
-	//	fmt.Printf("Installing to %s %s %s\n", r.root, p.Path(), dst)
-	_, err := os.Stat(dst)
-	if !os.IsNotExist(err) { // also check for the local policy
-		os.RemoveAll(dst)
+	unlock := func() {}
+	if v.IsSnapshot() && !IsBuild(v) {
+		if unlock, err = r.lockRepository(); err != nil { // until the build is in place, no one else can pick its number
+			return nil, err
+		}
+		defer unlock()
+		p.version = SnapshotBuild(v, p.timestamp, r.Builds(prj.name, v))
+	}
+	replaced, err := r.checkOverwrite(p.ID(), force)
+	if err != nil {
+		return nil, err
+	}
+	// the project is copied in a staging directory first, then moved into place
+	staging, err := r.stagingDir()
+	if err != nil {
+		return nil, err
 	}
-	os.MkdirAll(dst, os.ModeDir|os.ModePerm) // mkdir -p
+	defer os.RemoveAll(staging)
 
 	//prepare recursive handlers
 	dirHandler := func(ldst, lsrc string) (err error) {
@@ -148,16 +402,64 @@ func (r *LocalRepository) InstallProject(prj *Project, v Version) (p *Package) {
 		return
 	}
 	//makes the copy
-	p.self.ScanProjectSrc(dst, dirHandler, fileHandler)
-	p.self.ScanBinPlatforms(dst, fileHandler)
+	p.self.ScanProjectSrc(staging, dirHandler, fileHandler)
+	p.self.ScanBinPlatforms(staging, fileHandler)
 	//walkDir(filepath.Join(dst, "src"), filepath.Join(prj.workingDir, "src"), dirHandler, fileHandler)
+	p.self.workingDir = staging
+	if err = p.Write(); err != nil { // the digest covers the .gpk file
+		return nil, err
+	}
+	if p.digest, err = DigestDir(staging); err != nil {
+		return nil, err
+	}
+	if err = p.Write(); err != nil {
+		return nil, err
+	}
+	// computes the absolute path
+	dst := filepath.Join(r.root, p.Path())
+	if err = replaceDir(dst, staging); err != nil {
+		return nil, err
+	}
 	p.self.workingDir = dst
-	p.Write()
+	if p.version.IsSnapshot() {
+		unlock()
+		r.pruneBuilds(p.Name(), p.version)
+	}
+	if replaced != nil {
+		err = r.logReplacement(replaced, p, localUser())
+	}
+	return
+}
+
+//Versions lists the versions of the package name installed in this local repository
+func (r *LocalRepository) Versions(name string) (versions []Version) {
+	dir := filepath.Join(r.root, name)
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		if fi.IsDir() && FileExists(filepath.Join(dir, fi.Name(), GpkFile)) {
+			if v, err := ParseVersion(fi.Name()); err == nil {
+				versions = append(versions, v)
+			}
+		}
+	}
 	return
 }
 
 //Search for package starting with name, and return them
 func (r *LocalRepository) Search(search string, start int) (result []protocol.PID) {
+	return r.SearchFilter(search, start, nil)
+}
+
+//SearchFilter is like Search, but only packages whose name is accepted by the filter are returned. A nil filter accepts everything.
+func (r *LocalRepository) SearchFilter(search string, start int, accept func(name string) bool) (result []protocol.PID) {
 	//fmt.Printf("q: %s start=%d\n", search, start)
 	sp := filepath.Join(r.root, search)
 	sd := filepath.Dir(sp)
@@ -166,25 +468,36 @@ func (r *LocalRepository) Search(search string, start int) (result []protocol.PI
 	results := make([]protocol.PID, M)
 	i := 0
 	handler := func(srcpath string) bool {
+		path, _ := filepath.Rel(r.root, srcpath)
+		pack := filepath.Dir(path)
+		if accept != nil && !accept(pack) {
+			return true
+		}
 		if i >= start {
-			path, _ := filepath.Rel(r.root, srcpath)
-			pack := filepath.Dir(path)
 			v, _ := ParseVersion(filepath.Base(path))
 			results[i-start] = protocol.PID{
 				Name:    pack,
 				Version: v,
 			}
+			if p, err := ReadPackageFile(filepath.Join(srcpath, GpkFile)); err == nil {
+				tm := p.Timestamp()
+				results[i-start].Timestamp = &tm
+				results[i-start].Digest = p.Digest()
+			}
 		}
 		i++
 		return i-start < M
 	}
 	PackageWalker(sd, base, handler)
-	return results[:i]
+	if i < start {
+		return results[:0]
+	}
+	return results[:i-start]
 }
 
-//ResolvePackageDependencies recursively scan a package's dependency ProjectID and tries to resolve every ProjectID into a Package object.
-//ProjectID can be seen as just a pointer, or a reference, whereas Package can be seen as real content
-// In the process of resolving PID -> Package there are two options:
+//ResolvePackageDependencies recursively scan a package's Dependency and tries to resolve every Dependency into a Package object.
+//Dependency can be seen as just a pointer, or a reference, whereas Package can be seen as real content
+// In the process of resolving Dependency -> Package there are two options:
 // offline: if offline does not use any remote to look for missing dependencies
 // update:  if online use remotes to search for a newest version of the package.
 // Note: update option does not make sens for "released" version, as they are read only, and cannot be updated.
@@ -193,147 +506,206 @@ func (r *LocalRepository) ResolvePackageDependencies(p *Package, offline, update
 	return r.ResolveDependencies(&p.self, offline, update)
 }
 
-//ResolveDependencies lookup recursively for all project dependencies
+//ResolveDependencies lookup recursively for all project dependencies.
+// For each package name, it selects the highest version that satisfies all the constraints in the dependency graph.
+// The ToolScope dependencies are resolved on their own, and listed last, see resolver.tools.
+// If there is none, the error is a *ConflictError that details every constraint, and where it comes from.
 func (r *LocalRepository) ResolveDependencies(p *Project, offline, update bool) (dependencies []*Package, err error) {
-	depMap := make(map[ProjectID]*Package)
-	dependencies = make([]*Package, 0, 10)
-	err = r.findProjectDependencies(p, r.remotes, depMap, &dependencies, offline, update)
-	return
+	return newResolver(r, offline, update).resolve(p)
 }
 
-//findProjectDependencies private recursive version
-func (r *LocalRepository) findProjectDependencies(p *Project, remotes []protocol.Client, dependencies map[ProjectID]*Package, dependencyList *[]*Package, offline, update bool) (err error) {
-	for _, d := range p.dependencies {
-		if dependencies[d] == nil { // it's a new dependencies
-			prj, err := r.FindPackage(d)
-			if !offline {
-				if err != nil { // missing dependency in local repo, search remote
-					log.Printf("Trying to download %s from remotes", d)
-					prj, err = remoteHandler(remotes, func(remote protocol.Client, suc chan *Package, fail chan error) (p *Package, err error) {
-						rprj, err := r.downloadPackage(remote, d, nil)
-						if err != nil {
-							fail <- err
-						} else {
-							suc <- rprj
-						}
-						return
-					})
-
-				} else if update {
-					if d.Version().IsSnapshot() {
-						// try to get a newer version into prjnew
-						log.Printf("Trying to download a newer version for %s", d)
-						prjnew, err := remoteHandler(remotes, func(remote protocol.Client, suc chan *Package, fail chan error) (p *Package, err error) {
-							t := prj.Timestamp()
-							rprj, err := r.downloadPackage(remote, d, &t) // always try to download updates, if there is no update it fails fast
-							if err != nil {
-								fail <- err
-							} else {
-								suc <- rprj
-							}
-							return
-						})
-						// prjnew contains a newer version (downloaded though)
-						if err == nil { //succeeded in updating 
-							prj = prjnew
-						}
-					}
-				}
-			}
-			if prj == nil {
-				return errors.New(fmt.Sprintf("Missing dependency: %v\n", d))
-			}
-			dependencies[d] = prj
-			*dependencyList = append(*dependencyList, prj)
-			err = r.findProjectDependencies(&prj.self, remotes, dependencies, dependencyList, offline, update)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return
+//Install read a package in the reader (a tar.gzed stream, with a package .gpk inside and the project content)
+// find a suitable place for it ( name/version ) and replace the content
+// The package content must match the digest declared in its .gpk, if any.
+func (r *LocalRepository) Install(reader io.Reader) (prj *Package, err error) {
+	return r.install(true, reader, origin{})
 }
 
-//remoteHandler process a function for every remote
-// it expect that
-func remoteHandler(remotes []protocol.Client, handler func(r protocol.Client, success chan *Package, failure chan error) (p *Package, err error)) (pak *Package, err error) {
-	success := make(chan *Package)
-	failure := make(chan error)
-
-	for _, rem := range remotes {
-		go handler(rem, success, failure)
-	}
-	// now collect results
-	for _ = range remotes {
-		select {
-		case pak = <-success:
-			err = nil
-			//close(success) // this is forbidden but I would like a way to leave with the first result asap
-		case err = <-failure:
-			// nothing to do // the loop will end after remotes queries
-		}
+//Receive installs a package pushed for pid. The package must be the one identified by pid, and its content must match the digest
+// and the detached signature declared by pid (unless they are empty).
+// A release can only replace an existing one if pid.Force is set, the replacement is then logged as done by "by".
+func (r *LocalRepository) Receive(pid protocol.PID, reader io.Reader, by string) (prj *Package, err error) {
+	if err = protocol.CheckName(pid.Name); err != nil {
+		return
 	}
-	return
+	return r.install(true, reader, origin{
+		source:    "the sender",
+		id:        NewProjectID(pid.Name, pid.Version),
+		digest:    pid.Digest,
+		signature: pid.Signature,
+		force:     pid.Force,
+		by:        by,
+	})
 }
 
-//downloadPackage fetch the package, and install it in the local repository
-func (r *LocalRepository) downloadPackage(remote protocol.Client, p ProjectID, timestamp *time.Time) (prj *Package, err error) {
-	log.Printf("Downloading %s from %s", p, remote.Name())
-	reader, err := remote.Fetch(protocol.PID{
-		Name:      p.Name(),
-		Version:   p.Version(),
-		Timestamp: timestamp,
-	})
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("Installing %s from %s ", p, remote.Name())
-	prj, err = r.Install(reader)
-	return
+func (r *LocalRepository) InstallAppend(reader io.Reader) (prj *Package, err error) {
+	return r.install(false, reader, origin{})
 }
 
-//Install read a package in the reader (a tar.gzed stream, with a package .gpk inside and the project content)
-// find a suitable place for it ( name/version ) and replace the content
-func (r *LocalRepository) Install(reader io.Reader) (prj *Package, err error) {
-	return r.install(true, reader)
+//ReceiveExecutables appends the executables pushed for pid. The package must be the one identified by pid, and the
+// executables the ones it has been installed with, see checkAppended.
+func (r *LocalRepository) ReceiveExecutables(pid protocol.PID, reader io.Reader) (prj *Package, err error) {
+	return r.install(false, reader, origin{source: "the sender", id: NewProjectID(pid.Name, pid.Version)})
 }
 
-func (r *LocalRepository) InstallAppend(reader io.Reader) (prj *Package, err error) {
-	return r.install(false, reader)
+//origin is what the sender of a package declares about it
+type origin struct {
+	source    string     // who declares it: a remote, the lock file
+	id        *ProjectID // the expected package, if any
+	digest    string     // the expected content digest, if any
+	signature string     // the detached signature, see Signature.Format, if any
+	force     bool       // replace the release if it already exists
+	by        string     // who is installing it, for the ReleaseLogFile
+}
+
+//accepts returns true if id is the package expected by o: its id, or a build of it if it is a plain snapshot.
+func (o origin) accepts(id ProjectID) bool {
+	if *o.id == id {
+		return true
+	}
+	v := o.id.Version()
+	return v.IsSnapshot() && !IsBuild(v) && IsBuild(id.Version()) && id.Name() == o.id.Name() && id.Version().PreRelease() == v.PreRelease()
 }
 
-func (r *LocalRepository) install(clean bool, reader io.Reader) (prj *Package, err error) {
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, reader) // download the tar.gz
-	//reader.Close()
+//install unpacks the package in reader. Unless it is appended, its content digest is checked against both the one declared by o, and the .gpk one,
+// and its signature against the trust store.
+// The package is streamed into a staging directory, and moved into place only if everything is fine.
+func (r *LocalRepository) install(clean bool, reader io.Reader, o origin) (prj *Package, err error) {
+	staging, err := r.stagingDir()
 	if err != nil {
 		return
 	}
-	mem := bytes.NewReader(buf.Bytes())
-	prj, err = ReadPackageInPackage(mem) // foretell the package object from within a buffer
+	defer os.RemoveAll(staging) // it is empty if the package has been moved into place
+
+	sums := make(map[string]string)
+	if err = unpack(staging, reader, sums); err != nil {
+		return nil, err
+	}
+	if !FileExists(filepath.Join(staging, GpkFile)) {
+		return nil, errors.New(fmt.Sprintf("Invalid package format, %v is missing", GpkFile))
+	}
+	prj, err = ReadPackageFile(filepath.Join(staging, GpkFile))
 	if err != nil {
-		//log.Printf("Cannot read package", err)
-		return
+		return nil, err
+	}
+	if err = protocol.CheckName(prj.Name()); err != nil { // it is installed under its name
+		log.Printf("%s sent the invalid package name %q", o.source, prj.Name())
+		return nil, err
+	}
+	if o.id != nil && !o.accepts(prj.ID()) {
+		log.Printf("%s declared %s but sent %s", o.source, o.id, prj.ID())
+		return nil, protocol.StatusIdentityMismatch
 	}
-	dst := filepath.Join(r.root, prj.self.name, prj.version.String())
-	_, err = os.Stat(dst)
-	if clean && !os.IsNotExist(err) {
-		os.RemoveAll(dst)
+	rewrite := prj.remote != "" // where the sender got it from is meaningless here
+	prj.remote = ""
+	var sig *Signature
+	var replaced *Package
+	unlock := func() {}
+	if clean {
+		if sums[GpkFile], err = metadataSum(prj); err != nil {
+			return nil, err
+		}
+		actual := digestSums(sums)
+		if err = checkDigest(prj.ID(), o.source, o.digest, actual); err != nil {
+			return nil, err
+		}
+		if err = checkDigest(prj.ID(), "its "+GpkFile, prj.digest, actual); err != nil {
+			return nil, err
+		}
+		if prj.digest == "" { // packages from older versions of gopack have no digest yet
+			prj.digest = actual
+			rewrite = true
+		}
+		if o.signature != "" {
+			if sig, err = ParseSignature(o.signature); err != nil {
+				return nil, err
+			}
+		}
+		if err = r.trust.Check(prj.ID(), actual, sig); err != nil {
+			return nil, err
+		}
+		if replaced, err = r.checkOverwrite(prj.ID(), o.force); err != nil {
+			return nil, err
+		}
+		if prj.version.IsSnapshot() && !IsBuild(prj.version) { // sent by an older version of gopack
+			if unlock, err = r.lockRepository(); err != nil { // until the build is in place, see InstallProject
+				return nil, err
+			}
+			defer unlock()
+			plain := prj.ID()
+			prj.version = SnapshotBuild(prj.version, time.Now(), r.Builds(prj.Name(), prj.version))
+			if sums[GpkFile], err = metadataSum(prj); err != nil {
+				return nil, err
+			}
+			prj.digest = digestSums(sums)
+			rewrite = true
+			if sig != nil { // it signs the plain snapshot, not the build
+				log.Printf("Dropping the signature of %s, it does not sign the build %s", plain, prj.ID())
+				sig = nil
+			}
+			if err = r.trust.Check(prj.ID(), prj.digest, sig); err != nil { // an unsigned build, refused in strict mode
+				return nil, err
+			}
+		}
+	}
+	if rewrite && clean {
+		if err = prj.Write(); err != nil {
+			return nil, err
+		}
+	}
+	if sig != nil {
+		if err = prj.writeSignature(sig); err != nil {
+			return nil, err
+		}
+	}
+
+	dst := filepath.Join(r.root, prj.Path())
+	if clean {
+		err = replaceDir(dst, staging)
+	} else {
+		var installed *Package
+		if installed, err = r.FindPackage(prj.ID()); err != nil { // executables of a plain snapshot go to its newest build
+			return nil, err
+		}
+		if err = checkAppended(installed, sums); err != nil {
+			return nil, err
+		}
+		os.Remove(filepath.Join(staging, GpkFile)) // the installed one is kept
+		prj, dst = installed, installed.InstallDir()
+		err = mergeDir(dst, staging)
 	}
-	err = os.MkdirAll(dst, os.ModeDir|os.ModePerm) // mkdir -p
 	if err != nil {
 		log.Printf("Cannot install package %s", err)
-		return
+		return nil, err
 	}
-
 	prj.self.workingDir = dst
-	mem = bytes.NewReader(buf.Bytes())
-	err = prj.Unpack(mem) // now I know the target I can unpack it.
-	//	if err != nil {
-	//		log.Printf("Installed with err ", err)
-	//	}
+	if clean && prj.version.IsSnapshot() {
+		unlock()
+		r.pruneBuilds(prj.Name(), prj.version)
+	}
+	if replaced != nil {
+		err = r.logReplacement(replaced, prj, o.by)
+	}
 	return
+}
 
+//checkAppended returns an error unless the files appended to the installed package p (see unpack for sums) are already
+// part of its content digest: executables are packed with the package, they cannot be replaced, or added afterwards.
+// Packages installed by older versions of gopack have no digest, anything can be appended to them.
+func checkAppended(p *Package, sums map[string]string) error {
+	if p.digest == "" {
+		return nil
+	}
+	installed, err := dirSums(p.InstallDir())
+	if err != nil {
+		return err
+	}
+	for name, sum := range sums {
+		if installed[name] != sum {
+			return errors.New(fmt.Sprintf("Cannot append %s to package %s, it is not part of its digest: executables must be installed with the package", name, p.ID()))
+		}
+	}
+	return nil
 }
 
 //GoPath computes a GOPATH string based on a slice of Packages (use os.PathListSeparator as separator)
@@ -354,25 +726,53 @@ func (r LocalRepository) Root() string {
 //UnmarshalJSON is part of the json protocol to make this object read/writable in json
 func (p *LocalRepository) UnmarshalJSON(data []byte) (err error) {
 	type RemoteFile struct {
-		Name  string
-		Url   string
-		Token string
+		Name       string
+		Url        string
+		Token      string
+		Credential string
+		Priority   int
+		MirrorOf   string
+		Prefixes   []string
 	}
 
 	type LocalRepositoryFile struct {
-		FormatVersion string
-		Remotes       []RemoteFile
+		FormatVersion     string
+		Credentials       string
+		SnapshotRetention int
+		DiskBudget        int64
+		Remotes           []RemoteFile
 	}
 	var pf LocalRepositoryFile
-	json.Unmarshal(data, &pf)
+	if err = json.Unmarshal(data, &pf); err != nil {
+		return
+	}
 	if pf.FormatVersion != GpkRepositoryFileVersion {
 		log.Printf("Warning: Unknown format version \"%s\"", pf.FormatVersion)
 	}
+	// a file written before the credential stores existed keeps its tokens in clear, see SetCredentials to move them
+	spec := pf.Credentials
+	if spec == "" {
+		spec = PlaintextStoreSpec
+	}
+	if p.credentials, err = p.ParseCredentials(spec); err != nil {
+		return
+	}
+	p.retention = pf.SnapshotRetention
+	p.budget = pf.DiskBudget
+	policies := make([]RemoteFile, 0, len(pf.Remotes))
 	for _, r := range pf.Remotes {
 		ur, err := url.Parse(r.Url)
 		if err != nil {
 			return err
 		}
+		if r.Credential != "" {
+			if p.credentials == nil {
+				return errors.New(fmt.Sprintf("The token of remote %s is in a credential store, but there is none", r.Name))
+			}
+			p.RemoteAdd(newStoredClient(r.Name, *ur, r.Credential, p.credentials))
+			policies = append(policies, r)
+			continue
+		}
 		token, err := protocol.ParseStdToken(r.Token)
 		if err != nil {
 			return err
@@ -382,36 +782,68 @@ func (p *LocalRepository) UnmarshalJSON(data []byte) (err error) {
 			continue
 		}
 		p.RemoteAdd(client)
+		policies = append(policies, r)
+	}
+	for _, r := range policies { // once every remote is known, as it might mirror a remote declared after it
+		if err := p.SetRemotePolicy(r.Name, RemotePolicy{r.Priority, r.MirrorOf, r.Prefixes}); err != nil {
+			log.Printf("Warning: invalid remote %s: %v", r.Name, err)
+		}
 	}
 	return
 }
 
 func (p *LocalRepository) MarshalJSON() ([]byte, error) {
 	type RemoteFile struct {
-		Name  string
-		Url   string
-		Token string
+		Name       string
+		Url        string
+		Token      string `json:",omitempty"`
+		Credential string `json:",omitempty"` // reference of the token in the credential store
+		Priority   int
+		MirrorOf   string
+		Prefixes   []string
 	}
 
 	type LocalRepositoryFile struct {
-		FormatVersion string
-		Remotes       []RemoteFile
+		FormatVersion     string
+		Credentials       string `json:",omitempty"`
+		SnapshotRetention int    `json:",omitempty"` // builds kept for each snapshot
+		DiskBudget        int64  `json:",omitempty"` // in bytes
+		Remotes           []RemoteFile
 	}
 
+	remotes := p.persistent()
 	pf := LocalRepositoryFile{
-		FormatVersion: GpkRepositoryFileVersion,
-		Remotes:       make([]RemoteFile, len(p.remotes)),
+		FormatVersion:     GpkRepositoryFileVersion,
+		SnapshotRetention: p.retention,
+		DiskBudget:        p.budget,
+		Remotes:           make([]RemoteFile, len(remotes)),
+	}
+	pf.Credentials = PlaintextStoreSpec
+	if p.credentials != nil {
+		pf.Credentials = p.credentials.String()
 	}
-	for i := range p.remotes {
-		pr := p.remotes[i]
+	for i := range remotes {
+		pr := remotes[i]
 		u := pr.Path()
+		policy := p.RemotePolicy(pr.Name())
 		pf.Remotes[i] = RemoteFile{
-			Name: pr.Name(),
-			Url:  u.String(),
+			Name:     pr.Name(),
+			Url:      u.String(),
+			Priority: policy.Priority,
+			MirrorOf: policy.MirrorOf,
+			Prefixes: policy.Prefixes,
+		}
+		if s, ok := pr.(*storedClient); ok && !s.resolved() {
+			pf.Remotes[i].Credential = s.ref
+			continue
 		}
 		tok := pr.Token()
-		if tok != nil {
-			pf.Remotes[i].Token = tok.FormatStd()
+		if tok != nil && len(*tok) > 0 {
+			if p.credentials != nil {
+				pf.Remotes[i].Credential = p.credentialRef(pr)
+			} else {
+				pf.Remotes[i].Token = tok.FormatStd()
+			}
 		}
 	}
 	return json.Marshal(pf)
@@ -0,0 +1,187 @@
+package gopack
+
+import (
+	"encoding/json"
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	LockFile        = ".gpk.lock"
+	LockFileVersion = "1.0.0"
+)
+
+//LockedPackage records a package selected by the resolution, with everything required to get the very same content back.
+type LockedPackage struct {
+	ID        ProjectID
+	Timestamp time.Time // the package timestamp, that changes every time a snapshot is installed
+	Remote    string    // the remote it has been downloaded from, empty if it has been installed locally
+	Digest    string    // the package content digest, see DigestDir
+}
+
+//Lock is the fully resolved dependency graph of a project, as stored in the .gpk.lock file next to the .gpk one.
+type Lock struct {
+	workingDir string
+	packages   []LockedPackage
+}
+
+//ReadLock reads the lock file of a project. If there is none, it returns a nil Lock, and no error.
+func ReadLock(p *Project) (l *Lock, err error) {
+	path := filepath.Join(p.workingDir, LockFile)
+	if !FileExists(path) {
+		return nil, nil
+	}
+	l = &Lock{}
+	err = JsonReadFile(path, l)
+	l.workingDir = p.workingDir
+	return
+}
+
+//NewLock creates the Lock of a project, from its resolved dependencies.
+func NewLock(p *Project, dependencies []*Package) (l *Lock, err error) {
+	l = &Lock{
+		workingDir: p.workingDir,
+		packages:   make([]LockedPackage, 0, len(dependencies)),
+	}
+	for _, d := range dependencies {
+		digest, err := DigestDir(d.InstallDir())
+		if err != nil {
+			return nil, err
+		}
+		l.packages = append(l.packages, LockedPackage{
+			ID:        d.ID(),
+			Timestamp: d.Timestamp(),
+			Remote:    d.Remote(),
+			Digest:    digest,
+		})
+	}
+	return
+}
+
+//Write down the lock file into the project working dir
+func (l *Lock) Write() (err error) {
+	return JsonWriteFile(filepath.Join(l.workingDir, LockFile), l)
+}
+
+//Packages returns every locked package
+func (l *Lock) Packages() []LockedPackage {
+	return l.packages[:]
+}
+
+//index the locked packages by name
+func (l *Lock) index() map[string]LockedPackage {
+	m := make(map[string]LockedPackage)
+	for _, lp := range l.packages {
+		m[lp.ID.Name()] = lp
+	}
+	return m
+}
+
+//verify checks that an installed package is still the one that has been locked
+func (lp LockedPackage) verify(p *Package) (err error) {
+	if !lp.Timestamp.Equal(p.Timestamp()) {
+		return errors.New(fmt.Sprintf("Package %s has changed since it was locked (created %s, locked %s)", lp.ID, p.Timestamp().Format(time.ANSIC), lp.Timestamp.Format(time.ANSIC)))
+	}
+	digest, err := DigestDir(p.InstallDir())
+	if err != nil {
+		return
+	}
+	if digest != lp.Digest {
+		return errors.New(fmt.Sprintf("Package %s content does not match the locked digest\n    \u21b3 found  %s\n    \u21b3 locked %s", lp.ID, digest, lp.Digest))
+	}
+	return
+}
+
+//StaleLockError is returned when the lock file does not match the project's dependencies anymore.
+type StaleLockError struct {
+	Name         string
+	Locked       *Version // nil if there is no such package in the lock file
+	Requirements []Requirement
+}
+
+//Error part of the error interface.
+func (e *StaleLockError) Error() string {
+	if e.Locked == nil {
+		return fmt.Sprintf("The lock file is out of date: %s is not locked. Run 'gpk lock -update'", e.Name)
+	}
+	msg := fmt.Sprintf("The lock file is out of date, run 'gpk lock -update': %s %s does not satisfy all constraints:", e.Name, e.Locked.String())
+	for _, q := range e.Requirements {
+		msg += fmt.Sprintf("\n        %s requires %s %s", strings.Join(q.Path, " -> "), e.Name, q.Constraint.String())
+	}
+	return msg
+}
+
+//ResolveLocked resolves the project dependencies using exactly the versions recorded in the lock file.
+// It fails if the lock file is out of date, or if a package content no longer matches what has been locked.
+func (r *LocalRepository) ResolveLocked(p *Project, l *Lock, offline bool) (dependencies []*Package, err error) {
+	s := newResolver(r, offline, false)
+	s.locked = l.index()
+	log.Printf("Resolving %s with %s", p.name, LockFile)
+	return s.resolve(p)
+}
+
+//UnmarshalJSON part of the json protocol
+func (l *Lock) UnmarshalJSON(data []byte) (err error) {
+	type LockedPackageFile struct {
+		Name, Version  string
+		Timestamp      time.Time
+		Remote, Digest string
+	}
+	type LockFile struct {
+		FormatVersion string
+		Packages      []LockedPackageFile
+	}
+	var lf LockFile
+	if err = json.Unmarshal(data, &lf); err != nil {
+		return
+	}
+	if lf.FormatVersion != LockFileVersion {
+		log.Printf("Warning: Unknown format version \"%s\"", lf.FormatVersion)
+	}
+	l.packages = make([]LockedPackage, 0, len(lf.Packages))
+	for _, lpf := range lf.Packages {
+		v, err := ParseVersion(lpf.Version)
+		if err != nil {
+			return err
+		}
+		l.packages = append(l.packages, LockedPackage{
+			ID:        *NewProjectID(lpf.Name, v),
+			Timestamp: lpf.Timestamp,
+			Remote:    lpf.Remote,
+			Digest:    lpf.Digest,
+		})
+	}
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (l *Lock) MarshalJSON() ([]byte, error) {
+	type LockedPackageFile struct {
+		Name, Version  string
+		Timestamp      time.Time
+		Remote, Digest string
+	}
+	type LockFile struct {
+		FormatVersion string
+		Packages      []LockedPackageFile
+	}
+	lf := LockFile{
+		FormatVersion: LockFileVersion,
+		Packages:      make([]LockedPackageFile, len(l.packages)),
+	}
+	for i, lp := range l.packages {
+		lf.Packages[i] = LockedPackageFile{
+			Name:      lp.ID.Name(),
+			Version:   lp.ID.Version().String(),
+			Timestamp: lp.Timestamp,
+			Remote:    lp.Remote,
+			Digest:    lp.Digest,
+		}
+	}
+	return json.Marshal(lf)
+}
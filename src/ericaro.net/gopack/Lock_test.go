@@ -0,0 +1,124 @@
+package gopack
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//lockTestProject installs ex/a 1.0.0 and 1.1.0, and ex/b 1.0.0 that requires ex/a ^1.0, and returns a project requiring ex/b ^1.0
+func lockTestProject(t *testing.T, r *LocalRepository) *Project {
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^1.0")
+	return newTestProject(t, "ex/p", "ex/b ^1.0")
+}
+
+//writeTestLock resolves the project dependencies, and writes them in its lock file
+func writeTestLock(t *testing.T, r *LocalRepository, p *Project) *Lock {
+	dependencies, err := r.ResolveDependencies(p, true, false)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	lock, err := NewLock(p, dependencies)
+	if err != nil {
+		t.Fatalf("Cannot lock: %v", err)
+	}
+	if err = lock.Write(); err != nil {
+		t.Fatalf("Cannot write the lock file: %v", err)
+	}
+	return lock
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	r := newTestRepository(t)
+	p := lockTestProject(t, r)
+	if lock, err := ReadLock(p); lock != nil || err != nil {
+		t.Fatalf("A missing lock file must be a nil lock, got %v, %v", lock, err)
+	}
+	written := writeTestLock(t, r, p)
+	read, err := ReadLock(p)
+	if err != nil {
+		t.Fatalf("Cannot read the lock file: %v", err)
+	}
+	if len(read.Packages()) != 2 || len(read.Packages()) != len(written.Packages()) {
+		t.Fatalf("Read %v, expected %v", read.Packages(), written.Packages())
+	}
+	for i, lp := range read.Packages() {
+		w := written.Packages()[i]
+		if lp.ID != w.ID || !lp.Timestamp.Equal(w.Timestamp) || lp.Remote != w.Remote || lp.Digest != w.Digest || lp.Digest == "" {
+			t.Errorf("Read %#v, expected %#v", lp, w)
+		}
+	}
+}
+
+func TestResolveLocked(t *testing.T) {
+	r := newTestRepository(t)
+	p := lockTestProject(t, r)
+	lock := writeTestLock(t, r, p)
+	installTestPackage(t, r, "ex/a", "1.1.0")
+
+	dependencies, err := r.ResolveDependencies(p, true, false)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	if ids := strings.Join(resolved(dependencies), ", "); ids != "ex/b 1.0.0, ex/a 1.1.0" {
+		t.Errorf("Resolved %s, expected the newest ex/a", ids)
+	}
+	dependencies, err = r.ResolveLocked(p, lock, true)
+	if err != nil {
+		t.Fatalf("Cannot resolve with the lock: %v", err)
+	}
+	if ids := strings.Join(resolved(dependencies), ", "); ids != "ex/b 1.0.0, ex/a 1.0.0" {
+		t.Errorf("Resolved %s with the lock, expected the locked ex/a 1.0.0", ids)
+	}
+}
+
+func TestResolveStaleLock(t *testing.T) {
+	r := newTestRepository(t)
+	p := lockTestProject(t, r)
+	lock := writeTestLock(t, r, p)
+	installTestPackage(t, r, "ex/a", "1.1.0")
+	installTestPackage(t, r, "ex/c", "1.0.0")
+
+	cases := []struct {
+		dependencies []string
+		name         string
+		locked       string // empty if it is not locked
+	}{
+		{[]string{"ex/b ^1.0", "ex/a ^1.1"}, "ex/a", "1.0.0"},
+		{[]string{"ex/b ^1.0", "ex/c ^1.0"}, "ex/c", ""},
+	}
+	for _, c := range cases {
+		changed := newTestProject(t, "ex/p", c.dependencies...)
+		_, err := r.ResolveLocked(changed, lock, true)
+		stale, ok := err.(*StaleLockError)
+		if !ok {
+			t.Errorf("%v: expected a *StaleLockError, got %v", c.dependencies, err)
+			continue
+		}
+		locked := ""
+		if stale.Locked != nil {
+			locked = stale.Locked.String()
+		}
+		if stale.Name != c.name || locked != c.locked {
+			t.Errorf("%v: unexpected stale lock %v", c.dependencies, stale)
+		}
+	}
+}
+
+func TestResolveLockedChangedContent(t *testing.T) {
+	r := newTestRepository(t)
+	p := lockTestProject(t, r)
+	lock := writeTestLock(t, r, p)
+	a, err := r.FindPackage(lock.Packages()[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(a.InstallDir(), "src", "ex", "a", "doc.go"), []byte("package a // changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ResolveLocked(p, lock, true); err == nil || !strings.Contains(err.Error(), "locked digest") {
+		t.Errorf("The changed content of ex/a has been accepted: %v", err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"time"
 )
@@ -23,8 +25,10 @@ type Package struct {
 	self      Project
 	version   Version
 	timestamp time.Time
+	remote    string // the remote it has been downloaded from, if any
+	digest    string // the digest of the package content, including its .gpk and executables, see DigestDir
 
-	// more to come, like sha1,signature, snapshot/release
+	// more to come, like signature, snapshot/release
 	// add also go1 , i.e the target go runtime.
 
 }
@@ -42,6 +46,16 @@ func (p *Package) Timestamp() time.Time {
 	return p.timestamp
 }
 
+//Remote returns the name of the remote this package has been downloaded from. It is empty if it has been installed locally.
+func (p *Package) Remote() string {
+	return p.remote
+}
+
+//Digest returns the digest of the package content, computed when it was installed. It is empty for packages installed by older versions of gopack.
+func (p *Package) Digest() string {
+	return p.digest
+}
+
 //Write down package info into this package InstallDir
 func (p *Package) Write() (err error) {
 	dst := filepath.Join(p.self.workingDir, GpkFile)
@@ -65,10 +79,40 @@ func (p *Package) License() License {
 }
 
 //Dependencies return the list of dependencies declared in this package's project
-func (p *Package) Dependencies() []ProjectID {
+func (p *Package) Dependencies() []Dependency {
 	return p.self.Dependencies()
 }
 
+//installExecutables copies this package executables for the platform (GOOS_GOARCH) into dst, and returns their path.
+// They are not checked, see LocalRepository.InstallExecutables.
+func (p *Package) installExecutables(dst, platform string) (installed []string, err error) {
+	src := filepath.Join(p.self.workingDir, "bin", platform)
+	files, err := ioutil.ReadDir(src)
+	if os.IsNotExist(err) { // no executables for this platform
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(dst, os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		exe := filepath.Join(dst, fi.Name())
+		if _, err = CopyFile(exe, filepath.Join(src, fi.Name())); err != nil {
+			return
+		}
+		if err = os.Chmod(exe, 0755); err != nil { // the copy in the repository has lost its mode
+			return
+		}
+		installed = append(installed, exe)
+	}
+	return
+}
+
 //Version this package semantic version
 func (p *Package) Version() Version {
 	return p.version
@@ -129,6 +173,25 @@ func (p *Package) Pack(w io.Writer) (err error) {
 func (p *Package) PackExecutables(w io.Writer) (err error) {
 	return p.packType(PACK_EXEC, w)
 }
+//PackReader returns the Pack stream of this Package. It is packed on the fly, while being read, so it must be closed.
+func (p *Package) PackReader() io.ReadCloser {
+	return packPipe(p.Pack)
+}
+
+//PackExecutablesReader returns the PackExecutables stream of this Package. It is packed on the fly, while being read, so it must be closed.
+func (p *Package) PackExecutablesReader() io.ReadCloser {
+	return packPipe(p.PackExecutables)
+}
+
+//packPipe runs pack in the background, writing into a pipe
+func packPipe(pack func(w io.Writer) error) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(pack(w))
+	}()
+	return r
+}
+
 const (
 	PACK_SRC  = iota
 	PACK_EXEC = iota
@@ -137,15 +200,13 @@ const (
 
 )
 
+//packType writes the package archive of typ into w. Any error is returned, the archive is then incomplete.
 func (p *Package) packType(typ int, w io.Writer) (err error) {
 	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
 	if err != nil {
 		return
 	}
-
 	tw := tar.NewWriter(gz)
-	defer gz.Close()
-	defer tw.Close()
 
 	//prepare recursive handlers
 	dirHandler := func(ldst, lsrc string) (err error) {
@@ -156,16 +217,23 @@ func (p *Package) packType(typ int, w io.Writer) (err error) {
 		return
 	}
 	// same remark as the "install" function
-	switch typ {
-
-	case PACK_SRC:
-		p.self.ScanProjectSrc("", dirHandler, fileHandler)
-	case PACK_EXEC:
-		p.self.ScanBinPlatforms("", fileHandler)
+	hasSrc, hasBin := FileExists(filepath.Join(p.self.workingDir, "src")), FileExists(filepath.Join(p.self.workingDir, "bin"))
+	if typ == PACK_SRC && hasSrc { // the whole package, as it is digested
+		err = p.self.ScanProjectSrc("", dirHandler, fileHandler)
+	}
+	if err == nil && hasBin {
+		err = p.self.ScanBinPlatforms("", fileHandler)
 	}
-	//walkDir("src", filepath.Join(p.self.workingDir, "src"), dirHandler, fileHandler)
 	// copy the package .gpk
-	TarFile(filepath.Join("", GpkFile), filepath.Join(p.self.workingDir, GpkFile), tw)
+	if err == nil {
+		err = TarFile(filepath.Join("", GpkFile), filepath.Join(p.self.workingDir, GpkFile), tw)
+	}
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
 	return
 }
 
@@ -175,12 +243,16 @@ func (p *Package) UnmarshalJSON(data []byte) (err error) {
 		Self      *Project
 		Version   string
 		Timestamp time.Time
+		Remote    string
+		Digest    string
 	}
 	var pf PackageFile
 	json.Unmarshal(data, &pf)
 
 	p.self = *pf.Self
 	p.timestamp = pf.Timestamp
+	p.remote = pf.Remote
+	p.digest = pf.Digest
 	v, _ := ParseVersion(pf.Version)
 	p.version = v
 	return
@@ -192,11 +264,15 @@ func (p *Package) MarshalJSON() ([]byte, error) {
 		Self      *Project
 		Version   string
 		Timestamp time.Time
+		Remote    string
+		Digest    string
 	}
 	pf := PackageFile{
 		Self:      &p.self,
 		Timestamp: p.timestamp,
 		Version:   p.version.String(),
+		Remote:    p.remote,
+		Digest:    p.digest,
 	}
 	return json.Marshal(pf)
 }
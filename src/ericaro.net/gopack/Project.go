@@ -8,24 +8,25 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	//"strings"
+	"strings"
 )
 
 const (
-	GpkFileVersion = "1.0.0"
+	GpkFileVersion = "1.1.0" // 1.1.0 adds the dependency scopes
+	ToolsDir       = "tools" // in the project working dir, where the ToolScope executables are installed
 )
 
 //Project is a Go Project, plus some metadata:
 // a workingDir that must be layouted as a standard go project (a src, bin, pkg directory)
 // a unique name
-// a list of dependency references (name, version)
+// a list of dependency references (name, version constraint)
 // and a license for the source code. This is required because we cannot move around licenses if we aren't allowed to.
 type Project struct {
-	workingDir   string      // transient workding directory aboslute path
-	name         string      // package name
-	dependencies []ProjectID // contains the current project's dependencies
-	license      License     // one of the predefined licenses
-	// TO be added build time , and test dependencies
+	workingDir   string           // transient workding directory aboslute path
+	name         string           // package name
+	dependencies []Dependency     // contains the current project's dependencies
+	license      License          // one of the predefined licenses
+	remotes      []DeclaredRemote // remotes the dependencies can be fetched from, merged with the user's ones
 }
 
 //ReadProject read project from the current dir, or parent's one (recursively)
@@ -61,6 +62,11 @@ func (p *Project) WorkingDir() string {
 	return p.workingDir
 }
 
+//ToolsDir the directory containing the executables of the ToolScope dependencies
+func (p *Project) ToolsDir() string {
+	return filepath.Join(p.workingDir, ToolsDir)
+}
+
 //Name the project unique name, it must be the package name.
 func (p *Project) Name() string {
 	return p.name
@@ -85,28 +91,27 @@ func (p *Project) SetLicense(license License) {
 	p.license = license
 }
 
-//Dependencies is a slice of ProjectID used by this project. Caveat this is not the whole dependency tree, just the root dependencies
-func (p *Project) Dependencies() []ProjectID {
+//Dependencies is a slice of Dependency used by this project. Caveat this is not the whole dependency tree, just the root dependencies
+func (p *Project) Dependencies() []Dependency {
 	return p.dependencies[:]
 }
 
 //AppendDependency append some root dependencies
-func (p *Project) AppendDependency(ref ProjectID) (rem *ProjectID) {
-	//BUG: check that the new dependencies does not "replace" existing one (there shall be only one dependency per package name
-	rem = p.RemoveDependency(ref.Name()) // first remove it
+func (p *Project) AppendDependency(ref Dependency) (rem *Dependency) {
+	rem = p.RemoveDependency(ref.Name()) // first remove it, there shall be only one dependency per package name
 	p.dependencies = append(p.dependencies, ref)
 	return
 }
 
 //RemoveDependency removes the dependency by name, and return the removed reference
-func (p *Project) RemoveDependency(name string) (ref *ProjectID) {
+func (p *Project) RemoveDependency(name string) (ref *Dependency) {
 	src := p.dependencies
 	// first compute the dependencies to be removed (yes accidentally there might be more than one
 	is := make([]int, 0, len(src))
 	for i, r := range src {
 		if r.Name() == name {
 			is = append(is, i)
-			ref = NewProjectID(name, r.Version())
+			ref = NewScopedDependency(name, r.Constraint(), r.Scope())
 		}
 	}
 	length := len(is)
@@ -116,7 +121,7 @@ func (p *Project) RemoveDependency(name string) (ref *ProjectID) {
 	// now apply the removal, unfortunately, I don't how to make it easier
 
 	// I create a new slice of project id
-	dep := make([]ProjectID, 0, len(src)-length)
+	dep := make([]Dependency, 0, len(src)-length)
 	// and copy all but the removed
 	if is[0] > 0 {
 		dep = append(dep, src[0:is[0]]...)
@@ -137,6 +142,37 @@ func (p *Project) RemoveDependency(name string) (ref *ProjectID) {
 	return
 }
 
+//DeclaredRemote is a remote declared by a project, for everyone building it: it has no credentials.
+type DeclaredRemote struct {
+	Name string
+	Url  string
+}
+
+//Remotes returns the remotes declared by this project
+func (p *Project) Remotes() []DeclaredRemote {
+	return p.remotes[:]
+}
+
+//AddRemote declares a remote, it replaces the one with the same name, if any.
+func (p *Project) AddRemote(name, u string) {
+	p.RemoveRemote(name)
+	p.remotes = append(p.remotes, DeclaredRemote{Name: name, Url: u})
+}
+
+//RemoveRemote removes the remote declared with that name, and returns true if there was one
+func (p *Project) RemoveRemote(name string) (removed bool) {
+	remotes := make([]DeclaredRemote, 0, len(p.remotes))
+	for _, r := range p.remotes {
+		if strings.EqualFold(r.Name, name) {
+			removed = true
+		} else {
+			remotes = append(remotes, r)
+		}
+	}
+	p.remotes = remotes
+	return
+}
+
 //ScanProjectSrc recursively walk into src directory  and fire callbacks to dirHandler, and fileHandler
 // dirHandler is called on every directory dst beeing a join between the path passed at first, and the relative path to the current directory, and src and absolute one. 
 // fileHandler is called on every source file. For now source files are just .go files
@@ -195,6 +231,7 @@ func (p *Project) ScanBinPlatforms(dst string, srcHandler func(dst, src string)
 		return err
 	}
 	files, err := file.Readdir(-1)
+	file.Close()
 	if err != nil {
 		return err
 	}
@@ -213,14 +250,17 @@ func (p *Project) ScanBinPlatforms(dst string, srcHandler func(dst, src string)
 			// this is a platform actually
 			ndst, nsrc := filepath.Join(dst, fi.Name()), filepath.Join(src, fi.Name())
 			nfile, err := os.Open(nsrc)
+			if err != nil {
+				return err
+			}
 			nfiles, err := nfile.Readdir(-1)
+			nfile.Close()
 			if err != nil {
 				return err
 			}
-			scanBinPlatform(ndst, nsrc, nfiles, srcHandler)
-		}
-		if err != nil {
-			return err
+			if err = scanBinPlatform(ndst, nsrc, nfiles, srcHandler); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -305,17 +345,23 @@ func (p *Project) UnmarshalJSON(data []byte) (err error) {
 	type ProjectFile struct { // TODO append a version number to make it possible to handle "format upgrade"
 		FormatVersion string
 		Name          string
-		Dependencies  []ProjectID
-		License       string // one of the value in the restricted list
+		Dependencies  []Dependency
+		License       string           // one of the value in the restricted list
+		Remotes       []DeclaredRemote `json:",omitempty"`
 	}
 	var pf ProjectFile
 	json.Unmarshal(data, &pf)
-	if pf.FormatVersion != GpkFileVersion {
+	switch pf.FormatVersion {
+	case GpkFileVersion:
+	case "1.0.0": // every dependency is in the CompileScope, the file is upgraded the next time it is written
+		log.Printf("Migrating %s from format version %s to %s", pf.Name, pf.FormatVersion, GpkFileVersion)
+	default:
 		log.Printf("Warning: Unknown format version \"%s\"", pf.FormatVersion)
 	}
 
 	p.name = pf.Name
 	p.dependencies = pf.Dependencies
+	p.remotes = pf.Remotes
 
 	if l, e := Licenses.Get(pf.License); e != nil {
 		err = errors.New(fmt.Sprintf(`Illegal license: "%s" was expecting one of: %s`, pf.License, Licenses))
@@ -330,14 +376,16 @@ func (p *Project) MarshalJSON() ([]byte, error) {
 	type ProjectFile struct { // TODO append a version number to make it possible to handle "format upgrade"
 		FormatVersion string
 		Name          string
-		Dependencies  []ProjectID
-		License       string // one of the value in the restricted list
+		Dependencies  []Dependency
+		License       string           // one of the value in the restricted list
+		Remotes       []DeclaredRemote `json:",omitempty"`
 	}
 	pf := ProjectFile{
 		FormatVersion: GpkFileVersion,
 		Name:          p.name,
 		Dependencies:  p.dependencies,
 		License:       p.license.FullName,
+		Remotes:       p.remotes,
 	}
 	return json.Marshal(pf)
 }
@@ -5,6 +5,7 @@ import (
 	. "ericaro.net/gopack/semver"
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 //ProjectID is a simple symbolic reference to a Package, made of a (name, version)
@@ -18,6 +19,13 @@ func NewProjectID(name string, version Version) *ProjectID {
 	return &ProjectID{name: name, version: version}
 }
 
+//hasNamePrefix returns true if the package name is prefix, or lies under it: "ex" covers "ex" and "ex/a", but not "example".
+// The empty prefix covers every name.
+func hasNamePrefix(name, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
 //Name the name of the package this ProjectID references
 func (p *ProjectID) Name() string {
 	return p.name
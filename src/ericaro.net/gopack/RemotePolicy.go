@@ -0,0 +1,170 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//ProjectRemotePriority is the priority of the remotes declared by a project: they are tried after the remotes of the
+// local repository that keep the default priority.
+const ProjectRemotePriority = 100
+
+//RemotePolicy tells which packages are resolved from a remote, and in which order the remotes are tried.
+type RemotePolicy struct {
+	Priority int      // remotes with the lowest priority are tried first, 0 by default
+	MirrorOf string   // the name of the remote it mirrors, if any. A mirror has its priority and prefixes, and is tried before it.
+	Prefixes []string // if not empty, only the packages whose name starts with one of them are resolved from the remote
+}
+
+//ParsePrefixes reads a comma separated list of package name prefixes. A trailing "*" is ignored: "corp.example.com/*" is the prefix "corp.example.com/".
+func ParsePrefixes(list string) (prefixes []string) {
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSuffix(strings.TrimSpace(p), "*")
+		if p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return
+}
+
+//String pretty prints the policy
+func (p RemotePolicy) String() string {
+	if p.MirrorOf != "" {
+		return "mirror of " + p.MirrorOf
+	}
+	s := fmt.Sprintf("priority %d", p.Priority)
+	if len(p.Prefixes) > 0 {
+		s += ", only " + strings.Join(p.Prefixes, " ")
+	}
+	return s
+}
+
+//RemotePolicy returns the policy of the remote name
+func (r *LocalRepository) RemotePolicy(name string) RemotePolicy {
+	return r.policies[strings.ToLower(name)]
+}
+
+//SetRemotePolicy sets the policy of the remote name. A remote can only mirror another existing remote, that is not a mirror itself.
+func (r *LocalRepository) SetRemotePolicy(name string, p RemotePolicy) (err error) {
+	if p.MirrorOf != "" {
+		if strings.EqualFold(p.MirrorOf, name) {
+			return errors.New(fmt.Sprintf("Remote %s cannot mirror itself", name))
+		}
+		if _, err = r.Remote(p.MirrorOf); err != nil {
+			return errors.New(fmt.Sprintf("Cannot mirror %s: %v", p.MirrorOf, err))
+		}
+		if r.RemotePolicy(p.MirrorOf).MirrorOf != "" {
+			return errors.New(fmt.Sprintf("Cannot mirror %s, it is already a mirror of %s", p.MirrorOf, r.RemotePolicy(p.MirrorOf).MirrorOf))
+		}
+	}
+	if r.policies == nil {
+		r.policies = make(map[string]RemotePolicy)
+	}
+	r.policies[strings.ToLower(name)] = p
+	return
+}
+
+//effectivePolicy is the policy of the remote name, or the one of the remote it mirrors
+func (r *LocalRepository) effectivePolicy(name string) (p RemotePolicy, mirror bool) {
+	p = r.RemotePolicy(name)
+	if p.MirrorOf == "" {
+		return p, false
+	}
+	if _, err := r.Remote(p.MirrorOf); err != nil { // the mirrored remote has been removed, it is on its own
+		return p, false
+	}
+	return r.RemotePolicy(p.MirrorOf), true
+}
+
+//rankedRemote is a remote, and where it stands in the remotes order
+type rankedRemote struct {
+	client protocol.Client
+	policy RemotePolicy // the effective policy
+	mirror bool
+	origin int // index of the remote it mirrors, or its own index
+	index  int
+}
+
+type rankedRemotes []rankedRemote
+
+func (s rankedRemotes) Len() int      { return len(s) }
+func (s rankedRemotes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rankedRemotes) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	switch {
+	case a.policy.Priority != b.policy.Priority:
+		return a.policy.Priority < b.policy.Priority
+	case a.origin != b.origin:
+		return a.origin < b.origin
+	case a.mirror != b.mirror:
+		return a.mirror
+	}
+	return a.index < b.index
+}
+
+//ranked returns the remotes in the order they are tried
+func (r *LocalRepository) ranked() rankedRemotes {
+	ranked := make(rankedRemotes, 0, len(r.remotes))
+	for i, c := range r.remotes {
+		p, mirror := r.effectivePolicy(c.Name())
+		rr := rankedRemote{client: c, policy: p, mirror: mirror, origin: i, index: i}
+		if mirror {
+			for j, o := range r.remotes {
+				if strings.EqualFold(o.Name(), r.RemotePolicy(c.Name()).MirrorOf) {
+					rr.origin = j
+				}
+			}
+		}
+		ranked = append(ranked, rr)
+	}
+	sort.Stable(ranked)
+	return ranked
+}
+
+//OrderedRemotes lists the remotes in the order they are tried: by priority, mirrors first, and then in the order they have been added.
+func (r *LocalRepository) OrderedRemotes() []protocol.Client {
+	clients := make([]protocol.Client, 0, len(r.remotes))
+	for _, rr := range r.ranked() {
+		clients = append(clients, rr.client)
+	}
+	return clients
+}
+
+//claim is the length of the longest prefix of p that matches name: 0 if p has no prefixes, and -1 if none matches.
+// Prefixes match on a path boundary, "corp" matches "corp/a" but not "corporate/a".
+func claim(p RemotePolicy, name string) (length int) {
+	if len(p.Prefixes) == 0 {
+		return 0
+	}
+	length = -1
+	for _, prefix := range p.Prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if hasNamePrefix(name, prefix) && len(prefix) > length {
+			length = len(prefix)
+		}
+	}
+	return
+}
+
+//remotesFor returns the remotes allowed to resolve the package name, in the order they are tried (see OrderedRemotes):
+// the next one is only tried if the previous ones do not have the package.
+// Remotes restricted to a prefix of name take precedence over every other one: only the ones with the longest matching prefix are allowed.
+// So a package name under a prefix is never resolved from a remote that is not restricted to it.
+func (r *LocalRepository) remotesFor(name string) (remotes []protocol.Client) {
+	ranked := r.ranked()
+	longest := 0
+	for _, rr := range ranked {
+		if c := claim(rr.policy, name); c > longest {
+			longest = c
+		}
+	}
+	for _, rr := range ranked {
+		if claim(rr.policy, name) == longest {
+			remotes = append(remotes, rr.client)
+		}
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//addFileRemote adds a file remote name to r, and returns the repository it serves
+func addFileRemote(t *testing.T, r *LocalRepository, name string) *LocalRepository {
+	served := newTestRepository(t)
+	remote, err := NewFileClient(name, url.URL{Scheme: "file", Path: served.root}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.remotes = append(r.remotes, remote)
+	return served
+}
+
+//names lists the remotes names
+func names(remotes []protocol.Client) string {
+	var n []string
+	for _, r := range remotes {
+		n = append(n, r.Name())
+	}
+	return strings.Join(n, " ")
+}
+
+func TestRemotesFor(t *testing.T) {
+	r := newTestRepository(t)
+	for _, name := range []string{"a", "b", "c", "m", "internal", "team"} {
+		addFileRemote(t, r, name)
+	}
+	r.SetRemotePolicy("c", RemotePolicy{Priority: -1})
+	r.SetRemotePolicy("m", RemotePolicy{MirrorOf: "b"})
+	r.SetRemotePolicy("internal", RemotePolicy{Prefixes: []string{"corp"}})
+	r.SetRemotePolicy("team", RemotePolicy{Prefixes: []string{"corp/team/"}})
+
+	if ordered := names(r.OrderedRemotes()); ordered != "c a m b internal team" {
+		t.Errorf("Remotes ordered %s", ordered)
+	}
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"ex/a", "c a m b"},
+		{"corp", "internal"},
+		{"corp/a", "internal"},
+		{"corporate/a", "c a m b"},
+		{"corp/team/a", "team"},
+		{"corp/teams/a", "internal"},
+	}
+	for _, c := range cases {
+		for i := 0; i < 5; i++ { // it is not a race
+			if remotes := names(r.remotesFor(c.name)); remotes != c.expected {
+				t.Errorf("%s is resolved from %s, expected %s", c.name, remotes, c.expected)
+			}
+		}
+	}
+}
+
+func TestDownloadFromFirstRemote(t *testing.T) {
+	r := newTestRepository(t)
+	first := addFileRemote(t, r, "first")
+	second := addFileRemote(t, r, "second")
+	installTestPackage(t, second, "ex/a", "1.0.0", "ex/b ^1.0")
+	expected := installTestPackage(t, first, "ex/a", "1.0.0")
+	p, err := r.download(expected.ID(), nil, "")
+	if err != nil {
+		t.Fatalf("Cannot download %s: %v", expected.ID(), err)
+	}
+	if p.Digest() != expected.Digest() || p.remote != "first" {
+		t.Errorf("%s has been downloaded from %s, expected first", p.ID(), p.remote)
+	}
+
+	// the remotes that do not have it are skipped
+	only := installTestPackage(t, second, "ex/c", "1.0.0")
+	if p, err = r.download(only.ID(), nil, ""); err != nil || p.remote != "second" {
+		t.Errorf("%s has not been downloaded from second: %v", only.ID(), err)
+	}
+}
+
+func TestAvailableFromFirstRemote(t *testing.T) {
+	r := newTestRepository(t)
+	first := addFileRemote(t, r, "first")
+	second := addFileRemote(t, r, "second")
+	installTestPackage(t, r, "ex/a", "0.9.0")
+	installTestPackage(t, first, "ex/a", "1.0.0")
+	installTestPackage(t, second, "ex/a", "1.0.0")
+	installTestPackage(t, second, "ex/a", "2.0.0")
+	installTestPackage(t, second, "ex/b", "1.0.0")
+
+	s := newResolver(r, false, false)
+	var versions []string
+	for _, v := range s.available("ex/a") {
+		versions = append(versions, v.String())
+	}
+	if strings.Join(versions, " ") != "1.0.0 0.9.0" {
+		t.Errorf("Available ex/a %v, expected the local ones, and the first remote ones", versions)
+	}
+	if b := s.available("ex/b"); len(b) != 1 {
+		t.Errorf("Available ex/b %v, expected the second remote ones", b)
+	}
+}
@@ -0,0 +1,513 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//resolution is an iterative process: selecting a version can bring new constraints that invalidate a previous selection.
+// We give up after that number of rounds.
+const maxResolveRounds = 20
+
+//Requirement is a constraint put on a package name by one of the packages in the dependency graph.
+type Requirement struct {
+	Constraint Constraint
+	Path       []string // the chain of packages, starting with the root project, that declares this requirement
+}
+
+//String pretty prints the requirement, and the chain that lead to it.
+func (q Requirement) String() string {
+	return fmt.Sprintf("%s requires %s", strings.Join(q.Path, " -> "), q.Constraint.String())
+}
+
+//ConflictError is returned when there is no version of a package that satisfies every requirement on it.
+// Unless the conflict policy is to fail, a version is selected anyway: it is then only reported.
+type ConflictError struct {
+	Name         string
+	Requirements []Requirement
+	Selected     *Version // by the conflict policy, nil if the resolution failed
+}
+
+//Error part of the error interface.
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("No version of %s satisfies all constraints:", e.Name)
+	for _, q := range e.Requirements {
+		msg += fmt.Sprintf("\n        %s requires %s %s", strings.Join(q.Path, " -> "), e.Name, q.Constraint.String())
+	}
+	if e.Selected != nil {
+		msg += fmt.Sprintf("\n        %s %s has been selected", e.Name, e.Selected.String())
+	}
+	return msg
+}
+
+//CycleError reports a package that depends, directly or not, on itself.
+type CycleError struct {
+	Name string
+	Path []string // from the root project, the last element is the dependency on Name that closes the cycle
+	Self bool     // Name depends directly on itself
+}
+
+//Error part of the error interface.
+func (e *CycleError) Error() string {
+	if e.Self {
+		return fmt.Sprintf("Self dependency of %s: %s", e.Name, strings.Join(e.Path, " -> "))
+	}
+	return fmt.Sprintf("Cyclic dependency on %s: %s", e.Name, strings.Join(e.Path, " -> "))
+}
+
+//ConflictPolicy decides what the resolution does with conflicting versions of a package.
+// Cycles are only reported, whatever the policy: the packages on a cycle are still resolved once.
+type ConflictPolicy string
+
+const (
+	//FailOnConflict makes the resolution fail on the first conflict
+	FailOnConflict ConflictPolicy = "fail"
+	//NewestOnConflict selects the newest version required by one of the conflicting requirements, problems are only reported
+	NewestOnConflict ConflictPolicy = "newest"
+	//NearestOnConflict selects the version required by the requirement nearest to the root project, problems are only reported
+	NearestOnConflict ConflictPolicy = "nearest"
+)
+
+//ParseConflictPolicy reads one of the policy names
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case FailOnConflict, NewestOnConflict, NearestOnConflict:
+		return p, nil
+	}
+	return FailOnConflict, errors.New(fmt.Sprintf("Unknown conflict policy %q, expecting %s, %s or %s", s, FailOnConflict, NewestOnConflict, NearestOnConflict))
+}
+
+//SetConflictPolicy sets the policy used to resolve dependencies, FailOnConflict by default
+func (r *LocalRepository) SetConflictPolicy(policy ConflictPolicy) {
+	r.conflicts = policy
+}
+
+//OnProblem sets the function called with the dependency problems that did not stop the resolution: the conflicts settled
+// by the conflict policy, and the cycles.
+func (r *LocalRepository) OnProblem(report func(err error)) {
+	r.problems = report
+}
+
+//resolver computes the packages required by a project. It picks, for each package name, the highest version
+// that satisfies every constraint in the whole graph.
+type resolver struct {
+	repo     *LocalRepository
+	offline  bool                     // do not use remotes
+	update   bool                     // look for newer snapshots on remotes
+	versions map[string]Versions      // cache of available versions per name, newest first
+	packages map[ProjectID]*Package   // cache of the packages already read, or downloaded
+	locked   map[string]LockedPackage // if not nil, the only versions allowed, indexed by name
+	settled  map[string]Version       // selected by the conflict policy
+	mutex    sync.Mutex               // protects packages, fetched concurrently
+}
+
+func newResolver(r *LocalRepository, offline, update bool) *resolver {
+	return &resolver{
+		repo:     r,
+		offline:  offline,
+		update:   update,
+		versions: make(map[string]Versions),
+		packages: make(map[ProjectID]*Package),
+		settled:  make(map[string]Version),
+	}
+}
+
+//node is a package waiting to be visited in the graph walk
+type node struct {
+	path         []string
+	dependencies []Dependency
+}
+
+//resolve returns every package required by p, in the order they have been discovered (breadth first), and then its tools.
+func (s *resolver) resolve(p *Project) (dependencies []*Package, err error) {
+	product := *p // the tools are resolved on their own
+	product.dependencies = FilterScope(p.dependencies, CompileScope, TestScope)
+	if dependencies, err = s.resolveProduct(&product); err != nil {
+		return
+	}
+	tools, err := s.tools(p, dependencies)
+	if err != nil {
+		return nil, err
+	}
+	return append(dependencies, tools...), nil
+}
+
+//resolveProduct returns every package required by p, in the order they have been discovered (breadth first).
+func (s *resolver) resolveProduct(p *Project) (dependencies []*Package, err error) {
+	selected := make(map[string]Version)
+	for round := 0; round < maxResolveRounds; round++ {
+		order, requirements, err := s.walk(p, selected)
+		if err != nil {
+			return nil, err
+		}
+		// requirements discovered late in the walk might invalidate earlier selections
+		stable := true
+		for _, name := range order {
+			if satisfies(selected[name], requirements[name]) {
+				continue
+			}
+			v, err := s.choose(name, requirements[name])
+			if err != nil {
+				return nil, err
+			}
+			if v.String() == selected[name].String() { // a conflict already settled by the policy
+				continue
+			}
+			log.Printf("Selecting %s %s instead of %s", name, v, selected[name])
+			selected[name] = v
+			stable = false
+		}
+		if stable {
+			dependencies = make([]*Package, 0, len(order))
+			for _, name := range order {
+				dependencies = append(dependencies, s.packages[*NewProjectID(name, selected[name])])
+			}
+			if err = s.check(p, dependencies, order, requirements); err != nil {
+				return nil, err
+			}
+			return dependencies, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Cannot find a stable set of dependencies after %d attempts", maxResolveRounds))
+}
+
+//tools resolves the ToolScope dependencies of p, on their own: their executables are installed as they are built, so their
+// dependencies are not resolved, and they cannot change the versions the project compiles against. A tool the project
+// also compiles against must be the same version, it is then only listed once.
+func (s *resolver) tools(p *Project, product []*Package) (tools []*Package, err error) {
+	selected := make(map[string]*Package)
+	for _, d := range product {
+		selected[d.Name()] = d
+	}
+	ids := make([]ProjectID, 0)
+	for _, d := range FilterScope(p.dependencies, ToolScope) {
+		v, err := s.choose(d.name, []Requirement{{d.constraint, []string{p.name}}})
+		if err != nil {
+			return nil, err
+		}
+		if pkg, ok := selected[d.name]; ok {
+			if pkg.Version().String() != v.String() {
+				return nil, errors.New(fmt.Sprintf("The tool %s %s is not the version %s the project compiles against", d.name, v, pkg.Version()))
+			}
+			continue
+		}
+		ids = append(ids, *NewProjectID(d.name, v))
+	}
+	return s.fetchAll(ids)
+}
+
+//check reports the problems in the resolved graph: the conflicts settled by the policy, and the cycles.
+// With FailOnConflict, a package that does not satisfy its requirements is an error: it is not the version chosen.
+func (s *resolver) check(p *Project, dependencies []*Package, order []string, requirements map[string][]Requirement) error {
+	for i, name := range order {
+		if v := dependencies[i].Version(); !satisfies(v, requirements[name]) {
+			conflict := &ConflictError{name, requirements[name], &v}
+			if s.policy() == FailOnConflict {
+				return conflict
+			}
+			if s.repo.problems != nil {
+				s.repo.problems(conflict)
+			}
+		}
+	}
+	if s.repo.problems != nil {
+		for _, cycle := range NewGraph(p, dependencies).Cycles() {
+			s.repo.problems(cycle)
+		}
+	}
+	return nil
+}
+
+func (s *resolver) policy() ConflictPolicy {
+	if s.repo.conflicts == "" {
+		return FailOnConflict
+	}
+	return s.repo.conflicts
+}
+
+//walk visits the graph from p, using the selected versions when they still satisfy the requirements met so far.
+// It returns the package names in the order they have been discovered, and all the requirements on them.
+// The packages discovered at the same depth are fetched concurrently.
+func (s *resolver) walk(p *Project, selected map[string]Version) (order []string, requirements map[string][]Requirement, err error) {
+	requirements = make(map[string][]Requirement)
+	visited := make(map[string]bool)
+	queue := []node{{[]string{p.name}, p.dependencies}}
+	for len(queue) > 0 {
+		level := queue
+		queue = nil
+		parents := make([]node, 0) // the node that discovered each package in ids
+		ids := make([]ProjectID, 0)
+		for _, n := range level {
+			for _, d := range n.dependencies {
+				if d.name == p.name { // the project itself, it is reported as a cycle
+					continue
+				}
+				requirements[d.name] = append(requirements[d.name], Requirement{d.constraint, n.path})
+				if visited[d.name] {
+					continue
+				}
+				visited[d.name] = true
+				order = append(order, d.name)
+
+				v, ok := selected[d.name]
+				if !ok || !s.keeps(d.name, v, requirements[d.name]) {
+					v, err = s.choose(d.name, requirements[d.name])
+					if err != nil {
+						return
+					}
+					selected[d.name] = v
+				}
+				parents = append(parents, n)
+				ids = append(ids, *NewProjectID(d.name, v))
+			}
+		}
+		packages, err := s.fetchAll(ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, pkg := range packages {
+			parent := parents[i].path
+			path := make([]string, len(parent), len(parent)+1)
+			copy(path, parent)
+			queue = append(queue, node{append(path, pkg.ID().String()), FilterScope(pkg.Dependencies(), CompileScope)}) // the other scopes are not transitive
+		}
+	}
+	return
+}
+
+//fetchAll fetches every package concurrently, with at most repo.jobs downloads at the same time.
+// If several fail, the error is the one of the first package in ids.
+func (s *resolver) fetchAll(ids []ProjectID) (packages []*Package, err error) {
+	packages = make([]*Package, len(ids))
+	errs := make([]error, len(ids))
+	jobs := make(chan bool, s.repo.jobs)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id ProjectID) {
+			defer wg.Done()
+			jobs <- true
+			packages[i], errs[i] = s.fetch(id)
+			<-jobs
+		}(i, id)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+//satisfies returns true if v matches every requirement
+func satisfies(v Version, requirements []Requirement) bool {
+	for _, q := range requirements {
+		if !q.Constraint.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+//choose select the highest version available that satisfies all the requirements
+func (s *resolver) choose(name string, requirements []Requirement) (v Version, err error) {
+	if s.locked != nil { // there is no choice but the locked version
+		lp, ok := s.locked[name]
+		if !ok {
+			return v, &StaleLockError{Name: name, Requirements: requirements}
+		}
+		v = lp.ID.Version()
+		if !satisfies(v, requirements) && (s.policy() == FailOnConflict || !satisfiesAny(v, requirements)) {
+			return v, &StaleLockError{name, &v, requirements}
+		}
+		return v, nil
+	}
+	// exact requirements do not need to list available versions
+	for _, q := range requirements {
+		if exact, ok := q.Constraint.IsExact(); ok {
+			if !satisfies(exact, requirements) {
+				return s.settle(name, requirements)
+			}
+			return exact, nil
+		}
+	}
+	for _, candidate := range s.available(name) {
+		if satisfies(candidate, requirements) {
+			return candidate, nil
+		}
+	}
+	return s.settle(name, requirements)
+}
+
+//keeps returns true if the version selected in a previous round can be kept, while the requirements are discovered:
+// it satisfies them, or it has been settled by the conflict policy. The requirements are checked again once they are all known.
+func (s *resolver) keeps(name string, v Version, requirements []Requirement) bool {
+	settled, ok := s.settled[name]
+	return satisfies(v, requirements) || ok && settled.String() == v.String()
+}
+
+//satisfiesAny returns true if v matches at least one requirement
+func satisfiesAny(v Version, requirements []Requirement) bool {
+	for _, q := range requirements {
+		if q.Constraint.Match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+//settle selects a version of name, according to the conflict policy, when no version satisfies all the requirements
+func (s *resolver) settle(name string, requirements []Requirement) (v Version, err error) {
+	accepted := requirements
+	switch s.policy() {
+	case NearestOnConflict: // the shortest path, the first one declared if there are several
+		nearest := requirements[0]
+		for _, q := range requirements[1:] {
+			if len(q.Path) < len(nearest.Path) {
+				nearest = q
+			}
+		}
+		accepted = []Requirement{nearest}
+	case NewestOnConflict:
+	default:
+		return v, &ConflictError{name, requirements, nil}
+	}
+	candidates := s.available(name)
+	for _, q := range accepted {
+		if exact, ok := q.Constraint.IsExact(); ok {
+			candidates = append(candidates, exact)
+		}
+	}
+	found := false
+	for _, candidate := range candidates {
+		if satisfiesAny(candidate, accepted) && (!found || v.LowerThan(candidate)) {
+			v, found = candidate, true
+		}
+	}
+	if !found {
+		return v, &ConflictError{name, requirements, nil}
+	}
+	log.Printf("Selecting %s %s by the %s policy", name, v, s.policy())
+	s.settled[name] = v
+	return v, nil
+}
+
+//available lists the versions of a package, both in the local repository and in the first remote, among the ones allowed
+// to resolve it, that has any, newest first. The versions of the next remotes are not merged: they would be downloaded from the first one.
+func (s *resolver) available(name string) Versions {
+	if versions, ok := s.versions[name]; ok {
+		return versions
+	}
+	known := make(map[string]bool)
+	versions := make(Versions, 0)
+	add := func(v Version) {
+		if !known[v.String()] {
+			known[v.String()] = true
+			versions = append(versions, v)
+		}
+	}
+	for _, v := range s.repo.Versions(name) {
+		add(v)
+	}
+	if !s.offline {
+		for _, remote := range s.repo.remotesFor(name) {
+			found := remoteVersions(remote, name)
+			for _, v := range found {
+				add(v)
+			}
+			if len(found) > 0 {
+				break
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(versions))
+	s.versions[name] = versions
+	return versions
+}
+
+//max number of search pages read when listing the versions available on a remote
+const maxSearchPages = 100
+
+//remoteVersions lists the versions of the package name available on a remote.
+func remoteVersions(remote protocol.Client, name string) (versions []Version) {
+	for _, pid := range searchPages(func(start int) []protocol.PID { return remote.Search(name, start) }) {
+		if pid.Name == name {
+			versions = append(versions, pid.Version)
+		}
+	}
+	return
+}
+
+//searchPages reads every page of a search, up to maxSearchPages
+func searchPages(search func(start int) []protocol.PID) (result []protocol.PID) {
+	start := 0
+	for page := 0; page < maxSearchPages; page++ {
+		pids := search(start)
+		if len(pids) == 0 {
+			break
+		}
+		result = append(result, pids...)
+		start += len(pids)
+	}
+	return
+}
+
+//fetch returns the package identified by d, from the local repository, or downloaded from the remotes
+// It is safe for concurrent use.
+func (s *resolver) fetch(d ProjectID) (prj *Package, err error) {
+	s.mutex.Lock()
+	prj = s.packages[d]
+	s.mutex.Unlock()
+	if prj != nil {
+		return
+	}
+	r := s.repo
+	digest := s.locked[d.Name()].Digest // the downloaded content must be the locked one
+	prj, err = r.FindPackage(d)
+	if !s.offline {
+		if err != nil { // missing dependency in local repo, search remote
+			log.Printf("Trying to download %s from remotes", d)
+			prj, err = r.download(d, nil, digest)
+		} else if s.update {
+			if d.Version().IsSnapshot() {
+				// try to get a newer version into prjnew
+				log.Printf("Trying to download a newer version for %s", d)
+				t := prj.Timestamp()
+				// always try to download updates, if there is no update it fails fast
+				if prjnew, err := r.download(d, &t, digest); err == nil { //succeeded in updating
+					prj = prjnew
+				}
+			}
+		}
+	}
+	if prj == nil {
+		if err != nil && !s.offline { // tell why the download failed, it might be an integrity error
+			return nil, errors.New(fmt.Sprintf("Missing dependency: %v\n    \u21b3 %v\n", d, err))
+		}
+		return nil, errors.New(fmt.Sprintf("Missing dependency: %v\n", d))
+	}
+	if lp, ok := s.locked[d.Name()]; ok {
+		if err = lp.verify(prj); err != nil {
+			return nil, err
+		}
+	}
+	if s.repo.trust.Strict() {
+		if err = s.repo.checkSignature(prj); err != nil {
+			return nil, err
+		}
+	}
+	if err = r.leaseInstalled(prj); err != nil { // it must not be collected while it is used
+		return nil, errors.New(fmt.Sprintf("Cannot lease %s: %v", prj.ID(), err))
+	}
+	s.mutex.Lock()
+	s.packages[d] = prj
+	s.mutex.Unlock()
+	return prj, nil
+}
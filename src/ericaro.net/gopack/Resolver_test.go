@@ -0,0 +1,311 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//newTestRepository creates an empty local repository in a temporary directory
+func newTestRepository(t *testing.T) *LocalRepository {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatalf("Cannot create the local repository: %v", err)
+	}
+	return r
+}
+
+//newTestProject creates the project name, with a single source file, and its dependencies given as "NAME CONSTRAINT"
+func newTestProject(t *testing.T, name string, dependencies ...string) *Project {
+	p := &Project{workingDir: t.TempDir(), name: name}
+	p.SetLicense(Licenses[0])
+	for _, d := range dependencies {
+		nc := strings.SplitN(d, " ", 2)
+		c, err := ParseConstraint(nc[1])
+		if err != nil {
+			t.Fatalf("Invalid constraint %q: %v", d, err)
+		}
+		p.AppendDependency(*NewDependency(nc[0], c))
+	}
+	src := filepath.Join(p.workingDir, "src", name)
+	if err := os.MkdirAll(src, os.ModeDir|os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "doc.go"), []byte("package "+filepath.Base(name)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+//installTestPackage installs the version of the project name, with its dependencies given as "NAME CONSTRAINT"
+func installTestPackage(t *testing.T, r *LocalRepository, name, version string, dependencies ...string) *Package {
+	v, err := ParseVersion(version)
+	if err != nil {
+		t.Fatalf("Invalid version %q: %v", version, err)
+	}
+	p, err := r.InstallProject(newTestProject(t, name, dependencies...), v, false)
+	if err != nil {
+		t.Fatalf("Cannot install %s %s: %v", name, version, err)
+	}
+	return p
+}
+
+//resolved formats the resolved dependencies as "NAME VERSION", in the resolution order
+func resolved(dependencies []*Package) (ids []string) {
+	for _, d := range dependencies {
+		ids = append(ids, d.ID().String())
+	}
+	return
+}
+
+func TestResolveHighestSatisfying(t *testing.T) {
+	r := newTestRepository(t)
+	for _, v := range []string{"1.0.0", "1.2.0", "1.2.1", "2.0.0"} {
+		installTestPackage(t, r, "ex/a", v)
+	}
+	cases := []struct {
+		constraint string
+		expected   string
+	}{
+		{"^1.0", "ex/a 1.2.1"},
+		{"~1.2.0", "ex/a 1.2.1"},
+		{"1.2.0", "ex/a 1.2.0"},
+		{"<1.2", "ex/a 1.0.0"},
+		{"*", "ex/a 2.0.0"},
+	}
+	for _, c := range cases {
+		dependencies, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a "+c.constraint), true, false)
+		if err != nil {
+			t.Fatalf("Cannot resolve %s: %v", c.constraint, err)
+		}
+		if ids := resolved(dependencies); len(ids) != 1 || ids[0] != c.expected {
+			t.Errorf("ex/a %s resolved to %v, expected %s", c.constraint, ids, c.expected)
+		}
+	}
+}
+
+func TestResolveTransitiveConstraints(t *testing.T) {
+	r := newTestRepository(t)
+	for _, v := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		installTestPackage(t, r, "ex/a", v)
+	}
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a <1.2")
+	// ex/a is selected before ex/b requires a lower version of it
+	dependencies, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a ^1.0", "ex/b 1.0.0"), true, false)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	ids := resolved(dependencies)
+	if strings.Join(ids, ", ") != "ex/a 1.1.0, ex/b 1.0.0" {
+		t.Errorf("Resolved %v, expected ex/a 1.1.0 and ex/b 1.0.0", ids)
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	r := newTestRepository(t)
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		installTestPackage(t, r, "ex/a", v)
+	}
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^2.0")
+	_, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a ^1.0", "ex/b 1.0.0"), true, false)
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("Expected a *ConflictError, got %v", err)
+	}
+	if conflict.Name != "ex/a" || conflict.Selected != nil || len(conflict.Requirements) != 2 {
+		t.Fatalf("Unexpected conflict %#v", conflict)
+	}
+	expected := []string{
+		"No version of ex/a satisfies all constraints:",
+		"ex/p requires ex/a ^1.0",
+		"ex/p -> ex/b 1.0.0 requires ex/a ^2.0",
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("The conflict error does not tell %q:\n%v", e, err)
+		}
+	}
+}
+
+func TestConflictErrorSelected(t *testing.T) {
+	c, _ := ParseConstraint("^1.0")
+	v, _ := ParseVersion("1.4.0")
+	err := &ConflictError{"ex/a", []Requirement{{c, []string{"ex/p", "ex/b 1.0.0"}}}, &v}
+	expected := "No version of ex/a satisfies all constraints:\n        ex/p -> ex/b 1.0.0 requires ex/a ^1.0\n        ex/a 1.4.0 has been selected"
+	if err.Error() != expected {
+		t.Errorf("Unexpected message:\n%s\nexpected:\n%s", err.Error(), expected)
+	}
+}
+
+func TestResolveExactSnapshot(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	master := installTestPackage(t, r, "ex/a", "master")
+	installTestPackage(t, r, "ex/a", "develop")
+	for _, constraint := range []string{"master", "0.0.0-master"} {
+		dependencies, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a "+constraint), true, false)
+		if err != nil {
+			t.Fatalf("Cannot resolve ex/a %s: %v", constraint, err)
+		}
+		if ids := resolved(dependencies); len(ids) != 1 || ids[0] != master.ID().String() {
+			t.Errorf("ex/a %s resolved to %v, expected %s", constraint, ids, master.ID())
+		}
+	}
+}
+
+func TestResolveMissing(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	if _, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a ^2.0"), true, false); err == nil {
+		t.Errorf("ex/a ^2.0 has been resolved, there is no such version")
+	}
+}
+
+//resolveWith resolves p with the conflict policy, and returns the problems reported
+func resolveWith(t *testing.T, r *LocalRepository, policy ConflictPolicy, p *Project) (dependencies []*Package, problems []error, err error) {
+	r.SetConflictPolicy(policy)
+	r.OnProblem(func(err error) { problems = append(problems, err) })
+	dependencies, err = r.ResolveDependencies(p, true, false)
+	return
+}
+
+func TestSettleConflict(t *testing.T) {
+	r := newTestRepository(t)
+	for _, v := range []string{"1.0.0", "1.5.0", "2.0.0", "2.3.0", "3.0.0"} {
+		installTestPackage(t, r, "ex/a", v)
+	}
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^2.0")
+	cases := []struct {
+		policy   ConflictPolicy
+		expected string
+	}{
+		{NewestOnConflict, "ex/a 2.3.0"},  // the newest accepted by one of the requirements
+		{NearestOnConflict, "ex/a 1.5.0"}, // the one required by the project
+	}
+	for _, c := range cases {
+		dependencies, problems, err := resolveWith(t, r, c.policy, newTestProject(t, "ex/p", "ex/a ^1.0", "ex/b 1.0.0"))
+		if err != nil {
+			t.Fatalf("Cannot resolve with the %s policy: %v", c.policy, err)
+		}
+		if ids := resolved(dependencies); strings.Join(ids, ", ") != c.expected+", ex/b 1.0.0" {
+			t.Errorf("The %s policy resolved %v, expected %s", c.policy, ids, c.expected)
+		}
+		if len(problems) != 1 {
+			t.Fatalf("The %s policy reported %v, expected the conflict on ex/a", c.policy, problems)
+		}
+		if conflict, ok := problems[0].(*ConflictError); !ok || conflict.Selected == nil || "ex/a "+conflict.Selected.String() != c.expected {
+			t.Errorf("The %s policy reported %v", c.policy, problems[0])
+		}
+	}
+}
+
+func TestSettleExactConflict(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	installTestPackage(t, r, "ex/a", "1.1.0")
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a 1.1.0")
+	dependencies, _, err := resolveWith(t, r, NearestOnConflict, newTestProject(t, "ex/p", "ex/a 1.0.0", "ex/b 1.0.0"))
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	if ids := resolved(dependencies); ids[0] != "ex/a 1.0.0" {
+		t.Errorf("Resolved %v, expected the version required by the project", ids)
+	}
+	if _, _, err = resolveWith(t, r, FailOnConflict, newTestProject(t, "ex/p", "ex/a 1.0.0", "ex/b 1.0.0")); err == nil {
+		t.Errorf("The conflicting exact versions have been resolved")
+	}
+}
+
+func TestKeeps(t *testing.T) {
+	s := newResolver(newTestRepository(t), true, false)
+	c, _ := ParseConstraint("^1.0")
+	requirements := []Requirement{{c, []string{"ex/p"}}}
+	v1, _ := ParseVersion("1.2.0")
+	v2, _ := ParseVersion("2.0.0")
+	if !s.keeps("ex/a", v1, requirements) {
+		t.Errorf("A version that satisfies the requirements is not kept")
+	}
+	if s.keeps("ex/a", v2, requirements) {
+		t.Errorf("A version that does not satisfy the requirements is kept")
+	}
+	s.settled["ex/a"] = v2
+	if !s.keeps("ex/a", v2, requirements) {
+		t.Errorf("A version settled by the policy is not kept")
+	}
+	if s.keeps("ex/b", v2, requirements) {
+		t.Errorf("A version settled for another package is kept")
+	}
+}
+
+func TestResolveCycles(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0", "ex/b ^1.0")
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^1.0")
+	installTestPackage(t, r, "ex/s", "1.0.0", "ex/s ^1.0")
+	// the cycles are reported, whatever the policy
+	for _, policy := range []ConflictPolicy{FailOnConflict, NewestOnConflict} {
+		dependencies, problems, err := resolveWith(t, r, policy, newTestProject(t, "ex/p", "ex/a ^1.0", "ex/s ^1.0"))
+		if err != nil {
+			t.Fatalf("Cannot resolve with the %s policy: %v", policy, err)
+		}
+		if ids := resolved(dependencies); strings.Join(ids, ", ") != "ex/a 1.0.0, ex/s 1.0.0, ex/b 1.0.0" {
+			t.Errorf("Resolved %v", ids)
+		}
+		expected := []string{
+			"Cyclic dependency on ex/a: ex/p -> ex/a 1.0.0 -> ex/b 1.0.0 -> ex/a ^1.0",
+			"Self dependency of ex/s: ex/p -> ex/s 1.0.0 -> ex/s ^1.0",
+		}
+		var reported []string
+		for _, problem := range problems {
+			reported = append(reported, problem.Error())
+		}
+		if strings.Join(reported, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("The %s policy reported:\n%s\nexpected:\n%s", policy, strings.Join(reported, "\n"), strings.Join(expected, "\n"))
+		}
+	}
+}
+
+func TestResolveToolsOnTheirOwn(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	installTestPackage(t, r, "ex/a", "2.0.0")
+	installTestPackage(t, r, "ex/t", "1.0.0", "ex/a ^2.0") // built against another ex/a
+	p := newTestProject(t, "ex/p", "ex/a ^1.0")
+	c, _ := ParseConstraint("^1.0")
+	p.AppendDependency(*NewScopedDependency("ex/t", c, ToolScope))
+	dependencies, problems, err := resolveWith(t, r, FailOnConflict, p)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	if ids := resolved(dependencies); strings.Join(ids, ", ") != "ex/a 1.0.0, ex/t 1.0.0" {
+		t.Errorf("Resolved %v, expected the tool last, without changing ex/a", ids)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Unexpected problems %v", problems)
+	}
+	g := NewGraph(p, dependencies)
+	if edges := g.Edges("ex/t"); len(edges) != 0 {
+		t.Errorf("The dependencies of a tool are in the graph: %v", edges)
+	}
+	if ids := resolved(g.Scope(CompileScope)); strings.Join(ids, ", ") != "ex/a 1.0.0" {
+		t.Errorf("The project compiles against %v", ids)
+	}
+
+	// a tool the project compiles against is the same version
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^1.0")
+	for constraint, ok := range map[string]bool{"^1.0": true, "^2.0": false} {
+		p = newTestProject(t, "ex/p", "ex/b ^1.0")
+		c, _ := ParseConstraint(constraint)
+		p.AppendDependency(*NewScopedDependency("ex/a", c, ToolScope))
+		dependencies, _, err = resolveWith(t, r, FailOnConflict, p)
+		if ok && (err != nil || strings.Join(resolved(dependencies), ", ") != "ex/b 1.0.0, ex/a 1.0.0") {
+			t.Errorf("The tool ex/a %s resolved to %v: %v", constraint, resolved(dependencies), err)
+		}
+		if !ok && err == nil {
+			t.Errorf("The tool ex/a %s has been resolved, the project compiles against ex/a 1.0.0", constraint)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package gopack
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SignatureFile = ".gpk.sig"
+
+	Ed25519Algorithm = "ed25519"
+	RSAAlgorithm     = "rsa-sha256" // PKCS #1 v1.5 signature of the sha256 of the signed statement
+)
+
+//Signature is a detached signature of a package: it signs the package name, version and digest (see statement), so it
+// can be checked against the package content whatever the way it has been transferred.
+type Signature struct {
+	Algorithm string
+	PublicKey []byte // the signer public key, in PKIX, ASN.1 DER form
+	Value     []byte
+}
+
+//statement is what the signature of a package signs: its name, its version and its content digest (see DigestDir),
+// so that a signed content cannot be relabelled as another package, or another version.
+func statement(id ProjectID, digest string) []byte {
+	return []byte(fmt.Sprintf("gopack package\nname %s\nversion %s\ndigest %s\n", id.Name(), id.Version().String(), digest))
+}
+
+//SignPackage signs the identity and the content digest of a package with a private key. Only Ed25519 and RSA keys are supported.
+func SignPackage(key crypto.Signer, id ProjectID, digest string) (s *Signature, err error) {
+	signed := statement(id, digest)
+	s = &Signature{}
+	s.PublicKey, err = x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		s.Algorithm = Ed25519Algorithm
+		s.Value, err = key.Sign(rand.Reader, signed, crypto.Hash(0))
+	case *rsa.PublicKey:
+		s.Algorithm = RSAAlgorithm
+		h := sha256.Sum256(signed)
+		s.Value, err = key.Sign(rand.Reader, h[:], crypto.SHA256)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported key type %T, use an Ed25519 or RSA key", key.Public()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+//Verify checks that this signature is a valid signature of the package id, with that content digest. It says nothing
+// about the signer being trusted.
+func (s *Signature) Verify(id ProjectID, digest string) (err error) {
+	signed := statement(id, digest)
+	pub, err := x509.ParsePKIXPublicKey(s.PublicKey)
+	if err != nil {
+		return
+	}
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if s.Algorithm != Ed25519Algorithm || !ed25519.Verify(key, signed, s.Value) {
+			return errors.New(fmt.Sprintf("Invalid %s signature by key %s", s.Algorithm, s.KeyID()))
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if s.Algorithm != RSAAlgorithm || rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], s.Value) != nil {
+			return errors.New(fmt.Sprintf("Invalid %s signature by key %s", s.Algorithm, s.KeyID()))
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported key type %T", pub))
+	}
+	return
+}
+
+//KeyID is a short fingerprint of the signer public key
+func (s *Signature) KeyID() string {
+	return KeyID(s.PublicKey)
+}
+
+//KeyID computes a short fingerprint of a public key (in PKIX DER form)
+func KeyID(publicKey []byte) string {
+	h := sha256.Sum256(publicKey)
+	return hex.EncodeToString(h[:8])
+}
+
+//Format the signature in a single line, suitable for http headers, and url parameters: "<algorithm>:<public key>:<signature>", both in base64
+func (s *Signature) Format() string {
+	return s.Algorithm + ":" + base64.StdEncoding.EncodeToString(s.PublicKey) + ":" + base64.StdEncoding.EncodeToString(s.Value)
+}
+
+//ParseSignature reads a signature in the Format form
+func ParseSignature(v string) (s *Signature, err error) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return nil, errors.New(fmt.Sprintf("Invalid signature syntax \"%s\"", v))
+	}
+	s = &Signature{Algorithm: parts[0]}
+	if s.PublicKey, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return nil, err
+	}
+	if s.Value, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, err
+	}
+	return
+}
+
+//ReadPrivateKey reads a PEM encoded private key, either PKCS #8 (Ed25519 or RSA), or PKCS #1 (RSA)
+func ReadPrivateKey(path string) (key crypto.Signer, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a PEM file", path))
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := k.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New(fmt.Sprintf("Unsupported private key type %T", k))
+	}
+	return nil, errors.New(fmt.Sprintf("%s does not contain a private key, but a %s", path, block.Type))
+}
+
+//ReadPublicKey reads a PEM encoded public key, and returns it in PKIX DER form. If the file contains a private key, its public part is returned.
+func ReadPublicKey(path string) (publicKey []byte, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a PEM file", path))
+	}
+	if block.Type == "PUBLIC KEY" {
+		_, err = x509.ParsePKIXPublicKey(block.Bytes) // just to check it
+		return block.Bytes, err
+	}
+	key, err := ReadPrivateKey(path)
+	if err != nil {
+		return
+	}
+	return x509.MarshalPKIXPublicKey(key.Public())
+}
+
+//Signature reads the package detached signature. It returns nil if the package is not signed.
+func (p *Package) Signature() (s *Signature, err error) {
+	path := filepath.Join(p.self.workingDir, SignatureFile)
+	if !FileExists(path) {
+		return nil, nil
+	}
+	s = &Signature{}
+	err = JsonReadFile(path, s)
+	return
+}
+
+//Sign signs the package identity and digest with key, and writes the signature next to the package .gpk
+func (p *Package) Sign(key crypto.Signer) (s *Signature, err error) {
+	if p.digest == "" { // installed by an older version
+		if p.digest, err = DigestDir(p.InstallDir()); err != nil {
+			return
+		}
+		if err = p.Write(); err != nil {
+			return
+		}
+	}
+	if s, err = SignPackage(key, p.ID(), p.digest); err != nil {
+		return
+	}
+	err = p.writeSignature(s)
+	return
+}
+
+//writeSignature writes down the package signature
+func (p *Package) writeSignature(s *Signature) error {
+	return JsonWriteFile(filepath.Join(p.self.workingDir, SignatureFile), s)
+}
+
+//UnmarshalJSON part of the json protocol
+func (s *Signature) UnmarshalJSON(data []byte) (err error) {
+	type SignatureFile struct {
+		Algorithm        string
+		PublicKey, Value string
+	}
+	var sf SignatureFile
+	if err = json.Unmarshal(data, &sf); err != nil {
+		return
+	}
+	s.Algorithm = sf.Algorithm
+	if s.PublicKey, err = base64.StdEncoding.DecodeString(sf.PublicKey); err != nil {
+		return
+	}
+	s.Value, err = base64.StdEncoding.DecodeString(sf.Value)
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (s *Signature) MarshalJSON() ([]byte, error) {
+	type SignatureFile struct {
+		Algorithm        string
+		PublicKey, Value string
+	}
+	sf := SignatureFile{
+		Algorithm: s.Algorithm,
+		PublicKey: base64.StdEncoding.EncodeToString(s.PublicKey),
+		Value:     base64.StdEncoding.EncodeToString(s.Value),
+	}
+	return json.Marshal(sf)
+}
@@ -0,0 +1,215 @@
+package gopack
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"ericaro.net/gopack/protocol"
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+//testKeys returns an Ed25519 and an RSA key
+func testKeys(t *testing.T) []crypto.Signer {
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []crypto.Signer{ed, rs}
+}
+
+func TestSignatureCoversIdentity(t *testing.T) {
+	v1, _ := ParseVersion("1.0.0")
+	v2, _ := ParseVersion("2.0.0")
+	id := *NewProjectID("ex/a", v1)
+	digest := digestPrefix + "0123"
+	for _, key := range testKeys(t) {
+		s, err := SignPackage(key, id, digest)
+		if err != nil {
+			t.Fatalf("Cannot sign with %T: %v", key, err)
+		}
+		// it travels in its Format form
+		if s, err = ParseSignature(s.Format()); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Verify(id, digest); err != nil {
+			t.Errorf("%s: the signature does not verify: %v", s.Algorithm, err)
+		}
+		if s.Verify(*NewProjectID("ex/b", v1), digest) == nil {
+			t.Errorf("%s: the signature verifies for another name", s.Algorithm)
+		}
+		if s.Verify(*NewProjectID("ex/a", v2), digest) == nil {
+			t.Errorf("%s: the signature verifies for another version", s.Algorithm)
+		}
+		if s.Verify(id, digestPrefix+"3210") == nil {
+			t.Errorf("%s: the signature verifies for another digest", s.Algorithm)
+		}
+	}
+}
+
+func TestTrustedPrefix(t *testing.T) {
+	key := []byte("key")
+	cases := []struct {
+		prefix  string
+		name    string
+		trusted bool
+	}{
+		{"ex", "ex", true},
+		{"ex", "ex/a", true},
+		{"ex", "ex/a/b", true},
+		{"ex", "example", false},
+		{"ex", "exa/b", false},
+		{"ex/", "ex/a", true},
+		{"ex/a", "ex/ab", false},
+		{"ex/a", "ex", false},
+		{"", "anything", true},
+	}
+	for _, c := range cases {
+		store := &TrustStore{}
+		store.Trust(c.prefix, key, "")
+		if store.Trusted(c.name, key) != c.trusted {
+			t.Errorf("A key trusted for %q is trusted for %q: %v, expected %v", c.prefix, c.name, !c.trusted, c.trusted)
+		}
+		if store.Trusted(c.name, []byte("other")) {
+			t.Errorf("Another key is trusted for %q", c.name)
+		}
+	}
+}
+
+func TestReceiveSignedPackage(t *testing.T) {
+	key := testKeys(t)[0]
+	publicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+	r := newTestRepository(t)
+	p := installTestPackage(t, r, "ex/a", "1.0.0")
+	s, err := p.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err = p.Pack(&archive); err != nil {
+		t.Fatal(err)
+	}
+	pid := protocol.PID{Name: p.Name(), Version: p.Version(), Digest: p.Digest(), Signature: s.Format()}
+
+	cases := []struct {
+		prefix string
+		ok     bool
+	}{
+		{"ex", true},
+		{"ex/a", true},
+		{"ex/b", false},
+		{"e", false},
+	}
+	for _, c := range cases {
+		other := newTestRepository(t)
+		other.TrustStore().SetStrict(true)
+		other.TrustStore().Trust(c.prefix, publicKey, "")
+		_, err := other.Receive(pid, bytes.NewReader(archive.Bytes()), "test")
+		if (err == nil) != c.ok {
+			t.Errorf("Receiving %s signed by a key trusted for %q: %v", p.ID(), c.prefix, err)
+		}
+	}
+
+	// a signature of the same content as another version does not sign this one
+	v2, _ := ParseVersion("2.0.0")
+	other, _ := SignPackage(key, *NewProjectID("ex/a", v2), p.Digest())
+	relabelled := pid
+	relabelled.Signature = other.Format()
+	if _, err = newTestRepository(t).Receive(relabelled, bytes.NewReader(archive.Bytes()), "test"); err == nil {
+		t.Errorf("%s has been received with the signature of ex/a 2.0.0", p.ID())
+	}
+}
+
+func TestReceiveSignedLegacySnapshot(t *testing.T) {
+	key := testKeys(t)[0]
+	publicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+	// the plain snapshot master, signed by an older version of gopack
+	p := installTestPackage(t, newTestRepository(t), "ex/a", "master")
+	p.version, _ = ParseVersion("master")
+	p.digest = ""
+	if err := p.Write(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err = p.Pack(&archive); err != nil {
+		t.Fatal(err)
+	}
+	pid := protocol.PID{Name: p.Name(), Version: p.Version(), Digest: p.Digest(), Signature: s.Format()}
+
+	r := newTestRepository(t)
+	r.TrustStore().Trust("ex", publicKey, "")
+	received, err := r.Receive(pid, bytes.NewReader(archive.Bytes()), "test")
+	if err != nil {
+		t.Fatalf("Cannot receive %s: %v", p.ID(), err)
+	}
+	if !IsBuild(received.Version()) {
+		t.Fatalf("%s is not installed as a build", received.ID())
+	}
+	// it is verified again as it is installed: the signature of the plain snapshot is dropped
+	if sig, err := received.Signature(); sig != nil || err != nil {
+		t.Errorf("The signature of %s is kept for %s: %v", p.ID(), received.ID(), err)
+	}
+	if digest, err := DigestDir(received.InstallDir()); err != nil || digest != received.Digest() {
+		t.Errorf("The digest of %s is %s, its content %s: %v", received.ID(), received.Digest(), digest, err)
+	}
+	if err = r.checkSignature(received); err != nil {
+		t.Errorf("%s does not verify: %v", received.ID(), err)
+	}
+
+	// an unsigned build is refused in strict mode
+	strict := newTestRepository(t)
+	strict.TrustStore().SetStrict(true)
+	strict.TrustStore().Trust("ex", publicKey, "")
+	if received, err = strict.Receive(pid, bytes.NewReader(archive.Bytes()), "test"); err == nil {
+		t.Errorf("The unsigned build %s has been received in strict mode", received.ID())
+	}
+}
+
+func TestInstallExecutables(t *testing.T) {
+	key := testKeys(t)[0]
+	publicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+	r := newTestRepository(t)
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	p := installTestTool(t, r, "ex/a", "1.0.0")
+	if _, err := r.InstallExecutables(p, t.TempDir(), platform); err == nil {
+		t.Errorf("The executables of an unsigned package have been installed")
+	}
+	if _, err := p.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.InstallExecutables(p, t.TempDir(), platform); err == nil {
+		t.Errorf("The executables of a package signed by an untrusted key have been installed")
+	}
+
+	r.TrustStore().Trust("ex", publicKey, "")
+	dst := t.TempDir()
+	installed, err := r.InstallExecutables(p, dst, platform)
+	if err != nil || len(installed) != 1 {
+		t.Fatalf("Cannot install the executables of a trusted package: %v", err)
+	}
+	if info, err := os.Stat(installed[0]); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("The executable has not been installed: %v", err)
+	}
+
+	// the executable is replaced
+	if err = ioutil.WriteFile(executable(p), []byte("#!/bin/sh\necho tampered\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.InstallExecutables(p, t.TempDir(), platform); err == nil {
+		t.Errorf("A tampered executable has been installed")
+	}
+}
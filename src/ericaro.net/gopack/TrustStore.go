@@ -0,0 +1,164 @@
+package gopack
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+const (
+	TrustStoreFile        = ".gpktrust"
+	TrustStoreFileVersion = "1.0.0"
+)
+
+//TrustedKey is a public key accepted to sign the packages named Prefix, or under it (Prefix/...)
+type TrustedKey struct {
+	Prefix    string
+	PublicKey []byte // PKIX, ASN.1 DER form
+	Comment   string
+}
+
+//TrustStore is the set of keys a local repository trusts to sign packages. It is stored in the .gpktrust file, in the repository root.
+// In strict mode, every package used to resolve dependencies must be signed by a trusted key.
+type TrustStore struct {
+	path   string
+	strict bool
+	keys   []TrustedKey
+}
+
+//ReadTrustStore reads the trust store of a local repository. A missing file is an empty trust store.
+func ReadTrustStore(root string) (t *TrustStore, err error) {
+	t = &TrustStore{path: filepath.Join(root, TrustStoreFile)}
+	if FileExists(t.path) {
+		err = JsonReadFile(t.path, t)
+	}
+	return
+}
+
+//Write down the trust store into the repository root
+func (t *TrustStore) Write() (err error) {
+	return JsonWriteFile(t.path, t)
+}
+
+//Strict returns true if every package must be signed by a trusted key
+func (t *TrustStore) Strict() bool {
+	return t.strict
+}
+
+//SetStrict changes the strict mode
+func (t *TrustStore) SetStrict(strict bool) {
+	t.strict = strict
+}
+
+//Keys returns every trusted key
+func (t *TrustStore) Keys() []TrustedKey {
+	return t.keys[:]
+}
+
+//Trust adds a public key for the given prefix. It returns false if it was already trusted.
+func (t *TrustStore) Trust(prefix string, publicKey []byte, comment string) bool {
+	for _, k := range t.keys {
+		if k.Prefix == prefix && bytes.Equal(k.PublicKey, publicKey) {
+			return false
+		}
+	}
+	t.keys = append(t.keys, TrustedKey{prefix, publicKey, comment})
+	return true
+}
+
+//Untrust removes the keys trusted for exactly that prefix. If keyID is not empty, only the key with this ID is removed.
+// It returns the removed keys.
+func (t *TrustStore) Untrust(prefix, keyID string) (removed []TrustedKey) {
+	kept := make([]TrustedKey, 0, len(t.keys))
+	for _, k := range t.keys {
+		if k.Prefix == prefix && (keyID == "" || KeyID(k.PublicKey) == keyID) {
+			removed = append(removed, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+	t.keys = kept
+	return
+}
+
+//Trusted returns true if the key is trusted for the package name
+func (t *TrustStore) Trusted(name string, publicKey []byte) bool {
+	for _, k := range t.keys {
+		if hasNamePrefix(name, k.Prefix) && bytes.Equal(k.PublicKey, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+//Check verifies that the package identity and content digest are signed by s, and, in strict mode, that s is trusted for this package.
+// s can be nil if the package is not signed.
+func (t *TrustStore) Check(id ProjectID, digest string, s *Signature) (err error) {
+	if s == nil {
+		if t.strict {
+			return errors.New(fmt.Sprintf("Package %s is not signed", id))
+		}
+		return
+	}
+	if err = s.Verify(id, digest); err != nil {
+		return errors.New(fmt.Sprintf("Package %s signature does not match its name, version and content\n    \u21b3 %v", id, err))
+	}
+	if t.strict && !t.Trusted(id.Name(), s.PublicKey) {
+		return errors.New(fmt.Sprintf("Package %s is signed by key %s that is not trusted for it", id, s.KeyID()))
+	}
+	return
+}
+
+//UnmarshalJSON part of the json protocol
+func (t *TrustStore) UnmarshalJSON(data []byte) (err error) {
+	type TrustedKeyFile struct {
+		Prefix, PublicKey, Comment string
+	}
+	type TrustStoreFile struct {
+		FormatVersion string
+		Strict        bool
+		Keys          []TrustedKeyFile
+	}
+	var tf TrustStoreFile
+	if err = json.Unmarshal(data, &tf); err != nil {
+		return
+	}
+	if tf.FormatVersion != TrustStoreFileVersion {
+		log.Printf("Warning: Unknown format version \"%s\"", tf.FormatVersion)
+	}
+	t.strict = tf.Strict
+	t.keys = make([]TrustedKey, 0, len(tf.Keys))
+	for _, kf := range tf.Keys {
+		k, err := base64.StdEncoding.DecodeString(kf.PublicKey)
+		if err != nil {
+			return err
+		}
+		t.keys = append(t.keys, TrustedKey{kf.Prefix, k, kf.Comment})
+	}
+	return
+}
+
+//MarshalJSON part of the json protocol
+func (t *TrustStore) MarshalJSON() ([]byte, error) {
+	type TrustedKeyFile struct {
+		Prefix, PublicKey, Comment string
+	}
+	type TrustStoreFile struct {
+		FormatVersion string
+		Strict        bool
+		Keys          []TrustedKeyFile
+	}
+	tf := TrustStoreFile{
+		FormatVersion: TrustStoreFileVersion,
+		Strict:        t.strict,
+		Keys:          make([]TrustedKeyFile, len(t.keys)),
+	}
+	for i, k := range t.keys {
+		tf.Keys[i] = TrustedKeyFile{k.Prefix, base64.StdEncoding.EncodeToString(k.PublicKey), k.Comment}
+	}
+	return json.Marshal(tf)
+}
@@ -0,0 +1,263 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//API is the exported API of a project: every exported identifier of its importable packages, and its description.
+// Identifiers are "PACKAGE.Name", struct fields and methods are described on their own as "PACKAGE.Type.Member",
+// so that adding them is a compatible change.
+type API map[string]string
+
+//APIChange is an identifier that differs between two versions of an API
+type APIChange struct {
+	Name     string // the API identifier
+	Old, New string // descriptions, Old is empty if it has been added, New if it has been removed
+}
+
+//Compatible returns true if the change cannot break a dependant: it is an addition
+func (c APIChange) Compatible() bool {
+	return c.Old == ""
+}
+
+//String pretty prints the change, prefixed with +, - or ~
+func (c APIChange) String() string {
+	switch {
+	case c.Old == "":
+		return "+ " + c.New
+	case c.New == "":
+		return "- " + c.Old
+	}
+	return fmt.Sprintf("~ %s\n    ↳ %s", c.Old, c.New)
+}
+
+//CompareAPI returns the changes from old to new, sorted by identifier
+func CompareAPI(old, new API) (changes []APIChange) {
+	for name, desc := range old {
+		if desc != new[name] {
+			changes = append(changes, APIChange{name, desc, new[name]})
+		}
+	}
+	for name, desc := range new {
+		if _, ok := old[name]; !ok {
+			changes = append(changes, APIChange{name, "", desc})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return
+}
+
+//RequiredBump returns the smallest bump from old that the changes require: major for an incompatible change,
+// minor for an addition, patch otherwise. Like semver says, anything may change in the 0.y.z versions: one level
+// less is required.
+func RequiredBump(old Version, changes []APIChange) Bump {
+	required := PatchBump
+	for _, c := range changes {
+		if !c.Compatible() {
+			required = MajorBump
+			break
+		}
+		required = MinorBump
+	}
+	major, _, _ := old.Digits()
+	if major == 0 {
+		switch required {
+		case MajorBump:
+			return MinorBump
+		case MinorBump:
+			return PatchBump
+		}
+	}
+	return required
+}
+
+//ReleaseBump returns the part of the version that has been bumped from old to new: the first digit that differs,
+// or pre if only the prerelease does.
+func ReleaseBump(old, new Version) Bump {
+	oM, om, op := old.Digits()
+	nM, nm, np := new.Digits()
+	switch {
+	case oM != nM:
+		return MajorBump
+	case om != nm:
+		return MinorBump
+	case op != np:
+		return PatchBump
+	}
+	return PreBump
+}
+
+//SmallerBump returns true if b is a smaller bump than c
+func SmallerBump(b, c Bump) bool {
+	rank := map[Bump]int{PreBump: 0, PatchBump: 1, MinorBump: 2, MajorBump: 3}
+	return rank[b] < rank[c]
+}
+
+//PackageAPI computes the exported API of an installed package, see ProjectAPI.
+func (r *LocalRepository) PackageAPI(p *Package) (API, error) {
+	return r.ProjectAPI(&p.self)
+}
+
+//ProjectAPI computes the exported API of the project packages, type checked with go/types. The imports are read from
+// the project, its dependencies resolved offline, and the standard library sources.
+// Main and internal packages are not part of the API.
+// It fails on any type error: an unknown type would be reported as an incompatible change.
+func (r *LocalRepository) ProjectAPI(p *Project) (api API, err error) {
+	roots := []string{p.workingDir}
+	dependencies, err := r.ResolveDependencies(p, true, false)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot resolve %s dependencies: %v", p.name, err))
+	}
+	for _, d := range dependencies {
+		roots = append(roots, d.InstallDir())
+	}
+	fset := token.NewFileSet()
+	imp := &sourceImporter{
+		roots:    roots,
+		fset:     fset,
+		packages: make(map[string]*types.Package),
+		std:      importer.ForCompiler(fset, "source", nil), // the toolchain has no export data for the standard library
+	}
+	api = make(API)
+	for _, path := range p.Packages() {
+		path = filepath.ToSlash(path)
+		if isInternal(path) {
+			continue
+		}
+		pkg, err := imp.Import(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot read package %s: %v", path, err))
+		}
+		if pkg.Name() != "main" {
+			describePackage(api, pkg)
+		}
+	}
+	return
+}
+
+func isInternal(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
+
+//sourceImporter type checks the packages found in the src directory of the roots, and gets the others
+// from the standard importer.
+type sourceImporter struct {
+	roots    []string
+	fset     *token.FileSet
+	packages map[string]*types.Package
+	std      types.Importer
+}
+
+//Import part of the types.Importer interface
+func (s *sourceImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := s.packages[path]; ok {
+		return pkg, nil
+	}
+	for _, root := range s.roots {
+		dir := filepath.Join(root, "src", filepath.FromSlash(path))
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		bp, err := build.Default.ImportDir(dir, 0) // applies the build constraints
+		if err != nil {
+			return nil, err
+		}
+		files := make([]*ast.File, 0, len(bp.GoFiles))
+		for _, name := range bp.GoFiles {
+			f, err := parser.ParseFile(s.fset, filepath.Join(dir, name), nil, 0)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+		conf := types.Config{Importer: s}
+		pkg, err := conf.Check(path, s.fset, files, nil) // the first type error
+		if err != nil {
+			return nil, err
+		}
+		s.packages[path] = pkg
+		return pkg, nil
+	}
+	return s.std.Import(path)
+}
+
+//describePackage adds the exported identifiers of pkg to api
+func describePackage(api API, pkg *types.Package) {
+	qualifier := types.RelativeTo(pkg)
+	prefix := pkg.Path() + "."
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Const: // its value can change
+			api[prefix+name] = fmt.Sprintf("const %s %s", name, types.TypeString(obj.Type(), qualifier))
+		case *types.Var:
+			api[prefix+name] = fmt.Sprintf("var %s %s", name, types.TypeString(obj.Type(), qualifier))
+		case *types.Func:
+			api[prefix+name] = "func " + name + strings.TrimPrefix(types.TypeString(obj.Type(), qualifier), "func")
+		case *types.TypeName:
+			describeType(api, prefix+name, obj, qualifier)
+		}
+	}
+}
+
+//describeType adds a type, its exported fields and its exported methods to api
+func describeType(api API, id string, obj *types.TypeName, qualifier types.Qualifier) {
+	name := obj.Name()
+	if obj.IsAlias() {
+		api[id] = fmt.Sprintf("type %s = %s", name, types.TypeString(obj.Type(), qualifier))
+		return
+	}
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct: // fields are described on their own
+		api[id] = fmt.Sprintf("type %s struct", name)
+		for i := 0; i < u.NumFields(); i++ {
+			if f := u.Field(i); f.Exported() {
+				if f.Embedded() {
+					api[id+"."+f.Name()] = fmt.Sprintf("field %s.%s embedded %s", name, f.Name(), types.TypeString(f.Type(), qualifier))
+				} else {
+					api[id+"."+f.Name()] = fmt.Sprintf("field %s.%s %s", name, f.Name(), types.TypeString(f.Type(), qualifier))
+				}
+			}
+		}
+	case *types.Interface: // adding a method breaks the implementations
+		api[id] = fmt.Sprintf("type %s %s", name, types.TypeString(u, qualifier))
+		return
+	default:
+		api[id] = fmt.Sprintf("type %s %s", name, types.TypeString(u, qualifier))
+	}
+	// the methods of *T include the ones of T, and the promoted ones
+	methods := types.NewMethodSet(types.NewPointer(obj.Type()))
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.At(i).Obj().(*types.Func)
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		recv := name
+		if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+			recv = "*" + name
+		}
+		api[id+"."+m.Name()] = fmt.Sprintf("method (%s) %s%s", recv, m.Name(), strings.TrimPrefix(types.TypeString(sig, qualifier), "func"))
+	}
+}
@@ -0,0 +1,126 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//writeTestSource replaces the source of the project p, in its package named like its last path element
+func writeTestSource(t *testing.T, p *Project, source string) {
+	src := filepath.Join(p.workingDir, "src", p.name, "doc.go")
+	if err := ioutil.WriteFile(src, []byte("package "+filepath.Base(p.name)+"\n"+source), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareAPI(t *testing.T) {
+	old := API{"ex/a.F": "func F()", "ex/a.G": "func G()", "ex/a.T": "type T struct"}
+	new := API{"ex/a.F": "func F(x int)", "ex/a.T": "type T struct", "ex/a.T.X": "field T.X int"}
+	expected := []APIChange{
+		{"ex/a.F", "func F()", "func F(x int)"},
+		{"ex/a.G", "func G()", ""},
+		{"ex/a.T.X", "", "field T.X int"},
+	}
+	changes := CompareAPI(old, new)
+	if len(changes) != len(expected) {
+		t.Fatalf("Changes %v, expected %v", changes, expected)
+	}
+	for i, c := range changes {
+		if c != expected[i] || c.Compatible() != (i == 2) {
+			t.Errorf("Change %d is %v, expected %v", i, c, expected[i])
+		}
+	}
+	if changes := CompareAPI(old, old); len(changes) != 0 {
+		t.Errorf("The same API has changed: %v", changes)
+	}
+}
+
+func TestRequiredBump(t *testing.T) {
+	added := APIChange{"ex/a.F", "", "func F()"}
+	removed := APIChange{"ex/a.F", "func F()", ""}
+	changed := APIChange{"ex/a.F", "func F()", "func F(x int)"}
+	for _, c := range []struct {
+		old      string
+		changes  []APIChange
+		expected Bump
+	}{
+		{"1.2.3", nil, PatchBump},
+		{"1.2.3", []APIChange{added}, MinorBump},
+		{"1.2.3", []APIChange{removed}, MajorBump},
+		{"1.2.3", []APIChange{added, changed}, MajorBump},
+		{"0.2.3", nil, PatchBump},
+		{"0.2.3", []APIChange{added}, PatchBump},
+		{"0.2.3", []APIChange{changed}, MinorBump},
+	} {
+		v, _ := ParseVersion(c.old)
+		if bump := RequiredBump(v, c.changes); bump != c.expected {
+			t.Errorf("From %s, %v require a %s bump, expected %s", c.old, c.changes, bump, c.expected)
+		}
+	}
+}
+
+func TestReleaseBump(t *testing.T) {
+	for _, c := range []struct {
+		old, new string
+		expected Bump
+	}{
+		{"1.2.3", "2.0.0", MajorBump},
+		{"1.2.3", "1.3.0", MinorBump},
+		{"1.2.3", "1.2.4", PatchBump},
+		{"1.2.3", "1.2.4-rc.1", PatchBump},
+		{"1.2.4-rc.1", "1.2.4", PreBump},
+		{"1.2.4-rc.1", "1.2.4-rc.2", PreBump},
+	} {
+		old, _ := ParseVersion(c.old)
+		new, _ := ParseVersion(c.new)
+		if bump := ReleaseBump(old, new); bump != c.expected {
+			t.Errorf("%s -> %s is a %s bump, expected %s", c.old, c.new, bump, c.expected)
+		}
+	}
+	if !SmallerBump(PatchBump, MinorBump) || SmallerBump(MajorBump, MinorBump) || SmallerBump(PreBump, PreBump) {
+		t.Errorf("Bumps are not ordered")
+	}
+}
+
+func TestProjectAPI(t *testing.T) {
+	r := newTestRepository(t)
+	b := newTestProject(t, "ex/b")
+	writeTestSource(t, b, "type B struct{ X int }\n")
+	v, _ := ParseVersion("1.0.0")
+	if _, err := r.InstallProject(b, v, false); err != nil {
+		t.Fatal(err)
+	}
+	a := newTestProject(t, "ex/a", "ex/b ^1.0")
+	writeTestSource(t, a, `import ("ex/b"; "io")
+type T struct{ R io.Reader; y int }
+func (T) Read(p []byte) (int, error) { return 0, nil }
+func F(x b.B) *T { return nil }
+const c = 1
+`)
+	api, err := r.ProjectAPI(a)
+	if err != nil {
+		t.Fatalf("Cannot read the API: %v", err)
+	}
+	expected := API{
+		"ex/a.T":      "type T struct",
+		"ex/a.T.R":    "field T.R io.Reader",
+		"ex/a.T.Read": "method (T) Read(p []byte) (int, error)",
+		"ex/a.F":      "func F(x ex/b.B) *T",
+	}
+	if len(api) != len(expected) {
+		t.Errorf("API %v, expected %v", api, expected)
+	}
+	for id, desc := range expected {
+		if api[id] != desc {
+			t.Errorf("%s is %q, expected %q", id, api[id], desc)
+		}
+	}
+
+	// an unknown type is not described as an invalid one
+	writeTestSource(t, a, "import \"ex/c\"\nfunc F() c.C { return nil }\n")
+	if api, err = r.ProjectAPI(a); err == nil {
+		t.Errorf("The API with an unknown import has been read: %v", api)
+	}
+}
@@ -0,0 +1,52 @@
+package gopack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//ArchiveDir is the directory, in the repository root, where the archives of served packages are cached.
+// Serving a file, rather than packing the package on the fly, is what makes it possible to resume interrupted downloads.
+const ArchiveDir = ".gpkarchives"
+
+//ArchiveTag identifies the content of the package archive: it changes whenever the package is replaced.
+func ArchiveTag(p *Package) string {
+	h := sha256.Sum256([]byte(p.Digest() + " " + p.Timestamp().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(h[:8])
+}
+
+//Archive opens the archive of the package sources (see Package.Pack). It is packed only once, and then cached in the ArchiveDir.
+func (r *LocalRepository) Archive(p *Package) (f *os.File, err error) {
+	dir := filepath.Join(r.root, ArchiveDir, p.Path())
+	path := filepath.Join(dir, ArchiveTag(p)+".tar.gz")
+	if f, err = os.Open(path); err == nil {
+		return
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz")) // archives of the replaced package, if any
+	for _, s := range stale {
+		os.Remove(s)
+	}
+	if err = os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(dir, "pack")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	err = p.Pack(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil { // concurrent requests might be packing it too, the last one wins
+		return
+	}
+	return os.Open(path)
+}
@@ -2,6 +2,7 @@ package cmds
 
 import (
 	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
 	"ericaro.net/gopack/semver"
 )
 
@@ -12,6 +13,18 @@ func init() {
 
 }
 
+//versionMode is the syntax of the versions given on the command line: snapshot names are allowed, unless it is strict
+func versionMode(strict bool) semver.Mode {
+	if strict {
+		return semver.StrictMode
+	}
+	return semver.SnapshotMode
+}
+
+var installSignFlag *string
+var installForceFlag *bool
+var installNoAPIFlag *bool
+var installStrictFlag *bool
 var Install = Command{
 	Name:      `install`,
 	Alias:     `i`,
@@ -19,24 +32,63 @@ var Install = Command{
 	Short:     `Install into the local repository`,
 	Long: `Install the current project sources in the local repository.
        
-       VERSION is a semantic version to identify this specific project version.
-       See http://semver.org for more details about semantic versions.
+       VERSION is a semantic version to identify this specific project version, or a snapshot
+       name, e.g. master for 0.0.0-master. With -strict, it must follow http://semver.org strictly.
+       With -sign, the package is signed with the private key in KEYFILE.
+
+       A snapshot is installed as a new build, e.g. master+20261017.153000.3, see 'gpk retention'.
+       Releases (any version but snapshots) are write-once: installing the same release twice
+       fails, unless -force is set. Replacements are logged in the ` + ReleaseLogFile + ` file of the local repository.
+
+       The project API is compared with the previous release installed: it fails if VERSION is not
+       bumped enough for the changes (see 'gpk apidiff'), unless -force is set, then it only warns.
+       -noapi skips this check.
 `,
 	RequireProject: true,
+	FlagInit: func(Install *Command) {
+		installSignFlag = Install.Flag.String("sign", "", "KEYFILE. Sign the package with this PEM private key (Ed25519 or RSA).")
+		installForceFlag = Install.Flag.Bool("force", false, "replace the release if it is already installed, or install a version not bumped enough for the API changes.")
+		installNoAPIFlag = Install.Flag.Bool("noapi", false, "do not check the API changes since the previous release.")
+		installStrictFlag = Install.Flag.Bool("strict", false, "VERSION must follow semver 2.0 strictly, snapshot names are rejected.")
+	},
 	Run: func(Install *Command)  (err error){
 	
 		if len(Install.Flag.Args()) !=1 {
 			ErrorStyle.Printf("Missing version arguments\n")
 			NormalStyle.Printf("       gpk install VERSION\n")
 			return InvalidArgumentSize()
+		}
+		version, err := semver.Parse(Install.Flag.Arg(0), versionMode(*installStrictFlag))
+		if err != nil {
+			ErrorStyle.Printf("Syntax error on Version %s\n    \u21b3 %v\n", Install.Flag.Arg(0), err)
+			return
+		}
+		if !*installNoAPIFlag {
+			if err = checkAPI(Install, version, *installForceFlag); err != nil {
+				return
+			}
+		}
+		pkg, err := Install.Repository.InstallProject(Install.Project, version, *installForceFlag)
+		if err == protocol.StatusCannotOverwrite {
+			ErrorStyle.Printf("Release %s %s is already installed, releases cannot be replaced.\n", Install.Project.Name(), version.String())
+			NormalStyle.Printf("       Use a new version, or -force to replace it anyway.\n")
 			return
 		}
-		version, err := semver.ParseVersion(Install.Flag.Arg(0))
 		if err != nil {
-			ErrorStyle.Printf("Syntax error on Version %s\n", Install.Flag.Arg(0))
+			ErrorStyle.Printf("Cannot install the project:\n    \u21b3 %v\n", err)
 			return
 		}
-		Install.Repository.InstallProject(Install.Project, version)
+		if *installSignFlag != "" {
+			sig, err := signPackage(pkg, *installSignFlag)
+			if err != nil {
+				ErrorStyle.Printf("Cannot sign the package:\n    \u21b3 %v\n", err)
+				return err
+			}
+			SuccessStyle.Printf("Signed with key %s\n", sig.KeyID())
+		}
+		if version.IsSnapshot() {
+			SuccessStyle.Printf("       +%s %s\n", pkg.Name(), pkg.Version().String())
+		}
 		return
 	},
 }
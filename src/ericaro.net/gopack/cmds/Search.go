@@ -2,7 +2,6 @@ package cmds
 
 import (. "ericaro.net/gopack"
 	"ericaro.net/gopack/protocol"
-	"fmt"
 	)
 
 func init() {
@@ -33,10 +32,10 @@ var Search = Command{
 			remote, err := Search.Repository.Remote(rem)
 			if err != nil {
 				ErrorStyle.Printf("Unknown remote %s.\n", rem)
-				fmt.Printf("Available remotes are:\n")
+				ErrorStyle.Printf("Available remotes are:\n")
 				for _, r := range Search.Repository.Remotes() {
 					u := r.Path()
-					fmt.Printf("    %-40s %s\n", r.Name(), u.String())
+					ErrorStyle.Printf("    %-40s %s\n", r.Name(), u.String())
 				}
 				return err
 			}
@@ -45,6 +44,13 @@ var Search = Command{
 			result = Search.Repository.Search(search, 0)
 		}
 		// result contains the acual results every error should have been processed
+		if jsonFormat() {
+			docs := make([]PackageDoc, 0, len(result))
+			for _, pid := range result {
+				docs = append(docs, pidDoc(pid))
+			}
+			return printJSON(docs)
+		}
 
 		pkg := "" //(to avoid printing again and again the package name
 		for _, pid := range result {
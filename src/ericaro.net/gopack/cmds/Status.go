@@ -32,6 +32,14 @@ var Status = Command{
 			return
 		}
 		
+		if jsonFormat() {
+			return printJSON(StatusDoc{
+				Name:         Status.Project.Name(),
+				License:      Status.Project.License().FullName,
+				Dependencies: dependencyDocs(Status.Project.Dependencies()),
+				Remotes:      remoteDocs(Status.Repository, Status.Repository.Remotes()),
+			})
+		}
 		TitleStyle.Printf("    Name        : %s\n", Status.Project.Name())
 		SuccessStyle.Printf("    License     : %s\n", Status.Project.License().FullName)
 		dep := Status.Project.Dependencies()
@@ -40,7 +48,7 @@ var Status = Command{
 		} else {
 			SuccessStyle.Printf("    Dependencies:\n")
 			for _, d := range dep {
-				SuccessStyle.Printf("        %-40s %s\n", d.Name(), d.Version().String())
+				SuccessStyle.Printf("        %-40s %-20s %s\n", d.Name(), d.Constraint().String(), scopeLabel(d))
 			}
 		}
 
@@ -51,13 +59,7 @@ var Status = Command{
 			SuccessStyle.Printf("    Remotes     :\n")
 			for _, r := range rem {
 				u := r.Path()
-				tr := "" // str repr of the token
-				t := r.Token()
-				if t != nil { // applies only if not nul
-					tr = t.FormatStd()
-				}
-
-				SuccessStyle.Printf("        %-40s %-40s %s\n", r.Name(), u.String(), tr)
+				SuccessStyle.Printf("        %-40s %-40s %s%s\n", r.Name(), u.String(), Status.Repository.RedactedToken(r), originLabel(Status.Repository, r))
 			}
 		}
 		return
@@ -0,0 +1,157 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/semver"
+	"errors"
+)
+
+func init() {
+	Reg(
+		&ApiDiff,
+	)
+}
+
+//printAPIChanges prints the changes, the incompatible ones first
+func printAPIChanges(changes []APIChange) {
+	for _, c := range changes {
+		if !c.Compatible() {
+			ErrorStyle.Printf("    %s\n", c)
+		}
+	}
+	for _, c := range changes {
+		if c.Compatible() {
+			SuccessStyle.Printf("    %s\n", c)
+		}
+	}
+}
+
+//checkAPI compares the project API with the previous normal release installed in the local repository, and fails
+// if version is not bumped enough for the changes. With force, it only warns.
+// Prereleases are skipped: 1.3.0-rc.1 and 1.3.0 are compared with 1.2.x, as 1.3.0-rc.0 already was.
+func checkAPI(c *Command, version semver.Version, force bool) (err error) {
+	if version.IsSnapshot() {
+		return
+	}
+	var previous *semver.Version
+	versions := c.Repository.Versions(c.Project.Name())
+	for i, v := range versions {
+		if !v.IsSnapshot() && v.PreRelease() == "" && v.LowerThan(version) && (previous == nil || previous.LowerThan(v)) {
+			previous = &versions[i]
+		}
+	}
+	if previous == nil {
+		return
+	}
+	pkg, err := c.Repository.FindPackage(*NewProjectID(c.Project.Name(), *previous))
+	if err != nil {
+		return nil
+	}
+	old, err := c.Repository.PackageAPI(pkg)
+	if err != nil {
+		ErrorStyle.Printf("Warning: cannot read the API of %s, the API changes are not checked:\n    \u21b3 %v\n", pkg.ID(), err)
+		return nil
+	}
+	api, err := c.Repository.ProjectAPI(c.Project)
+	if err != nil {
+		ErrorStyle.Printf("Warning: cannot read the project API, the API changes are not checked:\n    \u21b3 %v\n", err)
+		return nil
+	}
+	changes := CompareAPI(old, api)
+	required, actual := RequiredBump(*previous, changes), ReleaseBump(*previous, version)
+	if !SmallerBump(actual, required) {
+		return
+	}
+	warning := ""
+	if force {
+		warning = "Warning: "
+	}
+	ErrorStyle.Printf("%s%s -> %s is a %s release, the API changes since %s require a %s one:\n", warning, previous.String(), version.String(), actual, previous.String(), required)
+	printAPIChanges(changes)
+	if force {
+		return nil
+	}
+	NormalStyle.Printf("       Use a larger version, or -force to accept it anyway.\n")
+	return errors.New("insufficient version bump")
+}
+
+//APIDiffDoc is the 'gpk apidiff' json document
+type APIDiffDoc struct {
+	Name     string
+	Old, New string
+	Changes  []APIChangeDoc
+	Required string // the smallest bump required by the changes
+	Bump     string // the actual bump from Old to New
+}
+
+//APIChangeDoc is an identifier that has been added (Old is empty), removed (New is empty) or changed
+type APIChangeDoc struct {
+	Name       string
+	Old        string `json:",omitempty"`
+	New        string `json:",omitempty"`
+	Compatible bool
+}
+
+var ApiDiff = Command{
+	Name:      `apidiff`,
+	Alias:     `ad`,
+	Category:  DependencyCategory,
+	UsageLine: `NAME V1 V2`,
+	Short:     `Compare the API of two versions of a package`,
+	Long: `Compare the exported API of two versions of the package NAME, installed in the local repository,
+       and report the identifiers removed (-), changed (~) or added (+) from V1 to V2.
+       Removed and changed identifiers are incompatible: they require a major release, added ones a minor
+       release (for 0.y.z versions, a minor and a patch release respectively).
+       It fails if V2 is not bumped enough from V1.
+
+       'gpk install' and 'gpk release' check the project API against the previous normal release the same way,
+       prereleases are skipped: 1.3.0-rc.1 and 1.3.0 are both checked against 1.2.x.`,
+	RequireProject: false,
+	Run: func(ApiDiff *Command) (err error) {
+		if len(ApiDiff.Flag.Args()) != 3 {
+			ErrorStyle.Printf("Illegal arguments count\n")
+			NormalStyle.Printf("       gpk apidiff NAME V1 V2\n")
+			return InvalidArgumentSize()
+		}
+		name := ApiDiff.Flag.Arg(0)
+		apis := make([]API, 2)
+		versions := make([]semver.Version, 2)
+		for i, arg := range ApiDiff.Flag.Args()[1:] {
+			if versions[i], err = semver.ParseVersion(arg); err != nil {
+				ErrorStyle.Printf("Invalid Version \"%s\".\n    \u21b3 %s\n", arg, err)
+				return
+			}
+			pkg, err := ApiDiff.Repository.FindPackage(*NewProjectID(name, versions[i]))
+			if err != nil {
+				ErrorStyle.Printf("Cannot find Package %s %s in Local Repository %s.\n    \u21b3 %s\n", name, arg, ApiDiff.Repository.Root(), err)
+				return err
+			}
+			if apis[i], err = ApiDiff.Repository.PackageAPI(pkg); err != nil {
+				ErrorStyle.Printf("Cannot read the API of %s:\n    \u21b3 %v\n", pkg.ID(), err)
+				return err
+			}
+		}
+		changes := CompareAPI(apis[0], apis[1])
+		required, actual := RequiredBump(versions[0], changes), ReleaseBump(versions[0], versions[1])
+
+		if jsonFormat() {
+			doc := APIDiffDoc{Name: name, Old: versions[0].String(), New: versions[1].String(), Changes: make([]APIChangeDoc, 0, len(changes)), Required: string(required), Bump: string(actual)}
+			for _, c := range changes {
+				doc.Changes = append(doc.Changes, APIChangeDoc{c.Name, c.Old, c.New, c.Compatible()})
+			}
+			err = printJSON(doc)
+		} else {
+			TitleStyle.Printf("\nAPI CHANGES FROM %s %s TO %s:\n", name, versions[0].String(), versions[1].String())
+			if len(changes) == 0 {
+				SuccessStyle.Printf("    <none>\n")
+			}
+			printAPIChanges(changes)
+			NormalStyle.Printf("\nThe changes require a %s release, %s is a %s release.\n", required, versions[1].String(), actual)
+		}
+		if err == nil && SmallerBump(actual, required) {
+			ErrorStyle.Printf("%s is not bumped enough from %s\n", versions[1].String(), versions[0].String())
+			return errors.New("insufficient version bump")
+		}
+		return
+	},
+}
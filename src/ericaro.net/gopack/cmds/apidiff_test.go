@@ -0,0 +1,66 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckAPI(t *testing.T) {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	p := newTestProject(t, "ex/a")
+	// an addition requires a minor release
+	if err = ioutil.WriteFile(filepath.Join(p.WorkingDir(), "src", "ex", "a", "f.go"), []byte("package a\nfunc F() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Command{Project: p, Repository: r}
+	for _, check := range []struct {
+		version string
+		force   bool
+		fails   bool
+	}{
+		{"1.0.1", false, true},
+		{"1.0.1", true, false},
+		{"1.1.0", false, false},
+		{"0.0.0-master", false, false}, // snapshots are not checked
+	} {
+		v, _ := ParseVersion(check.version)
+		if err := checkAPI(c, v, check.force); (err != nil) != check.fails {
+			t.Errorf("Checking %s (force %v): %v", check.version, check.force, err)
+		}
+	}
+}
+
+func TestCheckAPIPrereleases(t *testing.T) {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	installTestPackage(t, r, "ex/a", "1.2.0")
+	p := newTestProject(t, "ex/a")
+	if err = ioutil.WriteFile(filepath.Join(p.WorkingDir(), "src", "ex", "a", "f.go"), []byte("package a\nfunc F() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Command{Project: p, Repository: r}
+	// the prereleases of a minor release, and the release, without any other API change
+	for _, version := range []string{"1.3.0-rc.0", "1.3.0-rc.1", "1.3.0"} {
+		v, _ := ParseVersion(version)
+		if err = checkAPI(c, v, false); err != nil {
+			t.Errorf("Checking %s: %v", version, err)
+		}
+		if _, err = r.InstallProject(p, v, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the addition still requires a minor release
+	v, _ := ParseVersion("1.2.1-rc.0")
+	if err = checkAPI(c, v, false); err == nil {
+		t.Errorf("1.2.1-rc.0 is not bumped enough from 1.2.0")
+	}
+}
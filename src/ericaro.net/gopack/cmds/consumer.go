@@ -0,0 +1,110 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/oauth"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func init() {
+	Reg(
+		&ConsumerCmd,
+	)
+}
+
+//readProvider reads the OAuth provider file of the local repository, or creates a new one if it is missing.
+func readProvider(c *Command, acl *AccessControl) (*oauth.Provider, error) {
+	path := filepath.Join(c.Repository.Root(), oauth.ProviderFile)
+	if !FileExists(path) {
+		return oauth.NewProvider(path, acl), nil
+	}
+	return oauth.ReadProvider(path, acl)
+}
+
+var consumerSecretFlag *string
+var consumerRemoveFlag *bool
+var ConsumerCmd = Command{
+	Name:      `consumer`,
+	Alias:     `oc+`,
+	Category:  RemoteCategory,
+	UsageLine: `KEY [PEMFILE]`,
+	Short:     `Register an OAuth consumer of the served repository`,
+	Long: `Register the OAuth 1.0 consumer KEY, for 'gpk serve -oauth'.
+       KEY     the consumer key, given to 'gpk radd -o -oauth-consumer KEY'
+       PEMFILE the consumer RSA public key (or private key, only its public part is kept), to verify
+               RSA-SHA1 signatures.
+
+       With -secret, HMAC-SHA1 and PLAINTEXT signatures are verified with the consumer secret.
+       Registering an existing consumer replaces it. With -r, the consumer is removed, along with the
+       access tokens granted to it.
+       Without arguments, it lists the consumers.`,
+	RequireProject: false,
+	FlagInit: func(ConsumerCmd *Command) {
+		consumerSecretFlag = ConsumerCmd.Flag.String("secret", "", "SECRET. The consumer secret.")
+		consumerRemoveFlag = ConsumerCmd.Flag.Bool("r", false, "remove the consumer.")
+	},
+	Run: func(ConsumerCmd *Command) (err error) {
+		provider, err := readProvider(ConsumerCmd, nil)
+		if err != nil {
+			ErrorStyle.Printf("Invalid OAuth provider file %s:\n    \u21b3 %v\n", provider.Path(), err)
+			return
+		}
+		args := ConsumerCmd.Flag.Args()
+		if len(args) == 0 {
+			TitleStyle.Printf("\nCONSUMERS in %s:\n", provider.Path())
+			for _, c := range provider.Consumers() {
+				methods := ""
+				if c.PublicKey != "" {
+					methods += " " + oauth.RSA_SHA1
+				}
+				if c.Secret != "" {
+					methods += " " + oauth.HMAC_SHA1 + " " + oauth.PLAINTEXT
+				}
+				SuccessStyle.Printf("        %-20s%s\n", c.Key, methods)
+			}
+			return
+		}
+		if len(args) > 2 {
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+
+		key := args[0]
+		if *consumerRemoveFlag {
+			if !provider.RemoveConsumer(key) {
+				ErrorStyle.Printf("Unknown consumer %s\n", key)
+				return
+			}
+			if err = provider.Write(); err != nil {
+				ErrorStyle.Printf("Cannot write the OAuth provider file:\n    \u21b3 %v\n", err)
+				return
+			}
+			SuccessStyle.Printf("       -%s\n", key)
+			return
+		}
+
+		c := oauth.ConsumerEntry{Key: key, Secret: *consumerSecretFlag}
+		if len(args) == 2 {
+			data, err := ioutil.ReadFile(args[1])
+			if err != nil {
+				ErrorStyle.Printf("Cannot read the public key:\n    \u21b3 %v\n", err)
+				return err
+			}
+			if c.PublicKey, err = oauth.ParsePublicKey(data); err != nil {
+				ErrorStyle.Printf("Invalid public key %s:\n    \u21b3 %v\n", args[1], err)
+				return err
+			}
+		}
+		if err = provider.AddConsumer(c); err != nil {
+			ErrorStyle.Printf("Invalid consumer:\n    \u21b3 %v\n", err)
+			return
+		}
+		if err = provider.Write(); err != nil {
+			ErrorStyle.Printf("Cannot write the OAuth provider file:\n    \u21b3 %v\n", err)
+			return
+		}
+		SuccessStyle.Printf("       +%s\n", key)
+		return
+	},
+}
@@ -0,0 +1,83 @@
+package cmds
+
+import (
+	"bufio"
+	. "ericaro.net/gopack"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	Reg(
+		&Credentials,
+	)
+}
+
+//askPassphrase reads the credentials file passphrase on the terminal, without echoing it if possible
+func askPassphrase() (string, error) {
+	fmt.Fprintf(os.Stderr, "Passphrase of the credentials file (or set %s): ", PassphraseEnv)
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer stty("echo")
+	}
+	phrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	return strings.TrimRight(phrase, "\r\n"), err
+}
+
+var Credentials = Command{
+	Name:      `credentials`,
+	Alias:     `cred`,
+	Category:  RemoteCategory,
+	UsageLine: `[file|helper:COMMAND|none]`,
+	Short:     `Choose where the remote tokens are stored`,
+	Long: `Move the remote tokens to another store, the .gpkrepository file only references them.
+       file            the tokens are encrypted in the .gpkcredentials file of the local repository,
+                       with a key derived from a passphrase: the GPK_PASSPHRASE environment variable,
+                       or asked for when a token is needed. It is the default store of new local
+                       repositories: the ones created by an older gpk keep their tokens in clear until
+                       they are moved with this command.
+       helper:COMMAND  the tokens are kept by an external credential helper, like git's: COMMAND is run
+                       with "get", "store" or "erase", and reads "ref=REF" (and "token=TOKEN" to store it)
+                       on its standard input, up to a blank line. "get" prints "token=TOKEN".
+                       A COMMAND that is not a path is the gpk-credential-COMMAND executable,
+                       a COMMAND starting with "!" is run by the shell.
+       none            the tokens are written back into the .gpkrepository file, in clear.
+
+       Without arguments, it prints the current store.`,
+	RequireProject: false,
+	Run: func(Credentials *Command) (err error) {
+		r := Credentials.Repository
+		if len(Credentials.Flag.Args()) > 1 {
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+		spec := Credentials.Flag.Arg(0)
+		if spec == "" {
+			if r.Credentials() == nil {
+				NormalStyle.Printf("Tokens are stored in %s\n", GpkrepositoryFile)
+			} else {
+				NormalStyle.Printf("Tokens are stored in %s\n", r.Credentials())
+			}
+			return
+		}
+
+		store, err := r.ParseCredentials(spec)
+		if err != nil {
+			ErrorStyle.Printf("Invalid credential store:\n    \u21b3 %v\n", err)
+			return
+		}
+		if err = r.SetCredentials(store); err != nil {
+			ErrorStyle.Printf("Cannot move the tokens:\n    \u21b3 %v\n", err)
+			return
+		}
+		SuccessStyle.Printf("       +%s\n", spec)
+		return
+	},
+}
@@ -3,6 +3,7 @@ package cmds
 import (
 	. "ericaro.net/gopack"
 	. "ericaro.net/gopack/semver"
+	"strings"
 )
 
 func init() {
@@ -12,6 +13,8 @@ func init() {
 	)
 }
 
+var addScopeFlag *string
+var addStrictFlag *bool
 var Add = Command{
 	Name:      `dadd`,
 	Alias:     `d+`,
@@ -21,18 +24,45 @@ var Add = Command{
 	Long: `Add a dependency.
        
        NAME     dependency package name
-       VERSION  a semantic version  
+       VERSION  a semantic version, or a constraint on it:
+                1.2.3            exactly 1.2.3
+                master           the newest build of the snapshot master
+                master+20261017.153000.3
+                                 exactly that build of master, see 'gpk retention'
+                '>=1.0.0 <2.0.0' all comparators must match
+                ^1.2             compatible with 1.2 (>=1.2.0 <2.0.0)
+                ~1.4.3           patch updates (>=1.4.3 <1.5.0)
+                1.x              any 1.y.z version
+       With -strict, the versions must follow http://semver.org strictly, e.g. 0.0.0-master.
+
+       With -scope, the dependency is only required for:
+       compile  compiling the project, and the projects depending on it. This is the default.
+       test     compiling and running the project tests, see 'gpk test'.
+       tool     its executables, installed in the project ` + ToolsDir + ` directory by 'gpk compile'.
 `,
 	RequireProject: true,
+	FlagInit: func(Add *Command) {
+		addScopeFlag = Add.Flag.String("scope", string(CompileScope), "compile, test or tool. What the dependency is required for.")
+		addStrictFlag = Add.Flag.Bool("strict", false, "VERSION must follow semver 2.0 strictly, snapshot names are rejected.")
+	},
 	Run: func(Add *Command) (err error) {
 
-		if len(Add.Flag.Args()) != 2 {
+		if len(Add.Flag.Args()) < 2 {
 			Add.Flag.Usage()
 			return InvalidArgumentSize()
 		}
-		name, version := Add.Flag.Arg(0), Add.Flag.Arg(1)
-		v, _ := ParseVersion(version)
-		ref := *NewProjectID(name, v)
+		name, version := Add.Flag.Arg(0), strings.Join(Add.Flag.Args()[1:], " ")
+		c, err := ParseConstraintMode(version, versionMode(*addStrictFlag))
+		if err != nil {
+			ErrorStyle.Printf("Invalid version %s\n    \u21b3 %v\n", version, err)
+			return
+		}
+		scope, err := ParseScope(*addScopeFlag)
+		if err != nil {
+			ErrorStyle.Printf("Invalid scope\n    \u21b3 %v\n", err)
+			return
+		}
+		ref := *NewScopedDependency(name, c, scope)
 		rem := Add.Project.AppendDependency(ref)
 		if rem != nil{
 		SuccessStyle.Printf("       - %v\n", rem)
@@ -64,10 +94,10 @@ var Remove = Command{
 		name := Remove.Flag.Arg(0)
 		ref := Remove.Project.RemoveDependency(name)
 		if ref != nil {
-			SuccessStyle.Printf("Removed Dependency %s %s\n", ref.Name(), ref.Version().String())
+			SuccessStyle.Printf("Removed Dependency %s %s\n", ref.Name(), ref.Constraint().String())
 			Remove.Project.Write()
 		} else {
-			ErrorStyle.Printf("Nothing to remove %s\n")
+			ErrorStyle.Printf("Nothing to remove %s\n", name)
 		}
 		return nil
 	},
@@ -28,6 +28,7 @@ var compileOfflineFlag *bool
 var compileUpdateFlag *bool
 var compileSkipTestFlag *bool
 var compileLDFlag *string
+var compileNoLockFlag *bool
 var Compile = Command{
 	Name:      `compile`,
 	Alias:     `c`,
@@ -35,7 +36,10 @@ var Compile = Command{
 	UsageLine: ``,
 	Short:     `Compile project`,
 	Long: `Computes current project dependencies as a GOPATH variable (accessible through the p Option),
-       and then run go install on the project.`,
+       and then run go install on the project.
+       The test scope dependencies are only in the GOPATH of the tests compilation. The executables
+       of the tool scope dependencies, for the current platform, are installed in the ` + ToolsDir + ` directory:
+       they must be signed by a key trusted for them, see 'gpk trust'. Their own dependencies are not resolved.`,
 	RequireProject: true,
 	FlagInit: func(Compile *Command) {
 		compileAllFlag = Compile.Flag.Bool("a", false, "all. Force rebuilding of packages that are already up-to-date.")
@@ -43,21 +47,28 @@ var Compile = Command{
 		compileUpdateFlag = Compile.Flag.Bool("u", false, "update. Look for updated version of dependencies.")
 		compileLDFlag = Compile.Flag.String("ldflags", "", "pass ldflags to all sub commands")
 		compileSkipTestFlag = Compile.Flag.Bool("s", false, "skip. Skip Test compilation")
+		compileNoLockFlag = Compile.Flag.Bool("nolock", false, "nolock. Ignore the lock file, and resolve dependencies again.")
 	},
 	Run: func(Compile *Command) (err error) {
 		// parse dependencies, and build the gopath
-		dependencies, err := Compile.Repository.ResolveDependencies(Compile.Project, *compileOfflineFlag, *compileUpdateFlag)
+		dependencies, err := resolveLocked(Compile, *compileOfflineFlag, *compileUpdateFlag, *compileNoLockFlag)
 		if err != nil {
 			ErrorStyle.Printf("Error Resolving project's dependencies:\n    \u21b3 %v", err)
 			return
 		}
+		graph := NewGraph(Compile.Project, dependencies)
+		if err = installTools(Compile.Repository, Compile.Project, graph); err != nil {
+			return
+		}
 		// run the go build command for local src, and with the appropriate gopath
-		gopath, err := Compile.Repository.GoPath(dependencies)
+		gopath, err := Compile.Repository.GoPath(graph.Scope(CompileScope))
 
 		goEnv := gocmd.NewGoEnv(gopath)
 		err = goEnv.Install(Compile.Project.WorkingDir(), *compileAllFlag, *compileLDFlag)
 
 		if !*compileSkipTestFlag {
+			testpath, _ := Compile.Repository.GoPath(graph.Scope(CompileScope, TestScope))
+			goEnv = gocmd.NewGoEnv(testpath)
 
 			//compute the GOOS that will be used by the test compiler (mainly to be crossplatform compliant
 			goos := runtime.GOOS //default value
@@ -104,31 +115,57 @@ var Compile = Command{
 	},
 }
 
+//installTools installs the executables of the project ToolScope dependencies in its ToolsDir. They must be signed by a trusted key.
+func installTools(r *LocalRepository, p *Project, graph *Graph) (err error) {
+	platform := runtime.GOOS + "_" + runtime.GOARCH // tools run here, whatever the GOOS used to compile
+	for _, d := range FilterScope(p.Dependencies(), ToolScope) {
+		pkg := graph.Package(d.Name())
+		if pkg == nil {
+			continue
+		}
+		installed, err := r.InstallExecutables(pkg, p.ToolsDir(), platform)
+		if err != nil {
+			ErrorStyle.Printf("Cannot install the %s tools:\n    \u21b3 %v\n", pkg.ID(), err)
+			return err
+		}
+		if len(installed) == 0 {
+			ErrorStyle.Printf("Warning: %s has no executables for %s\n", pkg.ID(), platform)
+		}
+		for _, exe := range installed {
+			rel, _ := filepath.Rel(p.WorkingDir(), exe)
+			SuccessStyle.Printf("       +%s (%s)\n", rel, pkg.ID())
+		}
+	}
+	return
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////:
 
 var testWatchFlag *time.Duration
+var testNoLockFlag *bool
 var Test = Command{
 	Name:           `test`,
 	Alias:          `t`,
 	Category:       CompileCategory,
 	UsageLine:      ``,
 	Short:          `Run go test`,
-	Long:           `Run go test on the whole project.`, // TODO add options to select the package to be executed
+	Long:           `Run go test on the whole project, with the test scope dependencies in the GOPATH.`, // TODO add options to select the package to be executed
 	RequireProject: true,
 	FlagInit: func(Test *Command) {
 		testWatchFlag = Test.Flag.Duration("w", 0, "watch. Repeat the command for ever every watched seconds")
+		testNoLockFlag = Test.Flag.Bool("nolock", false, "nolock. Ignore the lock file, and resolve dependencies again.")
 	},
 	Run: func(Test *Command) (err error) {
 
 		// parse dependencies, and build the gopath
-		dependencies, err := Test.Repository.ResolveDependencies(Test.Project, true, false)
+		dependencies, err := resolveLocked(Test, true, false, *testNoLockFlag)
 		if err != nil {
 			ErrorStyle.Printf("Error Resolving project's dependencies:\n    \u21b3 %v", err)
 			return
 		}
 
-		// run the go build command for local src, and with the appropriate gopath
-		gopath, err := Test.Repository.GoPath(dependencies)
+		// run the go build command for local src, and with the appropriate gopath, test scope included
+		gopath, err := Test.Repository.GoPath(NewGraph(Test.Project, dependencies).Scope(CompileScope, TestScope))
 		if err != nil {
 			ErrorStyle.Printf("Invalid dependency:\n    \u21b3 %v", err)
 			return
@@ -0,0 +1,174 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	Reg(
+		&GC,
+	)
+}
+
+//UsageDoc is the 'gpk gc' json document: the packages removed, or with -du all of them
+type UsageDoc struct {
+	Packages []PackageUsageDoc
+	Size     int64 // of the packages, in bytes
+	Freed    int64 `json:",omitempty"` // in bytes, including the caches
+	Budget   int64 `json:",omitempty"` // in bytes
+}
+
+//PackageUsageDoc is the disk space used by a package
+type PackageUsageDoc struct {
+	Name       string
+	Version    string
+	Size       int64 // in bytes
+	Accessed   time.Time
+	Remote     string `json:",omitempty"` // where it has been downloaded from
+	Referenced bool   // used by a registered project
+	Leased     bool   // used by a running gpk process
+}
+
+func usageDoc(u PackageUsage, refs *References, leased map[string]bool) PackageUsageDoc {
+	return PackageUsageDoc{
+		Name:       u.Package.Name(),
+		Version:    u.Package.Version().String(),
+		Size:       u.Size,
+		Accessed:   u.Accessed,
+		Remote:     u.Package.Remote(),
+		Referenced: refs != nil && refs.Has(u.Package),
+		Leased:     leased[filepath.ToSlash(u.Package.Path())],
+	}
+}
+
+var gcUnusedFlag *bool
+var gcBudgetFlag *string
+var gcSaveFlag *bool
+var gcUsageFlag *bool
+var gcDryRunFlag *bool
+var GC = Command{
+	Name:      `gc`,
+	Alias:     `gc`,
+	Category:  RemoteCategory,
+	UsageLine: ``,
+	Short:     `Remove the packages the local repository no longer needs`,
+	Long: `Remove packages from the local repository, and the caches of the removed ones.
+
+       -unused  removes the versions that no registered project uses. Every project gpk runs in is
+                registered, until its directory is removed. A project uses the packages of its lock file,
+                its dependencies of every scope resolved offline, and its own versions. It fails if the
+                dependencies of a registered project cannot be resolved offline.
+       -budget  evicts the least recently used packages until the others fit in SIZE, like 500M or 2G.
+                Only the packages downloaded from a remote are evicted: they can be downloaded again.
+                With -save, SIZE becomes the budget of every 'gpk gc', 0 removes it.
+       -du      only reports the disk used by every package, the least recently used first.
+
+       Packages used by a running gpk process, like a compile, are never removed.
+       Staging directories and partial downloads older than a day are removed too.`,
+	RequireProject: false,
+	FlagInit: func(GC *Command) {
+		gcUnusedFlag = GC.Flag.Bool("unused", false, "remove the packages that no registered project uses.")
+		gcBudgetFlag = GC.Flag.String("budget", "", "SIZE. Evict the least recently used packages beyond SIZE. The saved budget by default.")
+		gcSaveFlag = GC.Flag.Bool("save", false, "save -budget as the default budget.")
+		gcUsageFlag = GC.Flag.Bool("du", false, "disk usage. Only report the size of every package.")
+		gcDryRunFlag = GC.Flag.Bool("n", false, "dry run. Only print what would be removed.")
+	},
+	Run: func(GC *Command) (err error) {
+		r := GC.Repository
+		budget := r.DiskBudget()
+		if *gcBudgetFlag != "" {
+			if budget, err = ParseSize(*gcBudgetFlag); err != nil {
+				ErrorStyle.Printf("%v\n", err)
+				return
+			}
+		}
+		if *gcSaveFlag {
+			r.SetDiskBudget(budget)
+			if err = r.Write(); err != nil {
+				ErrorStyle.Printf("Cannot write the local repository:\n    \u21b3 %v\n", err)
+				return
+			}
+		}
+
+		var refs *References
+		if *gcUnusedFlag || *gcUsageFlag {
+			if refs, err = r.References(); err != nil {
+				if !*gcUsageFlag {
+					ErrorStyle.Printf("Cannot tell which packages are used:\n    \u21b3 %v\n", err)
+					return
+				}
+				ErrorStyle.Printf("Warning: cannot tell which packages are used:\n    \u21b3 %v\n", err)
+				err = nil
+			}
+		}
+		leased, err := r.Leased()
+		if err != nil {
+			ErrorStyle.Printf("Cannot read the leases:\n    \u21b3 %v\n", err)
+			return
+		}
+		usage, err := r.DiskUsage()
+		if err != nil {
+			ErrorStyle.Printf("Cannot read the local repository:\n    \u21b3 %v\n", err)
+			return
+		}
+
+		doc := UsageDoc{Packages: make([]PackageUsageDoc, 0), Budget: budget}
+		if *gcUsageFlag {
+			for _, u := range usage {
+				doc.Packages = append(doc.Packages, usageDoc(u, refs, leased))
+				doc.Size += u.Size
+			}
+			if jsonFormat() {
+				return printJSON(doc)
+			}
+			TitleStyle.Printf("\nDISK USAGE OF %s:\n", r.Root())
+			for _, p := range doc.Packages {
+				flags := ""
+				if p.Leased {
+					flags += " leased"
+				}
+				if refs != nil && !p.Referenced {
+					flags += " unused"
+				}
+				SuccessStyle.Printf("    %10s  %s  %-40s %-20s%s\n", formatSize(p.Size), p.Accessed.Format("2006-01-02 15:04"), p.Name, p.Version, flags)
+			}
+			NormalStyle.Printf("\n    %10s  total", formatSize(doc.Size))
+			if budget > 0 {
+				NormalStyle.Printf(", budget %s", formatSize(budget))
+			}
+			NormalStyle.Printf("\n")
+			return
+		}
+
+		for _, u := range Collectable(usage, refs, leased, budget) {
+			if !*gcDryRunFlag {
+				if err := r.RemovePackage(u.Package.ID()); err != nil {
+					ErrorStyle.Printf("Cannot remove %s:\n    \u21b3 %v\n", u.Package.ID(), err)
+					continue
+				}
+			}
+			doc.Packages = append(doc.Packages, usageDoc(u, refs, leased))
+			doc.Size += u.Size
+		}
+		caches, err := r.CleanCaches(*gcDryRunFlag)
+		if err != nil {
+			ErrorStyle.Printf("Cannot clean the caches:\n    \u21b3 %v\n", err)
+			return
+		}
+		doc.Freed = doc.Size + caches
+		if jsonFormat() {
+			return printJSON(doc)
+		}
+		for _, p := range doc.Packages {
+			SuccessStyle.Printf("       -%s %s (%s)\n", p.Name, p.Version, formatSize(p.Size))
+		}
+		if *gcDryRunFlag {
+			NormalStyle.Printf("%s would be freed\n", formatSize(doc.Freed))
+		} else {
+			NormalStyle.Printf("%s freed\n", formatSize(doc.Freed))
+		}
+		return
+	},
+}
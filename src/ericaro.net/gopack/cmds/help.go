@@ -60,6 +60,6 @@ TitleStyle.Printf("\nNAME\n")
 			TitleStyle.Printf("\n\nDESCRIPTION\n")
 			fmt.Print("       " + cmd.Long)
 		}
-		fmt.Println("\n")
+		fmt.Print("\n\n")
 
 }
\ No newline at end of file
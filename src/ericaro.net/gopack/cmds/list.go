@@ -3,7 +3,9 @@ package cmds
 import (
 	. "ericaro.net/gopack"
 	"ericaro.net/gopack/gocmd"
+	"ericaro.net/gopack/semver"
 	"fmt"
+	"strings"
 )
 
 func init() {
@@ -35,16 +37,28 @@ var Imports = Command{
 		toSave := false
 		missing := Imports.Repository.MissingImports(Imports.Project, *importsOfflineFlag)
 		missingPack := Imports.Repository.MissingPackages(missing)
-		SuccessStyle.Printf("Missing imports (%d), missing packages (%d)\n", len(missing), len(missingPack))
+		doc := MissingDoc{Imports: missing, Packages: make([]MissingPackageDoc, 0, len(missingPack))}
+		if !jsonFormat() {
+			SuccessStyle.Printf("Missing imports (%d), missing packages (%d)\n", len(missing), len(missingPack))
+		}
 		for _, m := range missingPack {
 			found := Imports.Repository.ImportSearch(m)
+			if jsonFormat() {
+				candidates := make([]PackageDoc, 0, len(found))
+				for _, id := range found {
+					candidates = append(candidates, projectDoc(id))
+				}
+				doc.Packages = append(doc.Packages, MissingPackageDoc{m, candidates})
+			}
 			if len(found) > 0 {
-				SuccessStyle.Printf("Missing packages %-40s -> ☑ %s \n", m, found[0])
-				for _, pid := range found[1:] {
-					SuccessStyle.Printf("                 %-40s -> ☐ %s \n", "", pid)
+				if !jsonFormat() {
+					SuccessStyle.Printf("Missing packages %-40s -> ☑ %s \n", m, found[0])
+					for _, pid := range found[1:] {
+						SuccessStyle.Printf("                 %-40s -> ☐ %s \n", "", pid)
+					}
 				}
 				if *importsAutofixFlag {
-					Imports.Project.AppendDependency(found[0])
+					Imports.Project.AppendDependency(*NewDependency(found[0].Name(), semver.Exact(found[0].Version())))
 					toSave = true
 				}
 			}
@@ -54,6 +68,9 @@ var Imports = Command{
 			SuccessStyle.Printf("Project Updated\n")
 			Imports.Project.Write()
 		}
+		if jsonFormat() {
+			return printJSON(doc)
+		}
 		return
 	},
 }
@@ -70,11 +87,14 @@ var ListDependencies = Command{
 	FlagInit: func(ListDependencies *Command) {
 	},
 	Run: func(ListDependencies *Command) (err error) {
+		if jsonFormat() {
+			return printJSON(dependencyDocs(ListDependencies.Project.Dependencies()))
+		}
 		TitleStyle.Printf("\nLIST OF DECLARED DEPENDENCIES:\n")
 		// TODO print in a suitable way for copy pasting
 		dependencies := ListDependencies.Project.Dependencies()
 		for _, d := range dependencies {
-			SuccessStyle.Printf("        %-40s %s\n", d.Name(), d.Version().String())
+			SuccessStyle.Printf("        %-40s %-20s %s\n", d.Name(), d.Constraint().String(), scopeLabel(d))
 		}
 		return
 	},
@@ -87,23 +107,20 @@ var ListRemotes = Command{
 	Category:       RemoteCategory,
 	UsageLine:      ``,
 	Short:          `List Remotes.`,
-	Long:           `List declared remotes.`,
+	Long:           `List declared remotes, in the order they are tried, with their priority, the remote they mirror, or the prefixes they are restricted to.`,
 	RequireProject: false,
 	Run: func(ListRemotes *Command) (err error) {
+		rem := ListRemotes.Repository.OrderedRemotes()
+		if jsonFormat() {
+			return printJSON(remoteDocs(ListRemotes.Repository, rem))
+		}
 		TitleStyle.Printf("\nLIST OF REMOTES:\n")
-		rem := ListRemotes.Repository.Remotes()
 		if len(rem) == 0 {
 			SuccessStyle.Printf("       <empty>\n")
 		} else {
 			for _, r := range rem {
 				u := r.Path()
-				tr := "" // str repr of the token
-				t := r.Token()
-				if t != nil { // applies only if not nul
-					tr = t.FormatStd()
-				}
-
-				SuccessStyle.Printf("       %-8s %-40s %s\n", r.Name(), u.String(), tr)
+				SuccessStyle.Printf("       %-8s %-40s %-30s %s%s\n", r.Name(), u.String(), ListRemotes.Repository.RemotePolicy(r.Name()), ListRemotes.Repository.RedactedToken(r), originLabel(ListRemotes.Repository, r))
 			}
 		}
 		return
@@ -113,6 +130,8 @@ var ListRemotes = Command{
 var pathListFlag *bool
 var pathOfflineFlag *bool
 var pathUpdateFlag *bool
+var pathNoLockFlag *bool
+var pathScopeFlag *string
 var Path = Command{
 	Name:      `list-package`,
 	Alias:     `lp`,
@@ -120,6 +139,9 @@ var Path = Command{
 	UsageLine: ``,
 	Short:     `List all packages dependencies (recursive)`,
 	Long: `Resolve current project dependencies and print result.
+       With -format=json, it prints the GOPATH and the resolved packages, with their path.
+       Only the packages required by the dependencies in the -scope are listed, by default those
+       required to compile the project and its tests.
        
        ` + "Tip:\n           type:\n           alias GP='export GOPATH=`gpk lp`'\n           to get a simple automatic GOPATH setter.",
 	RequireProject: true,
@@ -127,17 +149,37 @@ var Path = Command{
 		pathListFlag = Path.Flag.Bool("l", false, "list. Pretty Print the list.")
 		pathOfflineFlag = Path.Flag.Bool("o", false, "offline. Do not look outside for missing dependencies")
 		pathUpdateFlag = Path.Flag.Bool("u", false, "update. Look for updated version of dependencies")
+		pathNoLockFlag = Path.Flag.Bool("nolock", false, "nolock. Ignore the lock file, and resolve dependencies again.")
+		pathScopeFlag = Path.Flag.String("scope", string(CompileScope)+","+string(TestScope), "SCOPE[,SCOPE...]. The scopes of the dependencies to list: compile, test or tool.")
 	},
 	Run: func(Path *Command) (err error) {
 
 		// parse dependencies, and build the gopath
 		//dependencies, err := Compile.Repository.ResolveDependencies(Compile.Project, *compileOfflineFlag, *compileUpdateFlag)
-		dependencies, err := Path.Repository.ResolveDependencies(Path.Project, *pathOfflineFlag, *pathUpdateFlag) // path does not update the dependencies
+		scopes := make([]Scope, 0)
+		for _, name := range strings.Split(*pathScopeFlag, ",") {
+			scope, err := ParseScope(strings.TrimSpace(name))
+			if err != nil {
+				ErrorStyle.Printf("Invalid scope\n    \u21b3 %v\n", err)
+				return err
+			}
+			scopes = append(scopes, scope)
+		}
+		dependencies, err := resolveLocked(Path, *pathOfflineFlag, *pathUpdateFlag, *pathNoLockFlag)
 		if err != nil {
 			ErrorStyle.Printf("Error Resolving project's dependencies:\n    \u21b3 %v", err)
 			return
 		}
+		dependencies = NewGraph(Path.Project, dependencies).Scope(scopes...)
 
+		if jsonFormat() {
+			gopath, _ := Path.Repository.GoPath(dependencies)
+			doc := PathDoc{GoPath: gocmd.Join(Path.Project.WorkingDir(), gopath), Packages: make([]PackageDoc, 0, len(dependencies))}
+			for _, d := range dependencies {
+				doc.Packages = append(doc.Packages, packageDoc(d))
+			}
+			return printJSON(doc)
+		}
 		if *pathListFlag {
 			TitleStyle.Printf("\nLIST OF PACKAGES:\n")
 			// run the go build command for local src, and with the appropriate gopath
@@ -0,0 +1,101 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"fmt"
+	"time"
+)
+
+func init() {
+	Reg(
+		&LockCmd,
+	)
+}
+
+//resolveLocked resolves the current project dependencies. It honours the lock file, unless nolock is set.
+// If there is no lock file yet, it writes one.
+func resolveLocked(c *Command, offline, update, nolock bool) (dependencies []*Package, err error) {
+	if nolock {
+		return c.Repository.ResolveDependencies(c.Project, offline, update)
+	}
+	lock, err := ReadLock(c.Project)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid lock file %s: %v", LockFile, err)
+	}
+	if lock != nil {
+		if update {
+			NormalStyle.Printf("Dependencies are locked in %s, use 'gpk lock -update' to update them.\n", LockFile)
+		}
+		return c.Repository.ResolveLocked(c.Project, lock, offline)
+	}
+	dependencies, err = c.Repository.ResolveDependencies(c.Project, offline, update)
+	if err != nil {
+		return
+	}
+	lock, err = NewLock(c.Project, dependencies)
+	if err == nil {
+		err = lock.Write()
+	}
+	return
+}
+
+var lockUpdateFlag *bool
+var lockOfflineFlag *bool
+var LockCmd = Command{
+	Name:      `lock`,
+	Alias:     `lk`,
+	Category:  DependencyCategory,
+	UsageLine: ``,
+	Short:     `Lock the resolved dependencies`,
+	Long: `Resolve the project dependencies and record them in the ` + LockFile + ` file.
+
+       The lock file lists every package of the dependency graph with its exact version,
+       timestamp, source remote and content digest. compile, test and list-package
+       use it instead of resolving dependencies again.
+       Without -update, it only creates the lock file if it is missing, or checks it.`,
+	RequireProject: true,
+	FlagInit: func(LockCmd *Command) {
+		lockUpdateFlag = LockCmd.Flag.Bool("update", false, "update. Resolve again, look for newer snapshots, and rewrite the lock file.")
+		lockOfflineFlag = LockCmd.Flag.Bool("o", false, "offline. Do not use the network to look for missing dependencies.")
+	},
+	Run: func(LockCmd *Command) (err error) {
+		lock, err := ReadLock(LockCmd.Project)
+		if err != nil && !*lockUpdateFlag {
+			ErrorStyle.Printf("Invalid lock file %s:\n    \u21b3 %v\n", LockFile, err)
+			return
+		}
+
+		var dependencies []*Package
+		if lock != nil && !*lockUpdateFlag {
+			dependencies, err = LockCmd.Repository.ResolveLocked(LockCmd.Project, lock, *lockOfflineFlag)
+			if err != nil {
+				ErrorStyle.Printf("Error checking the lock file:\n    \u21b3 %v\n", err)
+				return
+			}
+			SuccessStyle.Printf("Lock file is up to date.\n")
+			return
+		}
+
+		dependencies, err = LockCmd.Repository.ResolveDependencies(LockCmd.Project, *lockOfflineFlag, *lockUpdateFlag)
+		if err != nil {
+			ErrorStyle.Printf("Error Resolving project's dependencies:\n    \u21b3 %v\n", err)
+			return
+		}
+		lock, err = NewLock(LockCmd.Project, dependencies)
+		if err == nil {
+			err = lock.Write()
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot write the lock file:\n    \u21b3 %v\n", err)
+			return
+		}
+		TitleStyle.Printf("\nLOCKED PACKAGES:\n")
+		if len(lock.Packages()) == 0 {
+			SuccessStyle.Printf("       <empty>\n")
+		}
+		for _, lp := range lock.Packages() {
+			SuccessStyle.Printf("        %-40s %-20s %s %s\n", lp.ID.Name(), lp.ID.Version().String(), lp.Timestamp.Format(time.ANSIC), lp.Remote)
+		}
+		return
+	},
+}
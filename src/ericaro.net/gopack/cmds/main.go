@@ -9,10 +9,12 @@ import (
 	"io/ioutil"
 	"log"
 	"math"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sort"
+	"strings"
 )
 
 var GopackageVersion string
@@ -26,6 +28,8 @@ func init() {
 const (
 	Cmd               = "gpk"
 	DefaultRepository = ".gpkrepository"
+	LocalEnv          = "GPK_LOCAL"   // overrides the default local repository path
+	RemotesEnv        = "GPK_REMOTES" // NAME=URL remotes, separated by commas or spaces, added to the local repository ones
 
 	RemoteCategory     = -iota
 	DependencyCategory = -iota
@@ -37,8 +41,11 @@ const (
 // here are gopackage flags not specific ones
 
 var versionFlag *bool = flag.Bool("v", false, "Print the version number.")
-var localRepositoryFlag *string = flag.String("local", DefaultRepository, "path to the local repository to be used by default.")
+var localRepositoryFlag *string = flag.String("local", DefaultRepository, "path to the local repository to be used by default, unless the GPK_LOCAL environment variable is set.")
 var verboseFlag *bool = flag.Bool("verbose", false, "print verbose output.")
+var jobsFlag *int = flag.Int("j", DefaultJobs, "maximum number of packages downloaded at the same time.")
+var conflictsFlag *string = flag.String("conflicts", string(FailOnConflict), "policy on conflicting versions in the dependency graph: fail, newest or nearest to the project. Cycles are only reported.")
+var formatFlag *string = flag.String("format", TextFormat, "output format of the listing commands: text, or json documents on the standard output.")
 
 // We keep a dict AND a list of all available commands, the main command being generic
 var Commands map[string]*Command = make(map[string]*Command)
@@ -97,7 +104,7 @@ func PrintGlobalUsage() {
 	}
 
 	SuccessStyle.Printf("\n\n       Type 'gpk help [COMMAND]' for more details about a command.")
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 }
 
 //Gopack is the main. the real function main lies outside to create an executable
@@ -112,6 +119,10 @@ func Gopack() {
 	if !*verboseFlag {
 		log.SetOutput(ioutil.Discard)
 	}
+	if err := setFormat(*formatFlag); err != nil {
+		ErrorStyle.Printf("%v\n", err)
+		return
+	}
 
 	sort.Sort(AllCommands)
 	if len(flag.Args()) == 0 {
@@ -132,15 +143,44 @@ func Gopack() {
 		ErrorStyle.Printf("Cannot initialize the default repository. %s\n", err)
 		return
 	}
+	r.SetJobs(*jobsFlag)
+	policy, err := ParseConflictPolicy(*conflictsFlag)
+	if err != nil {
+		ErrorStyle.Printf("%v\n", err)
+		return
+	}
+	r.SetConflictPolicy(policy)
+	r.OnProblem(func(err error) { ErrorStyle.Printf("Warning: %v\n", err) })
+	if !*verboseFlag && !jsonFormat() && isTerminal(os.Stdout) { // the progress lines would be mixed with the logs
+		r.SetProgress(newTerminalProgress(os.Stdout))
+	}
+	if isTerminal(os.Stdin) {
+		r.SetPassphrasePrompt(askPassphrase)
+	}
 	cmd.Repository = r
 
-	if cmd.RequireProject { // Commands can require to be executed on a project, in which case we have to load it
-		p, err := ReadProject()
-		if err != nil {
-			ErrorStyle.Printf("Cannot initialize the current project. %s\n", err)
+	// the current project, if any, declares remotes too
+	p, err := ReadProject()
+	if err == nil {
+		cmd.Project = p
+		addProjectRemotes(r, p)
+		if err := r.RegisterProject(p); err != nil { // so that 'gpk gc' keeps its dependencies
+			log.Printf("Cannot register the project in the local repository: %v", err)
+		}
+	} else if cmd.RequireProject { // Commands can require to be executed on a project
+		ErrorStyle.Printf("Cannot initialize the current project. %s\n", err)
+		return
+	}
+	for _, remote := range strings.FieldsFunc(os.Getenv(RemotesEnv), func(c rune) bool { return c == ',' || c == ' ' || c == '\t' || c == '\n' }) {
+		name, u, ok := strings.Cut(remote, "=")
+		if !ok {
+			ErrorStyle.Printf("Invalid %s entry %q, expecting NAME=URL\n", RemotesEnv, remote)
+			return
+		}
+		if err := addTransientRemote(r, name, u, RemotesEnv, true); err != nil {
+			ErrorStyle.Printf("Invalid remote in %s:\n    \u21b3 %v\n", RemotesEnv, err)
 			return
 		}
-		cmd.Project = p
 	}
 	cmd.Flag.Usage = func() { PrintCommandHelp(cmd) }
 	// now continue parsing the command's args, using the command flags
@@ -150,20 +190,56 @@ func Gopack() {
 		return
 	}
 	err = cmd.Run(cmd) // really execute the command
+	r.ReleaseLeases()
 	if err != nil {
 		os.Exit(-1)
 	}
 }
 
+//addProjectRemotes adds the remotes declared by the project p, they are tried after the local repository ones
+// (see ProjectRemotePriority). A remote that cannot be used is skipped with a warning: the others might be enough.
+func addProjectRemotes(r *LocalRepository, p *Project) {
+	for _, remote := range p.Remotes() {
+		if err := addTransientRemote(r, remote.Name, remote.Url, "project", false); err != nil {
+			ErrorStyle.Printf("Warning: the remote %s declared by the project is ignored:\n    \u21b3 %v\n", remote.Name, err)
+			continue
+		}
+		if r.RemoteOrigin(remote.Name) == "project" {
+			r.SetRemotePolicy(remote.Name, RemotePolicy{Priority: ProjectRemotePriority})
+		}
+	}
+}
+
+//addTransientRemote parses the remote url, and adds it to r for this execution only (see LocalRepository.AddTransientRemote)
+func addTransientRemote(r *LocalRepository, name, remote, origin string, override bool) error {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return err
+	}
+	if name == "" || u.Scheme == "" {
+		return errors.New(fmt.Sprintf("Invalid remote %s=%s", name, remote))
+	}
+	return r.AddTransientRemote(name, *u, origin, override)
+}
+
 //NewDefaultepository is the factory for a local repo. It tries to find one in the user's home dir. The full policy is defined here. 
+// The GPK_LOCAL environment variable replaces the default path, the -local option still wins over it.
 func NewDefaultRepository() (r *LocalRepository, err error) {
 	path := *localRepositoryFlag
+	if env := os.Getenv(LocalEnv); env != "" {
+		path = env
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "local" {
+				path = *localRepositoryFlag
+			}
+		})
+	}
 	if !filepath.IsAbs(path) {
 		u, err := user.Current()
 		if err != nil {
 			return nil, err
 		}
-		path = filepath.Join(u.HomeDir, *localRepositoryFlag)
+		path = filepath.Join(u.HomeDir, path)
 		path = filepath.Clean(path)
 	}
 	return NewLocalRepository(path)
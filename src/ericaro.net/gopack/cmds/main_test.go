@@ -0,0 +1,40 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAddProjectRemotes(t *testing.T) {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://central.example.com/")
+	central, _ := protocol.NewClient("central", *u, nil)
+	r.RemoteAdd(central)
+
+	p := newTestProject(t, "ex/p")
+	p.AddRemote("corp", "http://corp.example.com/")
+	p.AddRemote("secure", "oauth2:http://secure.example.com/") // it requires a token
+	p.AddRemote("central", "http://elsewhere.example.com/")    // the local repository one wins
+	addProjectRemotes(r, p)
+
+	var names []string
+	for _, remote := range r.OrderedRemotes() {
+		u := remote.Path()
+		names = append(names, remote.Name()+" "+u.String())
+	}
+	if strings.Join(names, ", ") != "central http://central.example.com/, corp http://corp.example.com/" {
+		t.Errorf("Remotes %v", names)
+	}
+	if policy := r.RemotePolicy("corp"); policy.Priority != ProjectRemotePriority {
+		t.Errorf("The project remote has the %s", policy)
+	}
+	if policy := r.RemotePolicy("central"); policy.Priority != 0 {
+		t.Errorf("The local repository remote has the %s", policy)
+	}
+}
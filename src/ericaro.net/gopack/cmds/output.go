@@ -0,0 +1,154 @@
+package cmds
+
+import (
+	"encoding/json"
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	TextFormat = "text"
+	JsonFormat = "json"
+)
+
+//jsonFormat returns true if the listing commands print json documents, instead of text columns
+func jsonFormat() bool {
+	return *formatFlag == JsonFormat
+}
+
+//setFormat selects the output format of the listing commands. In json, the styled text goes to the standard error
+func setFormat(format string) error {
+	switch format {
+	case TextFormat:
+		Output = os.Stdout
+	case JsonFormat: // the standard output is reserved to the json document
+		Output = os.Stderr
+	default:
+		return errors.New(fmt.Sprintf("Unknown format %s, expecting %s or %s", format, TextFormat, JsonFormat))
+	}
+	*formatFlag = format
+	return nil
+}
+
+//printJSON prints a listing document on the standard output, that is reserved to it in json format
+func printJSON(v interface{}) (err error) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(v); err != nil {
+		ErrorStyle.Printf("Cannot print the json document:\n    \u21b3 %v\n", err)
+	}
+	return
+}
+
+//PackageDoc is a package, in the json documents
+type PackageDoc struct {
+	Name      string
+	Version   string
+	Timestamp *time.Time `json:",omitempty"`
+	Digest    string     `json:",omitempty"`
+	Path      string     `json:",omitempty"` // where it is installed, for resolved packages
+}
+
+func pidDoc(pid protocol.PID) PackageDoc {
+	return PackageDoc{Name: pid.Name, Version: pid.Version.String(), Timestamp: pid.Timestamp, Digest: pid.Digest}
+}
+
+func packageDoc(p *Package) PackageDoc {
+	tm := p.Timestamp()
+	return PackageDoc{Name: p.Name(), Version: p.Version().String(), Timestamp: &tm, Digest: p.Digest(), Path: p.InstallDir()}
+}
+
+func projectDoc(id ProjectID) PackageDoc {
+	return PackageDoc{Name: id.Name(), Version: id.Version().String()}
+}
+
+//DependencyDoc is a declared dependency, in the json documents
+type DependencyDoc struct {
+	Name       string
+	Constraint string
+	Scope      string
+}
+
+func dependencyDocs(dependencies []Dependency) []DependencyDoc {
+	docs := make([]DependencyDoc, 0, len(dependencies))
+	for _, d := range dependencies {
+		docs = append(docs, DependencyDoc{d.Name(), d.Constraint().String(), string(d.Scope())})
+	}
+	return docs
+}
+
+//scopeLabel is printed after the declared dependencies in text, it is empty for the compile scope
+func scopeLabel(d Dependency) string {
+	if d.Scope() == CompileScope {
+		return ""
+	}
+	return "(" + string(d.Scope()) + ")"
+}
+
+//RemoteDoc is a remote, in the json documents. Its token is redacted.
+type RemoteDoc struct {
+	Name     string
+	Url      string
+	Priority int
+	MirrorOf string   `json:",omitempty"`
+	Prefixes []string `json:",omitempty"`
+	Token    string   `json:",omitempty"`
+	Origin   string   `json:",omitempty"` // who declared it, if it is not the local repository: the project, or the environment
+}
+
+func remoteDocs(r *LocalRepository, remotes []protocol.Client) []RemoteDoc {
+	docs := make([]RemoteDoc, 0, len(remotes))
+	for _, remote := range remotes {
+		u := remote.Path()
+		policy := r.RemotePolicy(remote.Name())
+		docs = append(docs, RemoteDoc{
+			Name:     remote.Name(),
+			Url:      u.String(),
+			Priority: policy.Priority,
+			MirrorOf: policy.MirrorOf,
+			Prefixes: policy.Prefixes,
+			Token:    r.RedactedToken(remote),
+			Origin:   r.RemoteOrigin(remote.Name()),
+		})
+	}
+	return docs
+}
+
+//originLabel is appended to the remotes listed in text, when they are not declared by the local repository
+func originLabel(r *LocalRepository, remote protocol.Client) string {
+	if origin := r.RemoteOrigin(remote.Name()); origin != "" {
+		return " (" + origin + ")"
+	}
+	return ""
+}
+
+//StatusDoc is the 'gpk status' json document
+type StatusDoc struct {
+	Name         string
+	License      string
+	Dependencies []DependencyDoc
+	Remotes      []RemoteDoc
+}
+
+//PathDoc is the 'gpk list-package' json document
+type PathDoc struct {
+	GoPath   string
+	Packages []PackageDoc
+}
+
+//MissingDoc is the 'gpk list-missing' json document: the imports that cannot be found, and the packages providing them
+type MissingDoc struct {
+	Imports  []string
+	Packages []MissingPackageDoc
+}
+
+//MissingPackageDoc maps a missing import path to the local packages providing it, the first one is the default choice
+type MissingPackageDoc struct {
+	Import     string
+	Candidates []PackageDoc
+}
@@ -0,0 +1,178 @@
+package cmds
+
+import (
+	"bytes"
+	"encoding/json"
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//newTestProject creates the project name, with a single source file, and its dependencies given as "NAME CONSTRAINT"
+func newTestProject(t *testing.T, name string, dependencies ...string) *Project {
+	p := &Project{}
+	p.SetWorkingDir(t.TempDir())
+	p.SetName(name)
+	p.SetLicense(Licenses[0])
+	for _, d := range dependencies {
+		nc := strings.SplitN(d, " ", 2)
+		c, err := ParseConstraint(nc[1])
+		if err != nil {
+			t.Fatalf("Invalid constraint %q: %v", d, err)
+		}
+		p.AppendDependency(*NewDependency(nc[0], c))
+	}
+	src := filepath.Join(p.WorkingDir(), "src", name)
+	if err := os.MkdirAll(src, os.ModeDir|os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "doc.go"), []byte("package "+filepath.Base(name)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+//installTestPackage installs the version of the project name, with its dependencies given as "NAME CONSTRAINT"
+func installTestPackage(t *testing.T, r *LocalRepository, name, version string, dependencies ...string) *Package {
+	v, _ := ParseVersion(version)
+	p, err := r.InstallProject(newTestProject(t, name, dependencies...), v, false)
+	if err != nil {
+		t.Fatalf("Cannot install %s %s: %v", name, version, err)
+	}
+	return p
+}
+
+//fieldNames returns the names of the fields of the json object encoded from v
+func fieldNames(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Cannot encode %T: %v", v, err)
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("%T is not a json object: %v", v, err)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestDocumentFields(t *testing.T) {
+	tm := time.Now()
+	pkg := PackageDoc{Name: "ex/a", Version: "1.0.0", Timestamp: &tm, Digest: "sha256:00", Path: "/a"}
+	for _, check := range []struct {
+		doc    interface{}
+		fields string
+	}{
+		{pkg, "Digest,Name,Path,Timestamp,Version"},
+		{PackageDoc{Name: "ex/a", Version: "1.0.0"}, "Name,Version"},
+		{RemoteDoc{Name: "central", Url: "http://central.example.com/", Priority: 1, MirrorOf: "*", Prefixes: []string{"ex/"}, Token: "token:00", Origin: "project"}, "MirrorOf,Name,Origin,Prefixes,Priority,Token,Url"},
+		{RemoteDoc{Name: "central", Url: "http://central.example.com/"}, "Name,Priority,Url"},
+		{MissingDoc{Imports: []string{"ex/a/b"}, Packages: []MissingPackageDoc{}}, "Imports,Packages"},
+		{MissingPackageDoc{Import: "ex/a", Candidates: []PackageDoc{pkg}}, "Candidates,Import"},
+		{PathDoc{GoPath: "/a", Packages: []PackageDoc{pkg}}, "GoPath,Packages"},
+		{StatusDoc{Name: "ex/p"}, "Dependencies,License,Name,Remotes"},
+	} {
+		if fields := fieldNames(t, check.doc); fields != check.fields {
+			t.Errorf("%T has the fields %s, expecting %s", check.doc, fields, check.fields)
+		}
+	}
+}
+
+func TestRemoteDocsRedactTokens(t *testing.T) {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := protocol.NewToken(32)
+	u, _ := url.Parse("http://central.example.com/")
+	central, _ := protocol.NewClient("central", *u, token)
+	r.RemoteAdd(central)
+
+	docs := remoteDocs(r, r.Remotes())
+	if len(docs) != 1 || docs[0].Token == "" {
+		t.Fatalf("The token of the remote is not listed: %v", docs)
+	}
+	data, _ := json.Marshal(docs)
+	for _, raw := range []string{token.FormatURL(), token.FormatStd(), string(*token)} {
+		if bytes.Contains(data, []byte(raw)) {
+			t.Errorf("The remote document contains the token: %s", data)
+		}
+	}
+}
+
+//captureStdout runs f, and returns what it printed on the standard output, styled text included
+func captureStdout(t *testing.T, f func()) []byte {
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout, output := os.Stdout, Output
+	os.Stdout, Output = out, out
+	defer func() { os.Stdout, Output = stdout, output }()
+	f()
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJsonFormatKeepsStdout(t *testing.T) {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	installTestPackage(t, r, "ex/lib", "1.0.0")
+	p := newTestProject(t, "ex/p")
+	if err = ioutil.WriteFile(filepath.Join(p.WorkingDir(), "src", "ex", "p", "p.go"), []byte("package p\nimport _ \"ex/lib\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.Write()
+
+	if err = Imports.Flag.Parse([]string{"-o", "-f"}); err != nil { // the fix prints a styled message
+		t.Fatal(err)
+	}
+	defer func() { *importsOfflineFlag, *importsAutofixFlag = false, false }()
+	defer func() { *formatFlag = TextFormat }()
+	c := &Command{Project: p, Repository: r}
+	stdout := captureStdout(t, func() {
+		if err := setFormat(JsonFormat); err != nil {
+			t.Fatal(err)
+		}
+		if err := Imports.Run(c); err != nil {
+			t.Errorf("Cannot list the missing imports: %v", err)
+		}
+		SuccessStyle.Printf("styled text\n")
+	})
+
+	dec := json.NewDecoder(bytes.NewReader(stdout))
+	dec.DisallowUnknownFields()
+	var doc MissingDoc
+	if err = dec.Decode(&doc); err != nil {
+		t.Fatalf("The standard output is not a missing document: %v\n%s", err, stdout)
+	}
+	if dec.More() {
+		t.Errorf("The standard output has more than the json document:\n%s", stdout)
+	}
+	expected := MissingDoc{
+		Imports:  []string{"ex/lib"},
+		Packages: []MissingPackageDoc{{Import: "ex/lib", Candidates: []PackageDoc{{Name: "ex/lib", Version: "1.0.0"}}}},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Missing document %+v, expecting %+v", doc, expected)
+	}
+}
@@ -0,0 +1,142 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//refresh rate of the progress lines
+const progressRate = 100 * time.Millisecond
+
+//isTerminal returns true if f is a terminal, rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+//download is a running download, displayed on its own line
+type download struct {
+	id             ProjectID
+	remote         string
+	size, received int64
+}
+
+//terminalProgress displays one line per running download, that is rewritten in place, and a final line once it is over.
+type terminalProgress struct {
+	out       io.Writer
+	mutex     sync.Mutex
+	downloads []*download // in the order they started
+	drawn     int         // number of lines currently displayed
+	last      time.Time   // when they have been displayed
+}
+
+func newTerminalProgress(out io.Writer) *terminalProgress {
+	return &terminalProgress{out: out}
+}
+
+func (t *terminalProgress) find(id ProjectID) *download {
+	for _, d := range t.downloads {
+		if d.id == id {
+			return d
+		}
+	}
+	return nil
+}
+
+//Start part of the Progress interface
+func (t *terminalProgress) Start(id ProjectID, remote string, size int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	d := t.find(id)
+	if d == nil {
+		d = &download{id: id}
+		t.downloads = append(t.downloads, d)
+	}
+	d.remote, d.size, d.received = remote, size, 0
+	t.draw()
+}
+
+//Update part of the Progress interface
+func (t *terminalProgress) Update(id ProjectID, received int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if d := t.find(id); d != nil {
+		d.received = received
+	}
+	if time.Since(t.last) >= progressRate {
+		t.draw()
+	}
+}
+
+//Done part of the Progress interface
+func (t *terminalProgress) Done(id ProjectID, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	d := t.find(id)
+	if d == nil {
+		return
+	}
+	running := make([]*download, 0, len(t.downloads))
+	for _, o := range t.downloads {
+		if o != d {
+			running = append(running, o)
+		}
+	}
+	t.downloads = running
+	t.erase()
+	if err != nil {
+		fmt.Fprint(t.out, ErrorStyle.Sprintf("       !%s %s from %s failed\n", id.Name(), id.Version().String(), d.remote))
+	} else {
+		fmt.Fprint(t.out, SuccessStyle.Sprintf("       +%s %s from %s (%s)\n", id.Name(), id.Version().String(), d.remote, formatSize(d.received)))
+	}
+	t.draw()
+}
+
+//erase the progress lines
+func (t *terminalProgress) erase() {
+	if t.drawn > 0 {
+		fmt.Fprintf(t.out, "\033[%dF\033[J", t.drawn) // back to the first line, and clear the screen below
+	}
+	t.drawn = 0
+}
+
+//draw the progress lines again
+func (t *terminalProgress) draw() {
+	t.erase()
+	for _, d := range t.downloads {
+		fmt.Fprint(t.out, NormalStyle.Sprintf("       %-40s %-10s %s\n", d.id.Name()+" "+d.id.Version().String(), d.remote, progressBar(d.received, d.size)))
+	}
+	t.drawn = len(t.downloads)
+	t.last = time.Now()
+}
+
+//progressBar pretty prints the download progress, size is -1 if it is unknown
+func progressBar(received, size int64) string {
+	const width = 20
+	if size <= 0 {
+		return formatSize(received)
+	}
+	done := int(received * width / size)
+	if done > width {
+		done = width
+	}
+	return fmt.Sprintf("[%s%s] %3d%% %s/%s", strings.Repeat("=", done), strings.Repeat(" ", width-done), received*100/size, formatSize(received), formatSize(size))
+}
+
+//formatSize pretty prints a number of bytes
+func formatSize(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
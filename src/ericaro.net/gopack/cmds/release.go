@@ -0,0 +1,164 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	Reg(
+		&Release,
+	)
+}
+
+//git runs a git command in the project directory, and returns its trimmed output
+func git(dir string, args ...string) (out string, err error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	b, err := cmd.CombinedOutput()
+	out = strings.TrimSpace(string(b))
+	if err != nil && out != "" {
+		err = errors.New(out)
+	}
+	return
+}
+
+var releaseRemoteFlag *string
+var releasePreFlag *string
+var releaseTagFlag *bool
+var releasePushFlag *bool
+var releaseExecutablesFlag *bool
+var releaseSignFlag *string
+var releaseDryRunFlag *bool
+var releaseNoAPIFlag *bool
+var releaseForceFlag *bool
+var Release = Command{
+	Name:      `release`,
+	Alias:     `rel`,
+	UsageLine: `major|minor|patch|pre`,
+	Short:     `Install the next version of the project`,
+	Long: `Compute the next version of the current project, and install it in the local repository.
+
+       The next version follows the newest release of the project in the local repository,
+       and on the -r remote if it is set (snapshots are ignored):
+       major    1.2.3 -> 2.0.0, and a prerelease 2.0.0-rc.1 -> 2.0.0
+       minor    1.2.3 -> 1.3.0, and a prerelease 1.3.0-rc.1 -> 1.3.0
+       patch    1.2.3 -> 1.2.4, and a prerelease 1.2.4-rc.1 -> 1.2.4
+       pre      1.2.3 -> 1.2.4-0, 1.2.4-rc.1 -> 1.2.4-rc.2
+       With -pre ID, the next version is the prerelease ID.0 of that version: 'gpk release -pre rc major'
+       turns 1.2.3 into 2.0.0-rc.0. Without any release, the version is bumped from 0.0.0.
+
+       Like 'gpk install', it fails if the API changes since the previous release require a larger bump,
+       unless -force is set.
+       With -push, the package is pushed to the -r remote, like 'gpk push' does.
+       With -tag, the git tag vVERSION is then created on the current commit of the project.`,
+	RequireProject: true,
+	FlagInit: func(Release *Command) {
+		releaseRemoteFlag = Release.Flag.String("r", "", "REMOTE. Look for the newest release on this remote too.")
+		releasePreFlag = Release.Flag.String("pre", "", "ID. Release the prerelease ID.0 of the next version, e.g. rc or beta.")
+		releaseTagFlag = Release.Flag.Bool("tag", false, "create the git tag vVERSION.")
+		releasePushFlag = Release.Flag.Bool("push", false, "push the package to the -r remote.")
+		releaseExecutablesFlag = Release.Flag.Bool("x", false, "with -push, push executables too.")
+		releaseSignFlag = Release.Flag.String("sign", "", "KEYFILE. Sign the package with this PEM private key (Ed25519 or RSA).")
+		releaseDryRunFlag = Release.Flag.Bool("n", false, "dry run. Only print the next version.")
+		releaseNoAPIFlag = Release.Flag.Bool("noapi", false, "do not check the API changes since the previous release.")
+		releaseForceFlag = Release.Flag.Bool("force", false, "release a version not bumped enough for the API changes.")
+	},
+	Run: func(Release *Command) (err error) {
+		if len(Release.Flag.Args()) != 1 {
+			ErrorStyle.Printf("Missing version part argument\n")
+			NormalStyle.Printf("       gpk release major|minor|patch|pre\n")
+			return InvalidArgumentSize()
+		}
+		bump, err := semver.ParseBump(Release.Flag.Arg(0))
+		if err != nil {
+			ErrorStyle.Printf("%v\n", err)
+			return
+		}
+		if *releasePushFlag && *releaseRemoteFlag == "" {
+			ErrorStyle.Printf("Illegal arguments combinaison, -push requires the -r remote.\n")
+			return errors.New("missing remote")
+		}
+		var remote protocol.Client
+		if *releaseRemoteFlag != "" {
+			if remote, err = Release.Repository.Remote(*releaseRemoteFlag); err != nil {
+				ErrorStyle.Printf("Unknown Remote %s.\n    \u21b3 %s\n", *releaseRemoteFlag, err)
+				return
+			}
+		}
+
+		p := Release.Project
+		latest, next, err := Release.Repository.NextVersion(p.Name(), remote, bump, *releasePreFlag)
+		if err == nil {
+			_, err = semver.Parse(next.String(), semver.StrictMode) // e.g. an invalid -pre ID
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot release %s:\n    \u21b3 %v\n", p.Name(), err)
+			return
+		}
+		if latest == nil {
+			NormalStyle.Printf("%s has no release yet, releasing %s\n", p.Name(), next.String())
+		} else {
+			NormalStyle.Printf("%s %s -> %s\n", p.Name(), latest.String(), next.String())
+		}
+		if !*releaseNoAPIFlag {
+			if err = checkAPI(Release, next, *releaseForceFlag); err != nil {
+				return
+			}
+		}
+		if *releaseDryRunFlag {
+			return
+		}
+
+		tag := "v" + next.String()
+		if *releaseTagFlag { // fail before installing anything
+			if _, err = git(p.WorkingDir(), "rev-parse", "--verify", "HEAD"); err != nil {
+				ErrorStyle.Printf("Cannot tag the project, it is not a git commit:\n    \u21b3 %v\n", err)
+				return
+			}
+			if _, err := git(p.WorkingDir(), "rev-parse", "-q", "--verify", "refs/tags/"+tag); err == nil {
+				ErrorStyle.Printf("The git tag %s already exists\n", tag)
+				return errors.New("existing tag")
+			}
+		}
+
+		pkg, err := Release.Repository.InstallProject(p, next, false)
+		if err == protocol.StatusCannotOverwrite {
+			ErrorStyle.Printf("Release %s %s is already installed, releases cannot be replaced.\n", p.Name(), next.String())
+			return
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot install the project:\n    \u21b3 %v\n", err)
+			return
+		}
+		if *releaseSignFlag != "" {
+			sig, err := signPackage(pkg, *releaseSignFlag)
+			if err != nil {
+				ErrorStyle.Printf("Cannot sign the package:\n    \u21b3 %v\n", err)
+				return err
+			}
+			SuccessStyle.Printf("Signed with key %s\n", sig.KeyID())
+		}
+		SuccessStyle.Printf("       +%s %s\n", p.Name(), next.String())
+
+		if *releasePushFlag {
+			if err = pushPackage(remote, pkg, false, *releaseExecutablesFlag, ""); err != nil {
+				return
+			}
+			SuccessStyle.Printf("       +%s %s pushed to %s\n", p.Name(), next.String(), remote.Name())
+		}
+		if *releaseTagFlag {
+			if _, err = git(p.WorkingDir(), "tag", "-a", tag, "-m", fmt.Sprintf("%s %s", p.Name(), next.String())); err != nil {
+				ErrorStyle.Printf("Cannot create the git tag %s:\n    \u21b3 %v\n", tag, err)
+				return
+			}
+			SuccessStyle.Printf("       +tag %s\n", tag)
+		}
+		return
+	},
+}
@@ -1,14 +1,18 @@
 package cmds
 
 import (
-	"bytes"
 	. "ericaro.net/gopack"
 	"ericaro.net/gopack/oauth"
+	"ericaro.net/gopack/oauth2"
 	"ericaro.net/gopack/protocol"
 	"ericaro.net/gopack/semver"
+	"flag"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 func init() {
@@ -22,6 +26,11 @@ func init() {
 }
 
 var serverAddrFlag *string
+var serveACLFlag *string
+var serveOAuthFlag *bool
+var serveProxyFlag *bool
+var serveTTLFlag *time.Duration
+var serveRetentionFlag *int
 
 var Serve = Command{
 	Name:      `serve`,
@@ -30,18 +39,73 @@ var Serve = Command{
 	Short:     `Serve local repository as an http server`,
 	Long: `Serve local repository as an http remote repository
        so that others can get latest updates, or push new releases.
-       ADDR usually ':8080' `,
+       ADDR usually ':8080'
+
+       Access to the packages is controlled by the access control file (the ` + AccessControlFile + ` file
+       in the local repository, unless -acl is set). It grants rights to tokens, see 'gpk token'.
+       Without access control file, everyone can read and push everything.
+
+       With -oauth, every request must be signed by an OAuth 1.0 consumer registered with 'gpk consumer',
+       on behalf of an access token. Users allow consumers with their token (see 'gpk token'), at the
+       URL printed by 'gpk radd -o', the access token then has the same rights.
+       PLAINTEXT signatures send the secrets as is: they should only be used behind TLS.
+
+       With -proxy, the server is a pull-through cache of the local repository remotes: missing packages
+       are downloaded from them, installed in the local repository, and then served. Snapshots, and search
+       results, are checked on the remotes again after -ttl. At most 10000 of each are cached.
+
+       Every snapshot pushed is kept as a new build, see 'gpk retention'. -retention overrides the number of
+       builds kept for each snapshot.`,
 	RequireProject: false, // false if we add the options to set which the local repo
 	FlagInit: func(Serve *Command) {
 		serverAddrFlag = Serve.Flag.String("s", ":8080", "Serve the current local repository as a remote one for others to use.")
+		serveACLFlag = Serve.Flag.String("acl", "", "FILE. The access control file.")
+		serveOAuthFlag = Serve.Flag.Bool("oauth", false, "require OAuth 1.0 signed requests. It requires an access control file.")
+		serveProxyFlag = Serve.Flag.Bool("proxy", false, "download missing packages from the remotes.")
+		serveTTLFlag = Serve.Flag.Duration("ttl", DefaultProxyTTL, "with -proxy, how long snapshots and search results are cached.")
+		serveRetentionFlag = Serve.Flag.Int("retention", -1, "N. Keep the last N builds of each snapshot, 0 keeps them all. The local repository setting by default.")
 	},
 	Run: func(Serve *Command) (err error) {
 
 		// run the go build command for local src, and with the appropriate gopath
 
+		if *serveRetentionFlag >= 0 {
+			Serve.Repository.SetRetention(*serveRetentionFlag)
+		}
 		server := HttpServer{
 			Local: *Serve.Repository,
 		}
+		acl := aclPath(Serve, *serveACLFlag)
+		if *serveACLFlag != "" || FileExists(acl) {
+			server.ACL, err = ReadAccessControl(acl)
+			if err != nil {
+				ErrorStyle.Printf("Invalid access control file %s:\n    \u21b3 %v\n", acl, err)
+				return
+			}
+			fmt.Printf("access control %s\n", acl)
+		} else {
+			fmt.Printf("no access control file, everyone can push\n")
+		}
+		if *serveOAuthFlag {
+			if server.ACL == nil {
+				ErrorStyle.Printf("OAuth requires an access control file, see 'gpk token'.\n")
+				return
+			}
+			provider, err := readProvider(Serve, server.ACL)
+			if err != nil {
+				ErrorStyle.Printf("Invalid OAuth provider file %s:\n    \u21b3 %v\n", provider.Path(), err)
+				return err
+			}
+			server.Auth = provider
+			fmt.Printf("OAuth required, %d consumers\n", len(provider.Consumers()))
+		}
+		if *serveProxyFlag {
+			server.Proxy = NewProxy(*serveTTLFlag)
+			fmt.Printf("proxy of %d remotes\n", len(Serve.Repository.Remotes()))
+		}
+		if n := server.Local.Retention(); n > 0 {
+			fmt.Printf("keeping the last %d builds of each snapshot\n", n)
+		}
 		fmt.Printf("starting server %s\n", *serverAddrFlag)
 		server.Start(*serverAddrFlag)
 		return
@@ -52,6 +116,8 @@ var Serve = Command{
 //var deployAddrFlag *string = Push.Flag.String("to", "central", "deploy to a specific remote repository.")
 //var pushRecursiveFlag *bool = Push.Flag.Bool("r", false, "Also pushes package's dependencies.")
 var pushExecutables *bool
+var pushSignFlag *string
+var pushForceFlag *bool
 var Push = Command{
 	Name:      `push`,
 	Alias:     `push`,
@@ -62,7 +128,9 @@ var Push = Command{
        PACKAGE a package available in the local repository (use search to list them)
        VERSION a semantic version of the PACKAGE available in the local repository
        
-       If binaries are pushed too, then they will be available at:
+       The executables installed with the package are part of it: they are always pushed with it, and
+       covered by its digest and signature. -x pushes them separately too, as older versions did.
+       They are available at:
        
        <remote-url>/get?n=<package-name>&v=<package-version>&goos=<os>&goarch=<architecture>&exe=<executable-name>
 
@@ -73,12 +141,18 @@ var Push = Command{
        
        the server returns a list, in json format of download url.
        
+       Releases (any version but snapshots) are write-once: pushing an existing release fails,
+       unless -force is set, and the token has the admin right on the package.
        
+       If the package is signed, its signature is pushed along. With -sign, the package is signed
+       with the private key in KEYFILE before being pushed.
        
        `,
 	RequireProject: false,
 	FlagInit: func(Push *Command) {
 		pushExecutables = Push.Flag.Bool("x", false, "pushes executables too.")
+		pushForceFlag = Push.Flag.Bool("force", false, "replace the release if it already exists on the remote. It requires the admin right.")
+		pushSignFlag = Push.Flag.String("sign", "", "KEYFILE. Sign the package with this PEM private key (Ed25519 or RSA) before pushing it.")
 	},
 	Run: func(Push *Command) (err error) {
 		rem := Push.Flag.Arg(0)
@@ -108,35 +182,7 @@ var Push = Command{
 			return
 		}
 
-		// build its ID 
-		tm := pkg.Timestamp()
-		pid := protocol.PID{
-			Name:      Push.Flag.Arg(1),
-			Version:   version,
-			Token:     remote.Token(),
-			Timestamp: &tm,
-		}
-
-		// read it in memory (tar.gz)
-
-		buf := new(bytes.Buffer)
-		pkg.Pack(buf) // pack either exec or src
-		// and finally push the buffer
-		log.Printf("pushing sources\n")
-		err = remote.Push(pid, buf) // either exec or src
-
-		if *pushExecutables {
-			log.Printf("pushing executables")
-			buf := new(bytes.Buffer)
-			pkg.PackExecutables(buf) // pack both exec or src
-
-			// and finally push the buffer
-			err = remote.PushExecutables(pid, buf) // either exec or src		
-		}
-
-		if err != nil {
-			ErrorStyle.Printf("Error from the remote while pushing.\n    \u21b3 %s\n", err)
-			// TODO as soon as I've got some search capability display similar results
+		if err = pushPackage(remote, pkg, *pushForceFlag, *pushExecutables, *pushSignFlag); err != nil {
 			return
 		}
 		SuccessStyle.Printf("Success\n")
@@ -144,6 +190,51 @@ var Push = Command{
 	},
 }
 
+//pushPackage pushes the package sources, its signature, and its executables if required, to the remote
+func pushPackage(remote protocol.Client, pkg *Package, force, executables bool, keyfile string) (err error) {
+	// build its ID
+	tm := pkg.Timestamp()
+	pid := protocol.PID{
+		Name:      pkg.Name(),
+		Version:   pkg.Version(),
+		Token:     remote.Token(),
+		Timestamp: &tm,
+		Digest:    pkg.Digest(),
+		Force:     force,
+	}
+	sig, err := pkg.Signature()
+	if keyfile != "" {
+		sig, err = signPackage(pkg, keyfile)
+		pid.Digest = pkg.Digest() // might have been computed to sign it
+	}
+	if err != nil {
+		ErrorStyle.Printf("Package signature error:\n    \u21b3 %v\n", err)
+		return
+	}
+	if sig != nil {
+		pid.Signature = sig.Format()
+	}
+
+	// streamed while it is packed (tar.gz)
+	r := pkg.PackReader()
+	defer r.Close()
+	log.Printf("pushing sources\n")
+	err = remote.Push(pid, r) // either exec or src
+
+	if err == nil && executables {
+		log.Printf("pushing executables")
+		x := pkg.PackExecutablesReader()
+		defer x.Close()
+		err = remote.PushExecutables(pid, x)
+	}
+
+	if err != nil {
+		ErrorStyle.Printf("Error from the remote while pushing.\n    \u21b3 %s\n", err)
+		// TODO as soon as I've got some search capability display similar results
+	}
+	return
+}
+
 //var Get = Command{
 //	Name:           `goget`,
 //	Alias:          `gg`,
@@ -158,6 +249,16 @@ var Push = Command{
 
 var oauthFlag *bool     // OAuth flag value
 var base64Token *string // Simple token value
+var priorityFlag *int
+var mirrorFlag *string
+var prefixFlag *string
+var oauthRequest oauth.TokenRequest // OAuth token procedure flags
+var oauth2TokenFlag *string
+var oauth2DeviceFlag *string
+var oauth2ClientFlag *string
+var oauth2SecretFlag *string
+var oauth2ScopeFlag *string
+var projectRemoteFlag *bool
 
 var AddRemote = Command{
 	Name:      `radd`,
@@ -168,11 +269,54 @@ var AddRemote = Command{
 	Long: `Remote server can be used to publish or receive other's code.
        NAME    local alias for this remote
        URL     full URL to the remote server.
-               file:// and http:// are actually supported. For http, see 'gpk serve'`,
+               file:// and http:// are actually supported. For http, see 'gpk serve'
+
+       Remotes are tried one after the other, by increasing -priority, and in the order they have been added.
+       A -mirror of another remote has its priority and prefixes, and is tried just before it.
+       With -prefix, the remote only resolves the packages whose name is under one of the prefixes,
+       and those packages are never resolved from a remote that is not restricted to them:
+       'gpk radd -prefix corp.example.com/* internal URL' resolves corp.example.com/ packages only from internal.
+       Adding an existing remote replaces it.
+
+       With -p, the remote is declared in the current project .gpk file instead, for everyone building it:
+       it is merged with the local repository remotes, a local repository remote with the same name wins.
+       It cannot have a token, nor a policy: it is tried after the local repository remotes that keep the
+       default priority. The GPK_REMOTES environment variable declares remotes too,
+       e.g. GPK_REMOTES="central=http://repo.example.com,mirror=file:///mnt/repo": they win over the others,
+       without changing the local repository.
+
+       Tokens are kept in the credential store of the local repository, see 'gpk credentials'.
+
+       With -o, an OAuth 1.0 access token is requested to the remote, it is stored with the remote.
+       The -oauth-* options answer the questions asked otherwise. When one of them is set, or when the input is
+       not a terminal, nothing is asked, and the default values are used instead:
+       'gpk radd -o -oauth-pem key.pem -oauth-callback localhost:0 central URL' prints the URL to allow the connection,
+       and waits for the remote to redirect to a local listener. Without -oauth-callback, the verification code
+       is pasted once the connection is allowed: the input must then be a terminal.
+
+       With -oauth2-token, the remote is protected by OAuth 2.0, and the access token is requested to this
+       token endpoint. With -oauth2-secret, the client credentials are used, otherwise you are asked to allow
+       the access on another device, using the -oauth2-device endpoint. The access token is stored with the remote,
+       and renewed when it expires.`,
 	RequireProject: false,
 	FlagInit: func(AddRemote *Command) {
 		oauthFlag = AddRemote.Flag.Bool("o", false, "OAuth, when the remote must be accessed using OAuth 1.0 authentification.")
 		base64Token = AddRemote.Flag.String("b", "", "Base64 token, when the remote must be accessed using a base64 token")
+		priorityFlag = AddRemote.Flag.Int("priority", 0, "remotes with the lowest priority are tried first.")
+		mirrorFlag = AddRemote.Flag.String("mirror", "", "REMOTE. The remote is a mirror of REMOTE.")
+		prefixFlag = AddRemote.Flag.String("prefix", "", "PREFIX[,PREFIX...]. Only resolve the packages whose name is under one of the prefixes.")
+		AddRemote.Flag.StringVar(&oauthRequest.PemFile, "oauth-pem", "", "FILE. With -o, the PEM file containing your RSA private key. Default ~/.ssh/id_rsa")
+		AddRemote.Flag.StringVar(&oauthRequest.ConsumerKey, "oauth-consumer", "", "KEY. With -o, the consumer key registered by the remote.")
+		AddRemote.Flag.StringVar(&oauthRequest.RequestTokenUrl, "oauth-request", "", "URL. With -o, the request token URL. Default URL/"+oauth.DefaultRequestTokenPath)
+		AddRemote.Flag.StringVar(&oauthRequest.AuthorizeTokenUrl, "oauth-authorize", "", "URL. With -o, the authorize token URL. Default URL/"+oauth.DefaultAuthorizeTokenPath)
+		AddRemote.Flag.StringVar(&oauthRequest.AccessTokenUrl, "oauth-access", "", "URL. With -o, the access token URL. Default URL/"+oauth.DefaultAccessTokenPath)
+		AddRemote.Flag.StringVar(&oauthRequest.Callback, "oauth-callback", oauth.OutOfBand, "ADDR. With -o, the host:port to listen to for the remote to send the verification code, instead of pasting it.")
+		oauth2TokenFlag = AddRemote.Flag.String("oauth2-token", "", "URL. The OAuth 2.0 token endpoint, when the remote must be accessed using OAuth 2.0.")
+		oauth2DeviceFlag = AddRemote.Flag.String("oauth2-device", "", "URL. With -oauth2-token, the device authorization endpoint.")
+		oauth2ClientFlag = AddRemote.Flag.String("oauth2-client", "gpk", "ID. With -oauth2-token, the client id.")
+		oauth2SecretFlag = AddRemote.Flag.String("oauth2-secret", "", "SECRET. With -oauth2-token, the client secret, for the client credentials flow.")
+		oauth2ScopeFlag = AddRemote.Flag.String("oauth2-scope", "", "SCOPE. With -oauth2-token, the scope of the access token.")
+		projectRemoteFlag = AddRemote.Flag.Bool("p", false, "declare the remote in the current project, without token.")
 	},
 	Run: func(AddRemote *Command) (err error) {
 
@@ -186,10 +330,36 @@ var AddRemote = Command{
 			ErrorStyle.Printf("Illegal arguments combinaison, -o and -b options can't be used together.\n")
 			return
 		}
+		if *oauth2TokenFlag != "" && (len(*base64Token) > 0 || *oauthFlag) {
+			ErrorStyle.Printf("Illegal arguments combinaison, -oauth2-token can't be used with -o or -b.\n")
+			return
+		}
+		if *oauth2TokenFlag != "" && *oauth2SecretFlag == "" && *oauth2DeviceFlag == "" {
+			ErrorStyle.Printf("Illegal arguments combinaison, -oauth2-token requires -oauth2-secret or -oauth2-device.\n")
+			return
+		}
 
 		// Retrieve NAME & URL values:
 		name, remote := AddRemote.Flag.Arg(0), AddRemote.Flag.Arg(1)
 
+		if *projectRemoteFlag {
+			if len(*base64Token) > 0 || *oauthFlag || *oauth2TokenFlag != "" || *priorityFlag != 0 || *mirrorFlag != "" || *prefixFlag != "" {
+				ErrorStyle.Printf("Illegal arguments combinaison, a project remote has no token, nor policy.\n")
+				return
+			}
+			return declareRemote(AddRemote, name, remote)
+		}
+
+		policy := RemotePolicy{
+			Priority: *priorityFlag,
+			MirrorOf: *mirrorFlag,
+			Prefixes: ParsePrefixes(*prefixFlag),
+		}
+		if policy.MirrorOf != "" && (policy.Priority != 0 || len(policy.Prefixes) > 0) {
+			ErrorStyle.Printf("Illegal arguments combinaison, a mirror has the priority and prefixes of the remote it mirrors.\n")
+			return
+		}
+
 		var token *protocol.Token // nil by default
 		// Handling Base64 token:
 		if len(*base64Token) > 0 {
@@ -205,7 +375,16 @@ var AddRemote = Command{
 		if *oauthFlag {
 			// When -o option, the method RequestOAuthToken() start a procedure to request
 			// an OAuth token:
-			token, err = oauth.RequestOAuthToken(name, remote)
+			oauthRequest.Interactive = isTerminal(os.Stdin)
+			if oauthRequest.Interactive {
+				oauthRequest.Input = os.Stdin // to paste the verification code
+			}
+			AddRemote.Flag.Visit(func(f *flag.Flag) {
+				if strings.HasPrefix(f.Name, "oauth-") {
+					oauthRequest.Interactive = false
+				}
+			})
+			token, err = oauth.RequestOAuthToken(name, remote, oauthRequest)
 			if err != nil {
 				ErrorStyle.Printf("Failed to request OAuth token.\n    \u21b3 %s\n", err)
 				return
@@ -215,6 +394,28 @@ var AddRemote = Command{
 			remote = "oauth:" + remote
 		}
 
+		// Handling OAuth 2.0 token:
+		if *oauth2TokenFlag != "" {
+			var t *oauth2.Token
+			if *oauth2SecretFlag != "" {
+				t, err = oauth2.ClientCredentials(*oauth2TokenFlag, *oauth2ClientFlag, *oauth2SecretFlag, *oauth2ScopeFlag)
+			} else {
+				t, err = oauth2.DeviceAuthorization(*oauth2DeviceFlag, *oauth2TokenFlag, *oauth2ClientFlag, *oauth2ScopeFlag, func(uri, code, complete string) {
+					fmt.Printf("\nPlease browse the URL below, and enter the code %s\n", code)
+					fmt.Printf("\nURL: %s\n", uri)
+					if complete != "" {
+						fmt.Printf("or browse directly %s\n", complete)
+					}
+				})
+			}
+			if err != nil {
+				ErrorStyle.Printf("Failed to request OAuth 2.0 token.\n    \u21b3 %s\n", err)
+				return
+			}
+			token = t.Format()
+			remote = "oauth2:" + remote
+		}
+
 		// Verifying that the remote is valid URL:
 		u, err := url.Parse(remote)
 		if err != nil {
@@ -243,26 +444,60 @@ var AddRemote = Command{
 			ErrorStyle.Printf("%s\n", err)
 			return
 		}
-		AddRemote.Repository.Write()
+		err = AddRemote.Repository.SetRemotePolicy(name, policy)
+		if err != nil {
+			AddRemote.Repository.RemoteRemove(name)
+			ErrorStyle.Printf("Invalid remote policy:\n    \u21b3 %s\n", err)
+			return
+		}
+		if err = AddRemote.Repository.Write(); err != nil {
+			ErrorStyle.Printf("Cannot save the remote:\n    \u21b3 %v\n", err)
+			return
+		}
 
 		// Display a success trace
-		stoken := ""
-		if token != nil {
-			stoken = fmt.Sprintf("%s", token)
-		}
-		SuccessStyle.Printf("       +%s %s %s\n", name, u, stoken)
+		SuccessStyle.Printf("       +%s %s %s (%s)\n", name, u, RedactToken(token), policy)
 		return
 	},
 }
 
+//declareRemote declares the remote in the current project
+func declareRemote(c *Command, name, remote string) (err error) {
+	if c.Project == nil {
+		ErrorStyle.Printf("Cannot declare the remote, there is no current project.\n")
+		return
+	}
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme == "" {
+		ErrorStyle.Printf("Invalid URL passed as a remote Repository.\n    \u21b3 %s\n", remote)
+		return
+	}
+	if _, err = protocol.NewClient(name, *u, nil); err != nil { // e.g. it requires a token
+		ErrorStyle.Printf("The remote cannot be declared by the project:\n    \u21b3 %v\n", err)
+		return
+	}
+	c.Project.AddRemote(name, u.String())
+	if err = c.Project.Write(); err != nil {
+		ErrorStyle.Printf("Cannot save the project:\n    \u21b3 %v\n", err)
+		return
+	}
+	SuccessStyle.Printf("       +%s %s (%s)\n", name, u, GpkFile)
+	return
+}
+
+var removeProjectRemoteFlag *bool
 var RemoveRemote = Command{
-	Name:           `rremove`,
-	Alias:          `r-`,
-	Category:       RemoteCategory,
-	UsageLine:      `NAME`,
-	Short:          `Remove a Remote`,
-	Long:           ``,
+	Name:      `rremove`,
+	Alias:     `r-`,
+	Category:  RemoteCategory,
+	UsageLine: `NAME`,
+	Short:     `Remove a Remote`,
+	Long: `Remove the remote NAME from the local repository.
+       With -p, remove the remote declared in the current project .gpk file instead.`,
 	RequireProject: false,
+	FlagInit: func(RemoveRemote *Command) {
+		removeProjectRemoteFlag = RemoveRemote.Flag.Bool("p", false, "remove the remote declared in the current project.")
+	},
 	Run: func(RemoveRemote *Command) (err error) {
 
 		if len(RemoveRemote.Flag.Args()) != 1 {
@@ -271,6 +506,22 @@ var RemoveRemote = Command{
 		}
 
 		name := RemoveRemote.Flag.Arg(0)
+		if *removeProjectRemoteFlag {
+			if RemoveRemote.Project == nil || !RemoveRemote.Project.RemoveRemote(name) {
+				ErrorStyle.Printf("Nothing to Remove\n")
+				return
+			}
+			if err = RemoveRemote.Project.Write(); err != nil {
+				ErrorStyle.Printf("Cannot save the project:\n    \u21b3 %v\n", err)
+				return
+			}
+			SuccessStyle.Printf("Removed Remote %s from %s\n", name, GpkFile)
+			return
+		}
+		if origin := RemoveRemote.Repository.RemoteOrigin(name); origin != "" {
+			ErrorStyle.Printf("Remote %s is declared by %s, not by the local repository\n", name, origin)
+			return
+		}
 		ref, err := RemoveRemote.Repository.RemoteRemove(name)
 		if err != nil {
 			ErrorStyle.Printf("Cannot Remove remote %s\n    \u21b3 %s", name, err)
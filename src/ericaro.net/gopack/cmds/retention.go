@@ -0,0 +1,53 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"strconv"
+)
+
+func init() {
+	Reg(
+		&Retention,
+	)
+}
+
+var Retention = Command{
+	Name:      `retention`,
+	Alias:     `ret`,
+	Category:  RemoteCategory,
+	UsageLine: `[N]`,
+	Short:     `Set how many builds of each snapshot are kept`,
+	Long: `Set the number of builds kept for each snapshot in the local repository.
+       Every install of a snapshot, like master, is a new build: master+20261017.153000.3 is the third build,
+       created at that UTC date and time. master stands for its newest build, a dependency on the exact build
+       pins it. When a build is installed, the oldest ones beyond N are removed. 0 keeps them all.
+       The builds that a registered project uses, like the ones pinned by its lock file, are kept (see 'gpk gc').
+       Without arguments, it prints the current setting. 'gpk serve -retention' overrides it for the server.`,
+	RequireProject: false,
+	Run: func(Retention *Command) (err error) {
+		r := Retention.Repository
+		switch len(Retention.Flag.Args()) {
+		case 0:
+		case 1:
+			n, err := strconv.Atoi(Retention.Flag.Arg(0))
+			if err != nil || n < 0 {
+				ErrorStyle.Printf("Invalid argument \"%s\", expecting a number of builds\n", Retention.Flag.Arg(0))
+				return InvalidArgumentSize()
+			}
+			r.SetRetention(n)
+			if err = r.Write(); err != nil {
+				ErrorStyle.Printf("Cannot write the local repository:\n    \u21b3 %v\n", err)
+				return err
+			}
+		default:
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+		if r.Retention() == 0 {
+			SuccessStyle.Printf("Every snapshot build is kept\n")
+		} else {
+			SuccessStyle.Printf("The last %d builds of each snapshot are kept\n", r.Retention())
+		}
+		return
+	},
+}
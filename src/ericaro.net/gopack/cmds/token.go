@@ -0,0 +1,164 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Reg(
+		&TokenCmd,
+		&RevokeCmd,
+	)
+}
+
+//aclPath returns the access control file path: path if it is set, or the local repository one.
+func aclPath(c *Command, path string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(c.Repository.Root(), AccessControlFile)
+}
+
+//readACL reads the access control file, or creates a new one if it is missing.
+func readACL(c *Command, path string) (acl *AccessControl, err error) {
+	path = aclPath(c, path)
+	if !FileExists(path) {
+		return NewAccessControl(path), nil
+	}
+	return ReadAccessControl(path)
+}
+
+//formatGrants pretty prints a slice of grants
+func formatGrants(grants []Grant) string {
+	s := make([]string, 0, len(grants))
+	for _, g := range grants {
+		s = append(s, fmt.Sprintf("%s:\"%s\"", g.Right, g.Prefix))
+	}
+	return strings.Join(s, " ")
+}
+
+var tokenACLFlag *string
+var tokenAnonymousFlag *bool
+var TokenCmd = Command{
+	Name:      `token`,
+	Alias:     `tk+`,
+	Category:  RemoteCategory,
+	UsageLine: `NAME RIGHT [PREFIX...]`,
+	Short:     `Mint a token to access the served repository`,
+	Long: `Mint a new token, and grant it RIGHT on every package named one of the PREFIXes, or under it,
+       in the access control file used by 'gpk serve'.
+       NAME    the token owner, to manage it
+       RIGHT   one of none, read (fetch and search), push, or admin (each one includes the previous)
+       PREFIX  a package name prefix: "ex" covers "ex/a", but not "example". By default the right is
+               granted on every package.
+
+       The token is printed once, and only its hash is stored. Its owner should add it to
+       the remote with 'gpk radd -b TOKEN NAME URL'.
+       With -anonymous, there is no NAME, it replaces the rights granted to requests without token.
+       Without arguments, it lists the tokens.
+       A new access control file grants read rights to everyone, and nothing else.`,
+	RequireProject: false,
+	FlagInit: func(TokenCmd *Command) {
+		tokenACLFlag = TokenCmd.Flag.String("acl", "", "FILE. The access control file, by default the "+AccessControlFile+" file in the local repository.")
+		tokenAnonymousFlag = TokenCmd.Flag.Bool("anonymous", false, "set the rights granted to everyone instead.")
+	},
+	Run: func(TokenCmd *Command) (err error) {
+		acl, err := readACL(TokenCmd, *tokenACLFlag)
+		if err != nil {
+			ErrorStyle.Printf("Invalid access control file:\n    \u21b3 %v\n", err)
+			return
+		}
+		args := TokenCmd.Flag.Args()
+		if len(args) == 0 {
+			TitleStyle.Printf("\nTOKENS in %s:\n", acl.Path())
+			SuccessStyle.Printf("        %-8s %-20s %s\n", "", "<anonymous>", formatGrants(acl.Anonymous()))
+			for _, e := range acl.Entries() {
+				SuccessStyle.Printf("        %-8s %-20s %s\n", e.ID(), e.Name, formatGrants(e.Grants))
+			}
+			return
+		}
+		var name string
+		if !*tokenAnonymousFlag {
+			name, args = args[0], args[1:]
+		}
+		if len(args) == 0 {
+			ErrorStyle.Printf("Missing RIGHT argument\n")
+			return InvalidArgumentSize()
+		}
+		right, err := ParseRight(args[0])
+		if err != nil {
+			ErrorStyle.Printf("%v\n", err)
+			return
+		}
+		prefixes := args[1:]
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		grants := make([]Grant, 0, len(prefixes))
+		for _, p := range prefixes {
+			grants = append(grants, Grant{Prefix: p, Right: right})
+		}
+
+		var token *protocol.Token
+		if *tokenAnonymousFlag {
+			acl.SetAnonymous(grants)
+		} else if token, err = acl.Mint(name, grants); err != nil {
+			ErrorStyle.Printf("Cannot mint a token:\n    \u21b3 %v\n", err)
+			return
+		}
+		if err = acl.Write(); err != nil {
+			ErrorStyle.Printf("Cannot write the access control file:\n    \u21b3 %v\n", err)
+			return
+		}
+		if token == nil {
+			SuccessStyle.Printf("       <anonymous> %s\n", formatGrants(grants))
+		} else {
+			SuccessStyle.Printf("       +%s %s", name, formatGrants(grants))
+			fmt.Printf("\n%s\n", token.FormatStd()) // unstyled, so that it can be copied
+		}
+		return
+	},
+}
+
+var revokeACLFlag *string
+var RevokeCmd = Command{
+	Name:      `revoke`,
+	Alias:     `tk-`,
+	Category:  RemoteCategory,
+	UsageLine: `NAME|ID`,
+	Short:     `Revoke tokens`,
+	Long: `Revoke the tokens owned by NAME, or the token identified by ID (as listed by 'gpk token').
+       A running 'gpk serve' takes it into account immediately.`,
+	RequireProject: false,
+	FlagInit: func(RevokeCmd *Command) {
+		revokeACLFlag = RevokeCmd.Flag.String("acl", "", "FILE. The access control file, by default the "+AccessControlFile+" file in the local repository.")
+	},
+	Run: func(RevokeCmd *Command) (err error) {
+		if len(RevokeCmd.Flag.Args()) != 1 {
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+		acl, err := readACL(RevokeCmd, *revokeACLFlag)
+		if err != nil {
+			ErrorStyle.Printf("Invalid access control file:\n    \u21b3 %v\n", err)
+			return
+		}
+		removed := acl.Revoke(RevokeCmd.Flag.Arg(0))
+		if len(removed) == 0 {
+			ErrorStyle.Printf("Nothing to revoke\n")
+			return
+		}
+		if err = acl.Write(); err != nil {
+			ErrorStyle.Printf("Cannot write the access control file:\n    \u21b3 %v\n", err)
+			return
+		}
+		for _, e := range removed {
+			SuccessStyle.Printf("       -%s %s\n", e.ID(), e.Name)
+		}
+		return
+	},
+}
@@ -0,0 +1,225 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"os"
+	"strings"
+)
+
+func init() {
+	Reg(
+		&Tree,
+		&Why,
+	)
+}
+
+//resolveGraph resolves the project dependencies, like list-package, into their graph
+func resolveGraph(c *Command, offline, update, nolock bool) (g *Graph, err error) {
+	dependencies, err := resolveLocked(c, offline, update, nolock)
+	if err != nil {
+		ErrorStyle.Printf("Error Resolving project's dependencies:\n    \u21b3 %v\n", err)
+		return
+	}
+	return NewGraph(c.Project, dependencies), nil
+}
+
+//edgeLabel prints the selected version of a dependency, and its constraint if it is not that exact version
+func edgeLabel(e Edge) string {
+	if e.Package == nil {
+		return e.Dependency.String() + " (unresolved)"
+	}
+	label := e.Dependency.Name() + " " + e.Package.Version().String()
+	if c := e.Dependency.Constraint(); c.String() != e.Package.Version().String() {
+		label += " (" + c.String() + ")"
+	}
+	if scope := scopeLabel(e.Dependency); scope != "" {
+		label += " " + scope
+	}
+	return label
+}
+
+//TreeDoc is a node of the 'gpk tree' json document
+type TreeDoc struct {
+	Name         string
+	Version      string    `json:",omitempty"` // selected
+	Constraint   string    `json:",omitempty"` // declared
+	Scope        string    `json:",omitempty"` // declared, by the root project
+	Conflict     bool      `json:",omitempty"` // the selected version does not satisfy the constraint
+	Duplicate    bool      `json:",omitempty"` // its dependencies are listed where it first appears
+	Dependencies []TreeDoc `json:",omitempty"`
+}
+
+func edgeDoc(e Edge) TreeDoc {
+	doc := TreeDoc{Name: e.Dependency.Name(), Constraint: e.Dependency.Constraint().String(), Scope: string(e.Dependency.Scope()), Conflict: e.Conflict()}
+	if e.Package != nil {
+		doc.Version = e.Package.Version().String()
+	}
+	return doc
+}
+
+var treeDotFlag *bool
+var treeOfflineFlag *bool
+var treeUpdateFlag *bool
+var treeNoLockFlag *bool
+var Tree = Command{
+	Name:      `tree`,
+	Alias:     `lt`,
+	Category:  DependencyCategory,
+	UsageLine: ``,
+	Short:     `Print the dependency tree`,
+	Long: `Resolve current project dependencies, like list-package, and print the nested dependency graph.
+       Each dependency is printed with its selected version, and the declared constraint if it is not that
+       exact version. A package already printed is marked with (*), its dependencies are not printed again.
+       Conflicts, where the selected version does not satisfy the declared constraint, are marked with ✗.
+       The project test and tool scope dependencies are followed by their scope.
+       With -dot, the graph is printed in the graphviz format, e.g. 'gpk tree -dot | dot -Tsvg > deps.svg'.`,
+	RequireProject: true,
+	FlagInit: func(Tree *Command) {
+		treeDotFlag = Tree.Flag.Bool("dot", false, "print the graph in the graphviz dot format.")
+		treeOfflineFlag = Tree.Flag.Bool("o", false, "offline. Do not look outside for missing dependencies")
+		treeUpdateFlag = Tree.Flag.Bool("u", false, "update. Look for updated version of dependencies")
+		treeNoLockFlag = Tree.Flag.Bool("nolock", false, "nolock. Ignore the lock file, and resolve dependencies again.")
+	},
+	Run: func(Tree *Command) (err error) {
+		g, err := resolveGraph(Tree, *treeOfflineFlag, *treeUpdateFlag, *treeNoLockFlag)
+		if err != nil {
+			return
+		}
+		switch {
+		case *treeDotFlag:
+			edges := make([]Edge, 0)
+			g.Walk(func(depth int, e Edge, last, duplicate bool) { edges = append(edges, e) })
+			return g.WriteDot(os.Stdout, edges)
+
+		case jsonFormat():
+			return printJSON(treeDoc(g))
+		}
+
+		TitleStyle.Printf("%s\n", g.Root().Name())
+		duplicates := false
+		for _, line := range treeLines(g) {
+			switch {
+			case line.conflict:
+				ErrorStyle.Printf("%s ✗\n", line.text)
+			case line.duplicate:
+				duplicates = true
+				NormalStyle.Printf("%s (*)\n", line.text)
+			default:
+				SuccessStyle.Printf("%s\n", line.text)
+			}
+		}
+		if duplicates {
+			NormalStyle.Printf("(*) already printed above\n")
+		}
+		return
+	},
+}
+
+//treeDoc is the 'gpk tree' json document of g
+func treeDoc(g *Graph) TreeDoc {
+	root := TreeDoc{Name: g.Root().Name()}
+	parents := []*TreeDoc{&root} // by depth
+	g.Walk(func(depth int, e Edge, last, duplicate bool) {
+		parent := parents[depth]
+		doc := edgeDoc(e)
+		doc.Duplicate = duplicate
+		parent.Dependencies = append(parent.Dependencies, doc)
+		// its dependencies, if any, are visited before its next sibling is appended
+		parents = append(parents[:depth+1], &parent.Dependencies[len(parent.Dependencies)-1])
+	})
+	return root
+}
+
+//treeLine is a line of the 'gpk tree' output, below the project name
+type treeLine struct {
+	text      string // the branches, and the edge label
+	conflict  bool
+	duplicate bool // already printed above
+}
+
+//treeLines draws the tree of g, one line per edge
+func treeLines(g *Graph) (lines []treeLine) {
+	indent := make([]string, 0) // by depth
+	g.Walk(func(depth int, e Edge, last, duplicate bool) {
+		branch, next := "├── ", "│   "
+		if last {
+			branch, next = "└── ", "    "
+		}
+		indent = append(indent[:depth], next)
+		lines = append(lines, treeLine{strings.Join(indent[:depth], "") + branch + edgeLabel(e), e.Conflict(), duplicate})
+	})
+	return
+}
+
+var whyDotFlag *bool
+var whyOfflineFlag *bool
+var whyNoLockFlag *bool
+var Why = Command{
+	Name:      `why`,
+	Alias:     `lw`,
+	Category:  DependencyCategory,
+	UsageLine: `NAME`,
+	Short:     `Explain why a package is a dependency`,
+	Long: `Resolve current project dependencies, like list-package, and print every path from the project
+       to the package NAME, with the selected versions, and the constraint declared on NAME at the end of each path.
+       With -dot, the paths are printed as a graph in the graphviz format.`,
+	RequireProject: true,
+	FlagInit: func(Why *Command) {
+		whyDotFlag = Why.Flag.Bool("dot", false, "print the paths in the graphviz dot format.")
+		whyOfflineFlag = Why.Flag.Bool("o", false, "offline. Do not look outside for missing dependencies")
+		whyNoLockFlag = Why.Flag.Bool("nolock", false, "nolock. Ignore the lock file, and resolve dependencies again.")
+	},
+	Run: func(Why *Command) (err error) {
+		if len(Why.Flag.Args()) != 1 {
+			ErrorStyle.Printf("Missing NAME argument\n")
+			return InvalidArgumentSize()
+		}
+		name := Why.Flag.Arg(0)
+		g, err := resolveGraph(Why, *whyOfflineFlag, false, *whyNoLockFlag)
+		if err != nil {
+			return
+		}
+		paths := g.Paths(name)
+		switch {
+		case *whyDotFlag:
+			edges := make([]Edge, 0)
+			for _, path := range paths {
+				edges = append(edges, path...)
+			}
+			return g.WriteDot(os.Stdout, edges, name)
+
+		case jsonFormat():
+			docs := make([][]TreeDoc, 0, len(paths))
+			for _, path := range paths {
+				doc := make([]TreeDoc, 0, len(path))
+				for _, e := range path {
+					doc = append(doc, edgeDoc(e))
+				}
+				docs = append(docs, doc)
+			}
+			return printJSON(docs)
+		}
+
+		if len(paths) == 0 {
+			ErrorStyle.Printf("%s is not a dependency of %s\n", name, g.Root().Name())
+			return
+		}
+		TitleStyle.Printf("\n%s IS REQUIRED BY:\n", name)
+		for _, path := range paths {
+			hops := []string{g.Root().Name()}
+			conflict := false
+			for _, e := range path[:len(path)-1] {
+				hops = append(hops, e.Package.ID().String())
+				conflict = conflict || e.Conflict()
+			}
+			last := path[len(path)-1]
+			hops = append(hops, edgeLabel(last))
+			if conflict || last.Conflict() {
+				ErrorStyle.Printf("    %s ✗\n", strings.Join(hops, " -> "))
+			} else {
+				SuccessStyle.Printf("    %s\n", strings.Join(hops, " -> "))
+			}
+		}
+		return
+	},
+}
@@ -0,0 +1,64 @@
+package cmds
+
+import (
+	. "ericaro.net/gopack"
+	"strings"
+	"testing"
+)
+
+//diamondGraph resolves ex/p, that requires ex/b and ex/c, that both require ex/d
+func diamondGraph(t *testing.T) *Graph {
+	r, err := NewLocalRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	installTestPackage(t, r, "ex/d", "1.1.0")
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/d ^1.0")
+	installTestPackage(t, r, "ex/c", "1.0.0", "ex/d 1.1.0")
+	p := newTestProject(t, "ex/p", "ex/b ^1.0", "ex/c ^1.0")
+	dependencies, err := r.ResolveDependencies(p, true, false)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	return NewGraph(p, dependencies)
+}
+
+func TestTreeDiamond(t *testing.T) {
+	var text []string
+	for _, line := range treeLines(diamondGraph(t)) {
+		if line.conflict {
+			t.Errorf("%q is not a conflict", line.text)
+		}
+		if line.duplicate {
+			text = append(text, line.text+" (*)")
+		} else {
+			text = append(text, line.text)
+		}
+	}
+	expected := []string{
+		"├── ex/b 1.0.0 (^1.0)",
+		"│   └── ex/d 1.1.0 (^1.0)",
+		"└── ex/c 1.0.0 (^1.0)",
+		"    └── ex/d 1.1.0 (*)",
+	}
+	if strings.Join(text, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Printed:\n%s\nexpected:\n%s", strings.Join(text, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestTreeDocDiamond(t *testing.T) {
+	doc := treeDoc(diamondGraph(t))
+	if doc.Name != "ex/p" || len(doc.Dependencies) != 2 {
+		t.Fatalf("Unexpected root %#v", doc)
+	}
+	b, c := doc.Dependencies[0], doc.Dependencies[1]
+	if b.Name != "ex/b" || len(b.Dependencies) != 1 || b.Dependencies[0].Duplicate {
+		t.Errorf("Unexpected ex/b %#v", b)
+	}
+	if c.Name != "ex/c" || len(c.Dependencies) != 1 || !c.Dependencies[0].Duplicate {
+		t.Errorf("Unexpected ex/c %#v", c)
+	}
+	if d := c.Dependencies[0]; d.Name != "ex/d" || d.Version != "1.1.0" || d.Constraint != "1.1.0" || len(d.Dependencies) != 0 {
+		t.Errorf("Unexpected ex/d %#v", d)
+	}
+}
@@ -0,0 +1,213 @@
+package cmds
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	. "ericaro.net/gopack"
+	"io/ioutil"
+)
+
+func init() {
+	Reg(
+		&KeyGen,
+		&Trust,
+		&Untrust,
+		&Strict,
+	)
+}
+
+//signPackage signs an installed package with the private key stored in keyfile
+func signPackage(pkg *Package, keyfile string) (sig *Signature, err error) {
+	key, err := ReadPrivateKey(keyfile)
+	if err != nil {
+		return
+	}
+	return pkg.Sign(key)
+}
+
+var keygenRSAFlag *bool
+var KeyGen = Command{
+	Name:      `keygen`,
+	Alias:     `kg`,
+	Category:  RemoteCategory,
+	UsageLine: `KEYFILE`,
+	Short:     `Generate a key to sign packages`,
+	Long: `Generate a new private key in KEYFILE, and its public key in KEYFILE.pub, both in PEM format.
+       The private key is used with 'gpk install -sign' and 'gpk push -sign',
+       the public key is the one to give to 'gpk trust'.
+       The key is an Ed25519 key, unless -rsa is set.`,
+	RequireProject: false,
+	FlagInit: func(KeyGen *Command) {
+		keygenRSAFlag = KeyGen.Flag.Bool("rsa", false, "generate a 3072 bits RSA key instead of an Ed25519 one.")
+	},
+	Run: func(KeyGen *Command) (err error) {
+		if len(KeyGen.Flag.Args()) != 1 {
+			ErrorStyle.Printf("Missing KEYFILE argument\n")
+			return InvalidArgumentSize()
+		}
+		keyfile := KeyGen.Flag.Arg(0)
+
+		var key crypto.Signer
+		if *keygenRSAFlag {
+			key, err = rsa.GenerateKey(rand.Reader, 3072)
+		} else {
+			_, key, err = ed25519.GenerateKey(rand.Reader)
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot generate the key:\n    \u21b3 %v\n", err)
+			return
+		}
+		private, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			ErrorStyle.Printf("Cannot encode the private key:\n    \u21b3 %v\n", err)
+			return
+		}
+		public, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			ErrorStyle.Printf("Cannot encode the public key:\n    \u21b3 %v\n", err)
+			return
+		}
+		err = ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600)
+		if err == nil {
+			err = ioutil.WriteFile(keyfile+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644)
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot write the key:\n    \u21b3 %v\n", err)
+			return
+		}
+		SuccessStyle.Printf("Generated key %s in %s and %s.pub\n", KeyID(public), keyfile, keyfile)
+		return
+	},
+}
+
+var trustCommentFlag *string
+var Trust = Command{
+	Name:      `trust`,
+	Alias:     `tr+`,
+	Category:  RemoteCategory,
+	UsageLine: `PREFIX KEYFILE`,
+	Short:     `Trust a key to sign packages`,
+	Long: `Add the public key in KEYFILE to the local repository trust store.
+       PREFIX  the key is trusted for the package PREFIX, and every package under it: "ex" covers "ex/a",
+               but not "example".
+               Use "" to trust it for every package.
+       KEYFILE a PEM public key (or private key, its public part is used).
+
+       Without arguments, it lists the trusted keys.
+       Trusted keys are only enforced in strict mode, see 'gpk strict'.`,
+	RequireProject: false,
+	FlagInit: func(Trust *Command) {
+		trustCommentFlag = Trust.Flag.String("c", "", "a comment to remember who the key belongs to.")
+	},
+	Run: func(Trust *Command) (err error) {
+		store := Trust.Repository.TrustStore()
+		switch len(Trust.Flag.Args()) {
+		case 0:
+			if store.Strict() {
+				TitleStyle.Printf("\nTRUSTED KEYS (strict mode):\n")
+			} else {
+				TitleStyle.Printf("\nTRUSTED KEYS:\n")
+			}
+			if len(store.Keys()) == 0 {
+				SuccessStyle.Printf("       <empty>\n")
+			}
+			for _, k := range store.Keys() {
+				SuccessStyle.Printf("        %-40s %s %s\n", k.Prefix, KeyID(k.PublicKey), k.Comment)
+			}
+			return
+		case 2:
+		default:
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+		prefix, keyfile := Trust.Flag.Arg(0), Trust.Flag.Arg(1)
+		key, err := ReadPublicKey(keyfile)
+		if err != nil {
+			ErrorStyle.Printf("Invalid key file %s:\n    \u21b3 %v\n", keyfile, err)
+			return
+		}
+		if !store.Trust(prefix, key, *trustCommentFlag) {
+			NormalStyle.Printf("Key %s is already trusted for \"%s\"\n", KeyID(key), prefix)
+			return
+		}
+		if err = store.Write(); err != nil {
+			ErrorStyle.Printf("Cannot write the trust store:\n    \u21b3 %v\n", err)
+			return
+		}
+		SuccessStyle.Printf("       +%s %s\n", prefix, KeyID(key))
+		return
+	},
+}
+
+var Untrust = Command{
+	Name:      `untrust`,
+	Alias:     `tr-`,
+	Category:  RemoteCategory,
+	UsageLine: `PREFIX [KEYID]`,
+	Short:     `Stop trusting keys`,
+	Long: `Remove the keys trusted for PREFIX from the local repository trust store.
+       If KEYID is set, only that key is removed. KEYID is the key id listed by 'gpk trust'.`,
+	RequireProject: false,
+	Run: func(Untrust *Command) (err error) {
+		if len(Untrust.Flag.Args()) < 1 || len(Untrust.Flag.Args()) > 2 {
+			ErrorStyle.Printf("Illegal arguments count\n")
+			return InvalidArgumentSize()
+		}
+		store := Untrust.Repository.TrustStore()
+		removed := store.Untrust(Untrust.Flag.Arg(0), Untrust.Flag.Arg(1))
+		if len(removed) == 0 {
+			ErrorStyle.Printf("Nothing to remove\n")
+			return
+		}
+		if err = store.Write(); err != nil {
+			ErrorStyle.Printf("Cannot write the trust store:\n    \u21b3 %v\n", err)
+			return
+		}
+		for _, k := range removed {
+			SuccessStyle.Printf("       -%s %s\n", k.Prefix, KeyID(k.PublicKey))
+		}
+		return
+	},
+}
+
+var Strict = Command{
+	Name:      `strict`,
+	Alias:     `strict`,
+	Category:  RemoteCategory,
+	UsageLine: `[on|off]`,
+	Short:     `Require trusted signatures`,
+	Long: `Turn the strict mode of the local repository on, or off.
+       In strict mode, every package installed from a remote, or used to resolve dependencies,
+       must be signed by a key trusted for its name (see 'gpk trust').
+       Without arguments, it prints the current mode.`,
+	RequireProject: false,
+	Run: func(Strict *Command) (err error) {
+		store := Strict.Repository.TrustStore()
+		switch Strict.Flag.Arg(0) {
+		case "":
+		case "on":
+			store.SetStrict(true)
+			err = store.Write()
+		case "off":
+			store.SetStrict(false)
+			err = store.Write()
+		default:
+			ErrorStyle.Printf("Invalid argument \"%s\", expecting on or off\n", Strict.Flag.Arg(0))
+			return InvalidArgumentSize()
+		}
+		if err != nil {
+			ErrorStyle.Printf("Cannot write the trust store:\n    \u21b3 %v\n", err)
+			return
+		}
+		if store.Strict() {
+			SuccessStyle.Printf("Strict mode is on\n")
+		} else {
+			SuccessStyle.Printf("Strict mode is off\n")
+		}
+		return
+	},
+}
@@ -0,0 +1,432 @@
+package gopack
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	CredentialsFile        = ".gpkcredentials"
+	CredentialsFileVersion = "1.0.0"
+	PassphraseEnv          = "GPK_PASSPHRASE" // passphrase of the credentials file, asked for if it is not set
+	FileStoreSpec          = "file"
+	PlaintextStoreSpec     = "none"            // the tokens are kept in the .gpkrepository file
+	DefaultStoreSpec       = FileStoreSpec     // of the new repositories, the older ones keep their tokens in clear
+	HelperStoreSpec        = "helper:"         // followed by the helper command
+	HelperPrefix           = "gpk-credential-" // of the helper executables, when they are not given by their path
+	kdfIterations          = 600000            // PBKDF2-SHA256 iterations to derive the credentials file key
+)
+
+//CredentialStore keeps the remote tokens out of the .gpkrepository file, that only holds a reference to them.
+type CredentialStore interface {
+	//Get returns the token stored under ref, or nil if there is none
+	Get(ref string) (*protocol.Token, error)
+	//Store saves token under ref, replacing the previous one
+	Store(ref string, token protocol.Token) error
+	//Erase forgets the token stored under ref, if any
+	Erase(ref string) error
+	//String returns the store specification, as parsed by NewCredentialStore
+	String() string
+}
+
+//NewCredentialStore parses a store specification: "file" for the encrypted credentials file of the repository root,
+// "helper:COMMAND" for an external credential helper, or "none" to keep the tokens in the .gpkrepository file (the
+// store is then nil).
+func NewCredentialStore(root, spec string, passphrase func() (string, error)) (CredentialStore, error) {
+	switch {
+	case spec == PlaintextStoreSpec:
+		return nil, nil
+	case spec == FileStoreSpec:
+		return NewFileStore(filepath.Join(root, CredentialsFile), passphrase), nil
+	case strings.HasPrefix(spec, HelperStoreSpec) && strings.TrimSpace(strings.TrimPrefix(spec, HelperStoreSpec)) != "":
+		return NewHelperStore(strings.TrimSpace(strings.TrimPrefix(spec, HelperStoreSpec))), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown credential store %q, expecting %q, %q or %q", spec, FileStoreSpec, HelperStoreSpec+"COMMAND", PlaintextStoreSpec))
+}
+
+//credentialRef is the reference of a remote token in the credential store
+func credentialRef(name string, u url.URL) string {
+	return strings.ToLower(name) + "@" + u.String()
+}
+
+//RedactToken returns a printable form of a token, that does not disclose it: its ID, as displayed by 'gpk token'
+func RedactToken(t *protocol.Token) string {
+	if t == nil || len(*t) == 0 {
+		return ""
+	}
+	return "token:" + AccessEntry{Hash: TokenHash(*t)}.ID()
+}
+
+//FileStore is a credential store encrypted with AES-256-GCM, with a key derived from a passphrase.
+// The file is only read, and the passphrase asked for, the first time a token is needed.
+type FileStore struct {
+	path       string
+	passphrase func() (string, error)
+	mutex      sync.Mutex
+	salt, key  []byte
+	tokens     map[string]*protocol.Token // by reference, nil until the file is open
+}
+
+//NewFileStore creates a store in the file path, encrypted with the passphrase returned by passphrase.
+func NewFileStore(path string, passphrase func() (string, error)) *FileStore {
+	return &FileStore{path: path, passphrase: passphrase}
+}
+
+type credentialsFile struct {
+	FormatVersion string
+	Iterations    int
+	Salt, Nonce   []byte
+	Data          []byte // the encrypted json map of tokens
+}
+
+func (s *FileStore) deriveKey(iterations int) (err error) {
+	phrase, err := s.passphrase()
+	if err != nil {
+		return
+	}
+	if phrase == "" {
+		return errors.New(fmt.Sprintf("No passphrase for the credentials file %s, set %s", s.path, PassphraseEnv))
+	}
+	s.key, err = pbkdf2.Key(sha256.New, phrase, s.salt, iterations, 32)
+	return
+}
+
+//open reads, and decrypts, the file once
+func (s *FileStore) open() (err error) {
+	if s.tokens != nil {
+		return
+	}
+	if !FileExists(s.path) {
+		s.salt = make([]byte, 16)
+		if _, err = rand.Read(s.salt); err != nil {
+			return
+		}
+		if err = s.deriveKey(kdfIterations); err != nil {
+			return
+		}
+		s.tokens = make(map[string]*protocol.Token)
+		return
+	}
+	var f credentialsFile
+	if err = JsonReadFile(s.path, &f); err != nil {
+		return
+	}
+	if f.FormatVersion != CredentialsFileVersion {
+		log.Printf("Warning: Unknown format version \"%s\"", f.FormatVersion)
+	}
+	s.salt = f.Salt
+	if err = s.deriveKey(f.Iterations); err != nil {
+		return
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return
+	}
+	data, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		s.key = nil
+		return errors.New(fmt.Sprintf("Cannot decrypt %s: wrong passphrase", s.path))
+	}
+	tokens := make(map[string]*protocol.Token)
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return
+	}
+	s.tokens = tokens
+	return
+}
+
+func (s *FileStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//write encrypts the tokens with a new nonce, the file is only readable by its owner
+func (s *FileStore) write() (err error) {
+	data, err := json.Marshal(s.tokens)
+	if err != nil {
+		return
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return
+	}
+	f := credentialsFile{FormatVersion: CredentialsFileVersion, Iterations: kdfIterations, Salt: s.salt, Nonce: make([]byte, aead.NonceSize())}
+	if _, err = rand.Read(f.Nonce); err != nil {
+		return
+	}
+	f.Data = aead.Seal(nil, f.Nonce, data, nil)
+	if err = JsonWriteFile(s.path, &f); err != nil {
+		return
+	}
+	return os.Chmod(s.path, 0600)
+}
+
+func (s *FileStore) Get(ref string) (*protocol.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s.tokens[ref], nil
+}
+
+func (s *FileStore) Store(ref string, token protocol.Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.open(); err != nil {
+		return err
+	}
+	if old, ok := s.tokens[ref]; ok && bytes.Equal(*old, token) {
+		return nil
+	}
+	s.tokens[ref] = &token
+	return s.write()
+}
+
+func (s *FileStore) Erase(ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !FileExists(s.path) {
+		return nil
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if _, ok := s.tokens[ref]; !ok {
+		return nil
+	}
+	delete(s.tokens, ref)
+	return s.write()
+}
+
+func (s *FileStore) String() string {
+	return FileStoreSpec
+}
+
+//HelperStore delegates the tokens to an external process, the way git does with its credential helpers.
+// The helper is run with one of the "get", "store" or "erase" actions as last argument, and reads key=value lines
+// on its standard input, up to a blank line: "ref" is the token reference and, for "store", "token" is the std base64 token.
+// For "get", it prints the "token=..." line, or nothing if it does not know the reference.
+type HelperStore struct {
+	command string
+}
+
+//NewHelperStore creates a store for the helper command. Like for git, a command starting with "!" is run by the shell,
+// a command that is not a path is the name of a gpk-credential-NAME executable.
+func NewHelperStore(command string) *HelperStore {
+	return &HelperStore{command: command}
+}
+
+func (s *HelperStore) cmd(action string) *exec.Cmd {
+	if strings.HasPrefix(s.command, "!") {
+		return exec.Command("sh", "-c", strings.TrimPrefix(s.command, "!")+" "+action)
+	}
+	args := strings.Fields(s.command)
+	if !strings.ContainsRune(args[0], filepath.Separator) {
+		args[0] = HelperPrefix + args[0]
+	}
+	return exec.Command(args[0], append(args[1:], action)...)
+}
+
+//run executes the helper action, with the attributes, and returns the attributes it printed
+func (s *HelperStore) run(action string, attributes ...string) (result map[string]string, err error) {
+	cmd := s.cmd(action)
+	cmd.Stdin = strings.NewReader(strings.Join(attributes, "\n") + "\n\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Credential helper %q failed to %s: %v", s.command, action, err))
+	}
+	result = make(map[string]string)
+	lines := bufio.NewReader(bytes.NewReader(out))
+	for {
+		line, err := lines.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if err == io.EOF || err == nil {
+				return result, nil
+			}
+			return nil, err
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			result[line[:i]] = line[i+1:]
+		}
+	}
+}
+
+func (s *HelperStore) Get(ref string) (*protocol.Token, error) {
+	result, err := s.run("get", "ref="+ref)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := result["token"]
+	if !ok {
+		return nil, nil
+	}
+	return protocol.ParseStdToken(t)
+}
+
+func (s *HelperStore) Store(ref string, token protocol.Token) error {
+	_, err := s.run("store", "ref="+ref, "token="+token.FormatStd())
+	return err
+}
+
+func (s *HelperStore) Erase(ref string) error {
+	_, err := s.run("erase", "ref="+ref)
+	return err
+}
+
+func (s *HelperStore) String() string {
+	return HelperStoreSpec + s.command
+}
+
+//storedClient is a remote whose token is in a credential store: the actual client is only created, and the token
+// read from the store, the first time it is needed, so that commands not using the remote never open the store.
+type storedClient struct {
+	name    string
+	url     url.URL
+	ref     string
+	store   CredentialStore
+	mutex   sync.Mutex // guards the client, it is resolved once
+	client  protocol.Client
+	err     error
+	renewed func()
+}
+
+func newStoredClient(name string, u url.URL, ref string, store CredentialStore) *storedClient {
+	return &storedClient{name: name, url: u, ref: ref, store: store}
+}
+
+//resolve reads the token and creates the actual client
+func (c *storedClient) resolve() (protocol.Client, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client != nil || c.err != nil {
+		return c.client, c.err
+	}
+	token, err := c.store.Get(c.ref)
+	if err != nil {
+		c.err = errors.New(fmt.Sprintf("Cannot read the token of %s: %v", c.name, err))
+		return nil, c.err
+	}
+	if c.client, c.err = protocol.NewClient(c.name, c.url, token); c.err != nil {
+		c.client = nil
+		return nil, c.err
+	}
+	if renewer, ok := c.client.(protocol.Renewer); ok && c.renewed != nil {
+		renewer.OnRenew(c.renewed)
+	}
+	return c.client, nil
+}
+
+//resolved returns true if the token has been read
+func (c *storedClient) resolved() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.client != nil
+}
+
+func (c *storedClient) Fetch(pid protocol.PID) (io.ReadCloser, error) {
+	client, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return client.Fetch(pid)
+}
+
+func (c *storedClient) FetchFrom(pid protocol.PID, offset int64, tag string) (*protocol.Archive, error) {
+	client, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if resumer, ok := client.(protocol.Resumer); ok {
+		return resumer.FetchFrom(pid, offset, tag)
+	}
+	rc, err := client.Fetch(pid)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewArchive(rc), nil
+}
+
+func (c *storedClient) Push(pid protocol.PID, r io.Reader) error {
+	client, err := c.resolve()
+	if err != nil {
+		return err
+	}
+	return client.Push(pid, r)
+}
+
+func (c *storedClient) PushExecutables(pid protocol.PID, r io.Reader) error {
+	client, err := c.resolve()
+	if err != nil {
+		return err
+	}
+	return client.PushExecutables(pid, r)
+}
+
+func (c *storedClient) Search(query string, start int) []protocol.PID {
+	client, err := c.resolve()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return client.Search(query, start)
+}
+
+func (c *storedClient) Name() string {
+	return c.name
+}
+
+func (c *storedClient) Path() url.URL {
+	return c.url
+}
+
+func (c *storedClient) Token() *protocol.Token {
+	client, err := c.resolve()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return client.Token()
+}
+
+func (c *storedClient) OnRenew(renewed func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.renewed = renewed
+	if renewer, ok := c.client.(protocol.Renewer); ok {
+		renewer.OnRenew(renewed)
+	}
+}
+
+//readPassphrase returns the passphrase from the environment, or asks for it
+func readPassphrase(prompt func() (string, error)) (string, error) {
+	if phrase := os.Getenv(PassphraseEnv); phrase != "" {
+		return phrase, nil
+	}
+	if prompt == nil {
+		return "", nil
+	}
+	return prompt()
+}
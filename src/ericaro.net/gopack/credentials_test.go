@@ -0,0 +1,205 @@
+package gopack
+
+import (
+	"bytes"
+	"ericaro.net/gopack/protocol"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//passphrase returns a passphrase function that always returns phrase
+func passphrase(phrase string) func() (string, error) {
+	return func() (string, error) { return phrase, nil }
+}
+
+//testHelper writes a credential helper keeping "REF TOKEN" lines in a file, and returns its command
+func testHelper(t *testing.T) string {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+f=` + filepath.Join(dir, "tokens") + `
+while read line && [ -n "$line" ]; do
+	case "$line" in
+	ref=*) ref="${line#ref=}" ;;
+	token=*) token="${line#token=}" ;;
+	esac
+done
+touch "$f"
+case "$1" in
+get) grep -F "$ref " "$f" | sed 's/^[^ ]* /token=/' ;;
+store) grep -vF "$ref " "$f" > "$f.new"; echo "$ref $token" >> "$f.new"; mv "$f.new" "$f" ;;
+erase) grep -vF "$ref " "$f" > "$f.new"; mv "$f.new" "$f" ;;
+*) exit 1 ;;
+esac
+exit 0
+`
+	path := filepath.Join(dir, "helper")
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//checkStore stores, reads and erases tokens in s
+func checkStore(t *testing.T, s CredentialStore) {
+	alice, bob := protocol.NewToken(32), protocol.NewToken(32)
+	if token, err := s.Get("alice@http://example.com"); err != nil || token != nil {
+		t.Fatalf("%s: unexpected token %v: %v", s, token, err)
+	}
+	if err := s.Store("alice@http://example.com", *alice); err != nil {
+		t.Fatalf("%s: cannot store: %v", s, err)
+	}
+	if err := s.Store("bob@http://example.com", *bob); err != nil {
+		t.Fatalf("%s: cannot store: %v", s, err)
+	}
+	if token, err := s.Get("alice@http://example.com"); err != nil || token == nil || !bytes.Equal(*token, *alice) {
+		t.Errorf("%s: alice's token is %v: %v", s, token, err)
+	}
+	if err := s.Erase("alice@http://example.com"); err != nil {
+		t.Fatalf("%s: cannot erase: %v", s, err)
+	}
+	if token, err := s.Get("alice@http://example.com"); err != nil || token != nil {
+		t.Errorf("%s: alice's token has not been erased: %v", s, err)
+	}
+	if token, err := s.Get("bob@http://example.com"); err != nil || token == nil || !bytes.Equal(*token, *bob) {
+		t.Errorf("%s: bob's token is %v: %v", s, token, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CredentialsFile)
+	checkStore(t, NewFileStore(path, passphrase("secret")))
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The credentials file is readable by others: %v", err)
+	}
+	if token, err := NewFileStore(path, passphrase("secret")).Get("bob@http://example.com"); err != nil || token == nil {
+		t.Errorf("Cannot read the credentials file again: %v", err)
+	}
+	if _, err := NewFileStore(path, passphrase("wrong")).Get("bob@http://example.com"); err == nil {
+		t.Errorf("The credentials file has been read with a wrong passphrase")
+	}
+	if _, err := NewFileStore(path, passphrase("")).Get("bob@http://example.com"); err == nil {
+		t.Errorf("The credentials file has been read without a passphrase")
+	}
+}
+
+func TestHelperStore(t *testing.T) {
+	checkStore(t, NewHelperStore(testHelper(t)))
+	if err := NewHelperStore("!exit 1;").Store("alice@http://example.com", *protocol.NewToken(32)); err == nil {
+		t.Errorf("A failing helper has stored the token")
+	}
+}
+
+//repositoryFile returns the content of the .gpkrepository file of r
+func repositoryFile(t *testing.T, r *LocalRepository) string {
+	content, err := ioutil.ReadFile(filepath.Join(r.Root(), GpkrepositoryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+//addTokenRemote adds the remote central, with a new token
+func addTokenRemote(t *testing.T, r *LocalRepository) *protocol.Token {
+	u, _ := url.Parse("http://example.com/")
+	token := protocol.NewToken(32)
+	client, err := protocol.NewClient("central", *u, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RemoteAdd(client); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokensAreStoredByDefault(t *testing.T) {
+	t.Setenv(PassphraseEnv, "secret")
+	r := newTestRepository(t)
+	token := addTokenRemote(t, r)
+	if err := r.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(repositoryFile(t, r), token.FormatStd()) {
+		t.Errorf("The token is written in clear")
+	}
+	read, err := NewLocalRepository(r.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote, err := read.Remote("central"); err != nil || !bytes.Equal(*remote.Token(), *token) {
+		t.Errorf("The token has not been stored: %v", err)
+	}
+}
+
+func TestPlaintextTokensAreMoved(t *testing.T) {
+	t.Setenv(PassphraseEnv, "secret")
+	r := newTestRepository(t)
+	token := addTokenRemote(t, r)
+	if err := r.SetCredentials(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(repositoryFile(t, r), token.FormatStd()) {
+		t.Fatalf("The token is not written in clear")
+	}
+	read, err := NewLocalRepository(r.Root())
+	if err != nil || read.Credentials() != nil {
+		t.Fatalf("The tokens are not kept in clear: %v", err)
+	}
+
+	// a file written before the credential stores existed keeps its tokens in clear, without asking for a passphrase
+	legacy := strings.Replace(repositoryFile(t, r), `"Credentials":"none",`, "", 1)
+	if err = ioutil.WriteFile(filepath.Join(r.Root(), GpkrepositoryFile), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PassphraseEnv, "")
+	if read, err = NewLocalRepository(r.Root()); err != nil || read.Credentials() != nil {
+		t.Fatalf("The tokens of a legacy repository are not kept in clear: %v", err)
+	}
+	read.SetRetention(3)
+	if err = read.Write(); err != nil {
+		t.Fatalf("Cannot write a legacy repository: %v", err)
+	}
+	if !strings.Contains(repositoryFile(t, read), token.FormatStd()) {
+		t.Fatalf("The token has been moved by an unrelated write")
+	}
+
+	// until they are moved explicitly
+	t.Setenv(PassphraseEnv, "secret")
+	store, err := read.ParseCredentials(DefaultStoreSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = read.SetCredentials(store); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(repositoryFile(t, read), token.FormatStd()) {
+		t.Errorf("The token is still written in clear")
+	}
+	if read, err = NewLocalRepository(r.Root()); err != nil {
+		t.Fatal(err)
+	}
+	if remote, err := read.Remote("central"); err != nil || !bytes.Equal(*remote.Token(), *token) {
+		t.Errorf("The token has not been moved: %v", err)
+	}
+}
+
+func TestInvalidRepositoryFile(t *testing.T) {
+	for _, content := range []string{
+		`{"FormatVersion":"1.0.0","Credentials":"unknown","Remotes":[{"Name":"central","Url":"http://example.com/","Credential":"central@http://example.com/"}]}`,
+		`{"FormatVersion":"1.0.0","Credentials":"none","Remotes":[{"Name":"central","Url":"http://example.com/","Credential":"central@http://example.com/"}]}`,
+		`{"FormatVersion":"1.0.0",`,
+	} {
+		root := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(root, GpkrepositoryFile), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewLocalRepository(root); err == nil {
+			t.Errorf("The invalid repository %s has been read", content)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package gopack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+//digests are prefixed by the algorithm used, so that it can be changed one day
+const digestPrefix = "sha256:"
+
+//DigestDir computes the content digest of a package installed in dir.
+// It covers the package .gpk file and every other file in dir, but the signature: it is the sha256 of the sorted list of
+// "<sha256 of the file> <relative path>" lines, so it does not depend on the way the files are stored, or transferred.
+// The .gpk sum is the metadataSum of the package, as the digest is written into it.
+func DigestDir(dir string) (digest string, err error) {
+	sums, err := dirSums(dir)
+	if err != nil {
+		return
+	}
+	return digestSums(sums), nil
+}
+
+//dirSums computes the sum of every file of the package installed in dir, by relative slash separated path, see DigestDir
+func dirSums(dir string) (sums map[string]string, err error) {
+	p, err := ReadPackageFile(filepath.Join(dir, GpkFile))
+	if err != nil {
+		return
+	}
+	sums = make(map[string]string)
+	if sums[GpkFile], err = metadataSum(p); err != nil {
+		return
+	}
+	fileHandler := func(ldst, lsrc string) (err error) {
+		name := filepath.ToSlash(ldst)
+		if !digested(name) {
+			return
+		}
+		sums[name], err = fileSum(lsrc)
+		return
+	}
+	err = walkDir("", dir, nil, fileHandler)
+	return
+}
+
+//digested returns true if the file name (relative to the package, with forward slashes) is summed as it is.
+// The .gpk file has its own sum, see metadataSum, and the signature is not part of the content it signs.
+func digested(name string) bool {
+	return name != GpkFile && name != SignatureFile
+}
+
+//metadataSum computes the sum of the package .gpk file, without what changes while it is transferred: the remote it has
+// been downloaded from, and the digest itself.
+func metadataSum(p *Package) (sum string, err error) {
+	m := *p
+	m.remote, m.digest = "", ""
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+//DigestArchive computes the content digest of a packed package (a tar.gzed stream), without unpacking it.
+// It is the same as the DigestDir of the directory the archive would be unpacked into.
+func DigestArchive(in io.Reader) (digest string, err error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	sums := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name := path.Clean(filepath.ToSlash(hdr.Name))
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if name == GpkFile {
+			p := &Package{}
+			if err = json.NewDecoder(tr).Decode(p); err != nil {
+				return "", err
+			}
+			if sums[name], err = metadataSum(p); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if !digested(name) {
+			continue
+		}
+		h := sha256.New()
+		if _, err = io.Copy(h, tr); err != nil {
+			return "", err
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	if _, ok := sums[GpkFile]; !ok {
+		return "", errors.New(fmt.Sprintf("Invalid package format, %v is missing", GpkFile))
+	}
+	return digestSums(sums), nil
+}
+
+//checkDigest returns an error if the actual digest is not the expected one. An empty expected digest means there is nothing to check.
+// source describes who declared the expected digest
+func checkDigest(id ProjectID, source, expected, actual string) error {
+	if expected == "" || expected == actual {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Package %s content does not match the digest declared by %s\n    \u21b3 found    %s\n    \u21b3 declared %s", id, source, actual, expected))
+}
+
+//digestSums combine every file sum into a single digest
+func digestSums(sums map[string]string) string {
+	paths := make([]string, 0, len(sums))
+	for p := range sums {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s %s\n", sums[p], p)
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+//fileSum returns the hex sha256 of a file content
+func fileSum(path string) (sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,149 @@
+package gopack
+
+import (
+	"bytes"
+	. "ericaro.net/gopack/semver"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+//installTestTool installs the version of the project name, with an executable in its bin directory
+func installTestTool(t *testing.T, r *LocalRepository, name, version string) *Package {
+	p := newTestProject(t, name)
+	bin := filepath.Join(p.workingDir, "bin")
+	if err := os.MkdirAll(bin, os.ModeDir|os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "tool"), []byte("#!/bin/sh\necho tool\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := ParseVersion(version)
+	pkg, err := r.InstallProject(p, v, false)
+	if err != nil {
+		t.Fatalf("Cannot install %s %s: %v", name, version, err)
+	}
+	return pkg
+}
+
+//executable is the path of the test tool in an installed package
+func executable(p *Package) string {
+	return filepath.Join(p.InstallDir(), "bin", runtime.GOOS+"_"+runtime.GOARCH, "tool")
+}
+
+func TestDigestCoversEveryEntry(t *testing.T) {
+	r := newTestRepository(t)
+	p := installTestTool(t, r, "ex/a", "1.0.0")
+	digest, err := DigestDir(p.InstallDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != p.Digest() {
+		t.Fatalf("The installed package digest %s is not its recorded one %s", digest, p.Digest())
+	}
+
+	changes := map[string]func(){
+		"a source file": func() {
+			ioutil.WriteFile(filepath.Join(p.InstallDir(), "src", "ex", "a", "doc.go"), []byte("package a // changed\n"), 0644)
+		},
+		"an executable": func() {
+			ioutil.WriteFile(executable(p), []byte("#!/bin/sh\nrm -rf /\n"), 0755)
+		},
+		"an added file": func() {
+			ioutil.WriteFile(filepath.Join(p.InstallDir(), "bin", "other"), []byte("other"), 0755)
+		},
+		"the dependencies": func() {
+			c, _ := ParseConstraint("^1.0")
+			p.self.AppendDependency(*NewDependency("ex/evil", c))
+			p.Write()
+		},
+		"the version": func() {
+			p.version, _ = ParseVersion("2.0.0")
+			p.Write()
+		},
+	}
+	for what, change := range changes {
+		r := newTestRepository(t)
+		p = installTestTool(t, r, "ex/a", "1.0.0")
+		change()
+		if changed, err := DigestDir(p.InstallDir()); err != nil || changed == p.Digest() {
+			t.Errorf("Changing %s does not change the digest: %v", what, err)
+		}
+	}
+
+	// what changes while the package is transferred is not part of the digest
+	p = installTestTool(t, r, "ex/b", "1.0.0")
+	p.remote = "central"
+	p.Write()
+	ioutil.WriteFile(filepath.Join(p.InstallDir(), SignatureFile), []byte("{}"), 0644)
+	if digest, _ := DigestDir(p.InstallDir()); digest != p.Digest() {
+		t.Errorf("The remote or the signature change the digest")
+	}
+}
+
+func TestDigestArchive(t *testing.T) {
+	r := newTestRepository(t)
+	p := installTestTool(t, r, "ex/a", "1.0.0")
+	var archive bytes.Buffer
+	if err := p.Pack(&archive); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := DigestArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != p.Digest() {
+		t.Errorf("The archive digest %s is not the package one %s", digest, p.Digest())
+	}
+
+	// the executables are packed with the package, and installed with it
+	other := newTestRepository(t)
+	q, err := other.Install(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot install the archive: %v", err)
+	}
+	if !FileExists(executable(q)) || q.Digest() != p.Digest() {
+		t.Errorf("The installed archive is not the packed package")
+	}
+}
+
+func TestInstallRejectsChangedArchive(t *testing.T) {
+	r := newTestRepository(t)
+	p := installTestTool(t, r, "ex/a", "1.0.0")
+	ioutil.WriteFile(executable(p), []byte("#!/bin/sh\nrm -rf /\n"), 0755)
+	var archive bytes.Buffer
+	if err := p.Pack(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestRepository(t).Install(&archive); err == nil {
+		t.Errorf("A package whose executable does not match its digest has been installed")
+	}
+}
+
+func TestAppendExecutables(t *testing.T) {
+	r := newTestRepository(t)
+	p := installTestTool(t, r, "ex/a", "1.0.0")
+	var same bytes.Buffer
+	if err := p.PackExecutables(&same); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.InstallAppend(&same); err != nil {
+		t.Errorf("Cannot append the executables the package has been installed with: %v", err)
+	}
+
+	other := newTestRepository(t)
+	q := installTestTool(t, other, "ex/a", "1.0.0")
+	ioutil.WriteFile(executable(q), []byte("#!/bin/sh\nrm -rf /\n"), 0755)
+	var changed bytes.Buffer
+	if err := q.PackExecutables(&changed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.InstallAppend(&changed); err == nil {
+		t.Errorf("Executables that are not part of the package digest have been appended")
+	}
+	if content, _ := ioutil.ReadFile(executable(p)); string(content) != "#!/bin/sh\necho tool\n" {
+		t.Errorf("The installed executable has been replaced")
+	}
+}
@@ -0,0 +1,225 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//DefaultJobs is the default maximum number of packages downloaded at the same time
+const DefaultJobs = 4
+
+//maxResumes is the number of times an interrupted download is resumed from the same remote, before trying the other ones.
+const maxResumes = 3
+
+//Progress is notified of every package download. Packages are downloaded concurrently, so it must be safe for concurrent use.
+type Progress interface {
+	//Start is called when the download of id from remote starts, or starts again. size is the whole archive size, or -1 if it is unknown.
+	Start(id ProjectID, remote string, size int64)
+	//Update is called while the archive is received, with the number of bytes received so far (including the ones of an interrupted download).
+	Update(id ProjectID, received int64)
+	//Done is called once the download is over. err is nil if the package has been installed.
+	Done(id ProjectID, err error)
+}
+
+//noProgress ignores the downloads
+type noProgress struct{}
+
+func (noProgress) Start(id ProjectID, remote string, size int64) {}
+func (noProgress) Update(id ProjectID, received int64)           {}
+func (noProgress) Done(id ProjectID, err error)                  {}
+
+//SetJobs sets the maximum number of packages downloaded at the same time.
+func (r *LocalRepository) SetJobs(jobs int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	r.jobs = jobs
+}
+
+//SetProgress sets the Progress notified of the downloads, nil to ignore them.
+func (r *LocalRepository) SetProgress(p Progress) {
+	if p == nil {
+		p = noProgress{}
+	}
+	r.progress = p
+}
+
+//download fetches the package p from the remotes allowed to resolve it, one after the other, in order (see remotesFor):
+// the first one that has it wins. If the download from it fails, the next ones are tried.
+// If timestamp is not nil, only a package newer than it is accepted. digest is the expected content digest (from the lock file), if any.
+// The package is not installed if its content does not match it, or if it does not match the digest declared by the remote.
+func (r *LocalRepository) download(p ProjectID, timestamp *time.Time, digest string) (prj *Package, err error) {
+	pid := protocol.PID{
+		Name:      p.Name(),
+		Version:   p.Version(),
+		Timestamp: timestamp,
+	}
+	part := r.partialPath(p)
+	var failure error // the most relevant error
+	started := false
+	for _, remote := range r.remotesFor(p.Name()) {
+		archive, err := fetchFrom(remote, pid, part)
+		if err != nil { // it does not have it
+			if failure == nil {
+				failure = err
+			}
+			continue
+		}
+		started = true
+		log.Printf("Downloading %s from %s", p, remote.Name())
+		prj, err = r.downloadArchive(remote, pid, archive, part, digest)
+		if err == nil {
+			r.progress.Done(p, nil)
+			return prj, nil
+		}
+		log.Printf("Cannot download %s from %s: %v", p, remote.Name(), err)
+		failure = err
+	}
+	if failure == nil {
+		failure = errors.New(fmt.Sprintf("No remote to download %s from", p.Name()))
+	}
+	if started {
+		r.progress.Done(p, failure)
+	}
+	return nil, failure
+}
+
+//fetchFrom fetches the package archive from remote. If the remote supports it, the partial download in part is resumed.
+func fetchFrom(remote protocol.Client, pid protocol.PID, part string) (*protocol.Archive, error) {
+	pid.Token = remote.Token()
+	if resumer, ok := remote.(protocol.Resumer); ok {
+		offset, tag := partialState(part)
+		return resumer.FetchFrom(pid, offset, tag)
+	}
+	rc, err := remote.Fetch(pid)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewArchive(rc), nil
+}
+
+//downloadArchive receives the archive into part, resuming it up to maxResumes times if it is interrupted, and then installs it.
+func (r *LocalRepository) downloadArchive(remote protocol.Client, pid protocol.PID, archive *protocol.Archive, part, digest string) (prj *Package, err error) {
+	id := *NewProjectID(pid.Name, pid.Version)
+	for resumes := 0; ; resumes++ {
+		if archive.Digest != "" { // do not even download it
+			if err = checkDigest(id, LockFile, digest, archive.Digest); err != nil {
+				archive.Close()
+				return
+			}
+		}
+		r.progress.Start(id, remote.Name(), archive.Size)
+		err = r.receiveArchive(id, archive, part)
+		archive.Close()
+		if err == nil {
+			break
+		}
+		if _, ok := remote.(protocol.Resumer); !ok || resumes >= maxResumes {
+			return
+		}
+		log.Printf("Resuming %s from %s: %v", id, remote.Name(), err)
+		if archive, err = fetchFrom(remote, pid, part); err != nil {
+			return
+		}
+	}
+
+	o := origin{source: LockFile, id: &id, digest: digest} // a remote must not answer with another version
+	if archive.Digest != "" {
+		o.source, o.digest = "remote "+remote.Name(), archive.Digest
+	}
+	o.signature = archive.Signature
+	f, err := os.Open(part)
+	if err != nil {
+		return
+	}
+	defer removePartial(part) // a complete archive is never resumed, even if it is invalid
+	defer f.Close()
+	log.Printf("Installing %s from %s ", id, remote.Name())
+	prj, err = r.install(true, f, o)
+	if err != nil {
+		return
+	}
+	prj.remote = remote.Name() // remember where it comes from
+	err = prj.Write()
+	return
+}
+
+//receiveArchive writes the archive in the partial download part, from the archive offset.
+func (r *LocalRepository) receiveArchive(id ProjectID, archive *protocol.Archive, part string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(part), os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if archive.Offset > fi.Size() {
+		return errors.New(fmt.Sprintf("Cannot resume %s at %d, only %d bytes have been received", id, archive.Offset, fi.Size()))
+	}
+	if err = f.Truncate(archive.Offset); err != nil {
+		return
+	}
+	if _, err = f.Seek(archive.Offset, io.SeekStart); err != nil {
+		return
+	}
+	// remember which archive it is, to resume it
+	if archive.Tag == "" {
+		os.Remove(part + ".tag")
+	} else if err = ioutil.WriteFile(part+".tag", []byte(archive.Tag), 0644); err != nil {
+		return
+	}
+	_, err = io.Copy(f, &progressReader{Reader: archive, id: id, received: archive.Offset, progress: r.progress})
+	return
+}
+
+//partialPath is where the archive of p is downloaded, it is kept there if the download is interrupted, to resume it later.
+func (r *LocalRepository) partialPath(p ProjectID) string {
+	return filepath.Join(r.root, StagingDir, "downloads", p.Path()+".part")
+}
+
+//partialState returns the size of the partial download part, and the tag of the archive it is part of.
+func partialState(part string) (offset int64, tag string) {
+	t, err := ioutil.ReadFile(part + ".tag")
+	if err != nil {
+		return 0, ""
+	}
+	fi, err := os.Stat(part)
+	if err != nil {
+		return 0, ""
+	}
+	return fi.Size(), string(t)
+}
+
+//removePartial removes the partial download part
+func removePartial(part string) {
+	os.Remove(part)
+	os.Remove(part + ".tag")
+	os.Remove(filepath.Dir(part)) // if it is empty
+}
+
+//progressReader notifies a Progress of the bytes read
+type progressReader struct {
+	io.Reader
+	id       ProjectID
+	received int64
+	progress Progress
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.Reader.Read(b)
+	p.received += int64(n)
+	p.progress.Update(p.id, p.received)
+	return
+}
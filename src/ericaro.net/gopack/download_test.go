@@ -0,0 +1,232 @@
+package gopack
+
+import (
+	"crypto/x509"
+	"ericaro.net/gopack/protocol"
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+//recordedProgress records the Progress notifications
+type recordedProgress struct {
+	sync.Mutex
+	starts   []int64 // the sizes
+	received int64   // the last update
+	done     []error
+}
+
+func (p *recordedProgress) Start(id ProjectID, remote string, size int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.starts = append(p.starts, size)
+}
+
+func (p *recordedProgress) Update(id ProjectID, received int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.received = received
+}
+
+func (p *recordedProgress) Done(id ProjectID, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.done = append(p.done, err)
+}
+
+//cutWriter stops writing the response after limit bytes
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if len(b) > w.limit {
+		n, _ := w.ResponseWriter.Write(b[:w.limit])
+		w.limit = 0
+		return n, errors.New("cut")
+	}
+	w.limit -= len(b)
+	return w.ResponseWriter.Write(b)
+}
+
+//downloadServer serves srv, and records the Range header of every fetch. While cut is positive, fetches are interrupted after cut bytes.
+type downloadServer struct {
+	sync.Mutex
+	handler http.Handler
+	ranges  []string
+	cut     int
+	cuts    int // the number of fetches left to interrupt
+}
+
+func (s *downloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	cut := s.cuts > 0
+	s.cuts--
+	s.Unlock()
+	if !cut {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	s.handler.ServeHTTP(&cutWriter{w, s.cut}, r)
+	w.(http.Flusher).Flush() // send what has been written
+	panic(http.ErrAbortHandler) // drop the connection, the client sees an unexpected EOF
+}
+
+//newDownloadTest serves a repository with ex/a 1.0.0, and returns a repository with that remote, and the served package
+func newDownloadTest(t *testing.T) (r *LocalRepository, s *downloadServer, p *Package) {
+	srv := newTestRepository(t)
+	p = installTestTool(t, srv, "ex/a", "1.0.0")
+	mux := http.NewServeMux()
+	protocol.HandleMux("/", &HttpServer{Local: *srv}, mux)
+	s = &downloadServer{handler: mux}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	remote, _ := NewHttpClient("srv", *u, nil)
+	r = newTestRepository(t)
+	r.remotes = append(r.remotes, remote)
+	return
+}
+
+func TestDownload(t *testing.T) {
+	r, s, p := newDownloadTest(t)
+	progress := &recordedProgress{}
+	r.SetProgress(progress)
+	d, err := r.download(p.ID(), nil, "")
+	if err != nil {
+		t.Fatalf("Cannot download %s: %v", p.ID(), err)
+	}
+	if d.Digest() != p.Digest() || !FileExists(executable(d)) || d.remote != "srv" {
+		t.Errorf("The downloaded package is not the served one")
+	}
+	if len(s.ranges) != 1 || s.ranges[0] != "" {
+		t.Errorf("Unexpected fetches %q", s.ranges)
+	}
+	if len(progress.starts) != 1 || progress.starts[0] <= 0 || progress.received != progress.starts[0] {
+		t.Errorf("Started %v, received %d", progress.starts, progress.received)
+	}
+	if len(progress.done) != 1 || progress.done[0] != nil {
+		t.Errorf("Done %v", progress.done)
+	}
+	if FileExists(r.partialPath(p.ID())) {
+		t.Errorf("The partial download has not been removed")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	r, s, p := newDownloadTest(t)
+	s.cut, s.cuts = 100, 2
+	progress := &recordedProgress{}
+	r.SetProgress(progress)
+	d, err := r.download(p.ID(), nil, "")
+	if err != nil {
+		t.Fatalf("Cannot download %s: %v", p.ID(), err)
+	}
+	if d.Digest() != p.Digest() {
+		t.Errorf("The resumed package is not the served one")
+	}
+	expected := []string{"", "bytes=100-", "bytes=200-"}
+	if len(s.ranges) != len(expected) {
+		t.Fatalf("Fetched %q, expected %q", s.ranges, expected)
+	}
+	for i, e := range expected {
+		if s.ranges[i] != e {
+			t.Errorf("Fetched %q, expected %q", s.ranges, expected)
+		}
+	}
+	if len(progress.starts) != 3 || progress.received != progress.starts[0] {
+		t.Errorf("Started %v, received %d", progress.starts, progress.received)
+	}
+}
+
+func TestDownloadGivesUp(t *testing.T) {
+	r, s, p := newDownloadTest(t)
+	s.cut, s.cuts = 50, maxResumes+1
+	progress := &recordedProgress{}
+	r.SetProgress(progress)
+	if _, err := r.download(p.ID(), nil, ""); err == nil {
+		t.Fatalf("A download interrupted %d times has been installed", maxResumes+1)
+	}
+	if len(s.ranges) != maxResumes+1 || len(progress.done) != 1 || progress.done[0] == nil {
+		t.Errorf("Fetched %q, done %v", s.ranges, progress.done)
+	}
+	// it is resumed the next time
+	offset, tag := partialState(r.partialPath(p.ID()))
+	if offset != 50*(maxResumes+1) || tag == "" {
+		t.Fatalf("The partial download is not kept: %d bytes, tag %q", offset, tag)
+	}
+	if _, err := r.download(p.ID(), nil, ""); err != nil {
+		t.Fatalf("Cannot resume the download: %v", err)
+	}
+	if last := s.ranges[len(s.ranges)-1]; last != fmt.Sprintf("bytes=%d-", offset) {
+		t.Errorf("The download has been resumed with %q", last)
+	}
+}
+
+func TestDownloadChangedArchive(t *testing.T) {
+	r, s, p := newDownloadTest(t)
+	part := r.partialPath(p.ID())
+	os.MkdirAll(filepath.Dir(part), os.ModeDir|os.ModePerm)
+	ioutil.WriteFile(part, []byte("the beginning of another archive"), 0644)
+	ioutil.WriteFile(part+".tag", []byte(`"another"`), 0644)
+	d, err := r.download(p.ID(), nil, "")
+	if err != nil {
+		t.Fatalf("Cannot download %s: %v", p.ID(), err)
+	}
+	if d.Digest() != p.Digest() {
+		t.Errorf("The downloaded package is not the served one")
+	}
+	// the Range is asked for, but the whole archive is sent, because the If-Range tag does not match
+	if len(s.ranges) != 1 || s.ranges[0] != "bytes=32-" {
+		t.Errorf("Unexpected fetches %q", s.ranges)
+	}
+}
+
+//lyingClient answers every fetch with the package answer
+type lyingClient struct {
+	protocol.Client
+	answer ProjectID
+}
+
+func (c *lyingClient) Fetch(pid protocol.PID) (io.ReadCloser, error) {
+	pid.Name, pid.Version = c.answer.Name(), c.answer.Version()
+	return c.Client.Fetch(pid)
+}
+
+func TestDownloadAnotherVersion(t *testing.T) {
+	key := testKeys(t)[0]
+	publicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+	r := newTestRepository(t)
+	r.TrustStore().SetStrict(true)
+	r.TrustStore().Trust("ex", publicKey, "")
+	served := addFileRemote(t, r, "liar")
+	old := installTestPackage(t, served, "ex/a", "1.0.0")
+	for _, p := range []*Package{old, installTestPackage(t, served, "ex/a", "1.2.0")} {
+		if _, err := p.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.remotes[0] = &lyingClient{r.remotes[0], old.ID()}
+
+	if _, err := r.ResolveDependencies(newTestProject(t, "ex/p", "ex/a ^1.2"), false, false); err == nil {
+		t.Errorf("ex/a 1.0.0 has been resolved for ex/a ^1.2")
+	}
+	v, _ := ParseVersion("1.2.0")
+	if _, err := r.download(*NewProjectID("ex/a", v), nil, ""); err != protocol.StatusIdentityMismatch {
+		t.Errorf("Downloading ex/a 1.2.0: %v, expected %v", err, protocol.StatusIdentityMismatch)
+	}
+	if versions := r.Versions("ex/a"); len(versions) != 0 {
+		t.Errorf("%v have been installed", versions)
+	}
+}
@@ -2,6 +2,8 @@ package gopack
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
 // use some escape character for vterm to pretty print text in a console
@@ -34,16 +36,19 @@ var (
 	NormalStyle  = PFormat{TERM_NULL, COLOR_DEFAULT, COLOR_DEFAULT}
 )
 
+//Output is where the styles print, the standard output unless it is reserved to machine-readable documents
+var Output io.Writer = os.Stdout
+
 type PFormat struct {
 	Attr, Foreground, Background int
 }
 
 func (f *PFormat) Printf(message string, v ...interface{}) {
-	fmt.Print(f.Sprintf(message, v...))
+	fmt.Fprint(Output, f.Sprintf(message, v...))
 }
 
 func (f *PFormat) Clear() {
-	fmt.Printf("\033[1;1H\033[2J")
+	fmt.Fprintf(Output, "\033[1;1H\033[2J")
 }
 
 func (f *PFormat) Sprintf(message string, v ...interface{}) string {
@@ -53,7 +58,6 @@ func (f *PFormat) Sprintf(message string, v ...interface{}) string {
 	} else { 
 		return fmt.Sprintf("\033[%d;%d;%dm%s\033[0m", f.Attr, f.Foreground+30, f.Background+40, fmt.Sprintf(message, v...))
 	}
-	panic("unreachable code")
 }
 
 func (f *PFormat) PrintTriple(small, medium, large string) {
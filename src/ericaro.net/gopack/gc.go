@@ -0,0 +1,346 @@
+package gopack
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//ProjectsFile lists, in the repository root, the working directories of the projects that have used it.
+	// The garbage collection keeps the packages they depend on.
+	ProjectsFile        = ".gpkprojects"
+	ProjectsFileVersion = "1.0.0"
+	//StaleAge is the age of the leftover staging directories and partial downloads that the garbage collection removes
+	StaleAge = 24 * time.Hour
+)
+
+//projectsFile is the content of the ProjectsFile
+type projectsFile struct {
+	FormatVersion string
+	Projects      []string // working directories
+}
+
+//RegisterProject records the project working directory in the ProjectsFile, if it is not there yet.
+func (r *LocalRepository) RegisterProject(p *Project) (err error) {
+	unlock, err := r.lockRepository() // concurrent gpk processes register their projects too
+	if err != nil {
+		return
+	}
+	defer unlock()
+	var pf projectsFile
+	path := filepath.Join(r.root, ProjectsFile)
+	JsonReadFile(path, &pf) // a missing file has no projects
+	for _, dir := range pf.Projects {
+		if dir == p.workingDir {
+			return
+		}
+	}
+	pf.FormatVersion = ProjectsFileVersion
+	pf.Projects = append(pf.Projects, p.workingDir)
+	return JsonWriteFile(path, &pf)
+}
+
+//Projects reads the projects registered in the ProjectsFile. The ones that no longer exist are forgotten.
+func (r *LocalRepository) Projects() (projects []*Project, err error) {
+	var pf projectsFile
+	path := filepath.Join(r.root, ProjectsFile)
+	if !FileExists(path) {
+		return
+	}
+	unlock, err := r.lockRepository() // see RegisterProject
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if err = JsonReadFile(path, &pf); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid %s file: %v", path, err))
+	}
+	kept := make([]string, 0, len(pf.Projects))
+	for _, dir := range pf.Projects {
+		gpk := filepath.Join(dir, GpkFile)
+		if !FileExists(gpk) {
+			log.Printf("Forgetting project %s, it no longer exists", dir)
+			continue
+		}
+		p := &Project{}
+		if err = JsonReadFile(gpk, p); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid project %s: %v", gpk, err))
+		}
+		p.workingDir = dir
+		projects = append(projects, p)
+		kept = append(kept, dir)
+	}
+	if len(kept) < len(pf.Projects) {
+		pf.Projects = kept
+		err = JsonWriteFile(path, &pf)
+	}
+	return
+}
+
+//References are the packages used by the registered projects
+type References struct {
+	paths map[string]bool // see ProjectID.Path, with forward slashes
+	names map[string]bool // of the registered projects: every version of them is kept
+}
+
+//Has returns true if the package is used by a registered project, or is a version of one.
+func (f *References) Has(p *Package) bool {
+	return f.names[p.Name()] || f.Uses(p.ID())
+}
+
+//Uses returns true if the package id is used by a registered project: pinned by its lock file, or one of its dependencies.
+func (f *References) Uses(id ProjectID) bool {
+	return f.paths[filepath.ToSlash(id.Path())]
+}
+
+//References computes the packages used by the registered projects: the ones in their lock file, and their
+// dependencies of every scope, resolved offline. It fails if the dependencies of a project cannot be resolved,
+// as some of them would be unknown.
+func (r *LocalRepository) References() (refs *References, err error) {
+	projects, err := r.Projects()
+	if err != nil {
+		return
+	}
+	r.collecting = true // resolving is not using
+	defer func() { r.collecting = false }()
+	refs = &References{make(map[string]bool), make(map[string]bool)}
+	for _, p := range projects {
+		refs.names[p.name] = true
+		lock, err := ReadLock(p)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid lock file of %s: %v", p.workingDir, err))
+		}
+		if lock != nil {
+			for _, lp := range lock.Packages() {
+				refs.paths[filepath.ToSlash(lp.ID.Path())] = true
+			}
+		}
+		dependencies, err := r.ResolveDependencies(p, true, false)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot resolve the dependencies of %s (%s):\n    \u21b3 %v", p.name, p.workingDir, err))
+		}
+		for _, d := range dependencies {
+			refs.paths[filepath.ToSlash(d.Path())] = true
+		}
+	}
+	return
+}
+
+//PackageUsage is the disk space used by an installed package
+type PackageUsage struct {
+	Package  *Package
+	Size     int64     // in bytes
+	Accessed time.Time // see LocalRepository.Accessed
+}
+
+//DiskUsage lists the packages installed in the repository, with their size, the least recently accessed first.
+func (r *LocalRepository) DiskUsage() (usage []PackageUsage, err error) {
+	PackageWalker(r.root, "", func(dir string) bool {
+		p, err := ReadPackageFile(filepath.Join(dir, GpkFile))
+		if err != nil {
+			log.Printf("Skipping invalid package %s: %v", dir, err)
+			return true
+		}
+		size, err := dirSize(dir)
+		if err != nil {
+			log.Printf("Cannot read the size of %s: %v", dir, err)
+		}
+		usage = append(usage, PackageUsage{p, size, r.Accessed(p)})
+		return true
+	})
+	sort.SliceStable(usage, func(i, j int) bool { return usage[i].Accessed.Before(usage[j].Accessed) })
+	return
+}
+
+//Collectable selects the packages to remove from usage, as listed by DiskUsage: the ones refs does not have, unless
+// it is nil, and then the least recently accessed ones, until the others fit in budget bytes (0 means no limit).
+// Only the packages downloaded from a remote are evicted for the budget: the others could not be downloaded again.
+// Leased packages are never selected.
+func Collectable(usage []PackageUsage, refs *References, leased map[string]bool, budget int64) (selected []PackageUsage) {
+	total := int64(0)
+	for _, u := range usage {
+		total += u.Size
+	}
+	removed := make(map[int]bool)
+	for i, u := range usage {
+		if refs != nil && !refs.Has(u.Package) && !leased[filepath.ToSlash(u.Package.Path())] {
+			selected = append(selected, u)
+			removed[i] = true
+			total -= u.Size
+		}
+	}
+	for i, u := range usage {
+		if budget <= 0 || total <= budget {
+			break
+		}
+		if removed[i] || u.Package.Remote() == "" || leased[filepath.ToSlash(u.Package.Path())] {
+			continue
+		}
+		selected = append(selected, u)
+		total -= u.Size
+	}
+	return
+}
+
+//RemovePackage removes an installed package, its cached archives and its access time.
+// It fails with ErrLeased if a running gpk process uses it. The repository is locked meanwhile, so that no process
+// can lease it in between, see LocalRepository.leaseInstalled.
+func (r *LocalRepository) RemovePackage(id ProjectID) (err error) {
+	unlock, err := r.lockRepository()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	leased, err := r.Leased() // read again right before removing it
+	if err != nil {
+		return
+	}
+	if leased[filepath.ToSlash(id.Path())] {
+		return ErrLeased
+	}
+	dir := filepath.Join(r.root, id.Path())
+	if err = os.RemoveAll(dir); err != nil {
+		return
+	}
+	os.RemoveAll(filepath.Join(r.root, ArchiveDir, id.Path()))
+	os.Remove(filepath.Join(r.root, AccessDir, id.Path()))
+	r.removeEmptyParents(dir)
+	return
+}
+
+//removeEmptyParents removes the parents of dir while they are empty, up to the repository root
+func (r *LocalRepository) removeEmptyParents(dir string) {
+	for dir = filepath.Dir(dir); strings.HasPrefix(dir, r.root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil { // not empty
+			return
+		}
+	}
+}
+
+//CleanCaches removes what the packages no longer need: the archives cached for packages that have been removed
+// or replaced, the access times of removed packages, and the staging directories and partial downloads older than
+// StaleAge. It returns the space freed, or that would be freed if dryRun is set.
+func (r *LocalRepository) CleanCaches(dryRun bool) (freed int64, err error) {
+	stale := func(path string, size int64) {
+		log.Printf("Removing %s", path)
+		freed += size
+		if !dryRun {
+			os.RemoveAll(path)
+		}
+	}
+	old := time.Now().Add(-StaleAge)
+	// the cached archives are ARCHIVEDIR/NAME/VERSION/TAG.tar.gz
+	archives := filepath.Join(r.root, ArchiveDir)
+	filepath.Walk(archives, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(archives, filepath.Dir(path))
+		p, perr := ReadPackageFile(filepath.Join(r.root, rel, GpkFile))
+		switch {
+		case strings.HasSuffix(path, ".tar.gz") && (perr != nil || filepath.Base(path) != ArchiveTag(p)+".tar.gz"):
+			stale(path, fi.Size())
+		case !strings.HasSuffix(path, ".tar.gz") && fi.ModTime().Before(old): // an interrupted packing
+			stale(path, fi.Size())
+		}
+		return nil
+	})
+	access := filepath.Join(r.root, AccessDir)
+	filepath.Walk(access, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(access, path)
+		if !FileExists(filepath.Join(r.root, rel, GpkFile)) {
+			stale(path, fi.Size())
+		}
+		return nil
+	})
+	// staging directories of interrupted installs, and partial downloads
+	staging := filepath.Join(r.root, StagingDir)
+	downloads := filepath.Join(staging, "downloads")
+	filepath.Walk(staging, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == staging || path == downloads {
+			return nil
+		}
+		if fi.IsDir() && filepath.Dir(path) == staging { // an install
+			if fi.ModTime().Before(old) {
+				size, _ := dirSize(path)
+				stale(path, size)
+			}
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() && fi.ModTime().Before(old) {
+			stale(path, fi.Size())
+		}
+		return nil
+	})
+	if !dryRun {
+		for _, dir := range []string{archives, access, downloads} {
+			removeEmptyDirs(dir)
+		}
+	}
+	return
+}
+
+//removeEmptyDirs removes the empty directories under root, and root itself if it is then empty
+func removeEmptyDirs(root string) {
+	dirs := make([]string, 0)
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- { // children first
+		os.Remove(dirs[i]) // fails if it is not empty
+	}
+}
+
+//dirSize is the total size of the files in dir
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return
+}
+
+//SetDiskBudget sets the disk space the packages may use, in bytes, see Collectable. 0 means no limit.
+func (r *LocalRepository) SetDiskBudget(budget int64) {
+	r.budget = budget
+}
+
+//DiskBudget returns the disk space the packages may use, in bytes, 0 if there is no limit.
+func (r *LocalRepository) DiskBudget() int64 {
+	return r.budget
+}
+
+//ParseSize reads a number of bytes, optionally followed by a K, M, G or T binary unit: 500M, 2G, 1.5G.
+func ParseSize(s string) (size int64, err error) {
+	n := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I") // 2GiB, 2GB
+	unit := float64(1)
+	if l := len(n); l > 0 {
+		if i := strings.IndexByte("KMGT", n[l-1]); i >= 0 {
+			unit = float64(int64(1) << (10 * uint(i+1)))
+			n = n[:l-1]
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid size %q, expecting a number of bytes, like 500M or 2G", s))
+	}
+	return int64(f * unit), nil
+}
@@ -0,0 +1,140 @@
+package gopack
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		expected int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"500M", 500 << 20},
+		{"2G", 2 << 30},
+		{"2GiB", 2 << 30},
+		{"2gb", 2 << 30},
+		{"1.5K", 1536},
+		{" 1T ", 1 << 40},
+	} {
+		if size, err := ParseSize(c.s); err != nil || size != c.expected {
+			t.Errorf("ParseSize(%q) = %d, expected %d: %v", c.s, size, c.expected, err)
+		}
+	}
+	for _, s := range []string{"", "M", "-1G", "2X", "two"} {
+		if size, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) = %d, expected an error", s, size)
+		}
+	}
+}
+
+func TestCollectable(t *testing.T) {
+	r := newTestRepository(t)
+	// the least recently accessed first, of 100 bytes each
+	var usage []PackageUsage
+	for i, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0"} {
+		p := installTestPackage(t, r, "ex/a", v)
+		if i != 1 {
+			p.remote = "central" // it can be downloaded again
+		}
+		usage = append(usage, PackageUsage{Package: p, Size: 100})
+	}
+	paths := func(versions ...int) map[string]bool {
+		m := make(map[string]bool)
+		for _, i := range versions {
+			m[filepath.ToSlash(usage[i].Package.Path())] = true
+		}
+		return m
+	}
+	for _, c := range []struct {
+		refs     *References
+		leased   map[string]bool
+		budget   int64
+		expected []int
+	}{
+		{nil, nil, 0, nil},
+		{&References{paths(2, 3), nil}, nil, 0, []int{0, 1}},
+		{&References{paths(2, 3), nil}, paths(0), 0, []int{1}},
+		{&References{nil, map[string]bool{"ex/a": true}}, nil, 0, nil},
+		{nil, nil, 250, []int{0, 2}},      // 1 has been installed locally
+		{nil, paths(0), 150, []int{2, 3}}, // still 200 bytes
+		{&References{paths(3), nil}, nil, 50, []int{0, 1, 2, 3}},
+	} {
+		var selected []string
+		for _, u := range Collectable(usage, c.refs, c.leased, c.budget) {
+			selected = append(selected, u.Package.Version().String())
+		}
+		var expected []string
+		for _, i := range c.expected {
+			expected = append(expected, usage[i].Package.Version().String())
+		}
+		if fmt.Sprint(selected) != fmt.Sprint(expected) {
+			t.Errorf("Collectable(%v, %v, %d) = %v, expected %v", c.refs, c.leased, c.budget, selected, expected)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	r := newTestRepository(t)
+	a1 := installTestPackage(t, r, "ex/a", "1.0.0")
+	a2 := installTestPackage(t, r, "ex/a", "1.1.0")
+	b := installTestPackage(t, r, "ex/b", "1.0.0", "ex/a ^1.0")
+	unused := installTestPackage(t, r, "ex/c", "1.0.0")
+	p := newTestProject(t, "ex/p", "ex/b ^1.0")
+	if err := p.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterProject(p); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := NewLock(p, []*Package{a1, b}) // pinned before ex/a 1.1.0 was installed
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Write(); err != nil {
+		t.Fatal(err)
+	}
+	ownVersion := installTestPackage(t, r, "ex/p", "0.1.0")
+
+	refs, err := r.References()
+	if err != nil {
+		t.Fatalf("Cannot compute the references: %v", err)
+	}
+	for _, used := range []*Package{a1, a2, b, ownVersion} {
+		if !refs.Has(used) {
+			t.Errorf("%s is not referenced", used.ID())
+		}
+	}
+	if refs.Has(unused) {
+		t.Errorf("%s is referenced", unused.ID())
+	}
+	if refs.Uses(ownVersion.ID()) {
+		t.Errorf("%s is used, it is only a version of a registered project", ownVersion.ID())
+	}
+}
+
+func TestRegisterProjectConcurrently(t *testing.T) {
+	r := newTestRepository(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		p := newTestProject(t, fmt.Sprintf("ex/p%d", i))
+		if err := p.Write(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.RegisterProject(p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if projects, err := r.Projects(); err != nil || len(projects) != 10 {
+		t.Errorf("%d projects are registered, expected 10: %v", len(projects), err)
+	}
+}
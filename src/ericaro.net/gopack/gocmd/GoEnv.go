@@ -54,7 +54,6 @@ func Join(path string, elements ...string) string {
 	} else {
 		return path + string(os.PathListSeparator) + strings.Join(elements, string(os.PathListSeparator))
 	}
-	panic("unreachable statement")
 
 }
 
@@ -0,0 +1,209 @@
+package gopack
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+//Graph is the resolved dependency graph of a project: the dependencies declared by the project, and by every package,
+// point to the single package selected for their name.
+type Graph struct {
+	root         *Project
+	packages     map[string]*Package // selected, by name
+	dependencies []*Package          // selected, in the resolution order
+	tools        map[string]bool     // the packages that are only tools, by name: their dependencies are not resolved
+}
+
+//Edge is a declared dependency, from a package, or the root project, to the package selected for it.
+type Edge struct {
+	From       string     // the package name
+	Dependency Dependency // as declared
+	Package    *Package   // selected, nil if the dependency has not been resolved
+}
+
+//Conflict returns true if the selected package does not satisfy the declared constraint
+func (e Edge) Conflict() bool {
+	return e.Package != nil && !e.Dependency.constraint.Match(e.Package.Version())
+}
+
+//NewGraph creates the graph of p, from the packages resolved for it (see ResolveDependencies).
+func NewGraph(p *Project, dependencies []*Package) *Graph {
+	g := &Graph{root: p, packages: make(map[string]*Package), dependencies: dependencies, tools: make(map[string]bool)}
+	for _, d := range dependencies {
+		g.packages[d.Name()] = d
+	}
+	// the tools the project does not compile against
+	required := make(map[string]bool)
+	var walk func(dependencies []Dependency)
+	walk = func(dependencies []Dependency) {
+		for _, d := range dependencies {
+			if pkg, ok := g.packages[d.name]; ok && !required[d.name] {
+				required[d.name] = true
+				walk(FilterScope(pkg.Dependencies(), CompileScope))
+			}
+		}
+	}
+	walk(FilterScope(p.dependencies, CompileScope, TestScope))
+	for _, d := range FilterScope(p.dependencies, ToolScope) {
+		g.tools[d.name] = !required[d.name]
+	}
+	return g
+}
+
+//Root returns the project this graph has been resolved for
+func (g *Graph) Root() *Project {
+	return g.root
+}
+
+//Package returns the package selected for name, or nil
+func (g *Graph) Package(name string) *Package {
+	return g.packages[name]
+}
+
+//Edges returns the dependencies declared by name: the root project or a package, in their declaration order.
+// Only the CompileScope dependencies of a package are part of the graph, they are the only transitive ones. A package that
+// is only a tool has none.
+func (g *Graph) Edges(name string) (edges []Edge) {
+	var dependencies []Dependency
+	if name == g.root.name {
+		dependencies = g.root.dependencies
+	} else if p, ok := g.packages[name]; ok && !g.tools[name] {
+		dependencies = FilterScope(p.Dependencies(), CompileScope)
+	}
+	for _, d := range dependencies {
+		edges = append(edges, Edge{name, d, g.packages[d.name]})
+	}
+	return
+}
+
+//Scope returns the packages required by the root project dependencies in one of the scopes, in the resolution order.
+func (g *Graph) Scope(scopes ...Scope) (packages []*Package) {
+	required := make(map[string]bool)
+	var walk func(edges []Edge)
+	walk = func(edges []Edge) {
+		for _, e := range edges {
+			if !required[e.Dependency.name] && e.Package != nil {
+				required[e.Dependency.name] = true
+				walk(g.Edges(e.Dependency.name))
+			}
+		}
+	}
+	for _, e := range g.Edges(g.root.name) {
+		if len(FilterScope([]Dependency{e.Dependency}, scopes...)) > 0 {
+			walk([]Edge{e})
+		}
+	}
+	for _, p := range g.dependencies {
+		if required[p.Name()] {
+			packages = append(packages, p)
+		}
+	}
+	return
+}
+
+//Walk visits the graph depth first, from the root project. Each package is only expanded the first time it is met:
+// then visit is called with duplicate set, and its dependencies are skipped. This is also what stops the cycles.
+// last is set for the last dependency declared by e.From.
+func (g *Graph) Walk(visit func(depth int, e Edge, last, duplicate bool)) {
+	expanded := map[string]bool{g.root.name: true}
+	var walk func(name string, depth int)
+	walk = func(name string, depth int) {
+		edges := g.Edges(name)
+		for i, e := range edges {
+			duplicate := expanded[e.Dependency.name]
+			visit(depth, e, i == len(edges)-1, duplicate)
+			if !duplicate && e.Package != nil {
+				expanded[e.Dependency.name] = true
+				walk(e.Dependency.name, depth+1)
+			}
+		}
+	}
+	walk(g.root.name, 0)
+}
+
+//Paths returns every path from the root project to the package name, as the edges followed.
+// Paths going through a cycle are only followed once around it.
+func (g *Graph) Paths(name string) (paths [][]Edge) {
+	onPath := map[string]bool{g.root.name: true}
+	var path []Edge
+	var walk func(from string)
+	walk = func(from string) {
+		for _, e := range g.Edges(from) {
+			if onPath[e.Dependency.name] {
+				continue
+			}
+			path = append(path, e)
+			if e.Dependency.name == name {
+				paths = append(paths, append([]Edge(nil), path...))
+			} else if e.Package != nil {
+				onPath[e.Dependency.name] = true
+				walk(e.Dependency.name)
+				delete(onPath, e.Dependency.name)
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	walk(g.root.name)
+	return
+}
+
+//Cycles returns the dependencies that close a cycle, each one is reported once, with the path from the root project.
+func (g *Graph) Cycles() (cycles []*CycleError) {
+	onPath := make(map[string]bool)
+	done := make(map[string]bool)
+	path := make([]string, 0)
+	var walk func(name string)
+	walk = func(name string) {
+		onPath[name] = true
+		path = append(path, g.label(name))
+		for _, e := range g.Edges(name) {
+			next := e.Dependency.name
+			switch {
+			case onPath[next]:
+				cycle := append(append([]string(nil), path...), e.Dependency.String())
+				cycles = append(cycles, &CycleError{Name: next, Path: cycle, Self: next == name})
+			case !done[next] && e.Package != nil:
+				walk(next)
+			}
+		}
+		path = path[:len(path)-1]
+		onPath[name] = false
+		done[name] = true
+	}
+	walk(g.root.name)
+	return
+}
+
+//label is the graphviz label of a node
+func (g *Graph) label(name string) string {
+	if p, ok := g.packages[name]; ok {
+		return p.ID().String()
+	}
+	return name
+}
+
+//WriteDot writes edges as a graphviz digraph, labelled with the declared constraints. The conflicting edges are red.
+// The nodes in highlight are filled.
+func (g *Graph) WriteDot(w io.Writer, edges []Edge, highlight ...string) (err error) {
+	quote := func(s string) string { return `"` + strings.Replace(s, `"`, `\"`, -1) + `"` }
+	lines := []string{"digraph dependencies {", "    node [shape=box];", fmt.Sprintf("    %s [style=bold];", quote(g.label(g.root.name)))}
+	for _, h := range highlight {
+		lines = append(lines, fmt.Sprintf("    %s [style=filled];", quote(g.label(h))))
+	}
+	seen := make(map[string]bool)
+	for _, e := range edges {
+		line := fmt.Sprintf("    %s -> %s [label=%s", quote(g.label(e.From)), quote(g.label(e.Dependency.name)), quote(e.Dependency.constraint.String()))
+		if e.Conflict() {
+			line += ", color=red, fontcolor=red"
+		}
+		line += "];"
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, "}")
+	_, err = fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return
+}
@@ -0,0 +1,119 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//diamondGraph resolves ex/p, that requires ex/b and ex/c, that both require ex/d, and the tool ex/t
+func diamondGraph(t *testing.T) *Graph {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/d", "1.0.0")
+	installTestPackage(t, r, "ex/d", "1.1.0")
+	installTestPackage(t, r, "ex/b", "1.0.0", "ex/d ^1.0")
+	installTestPackage(t, r, "ex/c", "1.0.0", "ex/d 1.1.0")
+	installTestPackage(t, r, "ex/t", "1.0.0")
+	p := newTestProject(t, "ex/p", "ex/b ^1.0", "ex/c ^1.0")
+	c, _ := ParseConstraint("^1.0")
+	p.AppendDependency(*NewScopedDependency("ex/t", c, ToolScope))
+	dependencies, err := r.ResolveDependencies(p, true, false)
+	if err != nil {
+		t.Fatalf("Cannot resolve: %v", err)
+	}
+	return NewGraph(p, dependencies)
+}
+
+func TestWalkDiamond(t *testing.T) {
+	g := diamondGraph(t)
+	var visits []string
+	g.Walk(func(depth int, e Edge, last, duplicate bool) {
+		visits = append(visits, fmt.Sprintf("%d %s -> %s last=%v duplicate=%v", depth, e.From, e.Package.ID(), last, duplicate))
+	})
+	expected := []string{
+		"0 ex/p -> ex/b 1.0.0 last=false duplicate=false",
+		"1 ex/b -> ex/d 1.1.0 last=true duplicate=false",
+		"0 ex/p -> ex/c 1.0.0 last=false duplicate=false",
+		"1 ex/c -> ex/d 1.1.0 last=true duplicate=true",
+		"0 ex/p -> ex/t 1.0.0 last=true duplicate=false",
+	}
+	if strings.Join(visits, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Walked:\n%s\nexpected:\n%s", strings.Join(visits, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestPathsDiamond(t *testing.T) {
+	g := diamondGraph(t)
+	var paths []string
+	for _, path := range g.Paths("ex/d") {
+		hops := []string{}
+		for _, e := range path {
+			hops = append(hops, e.Dependency.String())
+		}
+		paths = append(paths, strings.Join(hops, " -> "))
+	}
+	if strings.Join(paths, "; ") != "ex/b ^1.0 -> ex/d ^1.0; ex/c ^1.0 -> ex/d 1.1.0" {
+		t.Errorf("Unexpected paths to ex/d: %q", paths)
+	}
+	if paths := g.Paths("ex/unknown"); len(paths) != 0 {
+		t.Errorf("Unexpected paths to an unknown package: %v", paths)
+	}
+}
+
+func TestGraphScope(t *testing.T) {
+	g := diamondGraph(t)
+	cases := []struct {
+		scopes   []Scope
+		expected string
+	}{
+		{[]Scope{CompileScope}, "ex/b 1.0.0, ex/c 1.0.0, ex/d 1.1.0"},
+		{[]Scope{ToolScope}, "ex/t 1.0.0"},
+		{[]Scope{TestScope}, ""},
+	}
+	for _, c := range cases {
+		var ids []string
+		for _, p := range g.Scope(c.scopes...) {
+			ids = append(ids, p.ID().String())
+		}
+		if strings.Join(ids, ", ") != c.expected {
+			t.Errorf("Scope %v is %v, expected %s", c.scopes, ids, c.expected)
+		}
+	}
+}
+
+func TestEdgeConflict(t *testing.T) {
+	r := newTestRepository(t)
+	d := installTestPackage(t, r, "ex/d", "1.0.0")
+	b := installTestPackage(t, r, "ex/b", "1.0.0", "ex/d ^2.0")
+	g := NewGraph(newTestProject(t, "ex/p", "ex/b ^1.0", "ex/d ^1.0"), []*Package{b, d})
+	for _, e := range g.Edges("ex/p") {
+		if e.Conflict() {
+			t.Errorf("%s -> %s is not a conflict", e.From, e.Dependency.String())
+		}
+	}
+	if edges := g.Edges("ex/b"); len(edges) != 1 || !edges[0].Conflict() || edges[0].Package != d {
+		t.Errorf("ex/b -> ex/d ^2.0 is a conflict: %v", edges)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	r := newTestRepository(t)
+	a := installTestPackage(t, r, "ex/a", "1.0.0", "ex/p ^1.0")
+	b := installTestPackage(t, r, "ex/b", "1.0.0", "ex/b ^1.0", "ex/a ^1.0")
+	g := NewGraph(newTestProject(t, "ex/p", "ex/a ^1.0", "ex/b ^1.0"), []*Package{a, b})
+	var cycles []string
+	for _, c := range g.Cycles() {
+		cycles = append(cycles, fmt.Sprintf("%s self=%v: %s", c.Name, c.Self, strings.Join(c.Path, " -> ")))
+	}
+	expected := []string{
+		"ex/p self=false: ex/p -> ex/a 1.0.0 -> ex/p ^1.0",
+		"ex/b self=true: ex/p -> ex/b 1.0.0 -> ex/b ^1.0",
+	}
+	if strings.Join(cycles, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Cycles:\n%s\nexpected:\n%s", strings.Join(cycles, "\n"), strings.Join(expected, "\n"))
+	}
+	if cycles := diamondGraph(t).Cycles(); len(cycles) != 0 {
+		t.Errorf("A diamond is not a cycle: %v", cycles)
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -148,6 +153,9 @@ func PackageWalker(srcpath, startwith string, handler func(gpkpath string) bool)
 	}
 
 	for _, fi := range subdir {
+		if fi.IsDir() && strings.HasPrefix(fi.Name(), ".") { // repository internals, like the StagingDir
+			continue
+		}
 		if fi.IsDir() && strings.HasPrefix(fi.Name(), startwith) {
 			c, err = PackageWalker(filepath.Join(srcpath, fi.Name()), "", handler)
 			if !c {
@@ -166,6 +174,12 @@ func PackageWalker(srcpath, startwith string, handler func(gpkpath string) bool)
 
 //Untar reads the .gopackage file within the tar in memory. It does not set the Root
 func Unpack(dst string, in io.Reader) (err error) {
+	return unpack(dst, in, nil)
+}
+
+//unpack the tar.gzed stream into dst. If sums is not nil, it is filled with the sha256 of every file but the .gpk one and
+// the signature, by relative path (see DigestDir)
+func unpack(dst string, in io.Reader, sums map[string]string) (err error) {
 	gz, err := gzip.NewReader(in)
 	if err != nil {
 		return
@@ -184,17 +198,32 @@ func Unpack(dst string, in io.Reader) (err error) {
 			return err
 		}
 		// make the target file
-		ndst := filepath.Join(dst, hdr.Name)
+		name := path.Clean(filepath.ToSlash(hdr.Name))
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return errors.New(fmt.Sprintf("Invalid package format, %s is outside the package", hdr.Name))
+		}
+		ndst := filepath.Join(dst, filepath.FromSlash(name))
 		os.MkdirAll(filepath.Dir(ndst), os.ModeDir|os.ModePerm) // mkdir -p
 		//fmt.Printf("%s\n", ndst)
 		df, err := os.Create(ndst)
 		if err != nil {
-			break
+			return err
+		}
+		var w io.Writer = df
+		h := sha256.New()
+		hashed := sums != nil && digested(name)
+		if hashed {
+			w = io.MultiWriter(df, h)
 		}
-		io.Copy(df, tr)
+		_, err = io.Copy(w, tr)
 		df.Close()
+		if err != nil {
+			return err
+		}
+		if hashed {
+			sums[name] = hex.EncodeToString(h.Sum(nil))
+		}
 	}
-	return
 }
 
 //TarFile tar src file into a dst file in the tar writer 
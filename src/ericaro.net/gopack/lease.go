@@ -0,0 +1,170 @@
+package gopack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	//AccessDir is the directory, in the repository root, that records when each package has last been found:
+	// the modification time of AccessDir/NAME/VERSION.
+	AccessDir = ".gpkaccess"
+	//LeaseDir is the directory, in the repository root, where every running gpk process lists the packages it uses,
+	// in a PID.lease file. They are never collected, see LocalRepository.Leased.
+	LeaseDir = ".gpkleases"
+)
+
+//ErrLeased is returned when removing a package that a running gpk process is using
+var ErrLeased = errors.New("The package is used by a running gpk process")
+
+//leases are the packages this process has leased, see LocalRepository.lease
+type leases struct {
+	mutex sync.Mutex      // dependencies are fetched concurrently
+	paths map[string]bool // already written in the lease file
+}
+
+//touch records that the package p has just been found, unless the repository is being collected.
+func (r *LocalRepository) touch(p *Package) {
+	if r.collecting {
+		return
+	}
+	path := filepath.Join(r.root, AccessDir, p.Path())
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil || !os.IsNotExist(err) {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	if f, err := os.Create(path); err == nil {
+		f.Close()
+	}
+}
+
+//Accessed returns when the package p has last been found, or installed if it has never been.
+func (r *LocalRepository) Accessed(p *Package) time.Time {
+	if fi, err := os.Stat(filepath.Join(r.root, AccessDir, p.Path())); err == nil {
+		return fi.ModTime()
+	}
+	if fi, err := os.Stat(filepath.Join(p.InstallDir(), GpkFile)); err == nil {
+		return fi.ModTime()
+	}
+	return p.Timestamp()
+}
+
+//leasePath is the lease file of this process
+func (r *LocalRepository) leasePath() string {
+	return filepath.Join(r.root, LeaseDir, fmt.Sprintf("%d.lease", os.Getpid()))
+}
+
+//lease protects the package p from the garbage collection, until the process ends or ReleaseLeases is called.
+// Nothing is leased while the repository is being collected.
+func (r *LocalRepository) lease(p *Package) (err error) {
+	if r.collecting || r.leases == nil {
+		return
+	}
+	r.leases.mutex.Lock()
+	defer r.leases.mutex.Unlock()
+	if r.leases.paths == nil {
+		r.leases.paths = make(map[string]bool)
+	}
+	path := filepath.ToSlash(p.Path())
+	if r.leases.paths[path] {
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(r.root, LeaseDir), os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	f, err := os.OpenFile(r.leasePath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.WriteString(path + "\n"); err == nil {
+		r.leases.paths[path] = true
+	}
+	return
+}
+
+//leaseInstalled leases the package p, found in this repository, under the repository lock that LocalRepository.RemovePackage
+// takes too: it fails if p has been removed meanwhile, otherwise it cannot be removed anymore.
+func (r *LocalRepository) leaseInstalled(p *Package) (err error) {
+	if r.collecting || r.leases == nil {
+		return
+	}
+	unlock, err := r.lockRepository()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if !FileExists(filepath.Join(p.InstallDir(), GpkFile)) {
+		return errors.New(fmt.Sprintf("Package %s has been removed meanwhile", p.ID()))
+	}
+	return r.lease(p)
+}
+
+//ReleaseLeases releases every package leased by this process
+func (r *LocalRepository) ReleaseLeases() {
+	if r.leases == nil {
+		return
+	}
+	r.leases.mutex.Lock()
+	defer r.leases.mutex.Unlock()
+	if r.leases.paths != nil {
+		os.Remove(r.leasePath())
+		r.leases.paths = nil
+	}
+}
+
+//Leased returns the paths (see ProjectID.Path, with forward slashes) of the packages leased by the running gpk processes.
+// The lease files of the processes that are gone are removed.
+func (r *LocalRepository) Leased() (paths map[string]bool, err error) {
+	paths = make(map[string]bool)
+	files, err := filepath.Glob(filepath.Join(r.root, LeaseDir, "*.lease"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		pid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".lease"))
+		if err != nil {
+			continue
+		}
+		if !processAlive(pid) {
+			log.Printf("Removing the lease of process %d, it is gone", pid)
+			os.Remove(file)
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			continue // released meanwhile
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			paths[scanner.Text()] = true
+		}
+		f.Close()
+	}
+	return
+}
+
+//processAlive returns true unless the process is known to be gone
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !(errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH))
+}
@@ -0,0 +1,49 @@
+package gopack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLeased(t *testing.T) {
+	r := newTestRepository(t)
+	a := installTestPackage(t, r, "ex/a", "1.0.0")
+	b := installTestPackage(t, r, "ex/b", "1.0.0")
+	if err := r.leaseInstalled(a); err != nil {
+		t.Fatal(err)
+	}
+	// a process that is gone
+	gone := filepath.Join(r.root, LeaseDir, fmt.Sprintf("%d.lease", deadPid))
+	ioutil.WriteFile(gone, []byte(filepath.ToSlash(b.Path())+"\n"), 0644)
+
+	leased, err := r.Leased()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leased[filepath.ToSlash(a.Path())] || leased[filepath.ToSlash(b.Path())] {
+		t.Errorf("The leased packages are %v, expected %s", leased, a.ID())
+	}
+	if FileExists(gone) {
+		t.Errorf("The lease of a process that is gone is kept")
+	}
+	if err = r.RemovePackage(a.ID()); err != ErrLeased {
+		t.Errorf("A leased package has been removed: %v", err)
+	}
+	if err = r.RemovePackage(b.ID()); err != nil || FileExists(b.InstallDir()) {
+		t.Errorf("Cannot remove a package that is not leased: %v", err)
+	}
+	if err = r.leaseInstalled(b); err == nil {
+		t.Errorf("A removed package has been leased")
+	}
+
+	r.ReleaseLeases()
+	if leased, _ = r.Leased(); len(leased) != 0 {
+		t.Errorf("The leases have not been released: %v", leased)
+	}
+	if _, err = os.Stat(r.leasePath()); !os.IsNotExist(err) {
+		t.Errorf("The lease file is left: %v", err)
+	}
+}
@@ -26,7 +26,7 @@ func (r *LocalRepository) MissingImports(p *Project, offline bool) (missing []st
 	if err != nil {
 		return
 	}
-	gopath, err := r.GoPath(dependencies)
+	gopath, err := r.GoPath(NewGraph(p, dependencies).Scope(CompileScope, TestScope)) // the sources include the tests
 	if err != nil {
 		return
 	}
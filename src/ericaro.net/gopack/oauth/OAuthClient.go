@@ -1,12 +1,14 @@
 package oauth
 
 import (
-	"bytes"
+	"encoding/json"
 	"ericaro.net/gopack/protocol"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -15,17 +17,21 @@ func init() {
 	protocol.RegisterClient("oauth", NewOAuthClient) // Register oauth client
 }
 
+//NewOAuthClient creates a client for the remote "oauth:<url>". Every request to <url> is signed with the OAuth token.
 func NewOAuthClient(name string, u url.URL, token *protocol.Token) (c protocol.Client, err error) {
 
 	// Remove 'oauth' prefix:
 	urlString := u.String()
-	urlString = strings.TrimLeft(urlString, "oauth:")
+	urlString = strings.TrimPrefix(urlString, "oauth:")
 	newUrl, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
 	}
 
 	// Initialize OAuth token:
+	if token == nil {
+		return nil, errors.New(fmt.Sprintf("Remote %s requires an OAuth token", name))
+	}
 	oToken := &OAuthToken{}
 	err = oToken.UnmarshalJSON(*token)
 	if err != nil {
@@ -34,75 +40,75 @@ func NewOAuthClient(name string, u url.URL, token *protocol.Token) (c protocol.C
 
 	c = &OAuthClient{
 		name:       name,
+		remote:     u,
 		url:        *newUrl,
 		oauthToken: oToken,
+		consumer:   NewConsumer(oToken.consumerKey, "", ServiceProvider{}, &RSA_SHA1_Signer{Private: oToken.privateKey}),
 	}
 
 	return c, nil
 }
 
+//OAuthClient is a client for a remote protected by OAuth 1.0: every request is signed with the access token and the RSA private key.
 type OAuthClient struct {
-	url        url.URL
+	remote     url.URL // the "oauth:" URL, it is the one persisted
+	url        url.URL // the URL the requests are sent to
 	name       string
 	oauthToken *OAuthToken
+	consumer   *Consumer
 }
 
 func (c *OAuthClient) Fetch(pid protocol.PID) (r io.ReadCloser, err error) {
-	v := &url.Values{}
-	pid.InParameter(v)
+	v := url.Values{}
+	pid.Token = nil // the OAuth token is used to sign the request, it must never be sent
+	pid.InParameter(&v)
 
-	u := &url.URL{
-		Path:     protocol.FETCH,
-		RawQuery: v.Encode(),
-	}
-	remote := c.Path()
-	resp, err := doOAuthGET(remote.ResolveReference(u))
+	resp, err := c.doOAuthGET(protocol.FETCH, v)
 	if err != nil {
 		return
 	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New(resp.Status)
-	}
-	return resp.Body, nil
+	return &protocol.Archive{
+		ReadCloser: resp.Body,
+		Digest:     resp.Header.Get(protocol.DigestHeader),
+		Signature:  resp.Header.Get(protocol.SignatureHeader),
+		Size:       resp.ContentLength,
+	}, nil
 }
 
 func (c *OAuthClient) Push(pid protocol.PID, r io.Reader) (err error) {
-	v := &url.Values{}
-	pid.InParameter(v)
-	//query url
-	u := &url.URL{
-		Path:     protocol.PUSH,
-		RawQuery: v.Encode(),
-	}
+	return c.post(protocol.PUSH, pid, r)
+}
+
+func (c *OAuthClient) PushExecutables(pid protocol.PID, r io.Reader) (err error) {
+	return c.post(protocol.PUSH_EXEC, pid, r)
+}
 
-	buf := new(bytes.Buffer)
-	io.Copy(buf, r)
+//post streams r to the operation path, for the package pid
+func (c *OAuthClient) post(path string, pid protocol.PID, r io.Reader) (err error) {
+	v := url.Values{}
+	pid.Token = nil // the OAuth token is used to sign the request, it must never be sent
+	pid.InParameter(&v)
 
-	var client http.Client
-	remote := c.Path()
-	req, err := http.NewRequest("POST", remote.ResolveReference(u).String(), buf)
+	resp, err := c.doOAuthPOST(path, v, r)
 	if err != nil {
 		return
 	}
-	req.ContentLength = int64(buf.Len()) // fuck I can't do that, I need to compute the length first
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New(resp.Status)
-	}
-	return
-}
-
-func (c *OAuthClient) PushExecutables(pid protocol.PID, r io.Reader) (err error) {
-	//[DL] TODO
+	resp.Body.Close()
 	return
 }
 
 func (c *OAuthClient) Search(query string, start int) (result []protocol.PID) {
-	//[DL] TODO
-	return
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("start", strconv.Itoa(start))
+
+	resp, err := c.doOAuthGET(protocol.SEARCH, v)
+	if err != nil {
+		return result
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	return result
 }
 
 func (c *OAuthClient) Name() string {
@@ -110,7 +116,7 @@ func (c *OAuthClient) Name() string {
 }
 
 func (c *OAuthClient) Path() url.URL {
-	return c.url
+	return c.remote
 }
 
 func (c *OAuthClient) Token() *protocol.Token {
@@ -119,12 +125,24 @@ func (c *OAuthClient) Token() *protocol.Token {
 	return &gpkToken
 }
 
-func doOAuthGET(url *url.URL) (resp *http.Response, err error) {
-	//[DL] TODO
-	return
+//doOAuthGET sends a signed GET request to the operation path, with the query v.
+// The response body must be closed, unless there is an error: responses that are not 2xx are returned as a *StatusError.
+func (c *OAuthClient) doOAuthGET(path string, v url.Values) (resp *http.Response, err error) {
+	return c.do("GET", path, v, nil)
 }
 
-func doOAuthPOST(url url.URL, buf *bytes.Buffer) (resp *http.Response, err error) {
-	//[DL] TODO
-	return
+//doOAuthPOST sends a signed POST request to the operation path, with the query v, and streams the body.
+// The body itself is not signed, only the query is.
+func (c *OAuthClient) doOAuthPOST(path string, v url.Values, body io.Reader) (resp *http.Response, err error) {
+	return c.do("POST", path, v, body)
+}
+
+func (c *OAuthClient) do(method, path string, v url.Values, body io.Reader) (resp *http.Response, err error) {
+	params := make(map[string]string, len(v))
+	for key := range v {
+		params[key] = v.Get(key) // protocol parameters are never repeated
+	}
+	u := c.url.ResolveReference(&url.URL{Path: path})
+	token := &AccessToken{Token: c.oauthToken.oauthToken, Secret: c.oauthToken.oauthTokenSecret}
+	return c.consumer.makeAuthorizedRequest(method, u.String(), LOC_URL, body, params, token)
 }
@@ -0,0 +1,205 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"ericaro.net/gopack/protocol"
+	"ericaro.net/gopack/semver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//provider is a minimal OAuth 1.0 service provider: it only serves requests signed with RSA-SHA1, by the consumer key and the access token.
+type provider struct {
+	t        *testing.T
+	key      *rsa.PublicKey
+	received map[string][]byte // bodies by operation
+	query    map[string]url.Values
+}
+
+//authorization parses the oauth parameters of the Authorization header
+func authorization(r *http.Request) (params map[string]string, ok bool) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "OAuth ") {
+		return nil, false
+	}
+	params = make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(h, "OAuth "), ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, false
+		}
+		v, err := url.QueryUnescape(strings.Trim(kv[i+1:], `"`))
+		if err != nil {
+			return nil, false
+		}
+		params[strings.TrimSpace(kv[:i])] = v
+	}
+	return params, true
+}
+
+//baseString computes the signature base string of r, as in RFC 5849 3.4.1
+func baseString(r *http.Request, oauth map[string]string) string {
+	var params []string
+	for k, v := range oauth {
+		if k != SIGNATURE_PARAM {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(params)
+	return r.Method + "&" + escape("http://"+r.Host+r.URL.Path) + "&" + escape(strings.Join(params, "&"))
+}
+
+func (p *provider) verify(r *http.Request) bool {
+	oauth, ok := authorization(r)
+	if !ok || oauth[SIGNATURE_METHOD_PARAM] != RSA_SHA1 || oauth[CONSUMER_KEY_PARAM] != "gpk" || oauth[TOKEN_PARAM] != "access" {
+		return false
+	}
+	if r.URL.Query().Get("k") != "" {
+		p.t.Errorf("the token has been sent in the query: %s", r.URL.RawQuery)
+	}
+	signature, err := base64.StdEncoding.DecodeString(oauth[SIGNATURE_PARAM])
+	if err != nil {
+		return false
+	}
+	h := sha1.Sum([]byte(baseString(r, oauth)))
+	return rsa.VerifyPKCS1v15(p.key, crypto.SHA1, h[:], signature) == nil
+}
+
+func (p *provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.verify(r) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	op := strings.TrimPrefix(r.URL.Path, "/repo/")
+	p.query[op] = r.URL.Query()
+	switch op {
+	case protocol.FETCH:
+		w.Header().Set(protocol.DigestHeader, "digest")
+		w.Write([]byte("archive"))
+	case protocol.PUSH, protocol.PUSH_EXEC:
+		p.received[op], _ = ioutil.ReadAll(r.Body)
+	case protocol.SEARCH:
+		v, _ := semver.ParseVersion("1.0.0")
+		json.NewEncoder(w).Encode([]protocol.PID{{Name: "a/b", Version: v}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//newTestClient starts a provider, and returns a client for it, signing with key
+func newTestClient(t *testing.T, key *rsa.PrivateKey, public *rsa.PublicKey) (*provider, protocol.Client, func()) {
+	p := &provider{t: t, key: public, received: make(map[string][]byte), query: make(map[string]url.Values)}
+	server := httptest.NewServer(p)
+	token, err := (&OAuthToken{privateKey: key, consumerKey: "gpk", oauthToken: "access", oauthTokenSecret: "secret"}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("oauth:" + server.URL + "/repo/")
+	tk := protocol.Token(token)
+	c, err := NewOAuthClient("test", *u, &tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, c, server.Close
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestOAuthClientURL(t *testing.T) {
+	key := generateKey(t)
+	_, c, stop := newTestClient(t, key, &key.PublicKey)
+	defer stop()
+	u := c.Path()
+	if u.String() != "oauth:"+c.(*OAuthClient).url.String() || c.(*OAuthClient).url.Path != "/repo/" {
+		t.Fatalf("wrong remote url %s", u.String())
+	}
+	none, _ := url.Parse("oauth:http://localhost/")
+	if _, err := NewOAuthClient("test", *none, nil); err == nil {
+		t.Fatalf("a client without token has been created")
+	}
+}
+
+func TestOAuthClientOperations(t *testing.T) {
+	key := generateKey(t)
+	p, c, stop := newTestClient(t, key, &key.PublicKey)
+	defer stop()
+	v, _ := semver.ParseVersion("1.0.0")
+	tk := c.Token()
+	pid := protocol.PID{Name: "a/b", Version: v, Token: tk}
+
+	r, err := c.Fetch(pid)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(content) != "archive" || r.(*protocol.Archive).Digest != "digest" {
+		t.Fatalf("wrong archive %q", content)
+	}
+	if p.query[protocol.FETCH].Get("n") != "a/b" {
+		t.Fatalf("wrong fetch query %v", p.query[protocol.FETCH])
+	}
+
+	if err = c.Push(pid, bytes.NewBufferString("sources")); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if string(p.received[protocol.PUSH]) != "sources" {
+		t.Fatalf("wrong pushed content %q", p.received[protocol.PUSH])
+	}
+
+	if err = c.PushExecutables(pid, bytes.NewBufferString("executables")); err != nil {
+		t.Fatalf("push executables failed: %v", err)
+	}
+	if string(p.received[protocol.PUSH_EXEC]) != "executables" {
+		t.Fatalf("wrong pushed executables %q", p.received[protocol.PUSH_EXEC])
+	}
+
+	result := c.Search("a b", 10)
+	if len(result) != 1 || result[0].Name != "a/b" {
+		t.Fatalf("wrong search result %v", result)
+	}
+	if q := p.query[protocol.SEARCH]; q.Get("q") != "a b" || q.Get("start") != "10" {
+		t.Fatalf("wrong search query %v", q)
+	}
+}
+
+func TestOAuthClientRejected(t *testing.T) {
+	key, other := generateKey(t), generateKey(t)
+	_, c, stop := newTestClient(t, key, &other.PublicKey)
+	defer stop()
+	v, _ := semver.ParseVersion("1.0.0")
+	pid := protocol.PID{Name: "a/b", Version: v}
+
+	_, err := c.Fetch(pid)
+	if e, ok := err.(*StatusError); !ok || e.Code != http.StatusUnauthorized {
+		t.Fatalf("fetch should be unauthorized: %v", err)
+	}
+	if err = c.Push(pid, bytes.NewBufferString("sources")); err == nil {
+		t.Fatalf("push should be unauthorized")
+	}
+	if result := c.Search("a", 0); len(result) != 0 {
+		t.Fatalf("search should be unauthorized: %v", result)
+	}
+}
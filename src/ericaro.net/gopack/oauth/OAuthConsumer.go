@@ -40,6 +40,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -47,6 +48,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -170,7 +172,7 @@ func NewConsumer(consumerKey string, consumerSecret string,
 		serviceProvider: serviceProvider,
 		clock:           clock,
 		HttpClient:      &http.Client{},
-		nonceGenerator:  rand.New(rand.NewSource(clock.Seconds())),
+		nonceGenerator:  &lockedNonceGenerator{rand: rand.New(rand.NewSource(time.Now().UnixNano()))},
 		signer:          signer,
 
 		AdditionalParams:                 make(map[string]string),
@@ -285,7 +287,7 @@ func (c *Consumer) AuthorizeToken(rtoken *RequestToken, verificationCode string)
 // - err:
 //   Set only if there was an error, nil otherwise.
 func (c *Consumer) Get(url string, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
-	return c.makeAuthorizedRequest("GET", url, LOC_URL, "", userParams, token)
+	return c.makeAuthorizedRequest("GET", url, LOC_URL, nil, userParams, token)
 }
 
 func encodeUserParams(userParams map[string]string) string {
@@ -302,15 +304,15 @@ func (c *Consumer) PostForm(url string, userParams map[string]string, token *Acc
 }
 
 func (c *Consumer) Post(url string, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
-	return c.makeAuthorizedRequest("POST", url, LOC_BODY, "", userParams, token)
+	return c.makeAuthorizedRequest("POST", url, LOC_BODY, nil, userParams, token)
 }
 
 func (c *Consumer) Delete(url string, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
-	return c.makeAuthorizedRequest("DELETE", url, LOC_URL, "", userParams, token)
+	return c.makeAuthorizedRequest("DELETE", url, LOC_URL, nil, userParams, token)
 }
 
 func (c *Consumer) Put(url string, body string, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
-	return c.makeAuthorizedRequest("PUT", url, LOC_URL, body, userParams, token)
+	return c.makeAuthorizedRequest("PUT", url, LOC_URL, strings.NewReader(body), userParams, token)
 }
 
 func (c *Consumer) Debug(enabled bool) {
@@ -328,7 +330,13 @@ func (p pairs) Len() int           { return len(p) }
 func (p pairs) Less(i, j int) bool { return p[i].key < p[j].key }
 func (p pairs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
-func (c *Consumer) makeAuthorizedRequest(method string, url string, dataLocation DataLocation, body string, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
+// Executes an HTTP request authorized via the AccessToken.
+// - dataLocation:
+//   LOC_URL to send userParams in the query string, LOC_BODY to send them form encoded in the body.
+//
+// - body:
+//   The request body, with LOC_URL only, or nil. It is streamed, and it is not part of the signature.
+func (c *Consumer) makeAuthorizedRequest(method string, url string, dataLocation DataLocation, body io.Reader, userParams map[string]string, token *AccessToken) (resp *http.Response, err error) {
 	allParams := c.baseParams(c.consumerKey, c.AdditionalParams)
 	allParams.Add(TOKEN_PARAM, token.Token)
 	authParams := allParams.Clone()
@@ -343,6 +351,7 @@ func (c *Consumer) makeAuthorizedRequest(method string, url string, dataLocation
 	sort.Sort(paramPairs)
 
 	queryParams := ""
+	form := ""
 	separator := "?"
 	if dataLocation == LOC_BODY {
 		separator = ""
@@ -355,7 +364,7 @@ func (c *Consumer) makeAuthorizedRequest(method string, url string, dataLocation
 			if dataLocation == LOC_URL {
 				queryParams += separator + thisPair
 			} else {
-				body += separator + thisPair
+				form += separator + thisPair
 			}
 			separator = "&"
 		}
@@ -369,10 +378,25 @@ func (c *Consumer) makeAuthorizedRequest(method string, url string, dataLocation
 	contentType := ""
 	if dataLocation == LOC_BODY {
 		contentType = "application/x-www-form-urlencoded"
+		body = strings.NewReader(form)
 	}
 	return c.httpExecute(method, url+queryParams, contentType, body, authParams)
 }
 
+//StatusError is returned when the HTTP response is not 2xx.
+type StatusError struct {
+	Status string
+	Code   int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Body
+}
+
 type request struct {
 	method      string
 	url         string
@@ -392,6 +416,18 @@ type nonceGenerator interface {
 	Int63() int64
 }
 
+// A nonceGenerator safe for concurrent use: a Consumer signs concurrent requests.
+type lockedNonceGenerator struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func (g *lockedNonceGenerator) Int63() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.rand.Int63()
+}
+
 type Signer interface {
 	Sign(message, key string) string
 	MethodName() string
@@ -413,10 +449,6 @@ func newGetRequest(url string, oauthParams *OrderedParams) *request {
 
 func (c *Consumer) signRequest(req *request, key string) *request {
 	base_string := c.requestString(req.method, req.url, req.oauthParams)
-
-	//TODO remove me
-	fmt.Printf("BASE STRING = {%s}\n", base_string)
-
 	req.oauthParams.Add(SIGNATURE_PARAM, c.signer.Sign(base_string, key))
 	return req
 }
@@ -531,7 +563,7 @@ func (c *Consumer) requestString(method string, url string, params *OrderedParam
 }
 
 func (c *Consumer) getBody(url string, oauthParams *OrderedParams) (*string, error) {
-	resp, err := c.httpExecute("GET", url, "", "", oauthParams)
+	resp, err := c.httpExecute("GET", url, "", nil, oauthParams)
 	if err != nil {
 		return nil, errors.New("httpExecute: " + err.Error())
 	}
@@ -549,9 +581,9 @@ func (c *Consumer) getBody(url string, oauthParams *OrderedParams) (*string, err
 }
 
 func (c *Consumer) httpExecute(
-	method string, urlStr string, contentType string, body string, oauthParams *OrderedParams) (*http.Response, error) {
+	method string, urlStr string, contentType string, body io.Reader, oauthParams *OrderedParams) (*http.Response, error) {
 	// Create base request.
-	req, err := http.NewRequest(method, urlStr, strings.NewReader(body))
+	req, err := http.NewRequest(method, urlStr, body)
 	if err != nil {
 		return nil, errors.New("NewRequest failed: " + err.Error())
 	}
@@ -560,15 +592,13 @@ func (c *Consumer) httpExecute(
 	req.Header = http.Header{}
 
 	// Add Host header field according to the doc 3.4.1: 
-	fmt.Printf("urlStr = {%s}\n", urlStr)
-	fmt.Printf("Host = {%s}\n", req.URL.Host)
 	req.Header.Add("Host", req.URL.Host)
 
 	oauthHdr := "OAuth "
-	for _, key := range oauthParams.Keys() {
-		// if pos > 0 {
-		oauthHdr += ","
-		// }
+	for pos, key := range oauthParams.Keys() {
+		if pos > 0 {
+			oauthHdr += ","
+		}
 		oauthHdr += key + "=\"" + oauthParams.Get(key) + "\""
 	}
 	req.Header.Add("Authorization", oauthHdr)
@@ -578,8 +608,6 @@ func (c *Consumer) httpExecute(
 		req.Header.Set("Content-Type", contentType)
 	}
 
-	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
-
 	if c.debug {
 		fmt.Printf("Request: %v", req)
 	}
@@ -588,22 +616,12 @@ func (c *Consumer) httpExecute(
 		return nil, errors.New("Do: " + err.Error())
 	}
 
-	debugHeader := ""
-	for k, vals := range req.Header {
-		for _, val := range vals {
-			debugHeader += "[key: " + k + ", val: " + val + "]"
-		}
-	}
-
-	if resp.StatusCode != http.StatusOK {
+	// the request headers are not part of the error, they contain the PLAINTEXT signature, that is the secrets
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bytes, _ := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
 
-		return nil, errors.New("HTTP response is not 200/OK as expected. Actual response: \n" +
-			"\tResponse Status: '" + resp.Status + "'\n" +
-			"\tResponse Code: " + strconv.Itoa(resp.StatusCode) + "\n" +
-			"\tResponse Body: " + string(bytes) + "\n" +
-			"\tRequst Headers: " + debugHeader)
+		return nil, &StatusError{resp.Status, resp.StatusCode, strings.TrimSpace(string(bytes))}
 	}
 	return resp, err
 }
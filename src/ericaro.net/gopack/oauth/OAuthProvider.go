@@ -0,0 +1,713 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The OAuth 1.0 service provider state, in the served repository: its consumers, and the access tokens it granted.
+const (
+	ProviderFile        = ".gpkoauth"
+	ProviderFileVersion = "1.0.0"
+)
+
+// Requests whose timestamp is further than MaxClockSkew from the server clock are rejected.
+// Nonces are remembered that long, so a request cannot be replayed.
+const MaxClockSkew = 5 * time.Minute
+
+// How long temporary credentials can be authorized, and exchanged for an access token.
+const temporaryTTL = 10 * time.Minute
+
+// A consumer registered by the provider. RSA-SHA1 signatures are verified with its PublicKey,
+// HMAC-SHA1 and PLAINTEXT ones with its Secret.
+type ConsumerEntry struct {
+	Key       string
+	Secret    string // empty if the consumer only signs with RSA-SHA1
+	PublicKey string // PEM encoded, empty if the consumer does not sign with RSA-SHA1
+}
+
+// An access token granted by the provider. The token itself is random, it is only valid when signed by its consumer:
+// it stands for the access control token of the user who allowed the connection, and has its rights, as long as it is not revoked.
+// Like in the access control file, only the hashes of the tokens are kept.
+type grantedToken struct {
+	Hash     string
+	Secret   string
+	Consumer string
+	Owner    string // the user who allowed the connection
+	Via      string // the hash of the access control token of the user
+}
+
+// Temporary credentials, until they are exchanged for an access token.
+type temporaryCredentials struct {
+	secret   string
+	consumer string
+	callback string
+	verifier string // set once a user has allowed the connection
+	owner    string // the user who allowed it
+	via      string // the hash of the access control token of the user
+	created  time.Time
+}
+
+// Provider is the OAuth 1.0 service provider side of 'gpk serve': it grants access tokens to the registered consumers,
+// and checks the signature of every protocol request (see RFC 5849).
+// The rights of an access token are the ones of the access control token of the user who allowed the connection.
+type Provider struct {
+	ACL       *gopack.AccessControl
+	path      string
+	modTime   time.Time // of the file when it was read, or written
+	consumers []ConsumerEntry
+	tokens    []grantedToken
+	mutex     sync.Mutex
+	temporary map[string]*temporaryCredentials // by token
+	nonces    map[string]time.Time             // when they can be forgotten
+}
+
+// Creates an empty provider, that will be saved in path. Users allow connections with their token in acl.
+func NewProvider(path string, acl *gopack.AccessControl) *Provider {
+	return &Provider{
+		ACL:       acl,
+		path:      path,
+		temporary: make(map[string]*temporaryCredentials),
+		nonces:    make(map[string]time.Time),
+	}
+}
+
+// Reads the provider file path.
+func ReadProvider(path string, acl *gopack.AccessControl) (p *Provider, err error) {
+	p = NewProvider(path, acl)
+	err = p.read()
+	return
+}
+
+func (p *Provider) read() (err error) {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return
+	}
+	if err = gopack.JsonReadFile(p.path, p); err != nil {
+		return
+	}
+	p.modTime = fi.ModTime()
+	return
+}
+
+// Reads the file again if it has been modified since, so that the consumers added, or removed, by 'gpk consumer'
+// are taken into account by a running server, and are not lost when it writes the file. The mutex must be held.
+func (p *Provider) refresh() {
+	fi, err := os.Stat(p.path)
+	if err != nil || fi.ModTime().Equal(p.modTime) {
+		return
+	}
+	log.Printf("Reloading OAuth provider %s", p.path)
+	fresh := &Provider{path: p.path}
+	if err := fresh.read(); err != nil {
+		log.Printf("Cannot reload %s, keeping the previous one: %v", p.path, err)
+		return
+	}
+	p.modTime, p.consumers, p.tokens = fresh.modTime, fresh.consumers, fresh.tokens
+}
+
+// Writes down the provider file. It contains secrets, only its owner can read it.
+func (p *Provider) Write() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.write()
+}
+
+func (p *Provider) write() (err error) {
+	if err = gopack.JsonWriteFile(p.path, p); err != nil {
+		return
+	}
+	if err = os.Chmod(p.path, 0600); err != nil {
+		return
+	}
+	if fi, err := os.Stat(p.path); err == nil {
+		p.modTime = fi.ModTime()
+	}
+	return
+}
+
+// The provider file path.
+func (p *Provider) Path() string {
+	return p.path
+}
+
+// Lists the registered consumers.
+func (p *Provider) Consumers() []ConsumerEntry {
+	return p.consumers[:]
+}
+
+// Registers the consumer, or replaces the one with the same key. It must have a secret, or a public key.
+func (p *Provider) AddConsumer(c ConsumerEntry) (err error) {
+	if c.Key == "" {
+		return errors.New("Missing consumer key")
+	}
+	if c.Secret == "" && c.PublicKey == "" {
+		return errors.New(fmt.Sprintf("Consumer %s needs a secret, or a public key", c.Key))
+	}
+	if c.PublicKey != "" {
+		if _, err = ParsePublicKey([]byte(c.PublicKey)); err != nil {
+			return
+		}
+	}
+	p.RemoveConsumer(c.Key)
+	p.consumers = append(p.consumers, c)
+	return
+}
+
+// Removes the consumer key, and the access tokens granted to it. It returns false if there is no such consumer.
+func (p *Provider) RemoveConsumer(key string) (removed bool) {
+	consumers := make([]ConsumerEntry, 0, len(p.consumers))
+	for _, c := range p.consumers {
+		if c.Key == key {
+			removed = true
+		} else {
+			consumers = append(consumers, c)
+		}
+	}
+	tokens := make([]grantedToken, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		if t.Consumer != key {
+			tokens = append(tokens, t)
+		}
+	}
+	p.consumers, p.tokens = consumers, tokens
+	return
+}
+
+func (p *Provider) consumer(key string) (c ConsumerEntry, ok bool) {
+	for _, c := range p.consumers {
+		if c.Key == key {
+			return c, true
+		}
+	}
+	return
+}
+
+// Reads an RSA public key in PEM format: either a public key (PKIX or PKCS #1), or a private key (PKCS #1 or PKCS #8).
+// It returns it PEM encoded as a PKIX public key.
+func ParsePublicKey(data []byte) (publicKey string, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("Wrong public key format")
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		var private *rsa.PrivateKey
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			key = &private.PublicKey
+		}
+	case "PRIVATE KEY":
+		var private interface{}
+		if private, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if rsaKey, ok := private.(*rsa.PrivateKey); ok {
+				key = &rsaKey.PublicKey
+			}
+		}
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported key type %s", block.Type))
+	}
+	if err != nil {
+		return
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return "", errors.New("Not an RSA key")
+	}
+	der, err := x509.MarshalPKIXPublicKey(rsaKey)
+	if err != nil {
+		return
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// A provider error, sent as the response status.
+type providerError struct {
+	code    int
+	message string
+}
+
+func (e *providerError) Error() string { return e.message }
+
+func badRequest(format string, args ...interface{}) error {
+	return &providerError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func unauthorized(format string, args ...interface{}) error {
+	return &providerError{http.StatusUnauthorized, fmt.Sprintf(format, args...)}
+}
+
+// Sends the error as the response.
+func fail(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	if e, ok := err.(*providerError); ok {
+		code = e.code
+	}
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `OAuth realm="gpk"`)
+	}
+	log.Printf("OAUTH %s %s: %v", r.Method, r.URL.Path, err)
+	http.Error(w, err.Error(), code)
+}
+
+// Parses the oauth parameters of the Authorization header.
+func authorizationParams(r *http.Request) (params map[string]string, err error) {
+	params = make(map[string]string)
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return
+	}
+	if !strings.HasPrefix(h, "OAuth ") {
+		return nil, badRequest("Unsupported authorization scheme")
+	}
+	for _, kv := range strings.Split(strings.TrimPrefix(h, "OAuth "), ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, badRequest("Malformed authorization header")
+		}
+		v, err := url.QueryUnescape(strings.Trim(kv[i+1:], `"`))
+		if err != nil {
+			return nil, badRequest("Malformed authorization header")
+		}
+		if kv[:i] != "realm" { // not part of the signature
+			params[kv[:i]] = v
+		}
+	}
+	return
+}
+
+// Verifies the signature of the request r: by a registered consumer, with a fresh timestamp and nonce.
+// If the request is made on behalf of a token, tokenSecret returns its secret, or false if the consumer cannot use it.
+// It returns every request parameter.
+func (p *Provider) verify(r *http.Request, tokenSecret func(token, consumer string) (string, bool)) (params map[string]string, err error) {
+	if params, err = authorizationParams(r); err != nil {
+		return
+	}
+	if params[SIGNATURE_PARAM] == "" {
+		return nil, unauthorized("Missing OAuth signature")
+	}
+	signed := NewOrderedParams()
+	for k, v := range params {
+		if k != SIGNATURE_PARAM {
+			signed.Add(k, v)
+		}
+	}
+	add := func(values url.Values) error {
+		for k, vs := range values {
+			if _, repeated := params[k]; repeated || len(vs) > 1 {
+				return badRequest("Repeated parameter %s", k)
+			}
+			params[k] = vs[0]
+			signed.Add(k, vs[0])
+		}
+		return nil
+	}
+	if err = add(r.URL.Query()); err != nil {
+		return
+	}
+	if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t == "application/x-www-form-urlencoded" {
+		if err = r.ParseForm(); err != nil {
+			return nil, badRequest("Malformed body")
+		}
+		if err = add(r.PostForm); err != nil {
+			return
+		}
+	}
+
+	if v, ok := params[VERSION_PARAM]; ok && v != OAUTH_VERSION {
+		return nil, badRequest("Unsupported OAuth version %s", v)
+	}
+	p.mutex.Lock()
+	p.refresh()
+	consumer, ok := p.consumer(params[CONSUMER_KEY_PARAM])
+	p.mutex.Unlock()
+	if !ok {
+		return nil, unauthorized("Unknown consumer %s", params[CONSUMER_KEY_PARAM])
+	}
+	timestamp, err := strconv.ParseInt(params[TIMESTAMP_PARAM], 10, 64)
+	if err != nil {
+		return nil, badRequest("Invalid timestamp")
+	}
+	at := time.Unix(timestamp, 0)
+	if skew := time.Since(at); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return nil, unauthorized("Expired timestamp")
+	}
+	if params[NONCE_PARAM] == "" {
+		return nil, badRequest("Missing nonce")
+	}
+	secret := ""
+	if token := params[TOKEN_PARAM]; token != "" {
+		if tokenSecret == nil {
+			return nil, badRequest("Unexpected token")
+		}
+		if secret, ok = tokenSecret(token, consumer.Key); !ok {
+			return nil, unauthorized("Invalid token")
+		}
+	} else if tokenSecret != nil {
+		return nil, badRequest("Missing token")
+	}
+
+	// the base string, as computed by the consumer
+	c := &Consumer{consumerSecret: consumer.Secret}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := c.requestString(r.Method, scheme+"://"+r.Host+r.URL.EscapedPath(), signed)
+	key := c.makeKey(secret)
+	signature := params[SIGNATURE_PARAM]
+	switch params[SIGNATURE_METHOD_PARAM] {
+	case HMAC_SHA1:
+		ok = consumer.Secret != "" && hmac.Equal([]byte(signature), []byte((&HMAC_SHA1_Signer{}).Sign(base, key)))
+	case PLAINTEXT:
+		ok = consumer.Secret != "" && hmac.Equal([]byte(signature), []byte((&PLAINTEXT_Signer{}).Sign(base, key)))
+	case RSA_SHA1:
+		ok = consumer.PublicKey != "" && verifyRSA(consumer.PublicKey, base, signature)
+	default:
+		return nil, badRequest("Unsupported signature method %s", params[SIGNATURE_METHOD_PARAM])
+	}
+	if !ok {
+		return nil, unauthorized("Invalid signature")
+	}
+
+	// only signed requests use nonces, so that they cannot be exhausted
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	for n, expires := range p.nonces {
+		if now.After(expires) {
+			delete(p.nonces, n)
+		}
+	}
+	nonce := strings.Join([]string{consumer.Key, params[TOKEN_PARAM], params[TIMESTAMP_PARAM], params[NONCE_PARAM]}, "&")
+	if _, used := p.nonces[nonce]; used {
+		return nil, unauthorized("Replayed nonce")
+	}
+	p.nonces[nonce] = at.Add(MaxClockSkew)
+	return params, nil
+}
+
+// Verifies an RSA-SHA1 signature, with the PEM encoded public key.
+func verifyRSA(publicKey, message, signature string) bool {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return false
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	hashed := sha1.Sum([]byte(message))
+	return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA1, hashed[:], raw) == nil
+}
+
+// A new random token, or secret.
+func randomString() (string, error) {
+	t := protocol.NewToken(24)
+	if t == nil {
+		return "", errors.New("Cannot generate a random token")
+	}
+	return t.FormatURL(), nil
+}
+
+// Registers the temporary credential, authorize, and token credential endpoints under the path prefix.
+// They are the Default*Path, relative to the remote URL, used by RequestOAuthToken.
+func (p *Provider) HandleMux(prefix string, mux *http.ServeMux) {
+	mux.HandleFunc(path.Join(prefix, DefaultRequestTokenPath), p.serveTemporaryCredentials)
+	mux.HandleFunc(path.Join(prefix, DefaultAuthorizeTokenPath), p.serveAuthorize)
+	mux.HandleFunc(path.Join(prefix, DefaultAccessTokenPath), p.serveTokenCredentials)
+}
+
+// Protect only lets the requests signed with an access token reach h. They are given a token (the "k" parameter)
+// that stands for the access control token of the user who allowed the connection (see AccessControl.Delegate),
+// so that the access control applies. The access token itself is not an access control token.
+func (p *Provider) Protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var granted grantedToken
+		_, err := p.verify(r, func(token, consumer string) (secret string, ok bool) {
+			if granted, ok = p.granted(token, consumer); ok {
+				secret = granted.Secret
+			}
+			return
+		})
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+		k, ok := p.ACL.Delegate(granted.Via)
+		if !ok { // revoked meanwhile
+			fail(w, r, unauthorized("Revoked token"))
+			return
+		}
+		q := r.URL.Query()
+		q.Set("k", k.FormatURL())
+		r.URL.RawQuery = q.Encode()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// The access token, if it has been granted to consumer, and the access control token it stands for has not been revoked.
+func (p *Provider) granted(token, consumer string) (g grantedToken, ok bool) {
+	t, err := protocol.ParseURLToken(token)
+	if err != nil {
+		return
+	}
+	hash := gopack.TokenHash(*t)
+	p.mutex.Lock()
+	p.refresh()
+	for _, g = range p.tokens {
+		if ok = g.Hash == hash && g.Consumer == consumer; ok {
+			break
+		}
+	}
+	p.mutex.Unlock()
+	if !ok {
+		return grantedToken{}, false
+	}
+	if _, ok = p.ACL.Delegate(g.Via); !ok { // revoked
+		return grantedToken{}, false
+	}
+	return
+}
+
+// The temporary credentials token, if they have not expired, and belong to consumer (if not empty).
+func (p *Provider) pending(token, consumer string) *temporaryCredentials {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for k, t := range p.temporary {
+		if time.Since(t.created) > temporaryTTL {
+			delete(p.temporary, k)
+		}
+	}
+	t, ok := p.temporary[token]
+	if !ok || (consumer != "" && t.consumer != consumer) {
+		return nil
+	}
+	return t
+}
+
+func (p *Provider) serveTemporaryCredentials(w http.ResponseWriter, r *http.Request) {
+	params, err := p.verify(r, nil)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	callback := params[CALLBACK_PARAM]
+	if callback == "" {
+		fail(w, r, badRequest("Missing %s", CALLBACK_PARAM))
+		return
+	}
+	if u, err := url.Parse(callback); callback != OutOfBand && (err != nil || !u.IsAbs()) {
+		fail(w, r, badRequest("Invalid callback %s", callback))
+		return
+	}
+	token, err := randomString()
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	secret, err := randomString()
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	p.pending("", "") // forget the expired ones
+	p.mutex.Lock()
+	p.temporary[token] = &temporaryCredentials{
+		secret:   secret,
+		consumer: params[CONSUMER_KEY_PARAM],
+		callback: callback,
+		created:  time.Now(),
+	}
+	p.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	fmt.Fprint(w, url.Values{
+		TOKEN_PARAM:                {token},
+		TOKEN_SECRET_PARAM:         {secret},
+		"oauth_callback_confirmed": {"true"},
+	}.Encode())
+}
+
+const authorizeForm = `<!DOCTYPE html>
+<html><head><title>gpk</title></head><body>
+<form method="POST">
+<p>Allow the consumer %s to access this repository with your rights.</p>
+<input type="hidden" name="oauth_token" value="%s">
+<label>Your gpk token <input type="password" name="k"></label>
+<input type="submit" value="Allow">
+</form>
+</body></html>
+`
+
+// The user allows the connection with their access control token, minted by 'gpk token', as the "k" parameter.
+// It is asked by a form, unless it is posted: it is never read from the URL, that ends up in logs and browser histories.
+func (p *Provider) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue(TOKEN_PARAM)
+	t := p.pending(token, "")
+	if t == nil {
+		fail(w, r, badRequest("Unknown, or expired, request token"))
+		return
+	}
+	k := ""
+	if r.Method == "POST" {
+		k = r.PostFormValue("k")
+	}
+	if k == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, authorizeForm, html.EscapeString(t.consumer), html.EscapeString(token))
+		return
+	}
+	acl, err := protocol.ParseStdToken(strings.TrimSpace(k))
+	if err != nil {
+		fail(w, r, badRequest("Invalid token"))
+		return
+	}
+	entry, ok := p.ACL.Lookup(acl)
+	if !ok {
+		fail(w, r, &providerError{http.StatusForbidden, "Unknown token"})
+		return
+	}
+	verifier, err := randomString()
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	p.mutex.Lock()
+	t.owner, t.via, t.verifier = entry.Name, entry.Hash, verifier
+	p.mutex.Unlock()
+	log.Printf("OAUTH %s allowed %s", entry.Name, t.consumer)
+
+	if t.callback == OutOfBand {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Verification code: %s\n", verifier)
+		return
+	}
+	u, _ := url.Parse(t.callback)
+	q := u.Query()
+	q.Set(TOKEN_PARAM, token)
+	q.Set(VERIFIER_PARAM, verifier)
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (p *Provider) serveTokenCredentials(w http.ResponseWriter, r *http.Request) {
+	var t *temporaryCredentials
+	params, err := p.verify(r, func(token, consumer string) (string, bool) {
+		t = p.pending(token, consumer)
+		if t == nil || t.verifier == "" { // not allowed yet
+			return "", false
+		}
+		return t.secret, true
+	})
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	if !hmac.Equal([]byte(params[VERIFIER_PARAM]), []byte(t.verifier)) {
+		fail(w, r, unauthorized("Invalid verifier"))
+		return
+	}
+	p.mutex.Lock()
+	_, ok := p.temporary[params[TOKEN_PARAM]]
+	delete(p.temporary, params[TOKEN_PARAM]) // they are used once
+	p.mutex.Unlock()
+	if !ok {
+		fail(w, r, unauthorized("Invalid token"))
+		return
+	}
+
+	// the access token stands for the access control token of the user, it is only valid when signed by the consumer
+	access := protocol.NewToken(24)
+	secret, err := randomString()
+	if access == nil || err != nil {
+		fail(w, r, errors.New("Cannot generate a random token"))
+		return
+	}
+	p.mutex.Lock()
+	p.refresh() // not to lose the consumers added meanwhile
+	p.tokens = append(p.tokens, grantedToken{Hash: gopack.TokenHash(*access), Secret: secret, Consumer: t.consumer, Owner: t.owner, Via: t.via})
+	err = p.write()
+	p.mutex.Unlock()
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	log.Printf("OAUTH granted an access token to %s for %s", t.consumer, t.owner)
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	fmt.Fprint(w, url.Values{
+		TOKEN_PARAM:        {access.FormatURL()},
+		TOKEN_SECRET_PARAM: {secret},
+	}.Encode())
+}
+
+// Part of the json protocol.
+func (p *Provider) UnmarshalJSON(data []byte) (err error) {
+	type ProviderFile struct {
+		FormatVersion string
+		Consumers     []ConsumerEntry
+		Tokens        []grantedToken
+	}
+	var pf ProviderFile
+	if err = json.Unmarshal(data, &pf); err != nil {
+		return
+	}
+	switch pf.FormatVersion {
+	case ProviderFileVersion:
+	default:
+		log.Printf("Warning: Unknown format version \"%s\"", pf.FormatVersion)
+	}
+	p.consumers, p.tokens = pf.Consumers, pf.Tokens
+	return
+}
+
+// Part of the json protocol.
+func (p *Provider) MarshalJSON() ([]byte, error) {
+	type ProviderFile struct {
+		FormatVersion string
+		Consumers     []ConsumerEntry
+		Tokens        []grantedToken
+	}
+	return json.Marshal(ProviderFile{
+		FormatVersion: ProviderFileVersion,
+		Consumers:     p.consumers,
+		Tokens:        p.tokens,
+	})
+}
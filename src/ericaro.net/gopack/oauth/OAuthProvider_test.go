@@ -0,0 +1,247 @@
+package oauth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//fixedClock is a clock that does not move
+type fixedClock int64
+
+func (c fixedClock) Seconds() int64 { return int64(c) }
+
+//providerServer starts a provider, protecting a handler that records the access control token of the requests.
+// It returns the user token, allowed to read and push every package.
+func providerServer(t *testing.T) (p *Provider, server *httptest.Server, user *protocol.Token, received *string) {
+	dir, err := ioutil.TempDir("", "gpkoauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl := gopack.NewAccessControl(filepath.Join(dir, gopack.AccessControlFile))
+	if user, err = acl.Mint("alice", []gopack.Grant{{Prefix: "", Right: gopack.PushRight}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = acl.Write(); err != nil {
+		t.Fatal(err)
+	}
+	p = NewProvider(filepath.Join(dir, ProviderFile), acl)
+	received = new(string)
+	mux := http.NewServeMux()
+	p.HandleMux("/", mux)
+	mux.Handle("/", p.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*received = r.URL.Query().Get("k")
+	})))
+	server = httptest.NewServer(mux)
+	return
+}
+
+//authorize runs the whole OAuth procedure out of band, for consumer, and returns the access token
+func authorize(t *testing.T, server *httptest.Server, consumer *Consumer, user *protocol.Token) *AccessToken {
+	rtoken, loginUrl, err := consumer.GetRequestTokenAndUrl(OutOfBand)
+	if err != nil {
+		t.Fatalf("no request token: %v", err)
+	}
+	resp, err := http.PostForm(loginUrl, url.Values{"k": {user.FormatStd()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("authorization failed: %s", body)
+	}
+	verifier := strings.TrimSpace(strings.TrimPrefix(string(body), "Verification code:"))
+	atoken, err := consumer.AuthorizeToken(rtoken, verifier)
+	if err != nil {
+		t.Fatalf("no access token: %v", err)
+	}
+	if _, err = consumer.AuthorizeToken(rtoken, verifier); err == nil {
+		t.Fatalf("the request token has been exchanged twice")
+	}
+	return atoken
+}
+
+func serviceProvider(server *httptest.Server) ServiceProvider {
+	return ServiceProvider{
+		RequestTokenUrl:   server.URL + "/" + DefaultRequestTokenPath,
+		AuthorizeTokenUrl: server.URL + "/" + DefaultAuthorizeTokenPath,
+		AccessTokenUrl:    server.URL + "/" + DefaultAccessTokenPath,
+	}
+}
+
+func TestProviderRSA(t *testing.T) {
+	p, server, user, received := providerServer(t)
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(p.Path()))
+	key := generateKey(t)
+	public, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.AddConsumer(ConsumerEntry{Key: "alice-gpk", PublicKey: public}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := NewConsumer("alice-gpk", "", serviceProvider(server), &RSA_SHA1_Signer{Private: key})
+	atoken := authorize(t, server, consumer, user)
+
+	// the oauth client works against the provider
+	bytes, _ := (&OAuthToken{privateKey: key, consumerKey: "alice-gpk", oauthToken: atoken.Token, oauthTokenSecret: atoken.Secret}).MarshalJSON()
+	token := protocol.Token(bytes)
+	u, _ := url.Parse("oauth:" + server.URL + "/")
+	c, err := NewOAuthClient("test", *u, &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Search("a", 0); *received == "" || *received == atoken.Token {
+		t.Fatalf("the request has not been given a token standing for the user one: %q", *received)
+	}
+	k, _ := protocol.ParseURLToken(*received)
+	entry, ok := p.ACL.Lookup(k)
+	if !ok || entry.Name != "alice" || p.ACL.RightOn(k, "ex/a") != gopack.PushRight {
+		t.Fatalf("the access token does not have the user rights: %v", entry)
+	}
+	// the access token is not a bearer token
+	access, _ := protocol.ParseURLToken(atoken.Token)
+	if _, ok = p.ACL.Lookup(access); ok || p.ACL.RightOn(access, "ex/a") != gopack.ReadRight {
+		t.Fatalf("the access token is an access control token")
+	}
+
+	// revoked in the access control
+	p.ACL.Revoke(entry.ID())
+	*received = ""
+	if c.Search("a", 0); *received != "" {
+		t.Fatalf("a revoked token has been accepted")
+	}
+
+	// the provider file is saved, and read back
+	read, err := ReadProvider(p.Path(), p.ACL)
+	if err != nil || len(read.Consumers()) != 1 || len(read.tokens) != 1 {
+		t.Fatalf("cannot read the provider file back: %v", err)
+	}
+}
+
+func TestProviderSecrets(t *testing.T) {
+	p, server, user, received := providerServer(t)
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(p.Path()))
+	if err := p.AddConsumer(ConsumerEntry{Key: "ci", Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, signer := range []Signer{&HMAC_SHA1_Signer{}, &PLAINTEXT_Signer{}} {
+		consumer := NewConsumer("ci", "s3cret", serviceProvider(server), signer)
+		atoken := authorize(t, server, consumer, user)
+		*received = ""
+		resp, err := consumer.Get(server.URL+"/search", map[string]string{"q": "a b"}, atoken)
+		if err != nil || *received == "" {
+			t.Fatalf("%s request rejected: %v", signer.MethodName(), err)
+		}
+		resp.Body.Close()
+
+		wrong := NewConsumer("ci", "wrong", serviceProvider(server), signer)
+		if _, err = wrong.Get(server.URL+"/search", nil, atoken); err == nil {
+			t.Fatalf("%s request with a wrong secret accepted", signer.MethodName())
+		}
+	}
+}
+
+func TestProviderReplay(t *testing.T) {
+	p, server, user, _ := providerServer(t)
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(p.Path()))
+	p.AddConsumer(ConsumerEntry{Key: "ci", Secret: "s3cret"})
+	consumer := NewConsumer("ci", "s3cret", serviceProvider(server), &HMAC_SHA1_Signer{})
+	atoken := authorize(t, server, consumer, user)
+
+	// the same nonce, and timestamp, is rejected
+	consumer.nonceGenerator = fixedNonce(42)
+	consumer.clock = fixedClock(consumer.clock.Seconds())
+	resp, err := consumer.Get(server.URL+"/search", nil, atoken)
+	if err != nil {
+		t.Fatalf("request rejected: %v", err)
+	}
+	resp.Body.Close()
+	if _, err = consumer.Get(server.URL+"/search", nil, atoken); err == nil {
+		t.Fatalf("replayed request accepted")
+	}
+
+	// an old timestamp is rejected
+	consumer.nonceGenerator = fixedNonce(43)
+	consumer.clock = fixedClock(consumer.clock.Seconds() - int64(2*MaxClockSkew.Seconds()))
+	if _, err = consumer.Get(server.URL+"/search", nil, atoken); err == nil {
+		t.Fatalf("expired request accepted")
+	}
+}
+
+type fixedNonce int64
+
+func (n fixedNonce) Int63() int64 { return int64(n) }
+
+func TestAuthorizeRequiresPost(t *testing.T) {
+	p, server, user, _ := providerServer(t)
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(p.Path()))
+	p.AddConsumer(ConsumerEntry{Key: "ci", Secret: "s3cret"})
+	consumer := NewConsumer("ci", "s3cret", serviceProvider(server), &HMAC_SHA1_Signer{})
+	rtoken, loginUrl, err := consumer.GetRequestTokenAndUrl(OutOfBand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(loginUrl + "&k=" + url.QueryEscape(user.FormatStd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<form") {
+		t.Fatalf("the token in the URL has been accepted: %s", body)
+	}
+	if _, err = consumer.AuthorizeToken(rtoken, "anything"); err == nil {
+		t.Fatalf("an access token has been granted without the connection being allowed")
+	}
+}
+
+func TestProviderReloadsConsumers(t *testing.T) {
+	p, server, user, received := providerServer(t)
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(p.Path()))
+	p.AddConsumer(ConsumerEntry{Key: "ci", Secret: "s3cret"})
+	if err := p.Write(); err != nil {
+		t.Fatal(err)
+	}
+	// 'gpk consumer' adds another consumer while the server runs
+	other, err := ReadProvider(p.Path(), p.ACL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // for the modification time to change
+	other.AddConsumer(ConsumerEntry{Key: "bot", Secret: "b0t"})
+	if err = other.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	bot := NewConsumer("bot", "b0t", serviceProvider(server), &HMAC_SHA1_Signer{})
+	atoken := authorize(t, server, bot, user)
+	authorize(t, server, NewConsumer("ci", "s3cret", serviceProvider(server), &HMAC_SHA1_Signer{}), user)
+	resp, err := bot.Get(server.URL+"/search", nil, atoken)
+	if err != nil || *received == "" {
+		t.Fatalf("the consumer added while the server runs is rejected: %v", err)
+	}
+	resp.Body.Close()
+
+	// granting the tokens has not lost it
+	read, err := ReadProvider(p.Path(), p.ACL)
+	if err != nil || len(read.Consumers()) != 2 || len(read.tokens) != 2 {
+		t.Fatalf("the provider file has lost the consumer added while the server runs: %v %v", read.Consumers(), err)
+	}
+}
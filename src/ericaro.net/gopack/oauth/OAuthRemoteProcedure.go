@@ -2,18 +2,40 @@ package oauth
 
 import (
 	"bufio"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"ericaro.net/gopack"
 	"ericaro.net/gopack/protocol"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/user"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 const gpkOauthConsumerKey string = "gpkOauthConsumerKey"
 
+// Default service provider endpoints, relative to the remote URL. They are the ones of 'gpk serve'.
+const (
+	DefaultRequestTokenPath   = "oauth/TemporaryCredentialRequest"
+	DefaultAuthorizeTokenPath = "oauth/Authorize"
+	DefaultAccessTokenPath    = "oauth/TokenCredentials"
+)
+
+// OutOfBand is the callback used when the verification code is pasted by the user.
+const OutOfBand = "oob"
+
+// How long the callback listener waits for the service provider.
+const callbackTimeout = 10 * time.Minute
+
 const introductionTitle string = "\n----------- OAuth 1.0 Token Procedure ----------\n"
 const introductionText string = "This wizzard will help you to configure OAuth \nauthentification for the new repository."
 
@@ -23,83 +45,219 @@ const promptAuthorizeTokenUrl string = "What is the authorize token URL"
 const promptAccessTokenUrl string = "What is the access token URL"
 
 const authorizationText string = "\nPlease browse the URL below, allow the connection\n and paste the verification code."
+const callbackText string = "\nPlease browse the URL below, and allow the connection."
 const verificationCodeText string = "Enter the verification code"
 
-func RequestOAuthToken(name, remote string) (t *protocol.Token, err error) {
+// TokenRequest configures the OAuth token procedure.
+// Empty fields are asked to the user if Interactive is set, otherwise their default value is used.
+type TokenRequest struct {
+	PemFile           string // the PEM file containing the RSA private key, ~/.ssh/id_rsa by default
+	ConsumerKey       string // the consumer key, as registered by the service provider
+	RequestTokenUrl   string // by default, relative to the remote URL: see DefaultRequestTokenPath
+	AuthorizeTokenUrl string
+	AccessTokenUrl    string
+	// Callback is either OutOfBand, to paste the verification code, or the host:port address of a local listener
+	// the service provider redirects to once the connection is allowed, "localhost:0" to pick any port.
+	Callback    string
+	Interactive bool
+	// Input is where the verification code is pasted, out of band. It must be a terminal: if it is nil,
+	// the out of band procedure fails, rather than waiting for a code that cannot be pasted.
+	Input io.Reader
+}
+
+// RequestOAuthToken runs the OAuth 1.0 procedure to get an access token for the remote, and returns it, with the private key,
+// as the token of an OAuthClient.
+func RequestOAuthToken(name, remote string, req TokenRequest) (t *protocol.Token, err error) {
+	outOfBand := req.Callback == "" || req.Callback == OutOfBand
+	if outOfBand && req.Input == nil {
+		return nil, errors.New("The verification code cannot be pasted, the input is not a terminal: use a callback listener instead, e.g. -oauth-callback localhost:0")
+	}
 
-	// Print introduction text:
-	gopack.TitleStyle.Printf(introductionTitle)
-	fmt.Println(introductionText)
-	waitForEnter()
+	if req.Interactive {
+		// Print introduction text:
+		gopack.TitleStyle.Printf(introductionTitle)
+		fmt.Println(introductionText)
+		waitForEnter()
+	}
 
 	// Ask for RSA pem file:
-	pemFile := promptUser(promptPemFile, "/home/dominique/.ssh/id_rsa")
-	if !gopack.FileExists(pemFile) {
-		err = fmt.Errorf("The file '%s' doesn't exist.", pemFile)
+	pemFile := req.ask(req.PemFile, promptPemFile, defaultPemFile())
+	privateKey, err := readPrivateKey(pemFile)
+	if err != nil {
 		return nil, err
 	}
+	signer := RSA_SHA1_Signer{Private: privateKey}
 
-	// Load private key file:
-	content, err := ioutil.ReadFile(pemFile)
+	// Ask for OAuth endpoint URLs:
+	base, err := url.Parse(remote)
 	if err != nil {
 		return nil, err
 	}
+	serviceProvider := ServiceProvider{
+		RequestTokenUrl:   req.ask(req.RequestTokenUrl, promptRequestTokenUrl, resolve(base, DefaultRequestTokenPath)),
+		AuthorizeTokenUrl: req.ask(req.AuthorizeTokenUrl, promptAuthorizeTokenUrl, resolve(base, DefaultAuthorizeTokenPath)),
+		AccessTokenUrl:    req.ask(req.AccessTokenUrl, promptAccessTokenUrl, resolve(base, DefaultAccessTokenPath)),
+	}
 
-	// Decode PEM bytes:
-	block, _ := pem.Decode(content)
-	if block == nil {
-		err = fmt.Errorf("Wrong private key format")
-		return nil, err
+	// Initialize OAuth consumer:
+	consumerKey := req.ConsumerKey
+	if consumerKey == "" {
+		consumerKey = gpkOauthConsumerKey
 	}
+	consumer := NewConsumer(consumerKey, "don't care", serviceProvider, &signer)
 
-	// Instantiate the private key with decoded bytes:
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	var atoken *AccessToken
+	if outOfBand {
+		atoken, err = authorizeOutOfBand(consumer, req.Input)
+	} else {
+		atoken, err = authorizeWithCallback(consumer, req.Callback)
+	}
 	if err != nil {
 		return nil, err
 	}
-	signer := RSA_SHA1_Signer{Private: privateKey}
-
-	// Ask for OAuth request endpoint URL:
-	// requestTokenUrl := promptUser(promptRequestTokenUrl, remote)
-	requestTokenUrl := promptUser(promptRequestTokenUrl, "http://192.168.111.136:8080/oauth/TemporaryCredentialRequest")
-
-	// Ask for OAuth authorize endpoint URL:
-	// authorizeTokenUrl := promptUser(promptAuthorizeTokenUrl, requestTokenUrl)
-	authorizeTokenUrl := promptUser(promptAuthorizeTokenUrl, "http://192.168.111.136:8080/oauth/Authorize")
 
-	// Ask for OAuth request endpoint URL:
-	// accessTokenUrl := promptUser(promptAccessTokenUrl, authorizeTokenUrl)
-	accessTokenUrl := promptUser(promptAccessTokenUrl, "http://192.168.111.136:8080/oauth/TokenCredentials")
-	serviceProvider := ServiceProvider{
-		RequestTokenUrl:   requestTokenUrl,
-		AuthorizeTokenUrl: authorizeTokenUrl,
-		AccessTokenUrl:    accessTokenUrl,
+	bytes, err := (&OAuthToken{
+		privateKey:       privateKey,
+		consumerKey:      consumerKey,
+		oauthToken:       atoken.Token,
+		oauthTokenSecret: atoken.Secret,
+	}).MarshalJSON()
+	if err != nil {
+		return nil, err
 	}
+	token := protocol.Token(bytes)
+	return &token, nil
+}
 
-	// Initialize OAuth consumer:
-	consumer := NewConsumer(gpkOauthConsumerKey, "don't care", serviceProvider, &signer)
-
+// authorizeOutOfBand asks the user to paste the verification code in input, and exchanges it for an access token.
+func authorizeOutOfBand(consumer *Consumer, input io.Reader) (atoken *AccessToken, err error) {
 	// Request a request token:
-	rtoken, loginUrl, err := consumer.GetRequestTokenAndUrl("oob")
+	rtoken, loginUrl, err := consumer.GetRequestTokenAndUrl(OutOfBand)
 	if err != nil {
 		return nil, err
 	}
 
 	// Ask for verification code:
 	fmt.Println(authorizationText)
-	fmt.Printf("\nURL: %s\n", loginUrl)
-	verificationCode := promptUser(verificationCodeText, "")
+	showLoginUrl(loginUrl)
+	verificationCode := promptUser(input, verificationCodeText, "")
+	if verificationCode == "" {
+		return nil, errors.New("Missing verification code")
+	}
 
 	// Ask server for an access token:
-	atoken, err := consumer.AuthorizeToken(rtoken, verificationCode)
+	return consumer.AuthorizeToken(rtoken, verificationCode)
+}
+
+// authorizeWithCallback listens on addr for the service provider to redirect to, with the verification code,
+// and exchanges it for an access token.
+func authorizeWithCallback(consumer *Consumer, addr string) (atoken *AccessToken, err error) {
+	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	defer l.Close()
+	callbackUrl := "http://" + l.Addr().String() + "/callback"
 
-	fmt.Println("AToken:", atoken)
-	os.Exit(0) //TODO remove me
+	// Request a request token:
+	rtoken, loginUrl, err := consumer.GetRequestTokenAndUrl(callbackUrl)
+	if err != nil {
+		return nil, err
+	}
 
-	return
+	verifiers := make(chan string, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/callback" || q.Get(TOKEN_PARAM) != rtoken.Token || q.Get(VERIFIER_PARAM) == "" {
+			http.Error(w, "Unexpected OAuth callback", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "The connection has been allowed, you can close this window.")
+		select {
+		case verifiers <- q.Get(VERIFIER_PARAM):
+		default: // already received
+		}
+	})}
+	go server.Serve(l)
+	defer server.Close()
+
+	fmt.Println(callbackText)
+	showLoginUrl(loginUrl)
+
+	select {
+	case verificationCode := <-verifiers:
+		// Ask server for an access token:
+		return consumer.AuthorizeToken(rtoken, verificationCode)
+	case <-time.After(callbackTimeout):
+		return nil, errors.New(fmt.Sprintf("The connection has not been allowed within %s", callbackTimeout))
+	}
+}
+
+// readPrivateKey reads the RSA private key in the PEM file, either PKCS #1 or PKCS #8 encoded.
+func readPrivateKey(pemFile string) (key *rsa.PrivateKey, err error) {
+	if !gopack.FileExists(pemFile) {
+		return nil, fmt.Errorf("The file '%s' doesn't exist.", pemFile)
+	}
+
+	// Load private key file:
+	content, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode PEM bytes:
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("Wrong private key format")
+	}
+
+	// Instantiate the private key with decoded bytes:
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("The file '%s' does not contain an RSA private key.", pemFile)
+	}
+	return key, nil
+}
+
+// defaultPemFile is the usual RSA private key of the current user.
+func defaultPemFile() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(u.HomeDir, ".ssh", "id_rsa")
+}
+
+// resolve returns the URL of path, relative to the remote base URL.
+func resolve(base *url.URL, path string) string {
+	b := *base
+	if !strings.HasSuffix(b.Path, "/") {
+		b.Path += "/"
+	}
+	return b.ResolveReference(&url.URL{Path: path}).String()
+}
+
+// ask returns value, if it is set. Otherwise, the user is prompted for it when the request is interactive, def is used if not.
+func (req *TokenRequest) ask(value, text, def string) string {
+	if value != "" {
+		return value
+	}
+	if !req.Interactive {
+		return def
+	}
+	return promptUser(os.Stdin, text, def)
+}
+
+// showLoginUrl prints the URL the user browses to allow the connection.
+var showLoginUrl = func(loginUrl string) {
+	fmt.Printf("\nURL: %s\n", loginUrl)
 }
 
 func waitForEnter() {
@@ -108,7 +266,7 @@ func waitForEnter() {
 	reader.ReadString('\n')
 }
 
-func promptUser(text, def string) (ans string) {
+func promptUser(input io.Reader, text, def string) (ans string) {
 	ans = def
 
 	// Display prompt text:
@@ -122,11 +280,10 @@ func promptUser(text, def string) (ans string) {
 	fmt.Print("\n> ")
 
 	// Read input:
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	input = strings.TrimRight(input, "\n")
-	if len(input) > 0 {
-		ans = input
+	line, _ := bufio.NewReader(input).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > 0 {
+		ans = line
 	}
 
 	return
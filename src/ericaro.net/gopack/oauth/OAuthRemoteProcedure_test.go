@@ -0,0 +1,148 @@
+package oauth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"ericaro.net/gopack/protocol"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//requestTest starts a provider, with the consumer alice-gpk, and returns the token request of its RSA key
+func requestTest(t *testing.T) (p *Provider, server string, user *protocol.Token, received *string, req TokenRequest) {
+	p, s, user, received := providerServer(t)
+	t.Cleanup(s.Close)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(p.Path())) })
+	key := generateKey(t)
+	private := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	public, err := ParsePublicKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.AddConsumer(ConsumerEntry{Key: "alice-gpk", PublicKey: public}); err != nil {
+		t.Fatal(err)
+	}
+	req = TokenRequest{PemFile: filepath.Join(t.TempDir(), "id_rsa"), ConsumerKey: "alice-gpk"}
+	if err = ioutil.WriteFile(req.PemFile, private, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p, s.URL + "/", user, received, req
+}
+
+//allow plays the user allowing the connection at loginUrl, and returns the page received
+func allow(t *testing.T, loginUrl string, user *protocol.Token) string {
+	resp, err := http.PostForm(loginUrl, url.Values{"k": {user.FormatStd()}})
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("The connection has not been allowed: %s", body)
+	}
+	return string(body)
+}
+
+//browse replaces the URL printed to the user by browse, while the test runs
+func browse(t *testing.T, browse func(loginUrl string)) {
+	show := showLoginUrl
+	showLoginUrl = browse
+	t.Cleanup(func() { showLoginUrl = show })
+}
+
+//checkToken checks that the token t, requested for the remote server, is accepted
+func checkToken(t *testing.T, server string, token *protocol.Token, received *string) {
+	u, _ := url.Parse("oauth:" + server)
+	c, err := NewOAuthClient("test", *u, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Search("a", 0); *received == "" {
+		t.Errorf("The requested token is not accepted")
+	}
+}
+
+func TestRequestOAuthTokenWithCallback(t *testing.T) {
+	_, server, user, received, req := requestTest(t)
+	req.Callback = "localhost:0"
+	browse(t, func(loginUrl string) {
+		go func() { // the user is redirected to the callback listener
+			if page := allow(t, loginUrl, user); !strings.Contains(page, "The connection has been allowed") {
+				t.Errorf("Unexpected callback page %q", page)
+			}
+		}()
+	})
+	token, err := RequestOAuthToken("test", server, req)
+	if err != nil {
+		t.Fatalf("Cannot request a token: %v", err)
+	}
+	checkToken(t, server, token, received)
+}
+
+func TestRequestOAuthTokenOutOfBand(t *testing.T) {
+	_, server, user, received, req := requestTest(t)
+	input, paste := io.Pipe()
+	req.Input = input
+	browse(t, func(loginUrl string) {
+		go func() { // the user pastes the verification code
+			code := strings.TrimSpace(strings.TrimPrefix(allow(t, loginUrl, user), "Verification code:"))
+			io.WriteString(paste, code+"\n")
+		}()
+	})
+	token, err := RequestOAuthToken("test", server, req)
+	if err != nil {
+		t.Fatalf("Cannot request a token: %v", err)
+	}
+	checkToken(t, server, token, received)
+}
+
+func TestRequestOAuthTokenWithoutTerminal(t *testing.T) {
+	_, server, _, _, req := requestTest(t)
+	browse(t, func(loginUrl string) {
+		t.Errorf("The connection has been requested, but the verification code cannot be pasted")
+	})
+	for _, callback := range []string{"", OutOfBand} {
+		req.Callback = callback
+		if _, err := RequestOAuthToken("test", server, req); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+			t.Errorf("Out of band, without a terminal: %v", err)
+		}
+	}
+}
+
+func TestAuthorizeWithCallbackRejectsForgedCallbacks(t *testing.T) {
+	p, server, user, _, req := requestTest(t)
+	key, _ := readPrivateKey(req.PemFile)
+	consumer := NewConsumer("alice-gpk", "", ServiceProvider{
+		RequestTokenUrl:   server + DefaultRequestTokenPath,
+		AuthorizeTokenUrl: server + DefaultAuthorizeTokenPath,
+		AccessTokenUrl:    server + DefaultAccessTokenPath,
+	}, &RSA_SHA1_Signer{Private: key})
+	browse(t, func(loginUrl string) {
+		go func() {
+			u, _ := url.Parse(loginUrl)
+			token := u.Query().Get(TOKEN_PARAM)
+			callback := p.pending(token, "").callback
+			// a callback with another token, or without a verifier, is rejected
+			for _, q := range []string{TOKEN_PARAM + "=other&" + VERIFIER_PARAM + "=v", TOKEN_PARAM + "=" + url.QueryEscape(token)} {
+				if resp, err := http.Get(callback + "?" + q); err == nil {
+					if resp.StatusCode != http.StatusBadRequest {
+						t.Errorf("The forged callback %s has been accepted", q)
+					}
+					resp.Body.Close()
+				}
+			}
+			allow(t, loginUrl, user)
+		}()
+	})
+	atoken, err := authorizeWithCallback(consumer, "localhost:0")
+	if err != nil || atoken.Token == "" {
+		t.Fatalf("Cannot authorize: %v", err)
+	}
+}
@@ -17,7 +17,7 @@ type OAuthToken struct {
 func (t *OAuthToken) UnmarshalJSON(bytes []byte) (err error) {
 	//[DL] TODO Add unit test
 	array := make([][]byte, 4)
-	json.Unmarshal(bytes, array)
+	json.Unmarshal(bytes, &array)
 
 	t.privateKey, err = x509.ParsePKCS1PrivateKey(array[0])
 	if err != nil {
@@ -0,0 +1,151 @@
+package oauth2
+
+import (
+	"ericaro.net/gopack"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+func init() {
+	protocol.RegisterClient("oauth2", NewOAuth2Client)
+}
+
+// OAuth2Client is a client for a remote protected by OAuth 2.0: every request carries the access token, as a bearer token.
+// The access token is renewed when it expires, or when the remote refuses it.
+type OAuth2Client struct {
+	name    string
+	remote  url.URL            // the "oauth2:" URL, it is the one persisted
+	http    *gopack.HttpClient // sends the protocol requests
+	mutex   sync.Mutex         // guards the token, requests are concurrent
+	token   *Token
+	renewed func()
+}
+
+// NewOAuth2Client creates a client for the remote "oauth2:<url>". token is the Token, see Format.
+func NewOAuth2Client(name string, u url.URL, token *protocol.Token) (c protocol.Client, err error) {
+	target, err := url.Parse(strings.TrimPrefix(u.String(), "oauth2:"))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || len(*token) == 0 {
+		return nil, errors.New(fmt.Sprintf("Remote %s requires an OAuth 2.0 token", name))
+	}
+	t, err := ParseToken(*token)
+	if err != nil {
+		return nil, err
+	}
+	client := &OAuth2Client{
+		name:   name,
+		remote: u,
+		token:  t,
+	}
+	client.http = &gopack.HttpClient{
+		BaseClient: *protocol.NewBaseClient(name, *target, nil), // the token is never sent as a parameter
+		Client:     &http.Client{Transport: &bearerTransport{client, target.Host}},
+	}
+	return client, nil
+}
+
+func (c *OAuth2Client) Fetch(pid protocol.PID) (r io.ReadCloser, err error) {
+	pid.Token = nil
+	return c.http.Fetch(pid)
+}
+
+func (c *OAuth2Client) FetchFrom(pid protocol.PID, offset int64, tag string) (*protocol.Archive, error) {
+	pid.Token = nil
+	return c.http.FetchFrom(pid, offset, tag)
+}
+
+func (c *OAuth2Client) Push(pid protocol.PID, r io.Reader) (err error) {
+	pid.Token = nil
+	return c.http.Push(pid, r)
+}
+
+func (c *OAuth2Client) PushExecutables(pid protocol.PID, r io.Reader) (err error) {
+	pid.Token = nil
+	return c.http.PushExecutables(pid, r)
+}
+
+func (c *OAuth2Client) Search(query string, start int) (result []protocol.PID) {
+	return c.http.Search(query, start)
+}
+
+func (c *OAuth2Client) Name() string {
+	return c.name
+}
+
+func (c *OAuth2Client) Path() url.URL {
+	return c.remote
+}
+
+// Token returns the current Token, it changes when it is renewed.
+func (c *OAuth2Client) Token() *protocol.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.token.Format()
+}
+
+// OnRenew is part of the protocol.Renewer interface.
+func (c *OAuth2Client) OnRenew(renewed func()) {
+	c.renewed = renewed
+}
+
+// accessToken returns the access token. It is renewed first if it has expired, or if it is stale: the remote has refused it.
+func (c *OAuth2Client) accessToken(stale string) (token string, err error) {
+	c.mutex.Lock()
+	renew := c.token.expired() || (stale != "" && stale == c.token.AccessToken) // it might have been renewed meanwhile
+	if renew {
+		err = c.token.Renew()
+	}
+	token = c.token.AccessToken
+	c.mutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if renew && c.renewed != nil {
+		c.renewed()
+	}
+	return
+}
+
+// bearerTransport adds the access token to the requests sent to the remote host. Requests redirected elsewhere are sent without it.
+type bearerTransport struct {
+	c    *OAuth2Client
+	host string
+}
+
+func (b *bearerTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if req.URL.Host != b.host {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	token, err := b.c.accessToken("")
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return
+	}
+	resp, err = http.DefaultTransport.RoundTrip(bearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil { // a streamed body cannot be sent again
+		return
+	}
+	// the access token has been revoked, or has expired earlier than announced
+	resp.Body.Close()
+	if token, err = b.c.accessToken(token); err != nil {
+		return nil, err
+	}
+	return http.DefaultTransport.RoundTrip(bearer(req, token))
+}
+
+// bearer returns a copy of req, with the access token.
+func bearer(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
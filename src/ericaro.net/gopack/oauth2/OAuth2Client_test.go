@@ -0,0 +1,199 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"ericaro.net/gopack/protocol"
+	"ericaro.net/gopack/semver"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+//authorizationServer is a stand-in token endpoint, and device authorization endpoint, in front of a protected remote.
+type authorizationServer struct {
+	mutex     sync.Mutex
+	expiresIn int64
+	issued    int    // number of access tokens issued
+	valid     string // the only access token accepted by the remote
+	refresh   string
+	polls     int // of the device code
+	pushed    []byte
+}
+
+func (a *authorizationServer) issue(w http.ResponseWriter, refresh bool) {
+	a.issued++
+	a.valid = fmt.Sprintf("access-%d", a.issued)
+	r := map[string]interface{}{"access_token": a.valid, "token_type": "bearer", "expires_in": a.expiresIn}
+	if refresh {
+		a.refresh = fmt.Sprintf("refresh-%d", a.issued)
+		r["refresh_token"] = a.refresh
+	}
+	json.NewEncoder(w).Encode(r)
+}
+
+func fail(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (a *authorizationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	switch r.URL.Path {
+	case "/device":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code": "dc", "user_code": "ABCD", "verification_uri": "http://example.com/device", "interval": 1, "expires_in": 60,
+		})
+	case "/token":
+		r.ParseForm()
+		switch r.PostForm.Get("grant_type") {
+		case "client_credentials":
+			if id, secret, _ := r.BasicAuth(); id != "ci" || secret != "s3cret" {
+				fail(w, "invalid_client")
+				return
+			}
+			a.issue(w, false)
+		case deviceCodeGrant:
+			if a.polls++; a.polls < 2 {
+				fail(w, "authorization_pending")
+				return
+			}
+			a.issue(w, true)
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != a.refresh {
+				fail(w, "invalid_grant")
+				return
+			}
+			a.issue(w, true)
+		default:
+			fail(w, "unsupported_grant_type")
+		}
+	default:
+		if r.Header.Get("Authorization") != "Bearer "+a.valid || r.URL.Query().Get("k") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/repo/search":
+			v, _ := semver.ParseVersion("1.0.0")
+			json.NewEncoder(w).Encode([]protocol.PID{{Name: "a/b", Version: v}})
+		case "/repo/push":
+			a.pushed, _ = ioutil.ReadAll(r.Body)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+//newClient creates a client for the remote, with the token, it counts its renewals
+func newClient(t *testing.T, server *httptest.Server, token *Token) (c *OAuth2Client, renewals *int) {
+	u, _ := url.Parse("oauth2:" + server.URL + "/repo/")
+	client, err := NewOAuth2Client("test", *u, token.Format())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = client.(*OAuth2Client)
+	renewals = new(int)
+	c.OnRenew(func() { *renewals++ })
+	return
+}
+
+func TestClientCredentials(t *testing.T) {
+	a := &authorizationServer{expiresIn: 1} // it always needs to be renewed
+	server := httptest.NewServer(a)
+	defer server.Close()
+
+	if _, err := ClientCredentials(server.URL+"/token", "ci", "wrong", ""); err == nil {
+		t.Fatalf("invalid client accepted")
+	}
+	token, err := ClientCredentials(server.URL+"/token", "ci", "s3cret", "read")
+	if err != nil {
+		t.Fatalf("client credentials refused: %v", err)
+	}
+	c, renewals := newClient(t, server, token)
+	if result := c.Search("a", 0); len(result) != 1 {
+		t.Fatalf("search refused")
+	}
+	v, _ := semver.ParseVersion("1.0.0")
+	if err = c.Push(protocol.PID{Name: "a/b", Version: v, Token: c.Token()}, bytes.NewBufferString("sources")); err != nil {
+		t.Fatalf("push refused: %v", err)
+	}
+	if string(a.pushed) != "sources" {
+		t.Fatalf("wrong pushed content %q", a.pushed)
+	}
+	if *renewals != 2 {
+		t.Fatalf("the expired token has been renewed %d times", *renewals)
+	}
+	saved, err := ParseToken(*c.Token())
+	if err != nil || saved.AccessToken != a.valid {
+		t.Fatalf("the renewed token is not saved: %v", err)
+	}
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	a := &authorizationServer{expiresIn: 3600}
+	server := httptest.NewServer(a)
+	defer server.Close()
+
+	var code string
+	token, err := DeviceAuthorization(server.URL+"/device", server.URL+"/token", "gpk", "", func(uri, c, complete string) { code = c })
+	if err != nil {
+		t.Fatalf("device authorization failed: %v", err)
+	}
+	if code != "ABCD" || token.RefreshToken == "" {
+		t.Fatalf("wrong device authorization %q %v", code, token)
+	}
+	c, renewals := newClient(t, server, token)
+	if result := c.Search("a", 0); len(result) != 1 || *renewals != 0 {
+		t.Fatalf("search refused")
+	}
+
+	// the access token is revoked, it is refreshed
+	a.valid = "revoked"
+	if result := c.Search("a", 0); len(result) != 1 || *renewals != 1 {
+		t.Fatalf("the revoked token has not been refreshed")
+	}
+	saved, _ := ParseToken(*c.Token())
+	if saved.RefreshToken != a.refresh {
+		t.Fatalf("the new refresh token is not saved")
+	}
+
+	// the refresh token is revoked too
+	a.valid, a.refresh = "revoked", "revoked"
+	if result := c.Search("a", 0); len(result) != 0 {
+		t.Fatalf("search accepted without a valid token")
+	}
+}
+
+func TestBearerStaysOnTheRemoteHost(t *testing.T) {
+	var leaked string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode([]protocol.PID{})
+	}))
+	defer elsewhere.Close()
+	a := &authorizationServer{expiresIn: 3600}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repo/search" { // the search has moved to another host
+			http.Redirect(w, r, elsewhere.URL+r.URL.Path, http.StatusFound)
+			return
+		}
+		a.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	token, err := ClientCredentials(server.URL+"/token", "ci", "s3cret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := newClient(t, server, token)
+	c.Search("a", 0)
+	if leaked != "" {
+		t.Fatalf("the access token has been sent to another host: %q", leaked)
+	}
+}
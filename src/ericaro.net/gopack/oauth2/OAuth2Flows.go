@@ -0,0 +1,88 @@
+package oauth2
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// The grant type of the device authorization flow.
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// How long to wait between two polls of the token endpoint, if the authorization server does not tell.
+const defaultInterval = 5 * time.Second
+
+// ClientCredentials gets an access token for the client itself (RFC 6749 4.4). Once it expires, it is renewed the same way.
+func ClientCredentials(tokenURL, clientID, clientSecret, scope string) (t *Token, err error) {
+	if clientSecret == "" {
+		return nil, errors.New("The client credentials flow requires a client secret")
+	}
+	t = &Token{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        scope,
+	}
+	if err = t.Renew(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// deviceResponse is the response of the device authorization endpoint (RFC 8628 3.2).
+type deviceResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthorization gets an access token on behalf of a user (RFC 8628). prompt is called with the URL the user must browse,
+// the code to enter there, and the URL that already contains the code, if any.
+// The token endpoint is then polled until the user has allowed, or denied, the access. Once it expires, it is renewed with the refresh token.
+func DeviceAuthorization(deviceURL, tokenURL, clientID, scope string, prompt func(uri, code, complete string)) (t *Token, err error) {
+	form := url.Values{}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	var d deviceResponse
+	if err = postForm(deviceURL, clientID, "", form, &d); err != nil {
+		return
+	}
+	if d.DeviceCode == "" || d.UserCode == "" || d.VerificationURI == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid response of %s", deviceURL))
+	}
+	prompt(d.VerificationURI, d.UserCode, d.VerificationURIComplete)
+
+	interval := defaultInterval
+	if d.Interval > 0 {
+		interval = time.Duration(d.Interval) * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(d.ExpiresIn) * time.Second)
+	t = &Token{TokenURL: tokenURL, ClientID: clientID, Scope: scope}
+	for d.ExpiresIn <= 0 || time.Now().Before(deadline) {
+		time.Sleep(interval)
+		r, err := requestToken(tokenURL, clientID, "", url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {d.DeviceCode},
+		})
+		if e, ok := err.(*Error); ok {
+			switch e.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.update(r)
+		return t, nil
+	}
+	return nil, errors.New("The device code has expired before the access was allowed")
+}
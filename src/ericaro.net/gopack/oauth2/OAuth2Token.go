@@ -0,0 +1,163 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"ericaro.net/gopack/protocol"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// expiryDelta: a token is renewed that long before it expires, so that it does not expire during a request.
+const expiryDelta = 30 * time.Second
+
+// Token is an OAuth 2.0 access token, with what it takes to renew it. It is stored, in json, as the remote protocol.Token.
+type Token struct {
+	TokenURL     string // the token endpoint
+	ClientID     string
+	ClientSecret string `json:",omitempty"` // only for the client credentials flow
+	Scope        string `json:",omitempty"`
+	AccessToken  string
+	RefreshToken string    `json:",omitempty"`
+	Expiry       time.Time // zero if it does not expire
+}
+
+// ParseToken reads a token stored as a protocol.Token.
+func ParseToken(data protocol.Token) (t *Token, err error) {
+	t = &Token{}
+	if err = json.Unmarshal(data, t); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid OAuth 2.0 token: %v", err))
+	}
+	if t.TokenURL == "" || t.AccessToken == "" {
+		return nil, errors.New("Invalid OAuth 2.0 token: missing token endpoint, or access token")
+	}
+	return
+}
+
+// Format returns the token as a protocol.Token.
+func (t *Token) Format() *protocol.Token {
+	data, _ := json.Marshal(t)
+	token := protocol.Token(data)
+	return &token
+}
+
+// expired returns true if the access token has expired, or is about to.
+func (t *Token) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(expiryDelta).After(t.Expiry)
+}
+
+// Renew gets a new access token: with the refresh token if there is one, or with the client credentials.
+func (t *Token) Renew() (err error) {
+	form := url.Values{}
+	switch {
+	case t.RefreshToken != "":
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", t.RefreshToken)
+	case t.ClientSecret != "":
+		form.Set("grant_type", "client_credentials")
+		if t.Scope != "" {
+			form.Set("scope", t.Scope)
+		}
+	default:
+		return errors.New("The OAuth 2.0 access token has expired, and it cannot be renewed: add the remote again")
+	}
+	r, err := requestToken(t.TokenURL, t.ClientID, t.ClientSecret, form)
+	if err != nil {
+		return
+	}
+	t.update(r)
+	return
+}
+
+// update sets the token received from the token endpoint.
+func (t *Token) update(r *tokenResponse) {
+	t.AccessToken = r.AccessToken
+	t.Expiry = time.Time{}
+	if r.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	if r.RefreshToken != "" { // otherwise the previous one is still valid
+		t.RefreshToken = r.RefreshToken
+	}
+	if r.Scope != "" {
+		t.Scope = r.Scope
+	}
+}
+
+// Error is an error response of the authorization server (RFC 6749 5.2).
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "OAuth 2.0 error " + e.Code
+	}
+	return fmt.Sprintf("OAuth 2.0 error %s: %s", e.Code, e.Description)
+}
+
+// tokenResponse is the response of the token endpoint, or of the device authorization endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Scope            string `json:"scope"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// postForm posts the form to endpoint, as the client, and decodes the json response in v.
+// A confidential client authenticates with HTTP Basic, a public one only sends its id.
+func postForm(endpoint, clientID, clientSecret string, form url.Values, v interface{}) (err error) {
+	if clientSecret == "" {
+		form.Set("client_id", clientID)
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var e tokenResponse
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return &Error{e.Error, e.ErrorDescription}
+		}
+		return errors.New(fmt.Sprintf("%s: %s", endpoint, resp.Status))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.New(fmt.Sprintf("Invalid response of %s: %v", endpoint, err))
+	}
+	return
+}
+
+// requestToken posts the grant form to the token endpoint.
+func requestToken(tokenURL, clientID, clientSecret string, form url.Values) (r *tokenResponse, err error) {
+	r = &tokenResponse{}
+	if err = postForm(tokenURL, clientID, clientSecret, form, r); err != nil {
+		return nil, err
+	}
+	if r.Error != "" {
+		return nil, &Error{r.Error, r.ErrorDescription}
+	}
+	if r.AccessToken == "" {
+		return nil, errors.New(fmt.Sprintf("No access token in the response of %s", tokenURL))
+	}
+	if r.TokenType != "" && !strings.EqualFold(r.TokenType, "Bearer") {
+		return nil, errors.New(fmt.Sprintf("Unsupported token type %s", r.TokenType))
+	}
+	return
+}
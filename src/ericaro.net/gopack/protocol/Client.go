@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/url"
 )
@@ -24,12 +26,16 @@ func RegisterClient(urlprotocol string, xtor ClientConstructor) {
 // name, an url, and a Token.
 func NewClient(name string, u url.URL, token *Token) (Client, error) {
 	//fmt.Printf("new remote %s %v. scheme factory = %s\n", name, u.String(), RemoteRepositoryFactory[u.Scheme])
-	return ClientFactory[u.Scheme](name, u, token)
+	xtor, ok := ClientFactory[u.Scheme]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported remote URL scheme %q", u.Scheme))
+	}
+	return xtor(name, u, token)
 }
 
 //Client is any kind of client that can talk to a remote repository. In the commands, it is called a Remote
 type Client interface {
-	//Fetch retrieve the package in the form of a io.ReadCloser. 
+	//Fetch retrieve the package in the form of a io.ReadCloser. If the remote declares the package digest, r is an *Archive
 	//r reads into a tar.gz stream, containing all the packages files, including the .gpk
 	Fetch(pid PID) (r io.ReadCloser, err error)
 	//Push will send what's in the reader to the remote.
@@ -53,6 +59,12 @@ type Client interface {
 	Token() *Token
 }
 
+//Renewer is implemented by the clients that renew their Token by themselves, like OAuth 2.0 ones.
+type Renewer interface {
+	//OnRenew sets the function called once the Token has been renewed, to persist it.
+	OnRenew(renewed func())
+}
+
 // A base implementation of a Client, does implement partially the Client interface.
 // therefore it can be used as a delegation field in a real client.
 type BaseClient struct {
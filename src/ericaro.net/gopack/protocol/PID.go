@@ -4,21 +4,49 @@ import (
 	"encoding/json"
 	"ericaro.net/gopack/semver" // todo move this version to another package (standalone semantic version package
 	"net/url"
+	"path"
 	"path/filepath"
 	"time"
 	"strconv"
+	"strings"
 )
 
 //PID represent a Project ID through the internet. Can be either passed as parameter to a query, or returned as a list in a search result
-// contains references to package name, version, the package timestamp (used in update procotol), and a Token
+// contains references to package name, version, the package timestamp (used in update procotol), the content digest, and a Token
 type PID struct {
 	Name      string
 	Version   semver.Version
 	Timestamp *time.Time
+	Digest    string // optional, the package content digest
+	Signature string // optional, the package detached signature
+	Force     bool   // optional, on push, replace the package even if it is a release
 	Executables *bool // optional parameter, used to only fetch executables
 	Token     *Token // is optional
 }
 
+//CheckName returns StatusInvalidName unless name is a canonical package name: a relative, slash separated and clean
+// path, without "." or ".." elements, so that it cannot lie outside the prefix it starts with.
+func CheckName(name string) error {
+	if name == "" || path.Clean(name) != name || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return StatusInvalidName
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "." || elem == ".." {
+			return StatusInvalidName
+		}
+	}
+	return nil
+}
+
+//CheckPathElement returns StatusInvalidParameter if s, a query value used as a single path element (an executable
+// name, a GOOS or GOARCH), contains a path separator or "..".
+func CheckPathElement(s string) error {
+	if strings.ContainsAny(s, `/\`) || strings.Contains(s, "..") {
+		return StatusInvalidParameter
+	}
+	return nil
+}
+
 //Path computes the relative path to the expected package (usually <name> / <version> )
 func (p PID) Path() string {
 	return filepath.Join(p.Name, p.Version.String())
@@ -30,7 +58,16 @@ func (pid *PID) InParameter(v *url.Values) {
 	v.Set("n", pid.Name)
 	v.Set("v", pid.Version.String())
 	if pid.Timestamp != nil {
-		v.Set("t", pid.Timestamp.Format(time.ANSIC))
+		v.Set("t", pid.Timestamp.UTC().Format(time.ANSIC)) // ANSIC has no zone, it is read as UTC
+	}
+	if pid.Digest != "" {
+		v.Set("d", pid.Digest)
+	}
+	if pid.Signature != "" {
+		v.Set("s", pid.Signature)
+	}
+	if pid.Force {
+		v.Set("f", "true")
 	}
 	if pid.Token != nil {
 		v.Set("k", pid.Token.FormatURL())
@@ -40,7 +77,12 @@ func (pid *PID) InParameter(v *url.Values) {
 //FromParameter decode a pid from an url.Values
 func FromParameter(v *url.Values) (pid *PID, err error) {
 	pid = &PID{}
-	pid.Name = v.Get("n") // todo validate the syntax
+	pid.Name = v.Get("n")
+	if pid.Name != "" { // the handlers tell a missing package
+		if err = CheckName(pid.Name); err != nil {
+			return
+		}
+	}
 	pid.Version, err = semver.ParseVersion(v.Get("v"))
 	if err != nil {
 		return // this is not an optional parameter
@@ -53,6 +95,9 @@ func FromParameter(v *url.Values) (pid *PID, err error) {
 	
 	
 	pid.Timestamp = &t
+	pid.Digest = v.Get("d")
+	pid.Signature = v.Get("s")
+	pid.Force, _ = strconv.ParseBool(v.Get("f"))
 	pid.Token = k
 	pid.Executables = &x
 	return
@@ -64,10 +109,15 @@ func (pid *PID) UnmarshalJSON(data []byte) (err error) {
 		Name      string
 		Version   string
 		Timestamp string
+		Digest    string
 	}
 	var pf Pidfile
 	json.Unmarshal(data, &pf)
+	if err = CheckName(pf.Name); err != nil {
+		return
+	}
 	pid.Name = pf.Name
+	pid.Digest = pf.Digest
 	pid.Version, err = semver.ParseVersion(pf.Version)
 	if err != nil {
 		return
@@ -87,13 +137,15 @@ func (pid *PID) MarshalJSON() ([]byte, error) {
 		Name      string
 		Version   string
 		Timestamp string
+		Digest    string
 	}
 	pf := Pidfile{
 		Name:    pid.Name,
 		Version: pid.Version.String(),
+		Digest:  pid.Digest,
 	}
 	if pid.Timestamp != nil {
-		pf.Timestamp = pid.Timestamp.Format(time.ANSIC)
+		pf.Timestamp = pid.Timestamp.UTC().Format(time.ANSIC)
 	}
 	return json.Marshal(pf)
 }
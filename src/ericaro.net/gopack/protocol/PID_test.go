@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"ex", "ex/a", "ericaro.net/gopack", "ex/a..b"} {
+		if err := CheckName(name); err != nil {
+			t.Errorf("%q is a valid name: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "ex/../other", "../ex", "/ex", "ex/", "ex//a", "ex/./a", `ex\..\other`} {
+		if err := CheckName(name); err == nil {
+			t.Errorf("%q is not a valid name", name)
+		}
+	}
+}
+
+func TestCheckPathElement(t *testing.T) {
+	for _, s := range []string{"", "linux", "amd64", "gpk.exe"} {
+		if err := CheckPathElement(s); err != nil {
+			t.Errorf("%q is a valid path element: %v", s, err)
+		}
+	}
+	for _, s := range []string{"..", "../gpk", "a/b", `a\b`} {
+		if err := CheckPathElement(s); err == nil {
+			t.Errorf("%q is not a valid path element", s)
+		}
+	}
+}
@@ -20,6 +20,38 @@ const ( // codes operations
 	SEARCH    = "search"
 )
 
+//http headers used to declare the digest of the package content, and its detached signature, sent by a fetch.
+const (
+	DigestHeader    = "X-Gpk-Digest"
+	SignatureHeader = "X-Gpk-Signature"
+)
+
+//Archive is a package stream (as returned by Client.Fetch) along with the digest of its content, and its signature, as declared by the remote.
+// Clients should return an *Archive whenever the remote declares them, so that they can be checked before installing the package.
+type Archive struct {
+	io.ReadCloser
+	Digest    string
+	Signature string // the detached signature, if the package is signed
+	Tag       string // identifies this archive content, to resume its download, if supported (the http ETag)
+	Offset    int64  // position of the first byte read in the whole archive: the download has been resumed if it is not 0
+	Size      int64  // size of the whole archive, or -1 if it is unknown
+}
+
+//NewArchive wraps a package stream returned by Client.Fetch into an Archive, if it is not one already.
+func NewArchive(r io.ReadCloser) *Archive {
+	if a, ok := r.(*Archive); ok {
+		return a
+	}
+	return &Archive{ReadCloser: r, Size: -1}
+}
+
+//Resumer is implemented by clients that can resume an interrupted Fetch.
+type Resumer interface {
+	//FetchFrom fetches the package archive from offset, if the remote archive is still the one identified by tag (see Archive.Tag).
+	// Otherwise, the whole archive is fetched: the returned Archive.Offset is where it actually starts.
+	FetchFrom(pid PID, offset int64, tag string) (*Archive, error)
+}
+
 //ProtocolError is an error, but adds an error code. This module provides several "standard" errors
 type ProtocolError struct {
 	Message string
@@ -36,6 +68,8 @@ var (
 	StatusCannotOverwrite   = &ProtocolError{"Cannot Overwrite a Package", http.StatusConflict}
 	StatusMissingDependency = &ProtocolError{"Missing Dependency", http.StatusNotAcceptable}
 	StatusNotNewPackage     = &ProtocolError{"No Newer Package", http.StatusNotModified}
+	StatusInvalidName       = &ProtocolError{"Invalid Package Name", http.StatusBadRequest}
+	StatusInvalidParameter  = &ProtocolError{"Invalid Parameter", http.StatusBadRequest}
 )
 
 // convert any error into a suitable error code. it uses http.StatusInternalServerError if this is not a protocol error
@@ -58,14 +92,16 @@ type Server interface {
 
 	//Serve is expected to find the package and write it down to the the writer interface.
 	// w must be a tar.gzed stream containing all the package structure, and a .gpk file
-	Serve(pid PID, w io.Writer) error
+	// The package digest and signature should be declared in the DigestHeader and SignatureHeader.
+	// Range requests in r should be honored (see http.ServeContent), so that interrupted downloads can be resumed.
+	Serve(pid PID, w http.ResponseWriter, r *http.Request) error
 
 	//Get download for the given goos goarch, the given executable
 	Get(pid PID, goos, goarch, name string, w io.Writer) error
 	List(pid PID, goos, goarch string, w io.Writer) ([]string, error)
 
-	//Search actually perform the query and return a list of PID found
-	Search(query string, start int) ([]PID, error)
+	//Search actually perform the query and return a list of PID found. token is the one sent by the client, if any
+	Search(query string, start int, token *Token) ([]PID, error)
 	// The handlers make use of a debugf function.	
 	Debugf(format string, args ...interface{})
 }
@@ -107,7 +143,7 @@ func servePush(s Server, w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
 	pid, err := FromParameter(&vals)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorCode(err))
 		log.Printf("%s invalid parameters. %s", PUSH, err)
 		return
 	}
@@ -134,7 +170,7 @@ func serveBuilt(s Server, w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
 	pid, err := FromParameter(&vals)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorCode(err))
 		log.Printf("%s invalid parameters. %s", PUSH, err)
 		return
 	}
@@ -153,17 +189,18 @@ func serveFetch(s Server, w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
 	pid, err := FromParameter(&vals)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorCode(err))
 		log.Printf("%s invalid parameters. %s", FETCH, err)
+		return
 	}
 	if pid.Name == "" {
 		http.NotFound(w, r)
 		return
 	}
-	err = s.Serve(*pid, w)
+	err = s.Serve(*pid, w, r)
 	if err != nil {
 		http.Error(w, err.Error(), ErrorCode(err))
-		log.Printf("%s Serve Error. %s", PUSH, err)
+		log.Printf("%s Serve Error. %s", FETCH, err)
 	}
 	return
 }
@@ -173,8 +210,9 @@ func serveGet(s Server, w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
 	pid, err := FromParameter(&vals) //n v t k x
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorCode(err))
 		log.Printf("%s invalid parameters. %s", GET, err)
+		return
 	}
 	if pid.Name == "" {
 		log.Printf("%s Serve Error. Empty package", GET)
@@ -185,6 +223,13 @@ func serveGet(s Server, w http.ResponseWriter, r *http.Request) {
 	name := vals.Get("exe")
 	goarch := vals.Get("goarch")
 	goos := vals.Get("goos")
+	for _, elem := range []string{name, goarch, goos} {
+		if err = CheckPathElement(elem); err != nil {
+			http.Error(w, err.Error(), ErrorCode(err))
+			log.Printf("%s invalid parameters. %q", GET, elem)
+			return
+		}
+	}
 	//time to get the binary itself
 	err = s.Get(*pid, goos, goarch, name, w)
 	if err != nil {
@@ -198,8 +243,9 @@ func serveList(s Server, w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
 	pid, err := FromParameter(&vals) //n v t k x
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorCode(err))
 		log.Printf("%s invalid parameters. %s", LIST, err)
+		return
 	}
 	if pid.Name == "" {
 		log.Printf("%s Serve Error. Empty package", LIST)
@@ -209,6 +255,13 @@ func serveList(s Server, w http.ResponseWriter, r *http.Request) {
 
 	goarch := vals.Get("goarch")
 	goos := vals.Get("goos")
+	for _, elem := range []string{goarch, goos} {
+		if err = CheckPathElement(elem); err != nil {
+			http.Error(w, err.Error(), ErrorCode(err))
+			log.Printf("%s invalid parameters. %q", LIST, elem)
+			return
+		}
+	}
 	//time to get the binaries itself
 	results, err := s.List(*pid, goos, goarch, w)
 	if err != nil {
@@ -224,7 +277,8 @@ func serveList(s Server, w http.ResponseWriter, r *http.Request) {
 func serveSearch(s Server, w http.ResponseWriter, r *http.Request) {
 	query := r.FormValue("q")
 	start, _ := strconv.Atoi(r.FormValue("start"))
-	results, err := s.Search(query, start)
+	token, _ := ParseURLToken(r.FormValue("k"))
+	results, err := s.Search(query, start, token)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("%s Search Error. %s", SEARCH, err)
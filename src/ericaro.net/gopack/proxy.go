@@ -0,0 +1,224 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+//DefaultProxyTTL is how long a proxy trusts its cached snapshots, and search results, before asking its remotes again.
+const DefaultProxyTTL = 5 * time.Minute
+
+//DefaultProxyEntries is how many snapshot checks, and search results, a proxy caches at most.
+const DefaultProxyEntries = 10000
+
+//Proxy makes an HttpServer a pull-through cache of the remotes of its local repository:
+// missing packages are downloaded from them, following their RemotePolicy, installed in the local repository, and then served.
+type Proxy struct {
+	TTL        time.Duration // how long snapshots, and search results, are cached
+	MaxEntries int           // how many snapshot checks, and search results, are cached at most
+	mutex      sync.Mutex
+	fetching   map[ProjectID]*fetchLock // a package is downloaded only once, even if it is requested several times at once
+	checked    map[ProjectID]time.Time  // when the snapshots have been checked on the remotes
+	searches   map[string]searchResult  // by query
+}
+
+//fetchLock is held while a package is downloaded, it is forgotten once nobody is waiting for it
+type fetchLock struct {
+	sync.Mutex
+	users int // holding it, or waiting for it
+}
+
+//searchResult is the whole result of a search, both local and on the remotes
+type searchResult struct {
+	pids []protocol.PID
+	at   time.Time
+}
+
+//NewProxy creates a proxy that caches snapshots, and search results, for ttl.
+func NewProxy(ttl time.Duration) *Proxy {
+	return &Proxy{
+		TTL:        ttl,
+		MaxEntries: DefaultProxyEntries,
+		fetching:   make(map[ProjectID]*fetchLock),
+		checked:    make(map[ProjectID]time.Time),
+		searches:   make(map[string]searchResult),
+	}
+}
+
+//lock prevents concurrent downloads of id, it returns the function to unlock it
+func (p *Proxy) lock(id ProjectID) func() {
+	p.mutex.Lock()
+	m, ok := p.fetching[id]
+	if !ok {
+		m = new(fetchLock)
+		p.fetching[id] = m
+	}
+	m.users++
+	p.mutex.Unlock()
+	m.Lock()
+	return func() {
+		m.Unlock()
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if m.users--; m.users == 0 {
+			delete(p.fetching, id)
+		}
+	}
+}
+
+//due returns true if the snapshot id has not been checked on the remotes for TTL, it is then considered as checked.
+func (p *Proxy) due(id ProjectID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if time.Since(p.checked[id]) < p.TTL {
+		return false
+	}
+	if _, ok := p.checked[id]; !ok && len(p.checked) >= p.MaxEntries {
+		p.evictChecked()
+	}
+	p.checked[id] = time.Now()
+	return true
+}
+
+//evictChecked makes room for a snapshot check: the expired ones are removed, and if it is still full, the oldest one too.
+// The mutex must be held.
+func (p *Proxy) evictChecked() {
+	var oldest *ProjectID
+	for id, at := range p.checked {
+		if time.Since(at) >= p.TTL {
+			delete(p.checked, id)
+		} else if oldest == nil || at.Before(p.checked[*oldest]) {
+			id := id
+			oldest = &id
+		}
+	}
+	if oldest != nil && len(p.checked) >= p.MaxEntries {
+		delete(p.checked, *oldest)
+	}
+}
+
+//evictSearches makes room for a search result: the expired ones are removed, and if it is still full, the oldest one too.
+// The mutex must be held.
+func (p *Proxy) evictSearches() {
+	oldest, found := "", false
+	for query, r := range p.searches {
+		if time.Since(r.at) >= p.TTL {
+			delete(p.searches, query)
+		} else if !found || r.at.Before(p.searches[oldest].at) {
+			oldest, found = query, true
+		}
+	}
+	if found && len(p.searches) >= p.MaxEntries {
+		delete(p.searches, oldest)
+	}
+}
+
+//find returns the package id from the local repository r. It is downloaded from the remotes if it is missing,
+// or if it is a snapshot that has not been checked for TTL, and there is a newer one.
+func (p *Proxy) find(r *LocalRepository, id ProjectID) (pkg *Package, err error) {
+	pkg, err = r.FindPackage(id)
+	if err == nil && !(id.Version().IsSnapshot() && p.due(id)) {
+		return
+	}
+	unlock := p.lock(id)
+	defer unlock()
+	if err != nil {
+		if pkg, err = r.FindPackage(id); err == nil { // downloaded while waiting for the lock
+			return
+		}
+		log.Printf("PROXY downloading %s", id)
+		return r.download(id, nil, "")
+	}
+	t := pkg.Timestamp()
+	if newer, err := r.download(id, &t, ""); err == nil {
+		log.Printf("PROXY updated %s", id)
+		return newer, nil
+	}
+	return pkg, nil
+}
+
+//search returns a page of the results of the query, in the local repository r, and in its remotes.
+// Only packages accepted by the filter are returned, a nil filter accepts everything.
+func (p *Proxy) search(r *LocalRepository, query string, start int, accept func(name string) bool) (result []protocol.PID) {
+	const M = 10 // like LocalRepository.Search
+	for _, pid := range p.searchAll(r, query) {
+		if accept != nil && !accept(pid.Name) {
+			continue
+		}
+		if start > 0 {
+			start--
+			continue
+		}
+		result = append(result, pid)
+		if len(result) == M {
+			break
+		}
+	}
+	return
+}
+
+//searchAll returns every result of the query, from the cache if it is younger than TTL.
+// Remote results are only kept if the remote is allowed to resolve them, see RemotePolicy, and if no remote before it has the same name.
+func (p *Proxy) searchAll(r *LocalRepository, query string) []protocol.PID {
+	p.mutex.Lock()
+	cached, ok := p.searches[query]
+	p.mutex.Unlock()
+	if ok && time.Since(cached.at) < p.TTL {
+		return cached.pids
+	}
+
+	known := make(map[string]bool)
+	pids := make([]protocol.PID, 0)
+	add := func(pid protocol.PID) {
+		key := pid.Name + " " + pid.Version.String()
+		if !known[key] {
+			known[key] = true
+			pids = append(pids, pid)
+		}
+	}
+	for _, pid := range searchPages(func(start int) []protocol.PID { return r.Search(query, start) }) {
+		add(pid)
+	}
+	from := make(map[string]protocol.Client) // the first remote that has a name, it is the one it is downloaded from
+	for _, remote := range r.OrderedRemotes() {
+		for _, pid := range searchPages(func(start int) []protocol.PID { return remote.Search(query, start) }) {
+			if first, ok := from[pid.Name]; ok {
+				if first == remote {
+					add(pid)
+				}
+				continue
+			}
+			for _, allowed := range r.remotesFor(pid.Name) {
+				if allowed == remote {
+					from[pid.Name] = remote
+					add(pid)
+					break
+				}
+			}
+		}
+	}
+	sort.Sort(pidsByName(pids))
+
+	p.mutex.Lock()
+	if _, ok := p.searches[query]; !ok && len(p.searches) >= p.MaxEntries {
+		p.evictSearches()
+	}
+	p.searches[query] = searchResult{pids, time.Now()}
+	p.mutex.Unlock()
+	return pids
+}
+
+//pidsByName sorts PIDs by name, and then by version
+type pidsByName []protocol.PID
+
+func (s pidsByName) Len() int      { return len(s) }
+func (s pidsByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s pidsByName) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].Version.LowerThan(s[j].Version)
+}
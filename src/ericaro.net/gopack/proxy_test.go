@@ -0,0 +1,91 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+//snapshotID is the id of the snapshot ex/a i
+func snapshotID(i int) ProjectID {
+	v, _ := ParseVersion(fmt.Sprintf("branch%d", i))
+	return *NewProjectID("ex/a", v)
+}
+
+func TestProxyLockIsForgotten(t *testing.T) {
+	p := NewProxy(time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			unlock := p.lock(snapshotID(i % 3))
+			unlock()
+		}(i)
+	}
+	wg.Wait()
+	if len(p.fetching) != 0 {
+		t.Errorf("%d download locks are kept", len(p.fetching))
+	}
+
+	// it is held until unlocked
+	unlock := p.lock(snapshotID(0))
+	locked := make(chan bool)
+	go func() {
+		defer p.lock(snapshotID(0))()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("The lock is not held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
+
+func TestProxyDue(t *testing.T) {
+	p := NewProxy(time.Minute)
+	if !p.due(snapshotID(0)) || p.due(snapshotID(0)) {
+		t.Errorf("A snapshot is due once per TTL")
+	}
+	p.checked[snapshotID(0)] = time.Now().Add(-2 * time.Minute)
+	if !p.due(snapshotID(0)) {
+		t.Errorf("A snapshot is due again after the TTL")
+	}
+}
+
+func TestProxyCachesAreCapped(t *testing.T) {
+	p := NewProxy(time.Minute)
+	p.MaxEntries = 10
+	for i := 0; i < 100; i++ {
+		p.due(snapshotID(i))
+	}
+	if len(p.checked) != p.MaxEntries {
+		t.Errorf("%d snapshot checks are cached, expected %d", len(p.checked), p.MaxEntries)
+	}
+	if _, ok := p.checked[snapshotID(99)]; !ok {
+		t.Errorf("The last snapshot check has been evicted")
+	}
+
+	r := newTestRepository(t)
+	for i := 0; i < 100; i++ {
+		p.searchAll(r, fmt.Sprintf("query%d", i))
+	}
+	if len(p.searches) != p.MaxEntries {
+		t.Errorf("%d search results are cached, expected %d", len(p.searches), p.MaxEntries)
+	}
+
+	// expired entries are evicted first
+	for id := range p.checked {
+		if id != snapshotID(99) {
+			p.checked[id] = time.Now().Add(-2 * time.Minute)
+		}
+	}
+	p.due(snapshotID(100))
+	if len(p.checked) != 2 {
+		t.Errorf("%d snapshot checks are cached, expected the 2 that have not expired", len(p.checked))
+	}
+}
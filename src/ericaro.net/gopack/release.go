@@ -0,0 +1,80 @@
+package gopack
+
+import (
+	"ericaro.net/gopack/protocol"
+	. "ericaro.net/gopack/semver"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+//ReleaseLogFile records, in the repository root, every release that has been replaced.
+const ReleaseLogFile = ".gpkreleases.log"
+
+//checkOverwrite returns protocol.StatusCannotOverwrite if installing id would replace a release: releases are write-once, unless force is set.
+// The package that would be replaced, if any, is returned so that the replacement can be logged.
+func (r *LocalRepository) checkOverwrite(id ProjectID, force bool) (replaced *Package, err error) {
+	if id.Version().IsSnapshot() { // snapshots are meant to be replaced
+		return
+	}
+	replaced, err = r.FindPackage(id)
+	if err != nil { // nothing to replace
+		return nil, nil
+	}
+	if !force {
+		log.Printf("Refusing to overwrite release %s", id)
+		return nil, protocol.StatusCannotOverwrite
+	}
+	return
+}
+
+//logReplacement appends the replacement of a release to the ReleaseLogFile
+func (r *LocalRepository) logReplacement(old, p *Package, by string) (err error) {
+	line := fmt.Sprintf("%s replaced %s %s by %s (created %s, digest %s) with (created %s, digest %s)\n",
+		time.Now().Format(time.RFC3339), p.Name(), p.Version().String(), by,
+		old.Timestamp().Format(time.RFC3339), old.Digest(), p.Timestamp().Format(time.RFC3339), p.Digest())
+	log.Print(line)
+	f, err := os.OpenFile(filepath.Join(r.root, ReleaseLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return
+}
+
+//localUser is the name of the user running gpk, used to log who replaced a release locally
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+//NextVersion computes the version following the newest release of name, both in the local repository and on the
+// remote if it is not nil, see Version.Bump. latest is nil if there is no release yet: the next version is then
+// bumped from 0.0.0. Snapshots are ignored. It fails if the next version would not be greater than the latest one.
+func (r *LocalRepository) NextVersion(name string, remote protocol.Client, bump Bump, id string) (latest *Version, next Version, err error) {
+	versions := r.Versions(name)
+	if remote != nil {
+		versions = append(versions, remoteVersions(remote, name)...)
+	}
+	for i := range versions {
+		if v := versions[i]; !v.IsSnapshot() && (latest == nil || latest.LowerThan(v)) {
+			latest = &versions[i]
+		}
+	}
+	if latest == nil {
+		next = Version{}.Bump(bump, id)
+	} else {
+		next = latest.Bump(bump, id)
+	}
+	if latest != nil && !latest.LowerThan(next) {
+		return latest, next, errors.New(fmt.Sprintf("The next version of %s %s would be %s", name, latest, next))
+	}
+	return
+}
@@ -0,0 +1,26 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"testing"
+)
+
+func TestNextVersion(t *testing.T) {
+	r := newTestRepository(t)
+	if latest, next, err := r.NextVersion("ex/a", nil, MinorBump, ""); err != nil || latest != nil || next.String() != "0.1.0" {
+		t.Errorf("Without release, the next version is %s: %v", next, err)
+	}
+	installTestPackage(t, r, "ex/a", "1.2.3")
+	installTestPackage(t, r, "ex/a", "1.2.4-beta.9")
+	installTestPackage(t, r, "ex/a", "master") // snapshots are ignored
+	latest, next, err := r.NextVersion("ex/a", nil, PreBump, "alpha")
+	if err != nil || latest.String() != "1.2.4-beta.9" || next.String() != "1.2.5-alpha.0" {
+		t.Errorf("The next version of %v is %s: %v", latest, next, err)
+	}
+
+	// the major digit cannot be incremented anymore
+	installTestPackage(t, r, "ex/a", "4294967295.0.0")
+	if _, next, err = r.NextVersion("ex/a", nil, MajorBump, ""); err == nil {
+		t.Errorf("The next version of 4294967295.0.0 is %s", next)
+	}
+}
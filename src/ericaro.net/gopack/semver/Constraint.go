@@ -0,0 +1,311 @@
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//partial is the regexp of a possibly incomplete version, where missing or wildcard digits (x, X or *) mean "any".
+var partial = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-(` + sub + `))?(?:\+(` + sub + `))?$`)
+
+// operators recognized in front of a version, longest first.
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+//comparator is a single "op version" test.
+type comparator struct {
+	op       string // one of *, =, !=, <, <=, >, >=
+	version  Version
+	build    bool // true if the version build part must be compared too (only for =)
+	snapshot bool // true if version is a snapshot name, it can only be compared with =
+}
+
+//match tests a version against this comparator
+func (c comparator) match(v Version) bool {
+	switch c.op {
+	case "*":
+		return true
+	case "=":
+		return sameVersion(v, c.version, c.build)
+	case "!=":
+		return !sameVersion(v, c.version, c.build)
+	case "<":
+		return v.LowerThan(c.version) && !sameVersion(v, c.version, false)
+	case "<=":
+		return v.LowerThan(c.version) || sameVersion(v, c.version, false)
+	case ">":
+		return !v.LowerThan(c.version) && !sameVersion(v, c.version, false)
+	case ">=":
+		return !v.LowerThan(c.version) || sameVersion(v, c.version, false)
+	}
+	return false
+}
+
+func (c comparator) String() string {
+	switch c.op {
+	case "*":
+		return "*"
+	case "=":
+		return c.version.String()
+	}
+	return c.op + c.version.String()
+}
+
+//sameVersion compares digits and prerelease, and the build part only if required.
+func sameVersion(v, w Version, build bool) bool {
+	if v.major != w.major || v.minor != w.minor || v.patch != w.patch || v.pre != w.pre {
+		return false
+	}
+	return !build || v.build == w.build
+}
+
+//Constraint is a set of acceptable versions, as written in a dependency declaration.
+//
+// The syntax is close to the one used by most package managers:
+//   1.2.3            exactly 1.2.3
+//   master           exactly the snapshot "master"
+//   >=1.0.0 <2.0.0   space separated comparators must all match
+//   ^1.2             compatible with 1.2: >=1.2.0 <2.0.0
+//   ~1.4.3           patch updates only: >=1.4.3 <1.5.0
+//   1.x, 1.2.*, 1    wildcards: >=1.0.0 <2.0.0 and >=1.2.0 <1.3.0
+//   ^1.0 || ^2.0     alternatives
+//
+// Snapshots are never matched by a range, only by their exact name.
+// Prerelease versions are matched only if one of the comparators mentions a prerelease of the same digits.
+type Constraint struct {
+	raw  string
+	sets [][]comparator // a union of intersections
+}
+
+//Exact creates a Constraint matching only the given version.
+func Exact(v Version) Constraint {
+	return Constraint{
+		raw:  v.String(),
+		sets: [][]comparator{{{op: "=", version: v, build: len(v.build) > 0, snapshot: v.IsSnapshot()}}},
+	}
+}
+
+//ParseConstraint reads a constraint expression, its versions in the SnapshotMode.
+func ParseConstraint(s string) (c Constraint, err error) {
+	return ParseConstraintMode(s, SnapshotMode)
+}
+
+//ParseConstraintMode reads a constraint expression, its full versions are read in the given mode: in the StrictMode,
+// a snapshot is only accepted in its full form, e.g. 0.0.0-master.
+func ParseConstraintMode(s string, mode Mode) (c Constraint, err error) {
+	s = strings.Trim(s, " {}[]\"'")
+	if s == "" {
+		return c, errors.New("Empty version constraint")
+	}
+	c.raw = s
+	for _, alt := range strings.Split(s, "||") {
+		set, err := parseSet(alt, mode)
+		if err != nil {
+			return c, fmt.Errorf("Invalid version constraint \"%s\": %s", s, err)
+		}
+		c.sets = append(c.sets, set)
+	}
+	return
+}
+
+//parseSet parses a space separated list of comparators
+func parseSet(s string, mode Mode) (set []comparator, err error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("empty alternative")
+	}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if isOperator(f) { // ">= 1.0" operator and version can be separated
+			if i+1 == len(fields) {
+				return nil, fmt.Errorf("missing version after %s", f)
+			}
+			i++
+			f += fields[i]
+		}
+		cs, err := parseComparator(f, mode)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, cs...)
+	}
+	return
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+//parseComparator parses a single term, it can expand into several comparators (like ^1.2)
+func parseComparator(s string, mode Mode) (cs []comparator, err error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	s = s[len(op):]
+
+	parts := partial.FindStringSubmatch(s)
+	if parts == nil {
+		if op != "" && op != "=" {
+			return nil, fmt.Errorf("%s cannot be applied to \"%s\"", op, s)
+		}
+		// not a digit version: this must be a snapshot name
+		if mode == StrictMode {
+			return nil, fmt.Errorf("invalid version \"%s\", a snapshot is written 0.0.0-%s", s, s)
+		}
+		v, err := ParseVersion(s)
+		if err != nil {
+			return nil, err
+		}
+		if !v.IsSnapshot() {
+			return nil, fmt.Errorf("invalid version \"%s\"", s)
+		}
+		return []comparator{{op: "=", version: v, build: len(v.build) > 0, snapshot: true}}, nil
+	}
+
+	// count the explicit digits
+	digits := [3]uint32{}
+	n := 0
+	for n < 3 && parts[n+1] != "" && !isWildcard(parts[n+1]) {
+		if d := parts[n+1]; mode == StrictMode && len(d) > 1 && d[0] == '0' {
+			return nil, fmt.Errorf("%s has a leading zero in \"%s\"", d, s)
+		}
+		if digits[n], err = atoi(parts[n+1]); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	for j := n; j < 3; j++ {
+		if parts[j+1] != "" && !isWildcard(parts[j+1]) {
+			return nil, fmt.Errorf("digit after a wildcard in \"%s\"", s)
+		}
+	}
+	pre, build := parts[4], parts[6] // sub contains a group itself
+	if n < 3 && (pre != "" || build != "") {
+		return nil, fmt.Errorf("prerelease on a partial version \"%s\"", s)
+	}
+	if n == 3 && mode == StrictMode {
+		if _, err = parseStrict(s); err != nil {
+			return nil, err
+		}
+	}
+	low := Version{major: digits[0], minor: digits[1], patch: digits[2], pre: pre, build: build}
+
+	// upper bound of a partial version: 1 -> 2.0.0, 1.2 -> 1.3.0
+	next := func(level int) Version {
+		switch level {
+		case 0:
+			return Version{major: low.major + 1}
+		case 1:
+			return Version{major: low.major, minor: low.minor + 1}
+		}
+		return Version{major: low.major, minor: low.minor, patch: low.patch + 1}
+	}
+	if n == 0 { // *, x: anything but snapshots
+		return []comparator{{op: "*"}}, nil
+	}
+
+	switch op {
+	case "", "=":
+		if n == 3 { // 0.0.0-master is the snapshot master
+			return []comparator{{op: "=", version: low, build: build != "", snapshot: low.IsSnapshot()}}, nil
+		}
+		return []comparator{{op: ">=", version: low}, {op: "<", version: next(n - 1)}}, nil
+	case "!=":
+		if n < 3 {
+			return nil, fmt.Errorf("!= requires a full version \"%s\"", s)
+		}
+		return []comparator{{op: "!=", version: low, build: build != ""}}, nil
+	case ">=", "<":
+		return []comparator{{op: op, version: low}}, nil
+	case ">":
+		if n == 3 {
+			return []comparator{{op: ">", version: low}}, nil
+		}
+		return []comparator{{op: ">=", version: next(n - 1)}}, nil
+	case "<=":
+		if n == 3 {
+			return []comparator{{op: "<=", version: low}}, nil
+		}
+		return []comparator{{op: "<", version: next(n - 1)}}, nil
+	case "~":
+		level := 1 // ~1.2.3 and ~1.2 allow patch updates, ~1 allows minor
+		if n == 1 {
+			level = 0
+		}
+		return []comparator{{op: ">=", version: low}, {op: "<", version: next(level)}}, nil
+	case "^":
+		// the first non zero digit must not change
+		level := 0
+		switch {
+		case low.major > 0 || n == 1:
+			level = 0
+		case low.minor > 0 || n == 2:
+			level = 1
+		default:
+			level = 2
+		}
+		return []comparator{{op: ">=", version: low}, {op: "<", version: next(level)}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func isWildcard(s string) bool {
+	return s == "x" || s == "X" || s == "*"
+}
+
+//Match returns true if the version satisfies this constraint.
+func (c Constraint) Match(v Version) bool {
+	for _, set := range c.sets {
+		if matchSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSet(set []comparator, v Version) bool {
+	if v.IsSnapshot() { // snapshots are matched only by name
+		for _, c := range set {
+			if c.op == "=" && c.snapshot && sameVersion(v, c.version, c.build) {
+				return true
+			}
+		}
+		return false
+	}
+	allowPre := len(v.pre) == 0
+	for _, c := range set {
+		if c.snapshot && c.op != "!=" {
+			return false
+		}
+		if !c.match(v) {
+			return false
+		}
+		if len(c.version.pre) > 0 && sameVersion(Version{major: v.major, minor: v.minor, patch: v.patch}, Version{major: c.version.major, minor: c.version.minor, patch: c.version.patch}, false) {
+			allowPre = true
+		}
+	}
+	return allowPre
+}
+
+//IsExact returns the only version matched by this constraint, if there is one.
+func (c Constraint) IsExact() (v Version, ok bool) {
+	if len(c.sets) == 1 && len(c.sets[0]) == 1 && c.sets[0][0].op == "=" {
+		return c.sets[0][0].version, true
+	}
+	return
+}
+
+//String returns the constraint as it was written
+func (c Constraint) String() string {
+	return c.raw
+}
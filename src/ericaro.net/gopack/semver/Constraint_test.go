@@ -0,0 +1,83 @@
+package semver
+
+import (
+	"testing"
+)
+
+type Match struct {
+	c       string
+	v       string
+	matches bool
+}
+
+func TestConstraintMatching(t *testing.T) {
+
+	matches := []Match{
+		Match{"1.2.3", "1.2.3", true},
+		Match{"1.2.3", "1.2.4", false},
+		Match{"master", "master", true},
+		Match{"master", "develop", false},
+		Match{"0.0.0-master", "master", true},
+		Match{"master", "0.0.0-master", true},
+		Match{"^1.2", "1.2.0", true},
+		Match{"^1.2", "1.9.7", true},
+		Match{"^1.2", "2.0.0", false},
+		Match{"^1.2", "1.1.9", false},
+		Match{"^0.2.3", "0.2.9", true},
+		Match{"^0.2.3", "0.3.0", false},
+		Match{"~1.4.3", "1.4.9", true},
+		Match{"~1.4.3", "1.5.0", false},
+		Match{"~1.4.3", "1.4.2", false},
+		Match{">=1.0.0 <2.0.0", "1.5.0", true},
+		Match{">=1.0.0 <2.0.0", "2.0.0", false},
+		Match{">= 1.0.0 < 2.0.0", "1.0.0", true},
+		Match{"1.x", "1.0.0", true},
+		Match{"1.x", "1.99.0", true},
+		Match{"1.x", "2.0.0", false},
+		Match{"1.2.*", "1.2.7", true},
+		Match{"1.2.*", "1.3.0", false},
+		Match{"*", "3.0.0", true},
+		Match{"*", "master", false},
+		Match{">=1.0.0", "master", false},
+		Match{"^1.0 || ^2.0", "2.1.0", true},
+		Match{"^1.0 || ^2.0", "3.0.0", false},
+		Match{"^1.2", "1.3.0-beta", false},
+		Match{">=1.3.0-alpha <2", "1.3.0-beta", true},
+		Match{">1.2", "1.2.9", false},
+		Match{">1.2", "1.3.0", true},
+		Match{"<=1.2", "1.2.9", true},
+		Match{"<=1.2", "1.3.0", false},
+	}
+
+	for _, m := range matches {
+		c, err := ParseConstraint(m.c)
+		if err != nil {
+			t.Fatalf("Cannot parse %v: %v\n", m.c, err)
+		}
+		v, _ := ParseVersion(m.v)
+		if c.Match(v) != m.matches {
+			t.Fatalf("Match mismatch %q against %q: expected %v\n", m.c, m.v, m.matches)
+		}
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	for _, s := range []string{"", ">=", "^master", "1.x.3", ">=1.0 ||"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Fatalf("Constraint %q should not parse\n", s)
+		}
+	}
+}
+
+func TestStrictConstraints(t *testing.T) {
+	for _, s := range []string{"1.2.3", "^1.2", "~1.4.3 || 2.x", ">=1.0.0-rc.1 <2.0.0", "0.0.0-master"} {
+		if _, err := ParseConstraintMode(s, StrictMode); err != nil {
+			t.Errorf("Constraint %q should parse: %v", s, err)
+		}
+	}
+	for _, s := range []string{"master", "1.02.3", "^01.2", "v1.2.3", "1.2.3-01", "1.2.3-rc..1"} {
+		if _, err := ParseConstraintMode(s, StrictMode); err == nil {
+			t.Errorf("Constraint %q should not parse in the strict mode", s)
+		}
+	}
+}
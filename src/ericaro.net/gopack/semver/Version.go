@@ -4,6 +4,7 @@
 // so we use this hole to define "snapshot" version:
 // version 0.0.0 are considered snapshots. the digits can be skipped, so does the prelease dash ("-")
 // this way "master" is a suitable semver, that fully qualifies to 0.0.0-master
+// This convention is only accepted in the SnapshotMode (see Parse), the one used by ParseVersion.
 package semver
 
 import (
@@ -28,7 +29,7 @@ var (
 //Version is a struct that hold all [http://semver.org/ semantic version] components.
 type Version struct {
 	major, minor, patch uint32
-	pre, build          string // for now pre and build part are not parsed (splitted into . ) 
+	pre, build          string // dot separated identifiers, they are split when compared
 }
 
 //NewVersion creates a new standard semver
@@ -43,14 +44,75 @@ func NewVersion(major, minor, patch uint32, pre, build string) *Version {
 }
 
 
-func (v Version) LowerThan( w Version) bool {
-	if v.major != w.major { return v.major < w.major}
-	if v.minor != w.minor { return v.minor < w.minor}
-	if v.patch != w.patch { return v.patch < w.patch}
-	if v.pre   != w.pre   { return v.pre   < w.pre  }
-	if v.build != w.build { return v.build < w.build}
-	return false
-	
+//LowerThan returns true if v has a lower precedence than w, as defined by http://semver.org/#spec-item-11:
+// the digits are compared numerically, a prerelease is lower than the normal version, the prerelease identifiers
+// are compared one by one (numerically if they are both numeric), and the build metadata is ignored.
+func (v Version) LowerThan(w Version) bool {
+	if v.major != w.major {
+		return v.major < w.major
+	}
+	if v.minor != w.minor {
+		return v.minor < w.minor
+	}
+	if v.patch != w.patch {
+		return v.patch < w.patch
+	}
+	return comparePreRelease(v.pre, w.pre) < 0
+}
+
+//comparePreRelease returns the sign of the precedence difference between two prerelease parts
+func comparePreRelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "": // the normal version
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifier(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(as) - len(bs)) // a larger set of identifiers has a higher precedence
+}
+
+//compareIdentifier compares numeric identifiers numerically, and lower than the alphanumeric ones, compared in ASCII order.
+func compareIdentifier(a, b string) int {
+	an, bn := isNumeric(a), isNumeric(b)
+	switch {
+	case an && bn: // they can be longer than any integer, and have leading zeros in the SnapshotMode
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return sign(len(a) - len(b))
+		}
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
 }
 
 //String pretty prints the version.
@@ -86,22 +148,202 @@ func (v Version) IsSnapshot() bool {
 	return v.major == 0 && v.minor == 0 && v.patch == 0
 }
 
-func atoi(s string) uint32 {
-	i, _ := strconv.ParseUint(s, 10, 8)
-	return uint32(i)
+//atoi reads a version number, that must fit in 32 bits. An empty string is 0.
+func atoi(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("version number %s is out of range", s)
+	}
+	return uint32(i), nil
+}
+
+//Mode selects the syntax accepted by Parse
+type Mode int
+
+const (
+	//StrictMode only accepts the semver 2.0 syntax: MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]
+	StrictMode Mode = iota
+	//SnapshotMode also accepts the snapshot convention: the digits can be omitted (they are then 0), and so does the
+	// prerelease dash ("-"): "master" is 0.0.0-master. Digits are not checked for leading zeros.
+	SnapshotMode
+)
+
+//Parse reads a version in the given mode, the error tells why it is not valid.
+func Parse(v string, mode Mode) (version Version, err error) {
+	if mode == StrictMode {
+		version, err = parseStrict(v)
+	} else {
+		version, err = parseSnapshot(v)
+	}
+	if err != nil {
+		err = fmt.Errorf("Invalid version \"%s\": %s", v, err)
+	}
+	return
 }
 
-//ParseVersion reads the version. It supports ommiting the digits (that are considered 0.0.0) and the leading "-"
+//ParseVersion reads the version in the SnapshotMode. It supports ommiting the digits (that are considered 0.0.0) and the leading "-"
 func ParseVersion(v string) (version Version, err error) {
-	v = strings.Trim(v, " {}[]\"'")
+	return Parse(strings.Trim(v, " {}[]\"'"), SnapshotMode)
+}
+
+//parseSnapshot reads a version with the SemVer regexp
+func parseSnapshot(v string) (version Version, err error) {
 	parts := SemVer.FindStringSubmatch(v)
-	//fmt.Printf("%24s -> %v\n",v, parts[1:])
-	version = Version{
-		major: atoi(parts[1]),
-		minor: atoi(parts[2]),
-		patch: atoi(parts[3]),
-		pre:   parts[4],
-		build: parts[6],
+	if parts == nil || parts[0] != v || parts[1] == "" && parts[4] == "" {
+		return version, fmt.Errorf("expecting MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD] or a snapshot name")
+	}
+	version.pre, version.build = parts[4], parts[6]
+	if version.major, err = atoi(parts[1]); err != nil {
+		return
+	}
+	if version.minor, err = atoi(parts[2]); err != nil {
+		return
+	}
+	version.patch, err = atoi(parts[3])
+	return
+}
+
+//parseStrict reads a version following the grammar in http://semver.org/#backusnaur-form-grammar-for-valid-semver-versions
+func parseStrict(v string) (version Version, err error) {
+	rest := v
+	if i := strings.Index(rest, "+"); i >= 0 { // the build metadata can contain a "-", it is cut first
+		rest, version.build = rest[:i], rest[i+1:]
+		if err = checkIdentifiers("build metadata", version.build, false); err != nil {
+			return
+		}
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		rest, version.pre = rest[:i], rest[i+1:]
+		if err = checkIdentifiers("prerelease", version.pre, true); err != nil {
+			return
+		}
+	}
+	digits := strings.Split(rest, ".")
+	if len(digits) != 3 {
+		return version, fmt.Errorf("expecting MAJOR.MINOR.PATCH, found \"%s\"", rest)
+	}
+	numbers := [3]*uint32{&version.major, &version.minor, &version.patch}
+	for i, name := range []string{"major", "minor", "patch"} {
+		d := digits[i]
+		switch {
+		case !isNumeric(d):
+			return version, fmt.Errorf("%s version \"%s\" is not a number", name, d)
+		case len(d) > 1 && d[0] == '0':
+			return version, fmt.Errorf("%s version %s has a leading zero", name, d)
+		}
+		if *numbers[i], err = atoi(d); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//checkIdentifiers checks the dot separated identifiers of the prerelease, or build metadata, part.
+// Numeric prerelease identifiers cannot have leading zeros.
+func checkIdentifiers(part, s string, numeric bool) error {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return fmt.Errorf("empty %s identifier", part)
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return fmt.Errorf("invalid character %q in %s identifier \"%s\"", c, part, id)
+			}
+		}
+		if numeric && len(id) > 1 && id[0] == '0' && isNumeric(id) {
+			return fmt.Errorf("numeric %s identifier %s has a leading zero", part, id)
+		}
+	}
+	return nil
+}
+
+//Bump is the part of a version incremented by Version.Bump
+type Bump string
+
+const (
+	MajorBump Bump = "major"
+	MinorBump Bump = "minor"
+	PatchBump Bump = "patch"
+	PreBump   Bump = "pre"
+)
+
+//ParseBump reads one of the bump names
+func ParseBump(s string) (Bump, error) {
+	switch b := Bump(s); b {
+	case MajorBump, MinorBump, PatchBump, PreBump:
+		return b, nil
+	}
+	return PatchBump, fmt.Errorf("Unknown version part %q, expecting %s, %s, %s or %s", s, MajorBump, MinorBump, PatchBump, PreBump)
+}
+
+//Bump returns the version following v, the build metadata is dropped:
+//   major, minor and patch increment their digit, and reset the following ones. But a prerelease of 2.0.0, 1.3.0
+//       or 1.2.4 is bumped to that very version by major, minor and patch respectively.
+//   pre increments the last numeric identifier of the prerelease, or appends ".0" if there is none.
+//       A normal version is bumped to the next patch prerelease.
+// If id is not empty, the result is the prerelease "id.0" of the bumped version, or, for pre, of the same digits
+// if the current prerelease does not start with id. If that prerelease is not greater than v (1.2.4-beta.9 bumped
+// by pre alpha) the digit is incremented anyway: 1.2.5-alpha.0. So the result is always greater than v.
+func (v Version) Bump(b Bump, id string) (w Version) {
+	w = Version{major: v.major, minor: v.minor, patch: v.patch}
+	switch b {
+	case MajorBump:
+		if v.pre == "" || v.minor != 0 || v.patch != 0 {
+			w = Version{major: v.major + 1}
+		}
+	case MinorBump:
+		if v.pre == "" || v.patch != 0 {
+			w = Version{major: v.major, minor: v.minor + 1}
+		}
+	case PatchBump:
+		if v.pre == "" {
+			w.patch++
+		}
+	case PreBump:
+		switch {
+		case v.pre == "":
+			w.patch++
+		case id == "" || v.pre == id || strings.HasPrefix(v.pre, id+"."):
+			ids := strings.Split(v.pre, ".")
+			for i := len(ids) - 1; i >= 0; i-- {
+				if isNumeric(ids[i]) {
+					n, _ := strconv.ParseUint(ids[i], 10, 64)
+					ids[i] = strconv.FormatUint(n+1, 10)
+					w.pre = strings.Join(ids, ".")
+					return
+				}
+			}
+			w.pre = v.pre + ".0"
+			return
+		}
+		if id == "" {
+			w.pre = "0"
+			return
+		}
+	}
+	if id != "" {
+		w.pre = id + ".0"
+		if !v.LowerThan(w) {
+			switch b {
+			case MajorBump:
+				w = Version{major: w.major + 1}
+			case MinorBump:
+				w = Version{major: w.major, minor: w.minor + 1}
+			default:
+				w = Version{major: w.major, minor: w.minor, patch: w.patch + 1}
+			}
+			w.pre = id + ".0"
+		}
 	}
 	return
 }
+
+//Versions is a sortable slice of Version, lowest first.
+type Versions []Version
+
+func (s Versions) Len() int           { return len(s) }
+func (s Versions) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s Versions) Less(i, j int) bool { return s[i].LowerThan(s[j]) }
@@ -27,6 +27,8 @@ func TestVersionParsing(t *testing.T) {
         Pair{ "1.3.7+build           ", Version{1,3,7,"","build"           }},
         Pair{ "1.3.7+build.2.b8f12d7 ", Version{1,3,7,"","build.2.b8f12d7" }},
         Pair{ "1.3.7+build.11.e0f985a", Version{1,3,7,"","build.11.e0f985a"}},
+        Pair{ "256.1000.70000        ", Version{256,1000,70000,"",""       }},
+        Pair{ "4294967295.0.0        ", Version{4294967295,0,0,"",""       }},
  
 	}
 	
@@ -44,3 +46,165 @@ func TestVersionParsing(t *testing.T) {
 	//Parser("1.0.0-b.498.alpha+r.12", t)
 
 }
+
+//valid versions, from the semver.org grammar examples
+var strictVersions = []Pair{
+	{"0.0.4", Version{0, 0, 4, "", ""}},
+	{"1.2.3", Version{1, 2, 3, "", ""}},
+	{"10.20.30", Version{10, 20, 30, "", ""}},
+	{"1.1.2-prerelease+meta", Version{1, 1, 2, "prerelease", "meta"}},
+	{"1.1.2+meta", Version{1, 1, 2, "", "meta"}},
+	{"1.1.2+meta-valid", Version{1, 1, 2, "", "meta-valid"}},
+	{"1.0.0-alpha", Version{1, 0, 0, "alpha", ""}},
+	{"1.0.0-alpha.beta.1", Version{1, 0, 0, "alpha.beta.1", ""}},
+	{"1.0.0-alpha0.valid", Version{1, 0, 0, "alpha0.valid", ""}},
+	{"1.0.0-alpha.0valid", Version{1, 0, 0, "alpha.0valid", ""}},
+	{"1.0.0-alpha-a.b-c-somethinglong+build.1-aef.1-its-okay", Version{1, 0, 0, "alpha-a.b-c-somethinglong", "build.1-aef.1-its-okay"}},
+	{"1.0.0-rc.1+build.1", Version{1, 0, 0, "rc.1", "build.1"}},
+	{"10.2.3-DEV-SNAPSHOT", Version{10, 2, 3, "DEV-SNAPSHOT", ""}},
+	{"2.0.1-alpha.1227", Version{2, 0, 1, "alpha.1227", ""}},
+	{"1.0.0-alpha+beta", Version{1, 0, 0, "alpha", "beta"}},
+	{"1.2.3----RC-SNAPSHOT.12.9.1--.12+788", Version{1, 2, 3, "---RC-SNAPSHOT.12.9.1--.12", "788"}},
+	{"1.0.0+0.build.1-rc.10000aaa-kk-0.1", Version{1, 0, 0, "", "0.build.1-rc.10000aaa-kk-0.1"}},
+	{"1.0.0-0A.is.legal", Version{1, 0, 0, "0A.is.legal", ""}},
+	{"1.0.0+001", Version{1, 0, 0, "", "001"}}, // leading zeros are allowed in the build metadata
+	{"4294967295.4294967295.4294967295", Version{4294967295, 4294967295, 4294967295, "", ""}},
+}
+
+func TestStrictParsing(t *testing.T) {
+	for _, p := range strictVersions {
+		v, err := Parse(p.s, StrictMode)
+		if err != nil {
+			t.Fatalf("Cannot parse %q: %v\n", p.s, err)
+		}
+		if v != p.v {
+			t.Fatalf("Result mismatch for %q (expected then result) \n%v\n%v \n", p.s, p.v, v)
+		}
+	}
+}
+
+//invalid versions in the StrictMode mode, from the semver.org grammar examples
+var invalidVersions = []string{
+	"",
+	"1",
+	"1.2",
+	"1.2.3.4",
+	"master",
+	"v1.2.3",
+	" 1.2.3",
+	"1.2.3-",
+	"1.2.3+",
+	"1.2.3-0123",
+	"1.2.3-0123.0123",
+	"1.1.2+.123",
+	"+invalid",
+	"-invalid",
+	"-invalid+invalid",
+	"alpha.beta",
+	"1.0.0-alpha_beta",
+	"1.0.0-alpha..",
+	"1.0.0-alpha..1",
+	"01.1.1",
+	"1.01.1",
+	"1.1.01",
+	"1.2.3.DEV",
+	"1.2-SNAPSHOT",
+	"1.2.31.2.3----RC-SNAPSHOT.12.09.1--..12+788",
+	"+justmeta",
+	"9.8.7+meta+meta",
+	"9.8.7-whatever+meta+meta",
+	"4294967296.0.0",
+	"99999999999999999999999.999999999999999999.99999999999999999",
+}
+
+func TestStrictErrors(t *testing.T) {
+	for _, s := range invalidVersions {
+		if v, err := Parse(s, StrictMode); err == nil {
+			t.Fatalf("Version %q should not parse, got %v\n", s, v)
+		}
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	for _, s := range []string{"", "!!!", "1.2.3 4", "+build", "4294967296.0.0", "1.2.99999999999"} {
+		if v, err := ParseVersion(s); err == nil {
+			t.Fatalf("Version %q should not parse, got %v\n", s, v)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	// each version has a lower precedence than the next one, from semver.org
+	ordered := [][]string{
+		{"1.0.0", "2.0.0", "2.1.0", "2.1.1"},
+		{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0"},
+		{"1.0.0-2", "1.0.0-10", "1.0.0-a"},
+		{"1.9.0", "1.10.0", "1.11.0"},
+		{"255.0.0", "256.0.0"},
+		{"1.0.0-alpha.99999999999999999999", "1.0.0-alpha.100000000000000000000"},
+	}
+	for _, versions := range ordered {
+		for i := 0; i+1 < len(versions); i++ {
+			v, _ := Parse(versions[i], StrictMode)
+			w, _ := Parse(versions[i+1], StrictMode)
+			if !v.LowerThan(w) || w.LowerThan(v) {
+				t.Fatalf("%s should have a lower precedence than %s\n", versions[i], versions[i+1])
+			}
+		}
+	}
+	// the build metadata is ignored
+	for _, pair := range [][2]string{{"1.0.0+a", "1.0.0+b"}, {"1.0.0-rc.1+build.2", "1.0.0-rc.1+build.11"}, {"1.0.0", "1.0.0+meta"}} {
+		v, _ := Parse(pair[0], StrictMode)
+		w, _ := Parse(pair[1], StrictMode)
+		if v.LowerThan(w) || w.LowerThan(v) {
+			t.Fatalf("%s and %s should have the same precedence\n", pair[0], pair[1])
+		}
+	}
+}
+
+func TestBump(t *testing.T) {
+	bumps := []struct {
+		v    string
+		b    Bump
+		id   string
+		next string
+	}{
+		{"1.2.3", MajorBump, "", "2.0.0"},
+		{"1.2.3", MinorBump, "", "1.3.0"},
+		{"1.2.3", PatchBump, "", "1.2.4"},
+		{"1.2.3+build.5", PatchBump, "", "1.2.4"},
+		{"1.2.3", PreBump, "", "1.2.4-0"},
+		{"1.2.3", PreBump, "rc", "1.2.4-rc.0"},
+		{"1.2.3", MajorBump, "beta", "2.0.0-beta.0"},
+		{"2.0.0-rc.1", MajorBump, "", "2.0.0"},
+		{"2.1.0-rc.1", MajorBump, "", "3.0.0"},
+		{"1.3.0-rc.1", MinorBump, "", "1.3.0"},
+		{"1.2.4-rc.1", MinorBump, "", "1.3.0"},
+		{"1.2.4-rc.1", PatchBump, "", "1.2.4"},
+		{"1.2.4-rc.1", PreBump, "", "1.2.4-rc.2"},
+		{"1.2.4-rc.1", PreBump, "rc", "1.2.4-rc.2"},
+		{"1.2.4-alpha.3", PreBump, "beta", "1.2.4-beta.0"},
+		{"1.2.4-beta.9", PreBump, "", "1.2.4-beta.10"},
+		{"1.2.4-2.beta", PreBump, "", "1.2.4-3.beta"},
+		{"1.2.4-alpha", PreBump, "", "1.2.4-alpha.0"},
+		{"0.0.0", MinorBump, "", "0.1.0"},
+		// the prerelease id would go backwards
+		{"1.2.4-beta.9", PreBump, "alpha", "1.2.5-alpha.0"},
+		{"2.0.0-rc.1", MajorBump, "beta", "3.0.0-beta.0"},
+		{"1.3.0-rc.1", MinorBump, "alpha", "1.4.0-alpha.0"},
+		{"1.2.4-rc.1", PatchBump, "alpha", "1.2.5-alpha.0"},
+		{"2.0.0-alpha.1", MajorBump, "beta", "2.0.0-beta.0"},
+	}
+	for _, b := range bumps {
+		v, err := Parse(b.v, StrictMode)
+		if err != nil {
+			t.Fatalf("Cannot parse %q: %v\n", b.v, err)
+		}
+		if next := v.Bump(b.b, b.id); next.String() != b.next {
+			t.Fatalf("%s bumped by %s %q should be %s, got %s\n", b.v, b.b, b.id, b.next, next)
+		}
+		if next := v.Bump(b.b, b.id); !v.LowerThan(next) {
+			t.Fatalf("%s bumped by %s %q is not greater: %s\n", b.v, b.b, b.id, next)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//BuildFormat is the layout of the date and time of a snapshot build, see SnapshotBuild.
+const BuildFormat = "20060102.150405"
+
+//SnapshotBuild returns the build of the snapshot v created at t, for instance master+20261017.153000.3: the build metadata
+// is the UTC date and time, and a build number that follows the ones in builds.
+func SnapshotBuild(v Version, t time.Time, builds []Version) Version {
+	n := 0
+	for _, b := range builds {
+		ids := strings.Split(b.Build(), ".")
+		if i, err := strconv.Atoi(ids[len(ids)-1]); err == nil && i > n {
+			n = i
+		}
+	}
+	return *NewVersion(0, 0, 0, v.PreRelease(), fmt.Sprintf("%s.%d", t.UTC().Format(BuildFormat), n+1))
+}
+
+//IsBuild returns true if v is a snapshot build, rather than a plain snapshot name that stands for its newest build
+func IsBuild(v Version) bool {
+	return v.IsSnapshot() && v.Build() != ""
+}
+
+//compareBuilds compares the build metadata of two snapshot builds: dot separated identifiers are compared numerically
+// when they are both numeric, as strings otherwise. Plain snapshots, installed before builds existed, are the oldest.
+func compareBuilds(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, xerr := strconv.ParseUint(as[i], 10, 64)
+		y, yerr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case xerr == nil && yerr == nil && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+//Builds lists the builds of the snapshot v of the package name installed in this local repository, oldest first.
+// A plain snapshot installed by an older version of gopack comes first.
+func (r *LocalRepository) Builds(name string, v Version) (builds []Version) {
+	for _, b := range r.Versions(name) {
+		if b.IsSnapshot() && b.PreRelease() == v.PreRelease() {
+			builds = append(builds, b)
+		}
+	}
+	sort.SliceStable(builds, func(i, j int) bool { return compareBuilds(builds[i].Build(), builds[j].Build()) < 0 })
+	return
+}
+
+//SetRetention sets the number of builds kept for each snapshot, the older ones are removed when a new one is installed.
+// 0 keeps them all.
+func (r *LocalRepository) SetRetention(n int) {
+	r.retention = n
+}
+
+//Retention returns the number of builds kept for each snapshot, 0 if they are all kept.
+func (r *LocalRepository) Retention() int {
+	return r.retention
+}
+
+//pruneBuilds removes the oldest builds of the snapshot v of name, so that only the retention newest ones are kept.
+// The builds used by a registered project (see References), like the ones pinned by its lock file, are kept, and so
+// are the ones used by a running gpk process, until the next time.
+func (r *LocalRepository) pruneBuilds(name string, v Version) {
+	builds := r.Builds(name, v)
+	if r.retention <= 0 || len(builds) <= r.retention {
+		return
+	}
+	refs, err := r.References()
+	if err != nil {
+		log.Printf("Cannot prune the builds of %s, the ones in use are unknown: %v", name, err)
+		return
+	}
+	for _, b := range builds[:len(builds)-r.retention] {
+		id := NewProjectID(name, b)
+		if refs.Uses(*id) {
+			log.Printf("Keeping snapshot build %s, it is used by a project", id)
+			continue
+		}
+		log.Printf("Removing snapshot build %s, only %d are kept", id, r.retention)
+		if err := r.RemovePackage(*id); err != nil {
+			log.Printf("Cannot remove %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package gopack
+
+import (
+	. "ericaro.net/gopack/semver"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBuild(t *testing.T) {
+	master, _ := ParseVersion("master")
+	at := time.Date(2026, 10, 17, 17, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	var builds []Version
+	for _, expected := range []string{"master+20261017.153000.1", "master+20261017.153000.2"} {
+		b := SnapshotBuild(master, at, builds)
+		if b.String() != expected || !IsBuild(b) {
+			t.Errorf("The build is %s, expected %s", b.String(), expected)
+		}
+		builds = append(builds, b)
+	}
+	if IsBuild(master) {
+		t.Errorf("%s is not a build", master.String())
+	}
+}
+
+func TestCompareBuilds(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int // its sign
+	}{
+		{"20261017.153000.1", "20261017.153000.1", 0},
+		{"20261017.153000.2", "20261017.153000.10", -1}, // numerically
+		{"20261018.090000.1", "20261017.153000.2", 1},
+		{"", "20261017.153000.1", -1}, // a plain snapshot is the oldest
+		{"20261017.153000.1.x", "20261017.153000.1", 1},
+		{"a.1", "b.1", -1},
+	} {
+		if sign := compareBuilds(c.a, c.b); sign*c.expected < 0 || (sign == 0) != (c.expected == 0) {
+			t.Errorf("compareBuilds(%q, %q) = %d, expected the sign of %d", c.a, c.b, sign, c.expected)
+		}
+	}
+}
+
+func TestBuilds(t *testing.T) {
+	r := newTestRepository(t)
+	installTestPackage(t, r, "ex/a", "1.0.0")
+	var installed []string
+	for i := 0; i < 3; i++ {
+		installed = append(installed, installTestPackage(t, r, "ex/a", "master").Version().String())
+	}
+	installTestPackage(t, r, "ex/a", "develop")
+	master, _ := ParseVersion("master")
+	builds := r.Builds("ex/a", master)
+	if len(builds) != len(installed) {
+		t.Fatalf("The builds are %v, expected %v", builds, installed)
+	}
+	for i, b := range builds {
+		if b.String() != installed[i] {
+			t.Errorf("Build %d is %s, expected %s", i, b.String(), installed[i])
+		}
+	}
+	// the plain name is the newest build
+	if p, err := r.FindPackage(*NewProjectID("ex/a", master)); err != nil || p.Version().String() != installed[2] {
+		t.Errorf("master is %v, expected %s: %v", p, installed[2], err)
+	}
+}
+
+func TestSnapshotBuildsAreUnique(t *testing.T) {
+	r := newTestRepository(t)
+	master, _ := ParseVersion("master")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.InstallProject(newTestProject(t, "ex/a"), master, false); err != nil {
+				t.Errorf("Cannot install: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if builds := r.Builds("ex/a", master); len(builds) != 5 {
+		t.Errorf("Concurrent installs have picked the same builds: %v", builds)
+	}
+}
+
+func TestPruneBuilds(t *testing.T) {
+	r := newTestRepository(t)
+	r.SetRetention(2)
+	pinned := installTestPackage(t, r, "ex/a", "master")
+	installTestPackage(t, r, "ex/a", "master")
+
+	// a registered project pins the oldest build in its lock file
+	p := newTestProject(t, "ex/p", "ex/a master")
+	if err := p.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterProject(p); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := NewLock(p, []*Package{pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	var newest []string
+	for i := 0; i < 3; i++ {
+		newest = append(newest, installTestPackage(t, r, "ex/a", "master").Version().String())
+	}
+	master, _ := ParseVersion("master")
+	expected := []string{pinned.Version().String(), newest[1], newest[2]}
+	builds := r.Builds("ex/a", master)
+	if len(builds) != len(expected) {
+		t.Fatalf("The builds kept are %v, expected %v", builds, expected)
+	}
+	for i, b := range builds {
+		if b.String() != expected[i] {
+			t.Errorf("Build %d is %s, expected %s", i, b.String(), expected[i])
+		}
+	}
+}
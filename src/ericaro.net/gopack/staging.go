@@ -0,0 +1,156 @@
+package gopack
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//StagingDir is the directory, in the repository root, where packages are prepared before being moved into place.
+// It is on the same file system as the packages, so that moving them is atomic.
+const StagingDir = ".gpkstaging"
+
+//RepositoryLockFile is the file, in the repository root, that a gpk process creates while it makes a change that
+// cannot be done atomically, see LocalRepository.lockRepository. It contains the process id.
+const RepositoryLockFile = ".gpklock"
+
+//LockTimeout is how long a gpk process waits for the repository lock, before giving up.
+var LockTimeout = time.Minute
+
+//lockWriteDelay is how long a lock file can be without its process id, while it is being written.
+// Older, it has been left by a process that died in between, and it is broken.
+const lockWriteDelay = time.Second
+
+//lockRepository waits until no other gpk process, or goroutine, holds the repository lock, takes it, and returns the
+// function that releases it, that can be called more than once. The lock of a process that is gone is broken.
+// After LockTimeout, it fails with an error naming the lock file.
+func (r *LocalRepository) lockRepository() (unlock func(), err error) {
+	path := filepath.Join(r.root, RepositoryLockFile)
+	deadline := time.Now().Add(LockTimeout)
+	for wait := time.Millisecond; ; {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			var once sync.Once
+			return func() { once.Do(func() { os.Remove(path) }) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		owner := "an unknown process"
+		if content, err := ioutil.ReadFile(path); err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+				if !processAlive(pid) {
+					log.Printf("Breaking the repository lock of process %d, it is gone", pid)
+					os.Remove(path)
+					continue
+				}
+				owner = fmt.Sprintf("process %d", pid)
+			} else if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockWriteDelay {
+				log.Printf("Breaking the repository lock %s, it has no process id", path)
+				os.Remove(path)
+				continue
+			} // otherwise, it is being written
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New(fmt.Sprintf("The repository is locked by %s since more than %v, remove %s if no gpk process is running", owner, LockTimeout, path))
+		}
+		time.Sleep(wait)
+		if wait < 100*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+//stagingDir creates a new, empty, staging directory. The replacements interrupted by a process that is gone are recovered first, see replaceDir.
+func (r *LocalRepository) stagingDir() (dir string, err error) {
+	parent := filepath.Join(r.root, StagingDir)
+	if err = os.MkdirAll(parent, os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	recoverReplaced(parent)
+	return ioutil.TempDir(parent, "install")
+}
+
+//replaceDir atomically moves the staging directory to dst. If dst already exists, it is replaced: it is first moved
+// away, next to staging, and removed once staging is in place. The replacement is recorded in a staging+".replace" file,
+// with the process id and dst, so that if the process dies in between, the previous content is put back by recoverReplaced.
+func replaceDir(dst, staging string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), os.ModeDir|os.ModePerm); err != nil {
+		return
+	}
+	if !FileExists(dst) {
+		return os.Rename(staging, dst)
+	}
+	journal, old := staging+".replace", staging+".old"
+	if err = ioutil.WriteFile(journal, []byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), dst)), 0644); err != nil {
+		return
+	}
+	defer os.Remove(journal) // once it is either replaced, or put back
+	if err = os.Rename(dst, old); err != nil {
+		return
+	}
+	if err = os.Rename(staging, dst); err != nil {
+		if rerr := os.Rename(old, dst); rerr != nil { // put it back
+			log.Printf("Cannot put %s back into %s: %v", old, dst, rerr)
+			return errors.New(fmt.Sprintf("Cannot replace %s: %v, and its previous content is left in %s", dst, err, old))
+		}
+		return
+	}
+	return os.RemoveAll(old)
+}
+
+//recoverReplaced completes the replacements (see replaceDir) left by the processes that are gone, in the staging directory parent:
+// if the previous content has been moved away, but the new one has not been moved into place, the previous one is put back.
+// Otherwise, the previous content is removed.
+func recoverReplaced(parent string) {
+	journals, _ := filepath.Glob(filepath.Join(parent, "*.replace"))
+	for _, journal := range journals {
+		content, err := ioutil.ReadFile(journal)
+		if err != nil {
+			continue // completed meanwhile
+		}
+		lines := strings.SplitN(strings.TrimSuffix(string(content), "\n"), "\n", 2)
+		pid, err := strconv.Atoi(lines[0])
+		if err != nil || len(lines) != 2 || processAlive(pid) {
+			continue
+		}
+		dst := lines[1]
+		staging := strings.TrimSuffix(journal, ".replace")
+		old := staging + ".old"
+		if FileExists(old) && !FileExists(dst) {
+			log.Printf("Recovering %s, its replacement has been interrupted", dst)
+			if err := os.Rename(old, dst); err != nil {
+				log.Printf("Cannot put %s back into %s: %v", old, dst, err)
+				continue
+			}
+		}
+		os.RemoveAll(old)
+		os.RemoveAll(staging)
+		os.Remove(journal)
+	}
+}
+
+//mergeDir moves every file in staging into dst, replacing existing ones, but keeping the others.
+// Each file is moved atomically.
+func mergeDir(dst, staging string) error {
+	dirHandler := func(ldst, lsrc string) error {
+		return os.MkdirAll(ldst, os.ModeDir|os.ModePerm)
+	}
+	fileHandler := func(ldst, lsrc string) error {
+		return os.Rename(lsrc, ldst)
+	}
+	return walkDir(dst, staging, dirHandler, fileHandler)
+}
@@ -0,0 +1,164 @@
+package gopack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//deadPid is the process id of a process that is gone
+const deadPid = 99999999
+
+//testDir creates the directory dir, with a single file content
+func testDir(t *testing.T, dir, content string) {
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "content"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//dirContent returns the content of the file written by testDir, or "" if there is none
+func dirContent(dir string) string {
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "content"))
+	return string(content)
+}
+
+func TestReplaceDir(t *testing.T) {
+	r := newTestRepository(t)
+	dst := filepath.Join(r.root, "ex", "a")
+	for _, content := range []string{"first", "second"} {
+		staging, err := r.stagingDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testDir(t, staging, content)
+		if err = replaceDir(dst, staging); err != nil {
+			t.Fatalf("Cannot replace %s: %v", dst, err)
+		}
+		if dirContent(dst) != content {
+			t.Errorf("%s contains %q, expected %q", dst, dirContent(dst), content)
+		}
+	}
+	if left, _ := ioutil.ReadDir(filepath.Join(r.root, StagingDir)); len(left) != 0 {
+		t.Errorf("The staging directory is not empty: %v", left)
+	}
+}
+
+func TestRecoverReplaced(t *testing.T) {
+	r := newTestRepository(t)
+	parent := filepath.Join(r.root, StagingDir)
+	cases := []struct {
+		moved    bool   // the previous content has been moved away
+		replaced bool   // the new content has been moved into place
+		expected string // in dst, once recovered
+		pid      int
+	}{
+		{true, false, "previous", deadPid},
+		{true, true, "new", deadPid},
+		{false, false, "previous", deadPid},
+		{true, false, "", os.Getpid()}, // it is still being replaced
+	}
+	for i, c := range cases {
+		dst := filepath.Join(r.root, "ex", fmt.Sprintf("a%d", i))
+		staging := filepath.Join(parent, fmt.Sprintf("install%d", i))
+		testDir(t, dst, "previous")
+		testDir(t, staging, "new")
+		ioutil.WriteFile(staging+".replace", []byte(fmt.Sprintf("%d\n%s\n", c.pid, dst)), 0644)
+		if c.moved {
+			os.Rename(dst, staging+".old")
+		}
+		if c.replaced {
+			os.Rename(staging, dst)
+		}
+		if _, err := r.stagingDir(); err != nil {
+			t.Fatal(err)
+		}
+		if content := dirContent(dst); content != c.expected {
+			t.Errorf("Case %d: %s contains %q, expected %q", i, dst, content, c.expected)
+		}
+		if live := c.pid == os.Getpid(); FileExists(staging+".replace") != live || FileExists(staging+".old") != live {
+			t.Errorf("Case %d: the replacement of %s has not been recovered", i, dst)
+		}
+	}
+}
+
+func TestPackError(t *testing.T) {
+	r := newTestRepository(t)
+	p := installTestPackage(t, r, "ex/a", "1.0.0")
+	// a file that cannot be read
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing"), filepath.Join(p.InstallDir(), "src", "ex", "a", "broken.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pack(ioutil.Discard); err == nil {
+		t.Errorf("An incomplete archive has been packed")
+	}
+	if _, err := ioutil.ReadAll(p.PackReader()); err == nil {
+		t.Errorf("An incomplete archive has been read")
+	}
+	if _, err := r.Archive(p); err == nil {
+		t.Errorf("An incomplete archive has been cached")
+	}
+}
+
+func TestLockRepository(t *testing.T) {
+	r := newTestRepository(t)
+	path := filepath.Join(r.root, RepositoryLockFile)
+	// the lock of a process that is gone is broken
+	ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", deadPid)), 0644)
+	unlock, err := r.lockRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan bool)
+	go func() {
+		unlock, err := r.lockRepository()
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlock()
+		}
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("The lock is not held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	unlock() // it is released once
+	<-locked
+	if FileExists(path) {
+		t.Errorf("The lock has not been released")
+	}
+}
+
+func TestLockRepositoryTimeout(t *testing.T) {
+	r := newTestRepository(t)
+	path := filepath.Join(r.root, RepositoryLockFile)
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 50 * time.Millisecond
+
+	// a live process holds the lock
+	ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	if _, err := r.lockRepository(); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("The lock held by a live process has been taken: %v", err)
+	}
+	// a lock without process id is being written, unless it is old
+	ioutil.WriteFile(path, nil, 0644)
+	if _, err := r.lockRepository(); err == nil {
+		t.Fatalf("The lock being written has been taken")
+	}
+	old := time.Now().Add(-2 * lockWriteDelay)
+	os.Chtimes(path, old, old)
+	unlock, err := r.lockRepository()
+	if err != nil {
+		t.Fatalf("The lock left without process id has not been broken: %v", err)
+	}
+	unlock()
+}